@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// checkRequest asks the daemon loop to start a cycle straight away
+type checkRequest struct {
+	channel channelId // just this channel, regardless of its schedule, or all due channels if empty
+}
+
+// serveAdmin serves the --admin-listen endpoints until ctx is cancelled.
+// POST /check sends a checkRequest on trigger, which is unbuffered so the send
+// only succeeds while the daemon loop is waiting between cycles; cycles never
+// overlap.
+func (b *bot) serveAdmin(ctx context.Context, addr string, trigger chan<- checkRequest) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/check", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		req := checkRequest{channel: channelId(r.URL.Query().Get("channel"))}
+		if req.channel != "" && !b.isMonitored(req.channel) {
+			http.Error(w, "channel "+string(req.channel)+" is not configured", http.StatusNotFound)
+			return
+		}
+		select {
+		case trigger <- req:
+			log.Info().Str("remote_addr", r.RemoteAddr).Str("channel_id", string(req.channel)).Msg("check requested")
+			w.WriteHeader(http.StatusAccepted)
+		default:
+			http.Error(w, "a check cycle is already running", http.StatusConflict)
+		}
+	})
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+	go func() {
+		err := srv.Serve(ln)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Error().AnErr("err", err).Msg("error serving admin endpoint")
+		}
+	}()
+	log.Info().Str("addr", ln.Addr().String()).Msg("serving admin endpoint")
+	return nil
+}
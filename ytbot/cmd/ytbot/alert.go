@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+)
+
+// alert severities, for --alert-level
+const (
+	alertLevelWarn  = "warn"  // something needs a look, e.g. the quota ran out or a post failed
+	alertLevelError = "error" // something failed, e.g. a channel check, a post given up on or the db
+)
+
+// Discord embed colours for each alert severity
+const (
+	alertColorWarn  = 0xf0a020
+	alertColorError = 0xd03030
+)
+
+// alertMaxErrorLength is how much of an error an alert shows, keeping it compact
+const alertMaxErrorLength = 200
+
+// validateAlertLevel checks an --alert-level is one of the known severities
+func validateAlertLevel(s string) error {
+	switch s {
+	case alertLevelWarn, alertLevelError:
+		return nil
+	}
+	return fmt.Errorf("unknown alert level %q, must be %s or %s", s, alertLevelWarn, alertLevelError)
+}
+
+// alert is a problem in a cycle, posted to --alert-webhook at the end of it
+type alert struct {
+	level   string
+	channel string // the channel the problem was with, if any
+	err     error
+}
+
+// newRunId returns a random ID for a cycle, logged and included in its alerts
+// so they can be matched up
+func newRunId() string {
+	b := make([]byte, 4)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// alerts returns the problems in the cycle at or above level, most severe first
+func (s cycleStats) alerts(level string) []alert {
+	var alerts []alert
+	var quotaDeferred, tripped int
+	for _, r := range s.channels {
+		switch {
+		case r.err != nil:
+			alerts = append(alerts, alert{level: alertLevelError, channel: string(r.channel.displayName()), err: r.err})
+		case r.quotaDeferred:
+			quotaDeferred++
+		case r.tripped:
+			tripped++
+		}
+	}
+	for _, err := range s.errors {
+		alerts = append(alerts, alert{level: alertLevelError, err: err})
+	}
+	if s.timedOut {
+		alerts = append(alerts, alert{level: alertLevelError, err: errors.New("max runtime exceeded")})
+	}
+	for _, a := range s.postFailures {
+		if a.level == alertLevelError {
+			alerts = append(alerts, a)
+		}
+	}
+	if level == alertLevelError {
+		return alerts
+	}
+
+	if quotaDeferred > 0 {
+		alerts = append(alerts, alert{level: alertLevelWarn, err: fmt.Errorf("daily API quota budget exhausted, %d channels deferred until it resets", quotaDeferred)})
+	}
+	if tripped > 0 {
+		alerts = append(alerts, alert{level: alertLevelWarn, err: fmt.Errorf("%d channels skipped after failing repeatedly", tripped)})
+	}
+	for _, a := range s.postFailures {
+		if a.level == alertLevelWarn {
+			alerts = append(alerts, a)
+		}
+	}
+	return alerts
+}
+
+// postFailed records a post Discord didn't accept, to alert about at the end
+// of the cycle
+func (b *bot) postFailed(level string, p pendingPost, err error) {
+	a := alert{level: level, channel: string(p.ChannelID), err: err}
+	for _, c := range b.currentChannels() {
+		if c.ID == p.ChannelID {
+			a.channel = string(c.displayName())
+			break
+		}
+	}
+	b.stats.postFailures = append(b.stats.postFailures, a)
+}
+
+// sendAlerts posts the cycle's problems to --alert-webhook, as one embed with
+// a field for each, so a broken run sends one message however much went
+// wrong. Failing to post it is only logged.
+func (b *bot) sendAlerts(ctx context.Context) {
+	if b.settings.alertWebhook == "" {
+		return
+	}
+	alerts := b.stats.alerts(b.settings.alertLevel)
+	if len(alerts) == 0 {
+		return
+	}
+
+	e := embed{
+		Title:  fmt.Sprintf("ytbot: %d problems", len(alerts)),
+		Color:  alertColorWarn,
+		Footer: &embedFooter{Text: "run " + b.runId},
+	}
+	if len(alerts) == 1 {
+		e.Title = "ytbot: 1 problem"
+	}
+	for i, a := range alerts {
+		if a.level == alertLevelError {
+			e.Color = alertColorError
+		}
+		if i == discordMaxEmbedFields-1 && len(alerts) > discordMaxEmbedFields {
+			e.Fields = append(e.Fields, embedField{Name: "…", Value: fmt.Sprintf("and %d more, see the logs", len(alerts)-i)})
+			break
+		}
+		name := a.level
+		if a.channel != "" {
+			name += ": " + limitRunes(a.channel, discordMaxEmbedFieldName-len(name)-2)
+		}
+		e.Fields = append(e.Fields, embedField{Name: name, Value: limitRunes(a.err.Error(), alertMaxErrorLength)})
+	}
+
+	payload := newWebhookPayload("", "")
+	payload.Embeds = []embed{e}
+	_, err := b.notifierFor(b.settings.alertWebhook).send(ctx, b.settings.alertWebhook, payload, false)
+	if err != nil {
+		log.Warn().AnErr("err", err).Str("run_id", b.runId).Msg("error posting alert")
+		return
+	}
+	log.Info().Str("run_id", b.runId).Int("alerts", len(alerts)).Msg("posted alert")
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+	"github.com/urfave/cli/v2"
+)
+
+// deadPostNotice is put in front of posts whose videos are no longer available
+const deadPostNotice = "⚠️ this video is no longer available"
+
+// auditResult counts what an audit of posted messages found
+type auditResult struct {
+	checked, dead int
+}
+
+// auditPosts looks up the videos of recently posted messages, and flags (or
+// with --delete-dead-posts, deletes) the posts of any that have been deleted or
+// are no longer public. Each dead video is only dealt with once.
+func (b *bot) auditPosts(ctx context.Context) (auditResult, error) {
+	var result auditResult
+	if b.service == nil {
+		return result, errors.New("auditing posts needs --apikey")
+	}
+
+	live, err := b.db.LiveMessages(peertubeIdPrefix)
+	if err != nil {
+		return result, fmt.Errorf("error querying db: %w", err)
+	}
+	var posts []trackedMessage
+	for _, m := range live {
+		posts = append(posts, trackedMessage{videoId: m.VideoID, webhook: m.Webhook, messageId: m.MessageID, content: m.Content})
+	}
+	if len(posts) == 0 {
+		return result, nil
+	}
+
+	// look the videos up in as few calls as possible, anything missing has been deleted
+	ids := make([]string, 0, len(posts))
+	for _, m := range posts {
+		ids = append(ids, m.videoId)
+	}
+	videos, err := videosById(ctx, b.service, ids)
+	if err != nil {
+		return result, fmt.Errorf("error getting videos: %w", err)
+	}
+	privacy := make(map[string]string, len(videos))
+	for _, v := range videos {
+		privacy[v.ID] = v.PrivacyStatus
+	}
+
+	deletePosts := b.cliContext.Bool("delete-dead-posts")
+	for _, m := range posts {
+		result.checked++
+		status, ok := privacy[m.videoId]
+		if !ok {
+			status = "deleted"
+		}
+		if status == "public" {
+			continue
+		}
+		if ctx.Err() != nil {
+			return result, nil
+		}
+		result.dead++
+		log := log.With().Str("video_id", m.videoId).Str("privacy_status", status).Logger()
+
+		if deletePosts {
+			err = b.deleteMessage(ctx, m.webhook, m.messageId)
+		} else {
+			err = b.editMessage(ctx, m.webhook, m.messageId, newWebhookPayload(deadPostNotice+"\n"+m.content, ""))
+		}
+		switch {
+		case errors.Is(err, errMessageGone):
+			log.Info().Msg("video no longer available, its post was already deleted")
+		case err != nil:
+			log.Error().AnErr("err", err).Msg("error updating post of video that is no longer available")
+			continue
+		case deletePosts:
+			log.Info().Msg("video no longer available, deleted its post")
+		default:
+			log.Info().Msg("video no longer available, flagged its post")
+		}
+
+		err = b.db.MarkMessageDead(m.videoId, m.webhook)
+		if err != nil {
+			return result, fmt.Errorf("error updating tracked message in db: %w", err)
+		}
+
+		// pace edits like posts
+		if !sleepContext(ctx, b.settings.postDelay) {
+			return result, nil
+		}
+	}
+	return result, nil
+}
+
+// runAudit flags the posts of videos that have been deleted or made private
+func runAudit(cliContext *cli.Context) error {
+	b, err := newBot(cliContext)
+	if err != nil {
+		return err
+	}
+	defer b.close()
+
+	result, err := b.auditPosts(cliContext.Context)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("checked %d posts, %d no longer available\n", result.checked, result.dead)
+	return nil
+}
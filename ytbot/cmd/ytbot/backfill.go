@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/urfave/cli/v2"
+
+	"pw-ytbot/store"
+)
+
+// parseBefore parses --before, a date like 2024-02-01 or a time like
+// 2024-02-01T12:00:00Z, defaulting to now
+func parseBefore(s string) (time.Time, error) {
+	if s == "" {
+		return time.Now(), nil
+	}
+	if t, err := time.Parse(time.DateOnly, s); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q, must be a date like 2024-02-01 or a time like 2024-02-01T12:00:00Z", s)
+	}
+	return t, nil
+}
+
+// backfillChannel records the videos a channel published before before, as
+// far back as a check can look, as backfilled without posting them, so they
+// aren't posted when the channel is next checked. It returns the videos
+// recorded, or with --dry-run those that would be, oldest first.
+func (b *bot) backfillChannel(ctx context.Context, c channel, before time.Time) ([]video, error) {
+	from := time.Now().Add(-max(c.Lookback, maxCatchUp))
+	videos, _, err := b.recentVideos(ctx, c, from, math.MaxInt)
+	if errors.Is(err, errNotModified) {
+		log.Info().Str("channel", string(c.displayName())).Msg("no videos since the channel's last check, nothing to backfill")
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := b.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("error starting db transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	sort.SliceStable(videos, func(i, j int) bool {
+		return videos[i].PublishedAt < videos[j].PublishedAt
+	})
+
+	var backfilled []video
+	for _, v := range videos {
+		published := v.publishedTime()
+		if published.IsZero() || !published.Before(before) {
+			continue
+		}
+		_, posted, err := tx.IsPosted(v.ID)
+		if err != nil {
+			return nil, fmt.Errorf("error querying db: %w", err)
+		}
+		if posted {
+			continue
+		}
+
+		err = recordVideo(tx, v.ID, postTypeBackfilled)
+		if err != nil {
+			return nil, fmt.Errorf("error inserting video into db: %w", err)
+		}
+		err = recordVideoDetails(tx, c, v)
+		if err != nil {
+			return nil, fmt.Errorf("error recording video details in db: %w", err)
+		}
+		if c.isPlaylist() {
+			err = tx.MarkPlaylistItemSeen(string(c.ID), v.ID)
+			if err != nil {
+				return nil, fmt.Errorf("error recording playlist item in db: %w", err)
+			}
+		}
+		backfilled = append(backfilled, v)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, fmt.Errorf("error committing db transaction: %w", err)
+	}
+	return backfilled, nil
+}
+
+// runBackfill records the videos one channel, or every channel, published
+// before --before as backfilled without posting anything, to swallow a
+// backlog after an outage rather than posting it all
+func runBackfill(cliContext *cli.Context) error {
+	allChannels := cliContext.Bool("all-channels")
+	if allChannels == cliContext.IsSet("channel") {
+		return errors.New("expected one of --channel or --all-channels")
+	}
+	before, err := parseBefore(cliContext.String("before"))
+	if err != nil {
+		return fmt.Errorf("--before: %w", err)
+	}
+
+	b, err := newBot(cliContext)
+	if err != nil {
+		return err
+	}
+	defer b.close()
+
+	var channels []channel
+	if allChannels {
+		for _, c := range b.currentChannels() {
+			if c.enabled() {
+				channels = append(channels, c)
+			}
+		}
+	} else {
+		cId := channelId(cliContext.String("channel"))
+		if !isSearchId(string(cId)) && !isPeerTubeId(string(cId)) {
+			cId, _, err = resolveChannelId(cliContext.Context, b.service, string(cId))
+			if err != nil {
+				return err
+			}
+		}
+		for _, c := range b.currentChannels() {
+			if c.ID == cId {
+				channels = append(channels, c)
+			}
+		}
+		if len(channels) == 0 {
+			return fmt.Errorf("channel %s is not configured, add it with channel add or the channels file", cId)
+		}
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CHANNEL\tVIDEO\tPUBLISHED\tTITLE")
+	var total int
+	for _, c := range channels {
+		videos, err := b.backfillChannel(cliContext.Context, c, before)
+		if err != nil {
+			w.Flush()
+			return fmt.Errorf("error backfilling channel %s: %w", c.displayName(), err)
+		}
+		for _, v := range videos {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", c.displayName(), v.ID, v.publishedTime().UTC().Format(store.TimeFormat), v.Title)
+		}
+		total += len(videos)
+	}
+	err = w.Flush()
+	if err != nil {
+		return err
+	}
+	verb := "backfilled"
+	if b.dryRun {
+		verb = "would be backfilled"
+	}
+	fmt.Printf("\n%s %s from %s\n", plural(total, "video"), verb, plural(len(channels), "channel"))
+	return nil
+}
@@ -0,0 +1,1307 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"text/template"
+	"time"
+	"unicode/utf8"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v3"
+
+	"google.golang.org/api/youtube/v3"
+
+	"pw-ytbot/store"
+)
+
+// bot holds everything needed to check channels and post their new videos
+type bot struct {
+	cliContext *cli.Context
+	settings   *settings
+	db         store.Store // logs its writes instead of making them in dry-run mode
+	lock       *runLock
+	service    *youtube.Service
+	quota      *quotaTracker
+
+	// where posts go, replaced in dry-run mode
+	dryRun bool
+	post   func(ctx context.Context, webhook string, payload any) (*http.Response, error)
+	outbox []pendingPost // queued posts in dry-run mode, which doesn't write them to the database
+
+	channelsMu sync.Mutex
+	channels   []channel
+
+	mastodonMu    sync.Mutex
+	mastodonLimit int // characters the Mastodon instance allows in a status, once looked up
+
+	eventFileMu sync.Mutex // held while writing to an event file, so lines aren't interleaved
+
+	discordChannelsMu   sync.Mutex
+	discordChannelTypes map[string]int // types of the Discord channels posted to with the bot, once looked up
+
+	stats cycleStats // counts for the current cycle
+	runId string     // random ID of the current cycle, for matching up its alerts and logs
+
+	// set by the check subcommand and the admin endpoint
+	force  bool      // check disabled channels, and channels not due to be checked
+	repost string    // ID of a video to post even if it already has been
+	only   channelId // check just this channel in a cycle
+}
+
+// cycleStats counts what happened during a check cycle
+type cycleStats struct {
+	posted      int // posts sent to discord
+	deferred    int // posts left for a later run by --max-posts-per-run
+	notModified int // channels whose videos hadn't changed since their last check
+
+	skippedAgeRestricted int // videos not posted as they're age-restricted, for --skip-age-restricted
+
+	channels []channelResult
+	errors   []error // errors not specific to a channel
+	timedOut bool    // the cycle ran past --max-runtime
+
+	postFailures []alert // posts Discord didn't accept, for --alert-webhook
+}
+
+// channelResult is the outcome of checking a channel, for the end of cycle summary
+type channelResult struct {
+	channel channel
+	checked bool
+	posted  int
+	err     error
+
+	// the channel wasn't checked, or its check was cut short, by shutdown or --max-runtime
+	notProcessed, interrupted bool
+
+	// the channel was left until the daily quota resets
+	quotaDeferred bool
+
+	// the channel was skipped after repeated failures, until it's retried
+	tripped bool
+
+	// YouTube said the channel's videos hadn't changed, so none were looked at
+	notModified bool
+}
+
+// err returns nil if everything in the cycle succeeded. Otherwise it returns
+// an error that exits with status 1 if some channels were checked
+// successfully, 2 if nothing was, or 3 if the cycle ran past --max-runtime.
+func (s cycleStats) err() error {
+	var failed, succeeded, unprocessed int
+	for _, r := range s.channels {
+		switch {
+		case r.err != nil:
+			failed++
+		case r.notProcessed || r.interrupted:
+			unprocessed++
+		case r.quotaDeferred, r.tripped:
+			// neither failed nor checked, it will be checked once the quota resets or it's retried
+		case r.checked:
+			succeeded++
+		}
+	}
+	failed += len(s.errors)
+	switch {
+	case s.timedOut:
+		return cli.Exit(fmt.Sprintf("max runtime exceeded, %d channels not processed", unprocessed), 3)
+	case failed == 0:
+		return nil
+	case succeeded == 0:
+		return cli.Exit(fmt.Sprintf("%d errors, no channels checked successfully", failed), 2)
+	}
+	return cli.Exit(fmt.Sprintf("%d errors, %d channels checked successfully", failed, succeeded), 1)
+}
+
+// healthy returns whether the cycle got anything done: nothing failed, or at
+// least one channel was checked, and it finished within --max-runtime
+func (s cycleStats) healthy() bool {
+	err := s.err()
+	var exit cli.ExitCoder
+	return err == nil || (errors.As(err, &exit) && exit.ExitCode() == 1)
+}
+
+// summary returns a table of each channel's outcome
+func (s cycleStats) summary(dryRun bool) string {
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CHANNEL\tID\tOUTCOME")
+	for _, r := range s.channels {
+		outcome := "ok"
+		switch {
+		case r.err != nil:
+			outcome = "error: " + r.err.Error()
+		case r.notProcessed:
+			outcome = "not processed"
+		case r.interrupted:
+			outcome = "interrupted"
+		case r.quotaDeferred:
+			outcome = "deferred, quota exhausted"
+		case r.tripped:
+			outcome = "skipped, failing repeatedly"
+		case !r.checked:
+			outcome = "skipped"
+		case r.notModified:
+			outcome = "not modified"
+		case r.posted > 0 && dryRun:
+			outcome = fmt.Sprintf("would post %d", r.posted)
+		case r.posted > 0:
+			outcome = fmt.Sprintf("posted %d", r.posted)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", r.channel.displayName(), r.channel.ID, outcome)
+	}
+	w.Flush()
+	return buf.String()
+}
+
+// canPost returns whether --max-posts-per-run allows another post this cycle
+func (b *bot) canPost() bool {
+	return b.settings.maxPostsPerRun == 0 || b.stats.posted < b.settings.maxPostsPerRun
+}
+
+// errAnotherInstance is returned by newBot if another instance holds the run lock
+var errAnotherInstance = errors.New("another instance is running")
+
+// newBot loads the configuration, opens the database and takes the run lock,
+// ready to check channels. The bot must be closed when done with.
+func newBot(cliContext *cli.Context) (*bot, error) {
+	dbOpts, err := dbOptionsFromFlags(cliContext)
+	if err != nil {
+		return nil, err
+	}
+	err = checkFlagsSet(cliContext, "webhook")
+	if err != nil {
+		return nil, err
+	}
+
+	// the lock's staleness is checked to the second, and it heartbeats every third of it
+	if cliContext.Duration("lock-timeout") < time.Second {
+		return nil, fmt.Errorf("--lock-timeout must be at least 1s, got %s", cliContext.Duration("lock-timeout"))
+	}
+
+	// load config before doing anything else so config errors fail fast
+	channelSettings, err := loadSettings(cliContext)
+	if err != nil {
+		return nil, err
+	}
+	if channelSettings.source == sourceAPI {
+		err = checkAPIKeySet(cliContext)
+		if err != nil {
+			return nil, err
+		}
+	}
+	fileChannels, err := loadChannelsFileFromFlags(cliContext)
+	if err != nil {
+		return nil, err
+	}
+	err = checkTelegramToken(fileChannels, channelSettings)
+	if err != nil {
+		return nil, err
+	}
+	err = checkMastodonAccount(fileChannels, channelSettings)
+	if err != nil {
+		return nil, err
+	}
+	err = checkBotToken(fileChannels, channelSettings)
+	if err != nil {
+		return nil, err
+	}
+	err = checkMatrixAccount(fileChannels, channelSettings)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Info().Msg("started")
+
+	// open database
+	db, err := openDB(dbOpts, !cliContext.Bool("no-builtin-channels"))
+	if err != nil {
+		return nil, fmt.Errorf("error opening database %s: %w", dbOpts.name(), err)
+	}
+
+	// only one instance may use the database at a time, so the run stops if
+	// another takes the lock over
+	ctx, lost := context.WithCancelCause(cliContext.Context)
+	lock, ok, err := acquireRunLock(db, cliContext.Duration("lock-timeout"), lost)
+	if err != nil {
+		lost(nil)
+		db.Close()
+		return nil, fmt.Errorf("error acquiring run lock: %w", err)
+	}
+	if !ok {
+		lost(nil)
+		db.Close()
+		return nil, errAnotherInstance
+	}
+	cliContext.Context = ctx
+
+	b := &bot{
+		cliContext: cliContext,
+		settings:   channelSettings,
+		db:         db,
+		lock:       lock,
+		post:       postWebhook,
+	}
+
+	// in dry-run mode, log posts instead of sending them and don't change the database
+	if cliContext.Bool("dry-run") {
+		log.Info().Msg("dry run, nothing will be posted or recorded")
+		b.dryRun = true
+		b.db = db.DryRun()
+		b.post = dryRunPost
+		b.settings.postDelay = 0
+	}
+
+	// prep youtube connection, which is optional when reading channel feeds
+	if channelSettings.source == sourceAPI || len(apiKeysFromFlags(cliContext)) > 0 {
+		b.quota, err = newQuotaTracker(cliContext, b.db)
+		if err != nil {
+			b.close()
+			return nil, err
+		}
+		b.service, err = newYoutubeService(cliContext, b.quota)
+		if err != nil {
+			b.close()
+			return nil, fmt.Errorf("error creating YouTube client: %w", err)
+		}
+	} else {
+		log.Info().Msg("no API key, live streams and premieres won't be detected and shorts are detected from feed links")
+	}
+
+	// get channels to monitor
+	b.channels, err = loadChannels(cliContext.Context, db, b.service, fileChannels, !cliContext.Bool("no-builtin-channels"))
+	if err != nil {
+		b.close()
+		return nil, err
+	}
+	applySettings(b.channels, channelSettings)
+	err = validateRetention(channelSettings.retention, b.channels)
+	if err != nil {
+		b.close()
+		return nil, err
+	}
+	err = applyChannelTitles(db, b.channels)
+	if err != nil {
+		b.close()
+		return nil, err
+	}
+	log.Info().Int("channels", len(b.channels)).Msg("loaded channels")
+
+	return b, nil
+}
+
+// close releases the run lock and closes the database
+func (b *bot) close() {
+	b.lock.release()
+	b.db.Close()
+}
+
+// currentChannels returns the channels to monitor
+func (b *bot) currentChannels() []channel {
+	b.channelsMu.Lock()
+	defer b.channelsMu.Unlock()
+	return b.channels
+}
+
+// isMonitored returns true if cId is still one of the channels to monitor
+func (b *bot) isMonitored(cId channelId) bool {
+	for _, c := range b.currentChannels() {
+		if c.ID == cId {
+			return true
+		}
+	}
+	return false
+}
+
+// reloadChannels re-reads the channels file and database, replacing the
+// channels to monitor and logging what changed
+func (b *bot) reloadChannels() error {
+	fileChannels, err := loadChannelsFileFromFlags(b.cliContext)
+	if err != nil {
+		return err
+	}
+	err = checkTelegramToken(fileChannels, b.settings)
+	if err != nil {
+		return err
+	}
+	err = checkMastodonAccount(fileChannels, b.settings)
+	if err != nil {
+		return err
+	}
+	err = checkBotToken(fileChannels, b.settings)
+	if err != nil {
+		return err
+	}
+	err = checkMatrixAccount(fileChannels, b.settings)
+	if err != nil {
+		return err
+	}
+	channels, err := loadChannels(b.cliContext.Context, b.db, b.service, fileChannels, !b.cliContext.Bool("no-builtin-channels"))
+	if err != nil {
+		return err
+	}
+	applySettings(channels, b.settings)
+	err = validateRetention(b.settings.retention, channels)
+	if err != nil {
+		return err
+	}
+	err = applyChannelTitles(b.db, channels)
+	if err != nil {
+		return err
+	}
+
+	b.channelsMu.Lock()
+	old := b.channels
+	b.channels = channels
+	b.channelsMu.Unlock()
+
+	// summarise what changed, comparing the configured (exported) fields
+	oldConfig := make(map[channelId]string)
+	for _, c := range old {
+		y, _ := yaml.Marshal(c)
+		oldConfig[c.ID] = string(y)
+	}
+	var added, removed, changed []string
+	for _, c := range channels {
+		y, _ := yaml.Marshal(c)
+		oc, ok := oldConfig[c.ID]
+		switch {
+		case !ok:
+			added = append(added, string(c.ID))
+		case oc != string(y):
+			changed = append(changed, string(c.ID))
+		}
+		delete(oldConfig, c.ID)
+	}
+	for cId := range oldConfig {
+		removed = append(removed, string(cId))
+	}
+	log.Info().
+		Int("channels", len(channels)).
+		Strs("added", added).
+		Strs("removed", removed).
+		Strs("changed", changed).
+		Msg("reloaded channels")
+
+	return nil
+}
+
+// deliverQueued delivers the posts waiting in the outbox for a channel, or
+// for every channel if cId is empty, unless it's quiet hours
+func (b *bot) deliverQueued(ctx context.Context, cId channelId) {
+	if b.settings.quietHours.contains(time.Now()) || ctx.Err() != nil {
+		return
+	}
+	err := b.deliver(ctx, cId)
+	if err != nil {
+		log.Error().AnErr("err", err).Msg("error delivering queued posts")
+		b.stats.errors = append(b.stats.errors, err)
+	}
+}
+
+// runCycle checks every channel for new videos, then cleans up the database.
+// It returns early if ctx is cancelled.
+func (b *bot) runCycle(ctx context.Context) {
+	b.stats = cycleStats{}
+	b.runId = newRunId()
+	log.Debug().Str("run_id", b.runId).Msg("starting cycle")
+
+	// whatever went wrong is rolled up into one alert at the end
+	defer b.sendAlerts(ctx)
+
+	// refresh cached channel details once they're a day old
+	err := b.refreshChannels(ctx)
+	if err != nil {
+		log.Error().AnErr("err", err).Msg("error refreshing channel details")
+		b.stats.errors = append(b.stats.errors, err)
+	}
+
+	// post anything left in the outbox, e.g. during quiet hours, once they're over
+	b.deliverQueued(ctx, "")
+
+	// for each tracked channel...
+	quotaWarned := false
+	var tripped []string
+	for _, c := range b.currentChannels() {
+		// channels removed by a reload part way through the cycle aren't checked
+		if !b.isMonitored(c.ID) || (b.only != "" && c.ID != b.only) {
+			continue
+		}
+		if ctx.Err() != nil {
+			b.stats.channels = append(b.stats.channels, channelResult{channel: c, notProcessed: true})
+			continue
+		}
+
+		// skip channels that keep failing, apart from a daily retry to see if they've recovered
+		if b.settings.maxFailures > 0 && !b.force {
+			s, err := channelBreaker(b.db, c.ID)
+			if err != nil {
+				log.Error().AnErr("err", err).Str("channel_id", string(c.ID)).Msg("error querying db for channel failures")
+			} else if s.tripped() && time.Now().Before(s.retryAt()) {
+				tripped = append(tripped, string(c.ID))
+				b.stats.channels = append(b.stats.channels, channelResult{channel: c, tripped: true})
+				continue
+			}
+		}
+
+		// once the day's quota is used up, leave the remaining channels until it resets
+		if b.quota != nil && b.quota.exhausted(b.checkCost(c)) && !quotaWarned {
+			log.Warn().Int("budget", b.quota.budget).Msg("daily API quota budget exhausted, deferring remaining channels until it resets")
+			quotaWarned = true
+		}
+		if quotaWarned {
+			b.stats.channels = append(b.stats.channels, channelResult{channel: c, quotaDeferred: true})
+			continue
+		}
+
+		// one channel failing doesn't stop the others being checked
+		posted, notModified := b.stats.posted, b.stats.notModified
+		checked, err := b.checkChannel(ctx, c)
+		interrupted := ctx.Err() != nil && errors.Is(err, ctx.Err())
+		if interrupted {
+			err = nil
+		}
+		quotaDeferred := errors.Is(err, errQuotaExhausted)
+		if quotaDeferred {
+			log.Warn().Int("budget", b.quota.budget).Msg("daily API quota budget exhausted, deferring remaining channels until it resets")
+			quotaWarned = true
+			err = nil
+		}
+		if err != nil {
+			log.Error().AnErr("err", err).Str("channel_name", string(c.displayName())).Str("channel_id", string(c.ID)).Msg("error checking channel")
+		}
+		if b.settings.maxFailures > 0 {
+			b.updateBreaker(c, checked, err)
+		}
+		b.deliverQueued(ctx, c.ID)
+		b.stats.channels = append(b.stats.channels, channelResult{
+			channel:       c,
+			checked:       checked,
+			posted:        b.stats.posted - posted,
+			err:           err,
+			interrupted:   interrupted,
+			quotaDeferred: quotaDeferred,
+			notModified:   b.stats.notModified > notModified,
+		})
+	}
+
+	// whatever is still queued once the post limit is reached waits for a later run
+	if !b.canPost() {
+		posts, err := b.outboxPosts("")
+		if err != nil {
+			log.Error().AnErr("err", err).Msg("error reading outbox")
+		}
+		b.stats.deferred = len(posts)
+	}
+
+	if len(tripped) > 0 {
+		log.Warn().Strs("channel_ids", tripped).Msg("skipped channels that keep failing, see ytbot channel list")
+	}
+
+	// post the digest once it's due, unless it's quiet hours or only one channel was checked
+	if b.settings.digest != "" && b.only == "" && ctx.Err() == nil && !b.settings.quietHours.contains(time.Now()) {
+		err := b.sendDigest(ctx)
+		if err != nil {
+			log.Error().AnErr("err", err).Msg("error sending digest")
+			b.stats.errors = append(b.stats.errors, err)
+		}
+	}
+
+	// post the report once it's due, unless it's quiet hours or only one channel was checked
+	if b.settings.report != "" && b.only == "" && ctx.Err() == nil && !b.settings.quietHours.contains(time.Now()) {
+		err := b.sendReport(ctx)
+		if err != nil {
+			log.Error().AnErr("err", err).Msg("error sending report")
+			b.stats.errors = append(b.stats.errors, err)
+		}
+	}
+
+	// keep the titles in recent posts up to date, unless only one channel was checked
+	if b.settings.trackTitleChanges && b.service != nil && b.only == "" && ctx.Err() == nil && !quotaWarned {
+		err := b.updateTitles(ctx)
+		if err != nil {
+			log.Error().AnErr("err", err).Msg("error updating changed titles")
+			b.stats.errors = append(b.stats.errors, err)
+		}
+	}
+
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		log.Error().Msg("max runtime exceeded, not checking remaining channels")
+		b.stats.timedOut = true
+	case ctx.Err() != nil:
+		log.Info().Msg("shutting down, not checking remaining channels")
+	}
+
+	postedKey := "posted"
+	if b.dryRun {
+		postedKey = "would_post"
+	}
+	log.Info().
+		Str("run_id", b.runId).
+		Int(postedKey, b.stats.posted).
+		Int("deferred", b.stats.deferred).
+		Int("not_modified", b.stats.notModified).
+		Int("skipped_age_restricted", b.stats.skippedAgeRestricted).
+		Int("errors", len(b.stats.errors)).
+		Msg("finished checking channels:\n" + b.stats.summary(b.dryRun))
+	if ctx.Err() != nil {
+		return
+	}
+
+	// clean up database, keeping videos for --retention
+	log.Debug().Msg("cleaning db")
+	counts, err := b.db.Prune(b.settings.dbRetention())
+	if err != nil {
+		log.Error().AnErr("err", err).Msg("error cleaning db")
+		b.stats.errors = append(b.stats.errors, err)
+	}
+	for _, c := range counts {
+		log.Debug().Str("table", c.Table).Int64("rows", c.Rows).Msg("removed old records from db")
+	}
+}
+
+// checkChannel looks for new videos on a channel and posts them, returning
+// whether the channel was due to be checked. Once ctx is cancelled, a video
+// that is being posted is finished but no more are started.
+func (b *bot) checkChannel(ctx context.Context, c channel) (checked bool, err error) {
+	cN, cId := c.displayName(), c.ID
+
+	// skip disabled channels before doing anything else
+	if !c.enabled() && !b.force {
+		log.Debug().Str("channel_name", string(cN)).Str("channel_id", string(cId)).Msg("channel disabled, skipping")
+		return false, nil
+	}
+
+	log := log.With().
+		Str("channel_name", string(cN)).
+		Str("channel_id", string(cId)).
+		Logger()
+
+	// work out where this channel's videos get posted
+	webhooks, destination := b.webhooksFor(c)
+	if len(webhooks) == 0 {
+		return false, fmt.Errorf("no %s webhook set", destination)
+	}
+	err = webhooks.validate()
+	if err != nil {
+		return false, fmt.Errorf("invalid %s webhook: %w", destination, err)
+	}
+	if c.MinViews > 0 && b.service == nil {
+		return false, errors.New("min_views needs --apikey to look up view counts")
+	}
+	if c.Streams && b.service == nil {
+		return false, errors.New("streams needs --apikey to search for upcoming streams")
+	}
+	log = log.With().
+		Str("destination", destination).
+		Strs("webhooks", redactWebhooks(webhooks)).
+		Logger()
+
+	// check if channel is due to be checked, comparing its check time with
+	// now rather than relying on old check times having been cleaned up
+	interval := b.checkInterval(c)
+	ct, checkedBefore, err := b.db.CheckTime(string(cId))
+	if err != nil {
+		return false, fmt.Errorf("error querying db: %w", err)
+	}
+	firstCheck := !checkedBefore
+	if checkedBefore {
+		dueAt := checkDueAt(ct, interval)
+		if time.Now().Before(dueAt) && !b.force {
+			log.Debug().Time("next_check_at", dueAt).Dur("check_interval", interval).Msg("channel not due to be checked, skipping")
+			return false, nil
+		}
+	}
+
+	// published videos within the channel's lookback window, or since the
+	// newest video seen if that's longer ago, e.g. after downtime
+	publishedAfter := time.Now().Add(-c.Lookback)
+	if !ct.LastSeenAt.IsZero() {
+		publishedAfter = catchUpFrom(ct.LastSeenAt, c.Lookback, time.Now())
+	}
+	log = log.With().Time("cutoff_date", publishedAfter).Logger()
+
+	log.Info().Msg("checking for new videos")
+
+	// forget the feed's caching headers if the check fails, so the next check
+	// fetches the whole feed rather than being told it hasn't changed
+	defer func() {
+		if err != nil && (b.settings.source == sourceRSS || c.isPeerTube()) {
+			dbErr := forgetFeedCache(b.db, cId)
+			if dbErr != nil {
+				log.Error().AnErr("err", dbErr).Msg("error clearing feed cache in db")
+			}
+		}
+	}()
+
+	// a playlist's whole backlog is new on its first check, so it's all
+	// fetched to be recorded, rather than the rest being posted next check
+	limit := b.settings.maxResults
+	if firstCheck && c.isPlaylist() && c.BackfillMode != backfillModePost {
+		limit = math.MaxInt
+	}
+
+	// find scheduled streams first, so they're announced before the channel's
+	// videos and aren't posted as premieres
+	var streams []video
+	if c.Streams {
+		streams, err = b.upcomingStreams(ctx, c)
+		if ctx.Err() != nil {
+			return false, ctx.Err()
+		}
+		if err != nil {
+			return true, err
+		}
+	}
+
+	// Make the API calls to YouTube.
+	videos, etag, err := b.recentVideos(ctx, c, publishedAfter, limit)
+	if ctx.Err() != nil {
+		return false, ctx.Err()
+	}
+	notModified := errors.Is(err, errNotModified)
+	if err != nil && !notModified {
+		return true, err
+	}
+
+	// look again at videos that hadn't reached min_views on earlier checks,
+	// whether or not they were found this time
+	if c.MinViews > 0 && !notModified {
+		videos, err = b.withViewCandidates(ctx, c, videos)
+		if ctx.Err() != nil {
+			return false, ctx.Err()
+		}
+		if err != nil {
+			return true, err
+		}
+	}
+
+	// fetch the details needed to check shorts, premieres, views and video
+	// status, and to link to shorts with --url-style auto, in one go, rather
+	// than a call per video. Videos left without them aren't posted this check.
+	if b.service != nil && !c.isPeerTube() {
+		videos, err = withDetails(ctx, b.service, videos, func(v video) bool {
+			return c.skipShorts || b.settings.skipAgeRestricted || c.MinViews > 0 || b.settings.urlStyle == urlStyleAuto || v.LiveBroadcastContent == broadcastUpcoming || (v.PrivacyStatus == "" && b.settings.verifyBeforePost)
+		})
+		if ctx.Err() != nil {
+			return false, ctx.Err()
+		}
+		if err != nil {
+			log.Error().AnErr("err", err).Msg("error getting video details")
+		}
+	}
+
+	// oldest first, so videos are queued, and so posted, in order
+	sort.SliceStable(videos, func(i, j int) bool {
+		return videos[i].PublishedAt < videos[j].PublishedAt
+	})
+
+	// work out the colour of the channel's embeds before the transaction is
+	// begun, as it may download a thumbnail to do it. Streams are announced
+	// first, so their thumbnails are tried first.
+	c.embedColor = b.embedColor(ctx, c, append(append([]video(nil), streams...), videos...))
+
+	// ask which of a new channel's videos to post before the transaction is
+	// begun, so the database isn't held while waiting for an answer
+	var backfill map[string]bool
+	if firstCheck && c.BackfillMode == backfillModeAsk && !notModified {
+		backfill, err = b.askBackfills(c, videos)
+		if err != nil {
+			return true, err
+		}
+	}
+
+	// everything the check finds is written in one transaction, committed
+	// once the channel's videos have all been dealt with, so a check that
+	// fails or is cut short by a crash, shutdown or --max-runtime leaves the
+	// channel as it was and it's checked again next run
+	tx, err := b.db.Begin()
+	if err != nil {
+		return true, fmt.Errorf("error starting db transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// put in db, scheduling the next check in the channel's slot. This is only
+	// once the channel's videos have been fetched, so a channel whose API
+	// calls fail is still due and is checked again next run.
+	err = tx.SetCheckTime(string(cId), nextCheckAt(cId, interval, time.Now()))
+	if err != nil {
+		return true, fmt.Errorf("error updating channel check time in db: %w", err)
+	}
+
+	if c.Streams {
+		err = b.announceStreams(ctx, tx, c, streams, webhooks, destination, firstCheck)
+		if err != nil {
+			return true, err
+		}
+	}
+
+	if notModified {
+		log.Debug().Msg("videos not modified since last check, skipping")
+		b.stats.notModified++
+		err = tx.Commit()
+		if err != nil {
+			return true, fmt.Errorf("error committing db transaction: %w", err)
+		}
+		return true, nil
+	}
+
+	// Iterate through each item, noting the first that is left for a later run
+	// and whether any are waiting for views
+	waitingForViews := false
+	heldFrom := len(videos)
+	for i, v := range videos {
+		if ctx.Err() != nil {
+			return false, ctx.Err()
+		}
+
+		log := log.With().
+			Str("video_id", v.ID).
+			Str("title", v.Title).
+			Str("live_broadcast_content", v.LiveBroadcastContent).
+			Logger()
+
+		log.Debug().Msg("found item")
+
+		// remember when the channel uploads, for --adaptive-interval
+		if v.LiveBroadcastContent == broadcastNone && !c.isPlaylist() && c.search == nil {
+			err = recordUpload(tx, cId, v)
+			if err != nil {
+				return true, fmt.Errorf("error recording upload in db: %w", err)
+			}
+		}
+
+		// check title filters
+		if reason := c.filterTitle(v.Title); reason != "" {
+			log.Debug().Str("reason", reason).Msg("item filtered")
+			continue
+		}
+
+		// check if item has already been posted
+		postedType, posted, err := tx.IsPosted(v.ID)
+		if err != nil {
+			return true, fmt.Errorf("error querying db: %w", err)
+		}
+		if posted && v.ID == b.repost {
+			log.Info().Str("post_type", postedType).Msg("item already posted, reposting")
+			posted = false
+		}
+		premiereStarted := posted && postedType == postTypePremiere && v.LiveBroadcastContent != broadcastUpcoming
+		if posted && !premiereStarted {
+			log.Debug().Str("post_type", postedType).Msg("item already posted")
+			continue
+		}
+
+		// don't flood discord with the history of a newly added channel
+		if firstCheck && c.BackfillMode != backfillModePost {
+			if c.BackfillMode != backfillModeAsk || !backfill[v.ID] {
+				log.Info().Str("backfill_mode", c.BackfillMode).Msg("skipping video found on channel's first check")
+				err = recordVideo(tx, v.ID, postTypeSkipped)
+				if err != nil {
+					return true, fmt.Errorf("error inserting video into db: %w", err)
+				}
+				continue
+			}
+		}
+
+		// give creators time to replace a botched upload before posting it
+		if b.settings.minVideoAge > 0 && v.LiveBroadcastContent == broadcastNone {
+			published, err := time.Parse(time.RFC3339, v.PublishedAt)
+			if err != nil {
+				log.Warn().AnErr("err", err).Str("published_at", v.PublishedAt).Msg("error parsing video publish time, not waiting")
+			} else if age := time.Since(published); age < b.settings.minVideoAge {
+				log.Info().Dur("wait", b.settings.minVideoAge-age).Msg("video too new, will post on a later run")
+				heldFrom = min(heldFrom, i)
+				continue
+			}
+		}
+
+		// check global keyword blocklist
+		if k := blockedKeyword(v.Title, b.settings.blockKeywords); k != "" {
+			log.Info().Str("keyword", k).Msg("skipping item with blocked keyword in title")
+			err = recordVideo(tx, v.ID, postTypeSkipped)
+			if err != nil {
+				return true, fmt.Errorf("error inserting video into db: %w", err)
+			}
+			continue
+		}
+
+		// skip age-restricted videos, which only YouTube videos can be
+		if b.settings.skipAgeRestricted && !c.isPeerTube() {
+			if !v.HasDetails {
+				log.Error().Msg("video age restriction not known, not posting")
+				heldFrom = min(heldFrom, i)
+				continue
+			}
+			if v.AgeRestricted {
+				log.Debug().Str("reason", "age restricted").Msg("skipping age-restricted video")
+				err = recordVideo(tx, v.ID, postTypeSkipped)
+				if err != nil {
+					return true, fmt.Errorf("error inserting video into db: %w", err)
+				}
+				b.stats.skippedAgeRestricted++
+				continue
+			}
+		}
+
+		// spot videos deleted and uploaded again, e.g. to fix the audio
+		reupload := false
+		if b.settings.reuploadAction != reuploadActionNone && !premiereStarted {
+			originalId, similarity, err := findReupload(tx, cId, v)
+			if err != nil {
+				return true, fmt.Errorf("error querying db: %w", err)
+			}
+			if originalId != "" {
+				log := log.With().Str("original_video_id", originalId).Float64("similarity", similarity).Logger()
+				if b.settings.reuploadAction == reuploadActionSkip {
+					log.Info().Msg("skipping likely re-upload of a recently posted video")
+					err = recordVideo(tx, v.ID, postTypeSkipped)
+					if err != nil {
+						return true, fmt.Errorf("error inserting video into db: %w", err)
+					}
+					continue
+				}
+				log.Info().Msg("likely re-upload of a recently posted video, annotating post")
+				reupload = true
+			}
+		}
+
+		// wait for videos to gain some traction before posting them, without
+		// holding up newer ones as they're looked at again from view_candidates
+		if c.MinViews > 0 && !premiereStarted {
+			if !v.HasDetails {
+				log.Error().Msg("video view count not known, not posting")
+				heldFrom = min(heldFrom, i)
+				continue
+			}
+			if v.ViewCount < c.MinViews {
+				published, err := time.Parse(time.RFC3339, v.PublishedAt)
+				if err == nil && time.Since(published) > b.settings.minViewsMaxAge {
+					log.Info().Int64("views", v.ViewCount).Int64("min_views", c.MinViews).Msg("video didn't reach min_views in time, giving up")
+					err = giveUpViewCandidate(tx, cId, v.ID)
+					if err != nil {
+						return true, err
+					}
+					continue
+				}
+				log.Debug().Int64("views", v.ViewCount).Int64("min_views", c.MinViews).Msg("video hasn't reached min_views, will look again next check")
+				err = recordViewCandidate(tx, cId, v)
+				if err != nil {
+					return true, fmt.Errorf("error recording video waiting for views in db: %w", err)
+				}
+				waitingForViews = true
+				continue
+			}
+		}
+
+		// work out how to post, applying the channel's live stream policy
+		messageTemplate, postType := c.messageTemplate, postTypeVideo
+		switch {
+		case premiereStarted:
+			// only say it's live if it still is, not if it has already finished
+			if v.LiveBroadcastContent != broadcastLive || (!b.settings.premiereLiveMessage && c.Live != livePolicyAnnounce) {
+				log.Debug().Msg("premiere already announced")
+				err = recordVideo(tx, v.ID, postTypeVideo)
+				if err != nil {
+					return true, fmt.Errorf("error updating video in db: %w", err)
+				}
+				continue
+			}
+			log.Info().Msg("announced premiere has started")
+			messageTemplate = b.settings.liveMessageTemplate
+
+		case v.LiveBroadcastContent == broadcastUpcoming && c.Streams && v.Duration == 0:
+			log.Debug().Msg("leaving scheduled stream to be announced as a stream")
+			continue
+
+		case v.LiveBroadcastContent == broadcastUpcoming:
+			if c.Live == livePolicyExclude {
+				log.Debug().Msg("skipping stream that isn't live yet")
+				continue
+			}
+			if v.ScheduledStart.IsZero() {
+				log.Error().Msg("premiere start time not known, not posting")
+				heldFrom = min(heldFrom, i)
+				continue
+			}
+			messageTemplate, postType = b.settings.premiereMessageTemplate, postTypePremiere
+
+		case v.LiveBroadcastContent == broadcastLive && c.Live == livePolicyExclude:
+			log.Info().Msg("skipping live stream")
+			err = recordVideo(tx, v.ID, postTypeSkipped)
+			if err != nil {
+				return true, fmt.Errorf("error inserting video into db: %w", err)
+			}
+			continue
+
+		case v.LiveBroadcastContent == broadcastLive && c.Live == livePolicyAnnounce:
+			messageTemplate = b.settings.liveMessageTemplate
+		}
+
+		// skip shorts
+		if c.skipShorts && (b.service == nil || c.isPeerTube()) {
+			// without the API all we know is whether the feed linked to the video as a short
+			if v.Short {
+				log.Info().Msg("skipping short")
+				err = recordVideo(tx, v.ID, postTypeSkipped)
+				if err != nil {
+					return true, fmt.Errorf("error inserting video into db: %w", err)
+				}
+				continue
+			}
+		} else if c.skipShorts {
+			if !v.HasDetails {
+				log.Error().Msg("video duration not known, not posting")
+				heldFrom = min(heldFrom, i)
+				continue
+			}
+			// live streams and premieres have no duration (P0D) yet
+			if v.Duration > 0 && v.Duration <= b.settings.shortsMaxDuration {
+				log.Info().Dur("duration", v.Duration).Msg("skipping short")
+				err = recordVideo(tx, v.ID, postTypeSkipped)
+				if err != nil {
+					return true, fmt.Errorf("error inserting video into db: %w", err)
+				}
+				continue
+			}
+		}
+
+		// queue video
+		log.Info().Msg("queueing item")
+
+		url := b.videoURL(v)
+		content, err := c.renderMessage(messageTemplate, v, url)
+		if err != nil {
+			return true, fmt.Errorf("error rendering message template: %w", err)
+		}
+		var annotation string
+		if reupload {
+			annotation = reuploadAnnotation
+			content += " " + annotation
+		}
+		payload := c.payload(content, v, url)
+		payload.Render = c.messageRender(messageTemplate, v, url, annotation, payload)
+		itemWebhooks, itemDestination := b.routeItem(log, c, v, webhooks, destination)
+		if itemDestination != destination {
+			// the live webhooks aren't the route's, so may not be a forum
+			payload.ThreadName = ""
+		}
+
+		// search results are noisy, so searches can be limited to a few posts a day
+		if c.search != nil && c.search.maxPostsPerDay > 0 {
+			n, err := searchPostsToday(tx, cId)
+			if err != nil {
+				return true, fmt.Errorf("error querying db: %w", err)
+			}
+			if n >= c.search.maxPostsPerDay {
+				log.Info().Int("max_posts_per_day", c.search.maxPostsPerDay).Msg("search's daily post limit reached, leaving item for tomorrow")
+				heldFrom = min(heldFrom, i)
+				continue
+			}
+		}
+
+		// don't post a dead link to a video made private or deleted since it was found
+		if v.PrivacyStatus == "" && b.settings.verifyBeforePost && b.service != nil {
+			log.Error().Msg("video status not known, not posting")
+			heldFrom = min(heldFrom, i)
+			continue
+		}
+		if v.PrivacyStatus != "" {
+			if reason := notPostableReason(v, v.PrivacyStatus, v.UploadStatus); reason != "" {
+				log.Info().Str("reason", reason).Msg("skipping video that isn't public")
+				err = recordVideo(tx, v.ID, postTypeSkippedPrivate)
+				if err != nil {
+					return true, fmt.Errorf("error inserting video into db: %w", err)
+				}
+				continue
+			}
+		}
+
+		// collect uploads for the digest rather than posting them, apart from
+		// live streams and premieres which can't wait for it
+		if c.digest && !v.isLive() {
+			log.Info().Msg("adding item to digest")
+			err = b.addToDigest(tx, c, v, url, itemWebhooks)
+			if err != nil {
+				return true, err
+			}
+		} else {
+			// queue it to be delivered after the check
+			err = b.enqueue(tx, pendingPost{
+				VideoID:       v.ID,
+				ChannelID:     cId,
+				Content:       content,
+				MentionRoleId: c.MentionRoleId,
+				Embeds:        payload.Embeds,
+				ThreadName:    payload.ThreadName,
+				Flags:         payload.Flags,
+				PostType:      postType,
+				Title:         v.Title,
+				Event:         c.videoEvent(v, url, postType),
+				Render:        payload.Render,
+			}, itemWebhooks)
+			if err != nil {
+				return true, err
+			}
+		}
+		err = recordVideoDetails(tx, c, v)
+		if err != nil {
+			return true, fmt.Errorf("error recording video details in db: %w", err)
+		}
+		if c.search != nil {
+			err = recordSearchPost(tx, cId, v.ID)
+			if err != nil {
+				return true, fmt.Errorf("error recording search post in db: %w", err)
+			}
+		}
+	}
+
+	// remember the newest video dealt with, up to the first one left for a
+	// later run, so the next check looks back at least that far
+	var newest time.Time
+	for _, v := range videos[:heldFrom] {
+		t, err := time.Parse(time.RFC3339, v.PublishedAt)
+		if err == nil && t.After(newest) {
+			newest = t
+		}
+	}
+	if !newest.IsZero() {
+		err = tx.SetLastSeen(string(cId), newest)
+		if err != nil {
+			return true, fmt.Errorf("error updating channel last seen time in db: %w", err)
+		}
+	}
+
+	// remember which of a playlist's videos have been dealt with, apart from
+	// upcoming premieres which are looked at again until they've started
+	if c.isPlaylist() {
+		for _, v := range videos[:heldFrom] {
+			if v.LiveBroadcastContent == broadcastUpcoming {
+				continue
+			}
+			err = tx.MarkPlaylistItemSeen(string(cId), v.ID)
+			if err != nil {
+				return true, fmt.Errorf("error recording playlist item in db: %w", err)
+			}
+		}
+	}
+
+	// only skip the channel's unchanged videos next check if none of them need
+	// looking at again: left for a later run, or premieres and streams that
+	// might still start or end
+	if etag != "" {
+		final := heldFrom == len(videos) && !waitingForViews
+		for _, v := range videos {
+			if v.LiveBroadcastContent == broadcastUpcoming || v.LiveBroadcastContent == broadcastLive {
+				final = false
+			}
+		}
+		if final {
+			err = saveListEtag(tx, cId, etag)
+		} else {
+			err = forgetListEtag(tx, cId)
+		}
+		if err != nil {
+			return true, fmt.Errorf("error updating etag in db: %w", err)
+		}
+	}
+
+	// videos waiting for views that have now been posted or skipped are done with
+	if c.MinViews > 0 {
+		err = forgetViewCandidates(tx, cId)
+		if err != nil {
+			return true, fmt.Errorf("error deleting videos waiting for views from db: %w", err)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return true, fmt.Errorf("error committing db transaction: %w", err)
+	}
+	return true, nil
+}
+
+// kinds of item, for routing them to webhooks
+const (
+	itemClassUpload = "upload" // an uploaded video
+	itemClassLive   = "live"   // a live stream or premiere, upcoming or live now
+)
+
+// routeItem returns the webhooks an item is posted to, and where they were
+// configured: the channel's live_webhook or --live-webhook for live streams
+// and premieres, otherwise the channel's usual webhooks. The decision is
+// logged, so misroutes can be diagnosed.
+func (b *bot) routeItem(log zerolog.Logger, c channel, v video, webhooks webhookList, destination string) (webhookList, string) {
+	class := itemClassUpload
+	if v.isLive() {
+		class = itemClassLive
+	}
+	routing := "usual webhook"
+	switch {
+	case class != itemClassLive:
+	case len(c.LiveWebhook) > 0:
+		webhooks, destination, routing = c.LiveWebhook, "channel live_webhook", "live webhook"
+	case len(b.settings.liveWebhooks) > 0:
+		webhooks, destination, routing = b.settings.liveWebhooks, "global live_webhook", "live webhook"
+	default:
+		routing = "usual webhook, no live webhook set"
+	}
+	log.Debug().
+		Str("classification", class).
+		Str("routing", routing).
+		Str("destination", destination).
+		Strs("webhooks", redactWebhooks(webhooks)).
+		Msg("routing item")
+	return webhooks, destination
+}
+
+// webhooksFor returns the webhooks a channel's videos are posted to, and
+// where they were configured: for the channel, by one of its tags, or globally
+func (b *bot) webhooksFor(c channel) (webhooks webhookList, destination string) {
+	switch {
+	case len(c.Webhook) > 0:
+		return c.Webhook, "channel"
+	case c.routeTag != "":
+		return c.routeWebhooks, "tag:" + c.routeTag
+	}
+	return b.settings.webhooks, "global"
+}
+
+// videoURL returns the link posted for a video, in --url-style
+func (b *bot) videoURL(v video) string {
+	return v.styledURL(b.settings.urlStyle, b.settings.shortsMaxDuration)
+}
+
+// messageData returns the data about one of the channel's videos made
+// available to message templates, linking to it with url
+func (c channel) messageData(v video, url string) messageData {
+	d := v.messageData()
+	d.URL = url
+	d.PublishedTimestamp = discordTimestamp(v.publishedTime(), c.timestampStyle)
+	// a link in <> isn't previewed, but embeds link to the video themselves
+	if c.suppressEmbeds && c.postStyle != postStyleEmbed {
+		d.URL = "<" + url + ">"
+	}
+	switch {
+	case c.isPlaylist():
+		d.PlaylistTitle = string(c.displayName())
+	case c.title != "":
+		d.ChannelTitle = string(c.title)
+	}
+	return d
+}
+
+// renderMessage renders the message posted for one of the channel's videos,
+// shortening the video's title if the message would be too long for Discord
+func (c channel) renderMessage(t *template.Template, v video, url string) (string, error) {
+	return renderFitting(t, c.messageData(v, url), c.Prefix, messageLimit(c.MentionRoleId))
+}
+
+// messageLimit returns how long a rendered message can be, leaving room for
+// the role mention and re-upload annotation added later
+func messageLimit(roleId string) int {
+	limit := discordMaxContent - utf8.RuneCountInString(" "+reuploadAnnotation)
+	if roleId != "" {
+		limit -= utf8.RuneCountInString(fmt.Sprintf("<@&%s> ", roleId))
+	}
+	return limit
+}
+
+// renderFitting renders a message from d, after prefix, shortening the title
+// for the message to be at most limit characters
+func renderFitting(t *template.Template, d messageData, prefix string, limit int) (string, error) {
+	title := []rune(d.Title)
+	for {
+		content, err := renderMessage(t, d.escaped())
+		if err != nil {
+			return "", err
+		}
+		if prefix != "" {
+			content = prefix + " " + content
+		}
+		over := utf8.RuneCountInString(content) - limit
+		if over <= 0 {
+			return content, nil
+		}
+		if len(title) == 0 || !strings.Contains(content, escapeMarkdown(d.Title)) {
+			return "", fmt.Errorf("message is over Discord's %d character limit", discordMaxContent)
+		}
+
+		// keep as much of the title as fits once escaped, with room for the ellipsis
+		room := utf8.RuneCountInString(escapeMarkdown(d.Title)) - over - 1
+		n := 0
+		for ; n < len(title); n++ {
+			room -= utf8.RuneCountInString(escapeMarkdown(string(title[n])))
+			if room < 0 {
+				break
+			}
+		}
+		title = title[:n]
+		d.Title = string(title) + "…"
+	}
+}
+
+// sleepContext sleeps for d, returning false if ctx is cancelled first
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// askBackfills asks which of the videos found on a channel's first check to
+// post, returning the answer for each. Videos the check won't post anyway,
+// filtered out or already posted, aren't asked about.
+func (b *bot) askBackfills(c channel, videos []video) (map[string]bool, error) {
+	post := make(map[string]bool)
+	for _, v := range videos {
+		if c.filterTitle(v.Title) != "" {
+			continue
+		}
+		postedType, posted, err := b.db.IsPosted(v.ID)
+		if err != nil {
+			return nil, fmt.Errorf("error querying db: %w", err)
+		}
+		premiereStarted := posted && postedType == postTypePremiere && v.LiveBroadcastContent != broadcastUpcoming
+		if posted && v.ID != b.repost && !premiereStarted {
+			continue
+		}
+		post[v.ID] = askBackfill(v)
+	}
+	return post, nil
+}
+
+// stdin reads answers to questions asked on the terminal. It's shared, so an
+// answer typed ahead isn't lost with the buffer of a reader for an earlier one.
+var stdin = bufio.NewReader(os.Stdin)
+
+// askBackfill asks on the terminal whether to post a video found on a
+// channel's first check. Without a terminal to ask on, the answer is no.
+func askBackfill(v video) bool {
+	fi, err := os.Stdin.Stat()
+	if err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+		log.Warn().Str("video_id", v.ID).Msg("backfill mode is ask but stdin is not a terminal, not posting")
+		return false
+	}
+	fmt.Fprintf(os.Stderr, "Post %q from %s (%s)? [y/N] ", v.Title, v.ChannelTitle, v.URL())
+	answer, _ := stdin.ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
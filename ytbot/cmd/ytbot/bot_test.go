@@ -0,0 +1,30 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCycleStatsHealthy(t *testing.T) {
+	failed := channelResult{err: errors.New("error listing uploads playlist")}
+	checked := channelResult{checked: true}
+	tests := []struct {
+		name  string
+		stats cycleStats
+		want  bool
+	}{
+		{"nothing due", cycleStats{}, true},
+		{"all checked", cycleStats{channels: []channelResult{checked, checked}}, true},
+		{"some failed", cycleStats{channels: []channelResult{checked, failed}}, true},
+		{"all failed", cycleStats{channels: []channelResult{failed, failed}}, false},
+		{"error outside channels", cycleStats{errors: []error{errors.New("error refreshing channel details")}}, false},
+		{"timed out", cycleStats{channels: []channelResult{checked}, timedOut: true}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.stats.healthy(); got != tt.want {
+				t.Errorf("healthy() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
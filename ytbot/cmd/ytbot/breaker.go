@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"pw-ytbot/store"
+)
+
+// breakerRetryInterval is how often a channel tripped by repeated failures is
+// checked to see if it has recovered
+const breakerRetryInterval = 24 * time.Hour
+
+// breakerState is a channel's run of consecutive failed checks
+type breakerState struct {
+	failures  int
+	lastError string
+	trippedAt time.Time // zero unless the channel is tripped
+}
+
+// tripped returns whether the channel is being skipped after repeated failures
+func (s breakerState) tripped() bool {
+	return !s.trippedAt.IsZero()
+}
+
+// retryAt returns when a tripped channel is next checked
+func (s breakerState) retryAt() time.Time {
+	return s.trippedAt.Add(breakerRetryInterval)
+}
+
+// String describes the state for channel list
+func (s breakerState) String() string {
+	switch {
+	case s.tripped():
+		return fmt.Sprintf("tripped after %d failures, retry at %s", s.failures, s.retryAt().Format(time.DateTime))
+	case s.failures > 0:
+		return fmt.Sprintf("%d failures", s.failures)
+	}
+	return "ok"
+}
+
+// channelBreaker returns a channel's breaker state
+func channelBreaker(db store.Queries, cId channelId) (breakerState, error) {
+	f, err := db.ChannelFailures(string(cId))
+	return breakerState{failures: f.Failures, lastError: f.LastError, trippedAt: f.TrippedAt}, err
+}
+
+// recordChannelFailure counts a failed check of a channel, tripping its
+// breaker once it has failed threshold times in a row, or again if it's
+// still failing when retried. It returns the channel's new state.
+func recordChannelFailure(db store.Queries, cId channelId, checkErr error, threshold int) (breakerState, error) {
+	s, err := channelBreaker(db, cId)
+	if err != nil {
+		return s, err
+	}
+	s.failures++
+	s.lastError = checkErr.Error()
+	if s.failures >= threshold {
+		s.trippedAt = time.Now().UTC().Truncate(time.Second)
+	}
+	err = db.SetChannelFailures(string(cId), store.ChannelFailures{Failures: s.failures, LastError: s.lastError, TrippedAt: s.trippedAt})
+	return s, err
+}
+
+// resetChannelBreaker forgets a channel's failures, returning whether it had any
+func resetChannelBreaker(db store.Queries, cId channelId) (bool, error) {
+	return db.ResetChannelFailures(string(cId))
+}
+
+// updateBreaker counts a channel's failed check towards tripping its breaker,
+// or clears its failures once a check succeeds
+func (b *bot) updateBreaker(c channel, checked bool, checkErr error) {
+	log := log.With().Str("channel_name", string(c.displayName())).Str("channel_id", string(c.ID)).Logger()
+	switch {
+	case checkErr != nil:
+		s, err := recordChannelFailure(b.db, c.ID, checkErr, b.settings.maxFailures)
+		if err != nil {
+			log.Error().AnErr("err", err).Msg("error recording channel failure in db")
+			return
+		}
+		if s.tripped() {
+			log.Warn().Int("failures", s.failures).Time("retry_at", s.retryAt()).Msg("channel keeps failing, skipping it until it's retried")
+		}
+	case checked:
+		cleared, err := resetChannelBreaker(b.db, c.ID)
+		if err != nil {
+			log.Error().AnErr("err", err).Msg("error clearing channel failures in db")
+			return
+		}
+		if cleared {
+			log.Info().Msg("channel checked successfully, cleared its failures")
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/urfave/cli/v2"
+
+	"pw-ytbot/store"
+)
+
+const (
+	// cadenceUploads is how many of each channel's most recent uploads are kept
+	// to work out how often it uploads
+	cadenceUploads = 10
+
+	minAdaptiveInterval = time.Hour
+	maxAdaptiveInterval = 48 * time.Hour
+)
+
+// recordUpload records when a video on a channel was published, keeping only
+// the channel's most recent uploads
+func recordUpload(db store.Queries, cId channelId, v video) error {
+	return db.RecordUpload(string(cId), v.ID, v.PublishedAt, cadenceUploads)
+}
+
+// uploadTimes returns when a channel's recorded uploads were published, oldest first
+func uploadTimes(db store.Queries, cId channelId) ([]time.Time, error) {
+	published, err := db.UploadTimes(string(cId))
+	if err != nil {
+		return nil, err
+	}
+	var times []time.Time
+	for _, s := range published {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			continue
+		}
+		times = append(times, t)
+	}
+	return times, nil
+}
+
+// medianUploadGap returns the median time between consecutive uploads, or
+// false if there aren't enough uploads to tell
+func medianUploadGap(times []time.Time) (time.Duration, bool) {
+	if len(times) < 3 {
+		return 0, false
+	}
+	gaps := make([]time.Duration, 0, len(times)-1)
+	for i := 1; i < len(times); i++ {
+		gaps = append(gaps, times[i].Sub(times[i-1]))
+	}
+	sort.Slice(gaps, func(i, j int) bool { return gaps[i] < gaps[j] })
+	mid := len(gaps) / 2
+	if len(gaps)%2 == 0 {
+		return (gaps[mid-1] + gaps[mid]) / 2, true
+	}
+	return gaps[mid], true
+}
+
+// adaptiveInterval suggests how often to check a channel: half the median
+// time between its uploads, between minAdaptiveInterval and maxAdaptiveInterval
+func adaptiveInterval(times []time.Time) (time.Duration, bool) {
+	gap, ok := medianUploadGap(times)
+	if !ok {
+		return 0, false
+	}
+	return min(max(gap/2, minAdaptiveInterval), maxAdaptiveInterval), true
+}
+
+// checkInterval returns how often a channel should be checked: its adaptive
+// interval if --adaptive-interval is set and there is enough upload history,
+// otherwise its configured check interval
+func (b *bot) checkInterval(c channel) time.Duration {
+	if !b.settings.adaptiveInterval {
+		return c.CheckInterval
+	}
+	times, err := uploadTimes(b.db, c.ID)
+	if err != nil {
+		return c.CheckInterval
+	}
+	if d, ok := adaptiveInterval(times); ok {
+		return d
+	}
+	return c.CheckInterval
+}
+
+// runChannelCadence prints how often each channel uploads and the check
+// interval --adaptive-interval would use for it
+func runChannelCadence(cliContext *cli.Context) error {
+	fileChannels, err := loadChannelsFileFromFlags(cliContext)
+	if err != nil {
+		return err
+	}
+	db, err := openDBFromFlags(cliContext)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	storedChannels, err := dbChannels(db)
+	if err != nil {
+		return err
+	}
+	channels, err := mergeChannels(storedChannels, fileChannels)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tUPLOADS\tMEDIAN GAP\tINTERVAL")
+	for _, c := range channels {
+		if c.search != nil {
+			continue
+		}
+		times, err := uploadTimes(db, c.ID)
+		if err != nil {
+			return err
+		}
+		gap, interval := "-", "-"
+		if d, ok := medianUploadGap(times); ok {
+			gap = d.Round(time.Minute).String()
+		}
+		if d, ok := adaptiveInterval(times); ok {
+			interval = d.Round(time.Minute).String()
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\n", c.ID, c.Name, len(times), gap, interval)
+	}
+	return w.Flush()
+}
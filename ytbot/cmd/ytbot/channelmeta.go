@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/urfave/cli/v2"
+	"google.golang.org/api/youtube/v3"
+
+	"pw-ytbot/store"
+)
+
+// channelMetaMaxAge is how long channel details are cached before they are refreshed
+const channelMetaMaxAge = 24 * time.Hour
+
+// refreshChannelMeta fetches the details of channels and playlists whose
+// cached details in channel_meta are missing or older than channelMetaMaxAge,
+// or of every channel if force is set, returning how many were refreshed
+func refreshChannelMeta(ctx context.Context, db store.Queries, service *youtube.Service, channels []channel, force bool) (int, error) {
+	var stale, stalePlaylists []string
+	for _, c := range channels {
+		if c.search != nil || c.isPeerTube() {
+			continue
+		}
+		fetchedAt, err := db.ChannelMetaFetchedAt(string(c.ID))
+		if err != nil {
+			return 0, fmt.Errorf("error querying db: %w", err)
+		}
+		switch {
+		case !force && !fetchedAt.IsZero() && time.Since(fetchedAt) <= channelMetaMaxAge:
+		case c.isPlaylist():
+			stalePlaylists = append(stalePlaylists, string(c.ID))
+		default:
+			stale = append(stale, string(c.ID))
+		}
+	}
+
+	refreshed := 0
+	for start := 0; start < len(stale); start += maxIdsPerCall {
+		batch := stale[start:min(start+maxIdsPerCall, len(stale))]
+		response, err := service.Channels.List([]string{"id", "snippet", "contentDetails"}).Id(batch...).MaxResults(maxIdsPerCall).Context(ctx).Do()
+		if err != nil {
+			return refreshed, fmt.Errorf("error getting channel details: %w", err)
+		}
+		for _, item := range response.Items {
+			var playlistId string
+			if item.ContentDetails != nil && item.ContentDetails.RelatedPlaylists != nil {
+				playlistId = item.ContentDetails.RelatedPlaylists.Uploads
+			}
+			err = updateChannelMeta(db, item.Id, item.Snippet.Title, playlistId, thumbnailURL(item.Snippet.Thumbnails))
+			if err != nil {
+				return refreshed, err
+			}
+			refreshed++
+		}
+	}
+
+	for start := 0; start < len(stalePlaylists); start += maxIdsPerCall {
+		batch := stalePlaylists[start:min(start+maxIdsPerCall, len(stalePlaylists))]
+		response, err := service.Playlists.List([]string{"id", "snippet"}).Id(batch...).MaxResults(maxIdsPerCall).Context(ctx).Do()
+		if err != nil {
+			return refreshed, fmt.Errorf("error getting playlist details: %w", err)
+		}
+		for _, item := range response.Items {
+			err = updateChannelMeta(db, item.Id, item.Snippet.Title, "", thumbnailURL(item.Snippet.Thumbnails))
+			if err != nil {
+				return refreshed, err
+			}
+			refreshed++
+		}
+	}
+	return refreshed, nil
+}
+
+// updateChannelMeta stores the details fetched for a channel or playlist
+func updateChannelMeta(db store.Queries, id, title, uploadsPlaylistId, thumbnailURL string) error {
+	err := db.SetChannelMeta(id, title, uploadsPlaylistId, thumbnailURL)
+	if err != nil {
+		return fmt.Errorf("error updating channel details in db: %w", err)
+	}
+	return nil
+}
+
+// channelTitles returns the cached title of each channel in channel_meta
+func channelTitles(db store.Queries) (map[channelId]channelName, error) {
+	stored, err := db.ChannelTitles()
+	if err != nil {
+		return nil, err
+	}
+	titles := make(map[channelId]channelName, len(stored))
+	for id, title := range stored {
+		titles[channelId(id)] = channelName(title)
+	}
+	return titles, nil
+}
+
+// applyChannelTitles sets each channel's title from channel_meta
+func applyChannelTitles(db store.Queries, channels []channel) error {
+	titles, err := channelTitles(db)
+	if err != nil {
+		return fmt.Errorf("error reading channel titles from db: %w", err)
+	}
+	for i := range channels {
+		channels[i].title = titles[channels[i].ID]
+	}
+	return nil
+}
+
+// refreshChannels refreshes stale cached channel details at the start of a
+// cycle, updating the monitored channels' titles
+func (b *bot) refreshChannels(ctx context.Context) error {
+	if b.service == nil {
+		return nil
+	}
+	n, err := refreshChannelMeta(ctx, b.db, b.service, b.currentChannels(), false)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return nil
+	}
+	log.Debug().Int("channels", n).Msg("refreshed channel details")
+
+	b.channelsMu.Lock()
+	defer b.channelsMu.Unlock()
+	return applyChannelTitles(b.db, b.channels)
+}
+
+// runChannelRefresh fetches every configured channel's details from YouTube,
+// however recently they were cached
+func runChannelRefresh(cliContext *cli.Context) error {
+	fileChannels, err := loadChannelsFileFromFlags(cliContext)
+	if err != nil {
+		return err
+	}
+	db, err := openDBFromFlags(cliContext)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	quota, err := newQuotaTracker(cliContext, db)
+	if err != nil {
+		return err
+	}
+	service, err := newYoutubeService(cliContext, quota)
+	if err != nil {
+		return err
+	}
+	channels, err := loadChannels(cliContext.Context, db, service, fileChannels, !cliContext.Bool("no-builtin-channels"))
+	if err != nil {
+		return err
+	}
+
+	n, err := refreshChannelMeta(cliContext.Context, db, service, channels, true)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("refreshed %d of %d channels\n", n, len(channels))
+	return nil
+}
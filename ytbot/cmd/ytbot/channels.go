@@ -0,0 +1,300 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/urfave/cli/v2"
+
+	"pw-ytbot/store"
+)
+
+// dbChannels returns all channels stored in the channels table
+func dbChannels(db store.Queries) ([]channel, error) {
+	stored, err := db.Channels()
+	if err != nil {
+		return nil, err
+	}
+	var channels []channel
+	for _, sc := range stored {
+		enabled := sc.Enabled
+		channels = append(channels, channel{ID: channelId(sc.ID), Name: channelName(sc.Name), Enabled: &enabled, source: sc.Source})
+	}
+	return channels, nil
+}
+
+// addChannel inserts a channel into the channels table
+func addChannel(db store.Queries, c channel) error {
+	if c.source == "" {
+		c.source = channelSourceDB
+	}
+	return db.AddChannel(store.Channel{ID: string(c.ID), Name: string(c.Name), Enabled: true, Source: c.source})
+}
+
+// removeChannel deletes a channel from the channels table. Posted video
+// history is kept so the channel can be re-added without re-posting.
+func removeChannel(db store.Queries, cId channelId) error {
+	return db.RemoveChannel(string(cId))
+}
+
+// setChannelEnabled enables or disables a channel in the channels table
+func setChannelEnabled(db store.Queries, cId channelId, enabled bool) error {
+	return db.SetChannelEnabled(string(cId), enabled)
+}
+
+// openDBFromFlags opens the database given by --db-driver and --dbfile or
+// --db-dsn for subcommands
+func openDBFromFlags(cliContext *cli.Context) (store.Store, error) {
+	opts, err := dbOptionsFromFlags(cliContext)
+	if err != nil {
+		return nil, err
+	}
+	return openDB(opts, !cliContext.Bool("no-builtin-channels"))
+}
+
+func runChannelAdd(cliContext *cli.Context) error {
+	if cliContext.NArg() != 1 {
+		return errors.New("expected exactly one channel ID")
+	}
+	c := channel{
+		ID:   channelId(cliContext.Args().First()),
+		Name: channelName(cliContext.String("name")),
+	}
+
+	db, err := openDBFromFlags(cliContext)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	// resolve playlist URLs, @handles and channel URLs to an ID
+	if pId := playlistIdFromURL(string(c.ID)); pId != "" {
+		c.ID = channelId(pId)
+	}
+	if !isChannelId(string(c.ID)) && !isPlaylistId(string(c.ID)) {
+		quota, err := newQuotaTracker(cliContext, db)
+		if err != nil {
+			return err
+		}
+		service, err := newYoutubeService(cliContext, quota)
+		if err != nil {
+			return fmt.Errorf("resolving %s: %w", c.ID, err)
+		}
+		cId, title, err := resolveChannelId(cliContext.Context, service, string(c.ID))
+		if err != nil {
+			return err
+		}
+		c.ID = cId
+		if c.Name == "" {
+			c.Name = channelName(title)
+		}
+	}
+	if c.Name == "" {
+		c.Name = channelName(c.ID)
+	}
+
+	err = addChannel(db, c)
+	if err != nil {
+		return fmt.Errorf("error adding channel %s: %w", c.ID, err)
+	}
+	fmt.Printf("added channel %s (%s)\n", c.ID, c.Name)
+	return nil
+}
+
+func runChannelRemove(cliContext *cli.Context) error {
+	if cliContext.NArg() != 1 {
+		return errors.New("expected exactly one channel ID")
+	}
+	cId := channelId(cliContext.Args().First())
+
+	db, err := openDBFromFlags(cliContext)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	err = removeChannel(db, cId)
+	if err != nil {
+		return fmt.Errorf("error removing channel %s: %w", cId, err)
+	}
+	fmt.Printf("removed channel %s\n", cId)
+	return nil
+}
+
+func runChannelEnable(cliContext *cli.Context) error {
+	return runChannelSetEnabled(cliContext, true)
+}
+
+func runChannelDisable(cliContext *cli.Context) error {
+	return runChannelSetEnabled(cliContext, false)
+}
+
+func runChannelSetEnabled(cliContext *cli.Context, enabled bool) error {
+	if cliContext.NArg() != 1 {
+		return errors.New("expected exactly one channel ID")
+	}
+	cId := channelId(cliContext.Args().First())
+
+	db, err := openDBFromFlags(cliContext)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	err = setChannelEnabled(db, cId, enabled)
+	if err != nil {
+		return fmt.Errorf("error updating channel %s: %w", cId, err)
+	}
+	if enabled {
+		fmt.Printf("enabled channel %s\n", cId)
+	} else {
+		fmt.Printf("disabled channel %s\n", cId)
+	}
+	return nil
+}
+
+func runChannelList(cliContext *cli.Context) error {
+	db, err := openDBFromFlags(cliContext)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	channels, err := dbChannels(db)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tENABLED\tSOURCE\tFAILURES")
+	for _, c := range channels {
+		s, err := channelBreaker(db, c.ID)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "%s\t%s\t%t\t%s\t%s\n", c.ID, c.Name, c.enabled(), c.source, s)
+	}
+	return w.Flush()
+}
+
+func runChannelReset(cliContext *cli.Context) error {
+	if cliContext.NArg() != 1 {
+		return errors.New("expected exactly one channel ID")
+	}
+	cId := channelId(cliContext.Args().First())
+
+	db, err := openDBFromFlags(cliContext)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	cleared, err := resetChannelBreaker(db, cId)
+	if err != nil {
+		return fmt.Errorf("error resetting channel %s: %w", cId, err)
+	}
+	if !cleared {
+		fmt.Printf("channel %s has no failed checks\n", cId)
+		return nil
+	}
+	fmt.Printf("reset channel %s\n", cId)
+	return nil
+}
+
+func runChannelVerify(cliContext *cli.Context) error {
+	fileChannels, err := loadChannelsFileFromFlags(cliContext)
+	if err != nil {
+		return err
+	}
+	db, err := openDBFromFlags(cliContext)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	quota, err := newQuotaTracker(cliContext, db)
+	if err != nil {
+		return err
+	}
+	service, err := newYoutubeService(cliContext, quota)
+	if err != nil {
+		return err
+	}
+	channels, err := loadChannels(cliContext.Context, db, service, fileChannels, !cliContext.Bool("no-builtin-channels"))
+	if err != nil {
+		return err
+	}
+
+	// look up channels and playlists in batches of as many as the API allows
+	type lookup struct{ title, privacyStatus string }
+	var ids, playlistIds []string
+	for _, c := range channels {
+		switch {
+		case c.search != nil, c.isPeerTube():
+			// searches and PeerTube channels aren't anything on YouTube to look up
+		case c.isPlaylist():
+			playlistIds = append(playlistIds, string(c.ID))
+		default:
+			ids = append(ids, string(c.ID))
+		}
+	}
+	found := make(map[channelId]lookup)
+	for i := 0; i < len(ids); i += maxIdsPerCall {
+		batch := ids[i:min(i+maxIdsPerCall, len(ids))]
+		response, err := service.Channels.List([]string{"id", "snippet", "status"}).Id(batch...).MaxResults(maxIdsPerCall).Context(cliContext.Context).Do()
+		if err != nil {
+			return fmt.Errorf("error looking up channels: %w", err)
+		}
+		for _, item := range response.Items {
+			l := lookup{title: item.Snippet.Title}
+			if item.Status != nil {
+				l.privacyStatus = item.Status.PrivacyStatus
+			}
+			found[channelId(item.Id)] = l
+		}
+	}
+	for i := 0; i < len(playlistIds); i += maxIdsPerCall {
+		batch := playlistIds[i:min(i+maxIdsPerCall, len(playlistIds))]
+		response, err := service.Playlists.List([]string{"id", "snippet", "status"}).Id(batch...).MaxResults(maxIdsPerCall).Context(cliContext.Context).Do()
+		if err != nil {
+			return fmt.Errorf("error looking up playlists: %w", err)
+		}
+		for _, item := range response.Items {
+			l := lookup{title: item.Snippet.Title}
+			if item.Status != nil {
+				l.privacyStatus = item.Status.PrivacyStatus
+			}
+			found[channelId(item.Id)] = l
+		}
+	}
+
+	failed := 0
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tYOUTUBE TITLE\tSTATUS")
+	for _, c := range channels {
+		if c.search != nil || c.isPeerTube() {
+			continue
+		}
+		l, ok := found[c.ID]
+		if !ok {
+			failed++
+			fmt.Fprintf(w, "%s\t%s\t\tNOT FOUND (doesn't exist or terminated)\n", c.ID, c.Name)
+			continue
+		}
+		status := "ok"
+		if l.privacyStatus != "" && l.privacyStatus != "public" {
+			status = l.privacyStatus
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", c.ID, c.Name, l.title, status)
+	}
+	err = w.Flush()
+	if err != nil {
+		return err
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d channels failed verification", failed, len(channels))
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/urfave/cli/v2"
+)
+
+// runCheck checks a single channel straight away, logging every decision made
+// about its videos, to debug why a video was or wasn't posted
+func runCheck(cliContext *cli.Context) error {
+	zerolog.SetGlobalLevel(zerolog.DebugLevel)
+
+	b, err := newBot(cliContext)
+	if err != nil {
+		return err
+	}
+	defer b.close()
+	b.force = cliContext.Bool("force")
+	b.repost = cliContext.String("repost")
+
+	cId := channelId(cliContext.String("channel"))
+	if !isSearchId(string(cId)) && !isPeerTubeId(string(cId)) {
+		cId, _, err = resolveChannelId(cliContext.Context, b.service, string(cId))
+		if err != nil {
+			return err
+		}
+	}
+	for _, c := range b.currentChannels() {
+		if c.ID == cId {
+			_, err = b.checkChannel(cliContext.Context, c)
+			if err != nil {
+				return err
+			}
+			if b.settings.quietHours.contains(time.Now()) {
+				log.Info().Msg("quiet hours, leaving queued posts in the outbox")
+				return nil
+			}
+			return b.deliver(cliContext.Context, c.ID)
+		}
+	}
+	return fmt.Errorf("channel %s is not configured, add it with channel add or the channels file", cId)
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCheckPostsOldestFirst(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	api := &fakeYouTube{videos: []fakeVideo{
+		{id: "aaaaaaaaaaa", title: "First", published: now.Add(-3 * time.Hour)},
+		{id: "bbbbbbbbbbb", title: "Second", published: now.Add(-2 * time.Hour)},
+		{id: "ccccccccccc", title: "Third", published: now.Add(-1 * time.Hour)},
+	}}
+	useFakeYouTube(t, api)
+	webhook := &fakeWebhook{}
+	srv := httptest.NewServer(webhook)
+	defer srv.Close()
+
+	dbfile := addTestChannel(t, t.TempDir())
+	args := append(testBotArgs(dbfile, srv.URL+"/api/webhooks/1/token"), "check", "--channel", testChannelId)
+	err := app.RunContext(context.Background(), args)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := webhook.postedVideos(api.videos)
+	want := []string{"aaaaaaaaaaa", "bbbbbbbbbbb", "ccccccccccc"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("posted %v, want %v", got, want)
+	}
+}
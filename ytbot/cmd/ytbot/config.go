@@ -0,0 +1,954 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/urfave/cli/v2"
+	"google.golang.org/api/youtube/v3"
+	"gopkg.in/yaml.v3"
+
+	"pw-ytbot/store"
+)
+
+type (
+	// channel is a single monitored YouTube channel
+	channel struct {
+		Name    channelName `yaml:"name"`
+		ID      channelId   `yaml:"id"`
+		Enabled *bool       `yaml:"enabled,omitempty"` // nil means enabled
+
+		// channels on other platforms are only settable in the channels file
+		Platform string `yaml:"platform,omitempty"` // youtube if empty, or peertube
+		Feed     string `yaml:"feed,omitempty"`     // URL of a PeerTube channel's feed
+
+		// optional per-channel settings, only settable in the channels file
+		Webhook         webhookList   `yaml:"webhook,omitempty"`
+		LiveWebhook     webhookList   `yaml:"live_webhook,omitempty"` // for live streams and premieres
+		MentionRoleId   string        `yaml:"mention_role_id,omitempty"`
+		Prefix          string        `yaml:"prefix,omitempty"`
+		MessageTemplate string        `yaml:"message_template,omitempty"`
+		CheckInterval   time.Duration `yaml:"check_interval,omitempty"`
+		Lookback        time.Duration `yaml:"lookback,omitempty"`
+		TitleInclude    []string      `yaml:"title_include,omitempty"`
+		TitleExclude    []string      `yaml:"title_exclude,omitempty"`
+		SkipShorts      *bool         `yaml:"skip_shorts,omitempty"`
+		Live            string        `yaml:"live,omitempty"`
+		Tags            []string      `yaml:"tags,omitempty"`
+		BackfillMode    string        `yaml:"backfill_mode,omitempty"`
+		MinViews        int64         `yaml:"min_views,omitempty"` // views a video needs before it's posted
+		Streams         bool          `yaml:"streams,omitempty"`   // announce scheduled live streams
+		PostStyle       string        `yaml:"post_style,omitempty"`
+		Digest          *bool         `yaml:"digest,omitempty"`      // false to keep posting as videos are found with --digest
+		EmbedColor      string        `yaml:"embed_color,omitempty"` // hex, e.g. #ff8800, or auto for the thumbnail's
+
+		// settings parsed from the above, or from the global settings
+		messageTemplate *template.Template
+		titleInclude    []*regexp.Regexp
+		titleExclude    []*regexp.Regexp
+		skipShorts      bool
+		postStyle       string
+		timestampStyle  string
+		embedColor      int    // colour of the channel's embeds, 0 for Discord's default
+		embedColorAuto  bool   // embedColor is worked out from a thumbnail, see autoEmbedColor
+		digest          bool   // new uploads go into the digest rather than being posted
+		routeTag        string // tag whose route the channel posts to, if any
+		routeWebhooks   webhookList
+		routeForum      bool              // the route's webhooks post to a forum channel
+		routeType       string            // the route's type, e.g. slack for Slack incoming webhooks
+		routeHeaders    map[string]string // sent with each post, for routes of type http
+		routeSuppress   *bool             // the route's suppress_embeds, if set
+		suppressEmbeds  bool              // stop Discord previewing links in the channel's posts
+
+		source string       // where the channel came from, one of the channelSource consts
+		title  channelName  // the channel's title on YouTube from channel_meta, if known
+		search *searchQuery // what to search for if this is a search rather than a channel
+	}
+
+	// channelsFile is the on-disk format of the file given by --channels-file
+	channelsFile struct {
+		Routes   map[string]route `yaml:"routes,omitempty"` // tag to webhooks
+		Channels []channel        `yaml:"channels"`
+		Searches []searchEntry    `yaml:"searches,omitempty"`
+	}
+
+	// route is where videos with a tag are posted, given in YAML as just the
+	// webhooks, or as a mapping with options
+	route struct {
+		Webhook webhookList `yaml:"webhook"`
+		Forum   bool        `yaml:"forum,omitempty"` // the webhooks post to a forum channel
+		Type    string      `yaml:"type,omitempty"`  // discord, slack for Slack incoming webhooks, or http for HTTP sinks
+
+		Headers map[string]string `yaml:"headers,omitempty"` // sent with each post, for type http
+
+		SuppressEmbeds *bool `yaml:"suppress_embeds,omitempty"` // instead of --suppress-embeds
+
+		TelegramChatID string `yaml:"telegram_chat_id,omitempty"` // also posted to with --telegram-token
+		Mastodon       bool   `yaml:"mastodon,omitempty"`         // also posted to the --mastodon-server account
+		EventFile      string `yaml:"event_file,omitempty"`       // also written to as JSON lines, - for stdout
+
+		DiscordChannelID string `yaml:"discord_channel_id,omitempty"` // also posted to with --bot-token
+		MatrixRoom       string `yaml:"matrix_room,omitempty"`        // also posted to with --matrix-token
+	}
+
+	// webhookList is the webhooks videos are posted to, given in YAML as a
+	// single URL or a list of them
+	webhookList []string
+)
+
+// destinations returns everywhere a route posts to, its webhooks and any
+// Telegram chat, Mastodon account, event file, Discord channel posted to with
+// the bot or Matrix room
+func (r route) destinations() webhookList {
+	destinations := slices.Clone(r.Webhook)
+	if r.TelegramChatID != "" {
+		destinations = append(destinations, telegramDestination(r.TelegramChatID))
+	}
+	if r.Mastodon {
+		destinations = append(destinations, mastodonDestination)
+	}
+	if r.EventFile != "" {
+		destinations = append(destinations, fileDestination(r.EventFile))
+	}
+	if r.DiscordChannelID != "" {
+		destinations = append(destinations, discordChannelDestination(r.DiscordChannelID))
+	}
+	if r.MatrixRoom != "" {
+		destinations = append(destinations, matrixDestination(r.MatrixRoom))
+	}
+	return destinations
+}
+
+// UnmarshalYAML accepts a route's webhooks alone, or a mapping with options
+func (r *route) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.MappingNode {
+		type plain route
+		return value.Decode((*plain)(r))
+	}
+	return value.Decode(&r.Webhook)
+}
+
+// UnmarshalYAML accepts a single webhook as well as a list of them
+func (l *webhookList) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*l = webhookList{value.Value}
+		return nil
+	}
+	var webhooks []string
+	err := value.Decode(&webhooks)
+	if err != nil {
+		return err
+	}
+	*l = webhooks
+	return nil
+}
+
+// MarshalYAML writes a single webhook as a plain URL
+func (l webhookList) MarshalYAML() (any, error) {
+	if len(l) == 1 {
+		return l[0], nil
+	}
+	return []string(l), nil
+}
+
+// validate checks every webhook in the list
+func (l webhookList) validate() error {
+	for _, webhook := range l {
+		err := validateWebhook(webhook)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// where a channel was configured
+const (
+	channelSourceBuiltin = "builtin" // seeded from the built-in channel list
+	channelSourceDB      = "db"      // added with the channel subcommands
+	channelSourceFile    = "file"    // listed in --channels-file
+)
+
+// loadChannelsFile reads and validates a YAML channels file
+func loadChannelsFile(path string) ([]channel, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var cf channelsFile
+	err = yaml.Unmarshal(b, &cf)
+	if err != nil {
+		return nil, fmt.Errorf("invalid YAML in channels file %s: %w", path, err)
+	}
+	if len(cf.Channels) == 0 && len(cf.Searches) == 0 {
+		return nil, fmt.Errorf("channels file %s contains no channels or searches", path)
+	}
+	for tag, r := range cf.Routes {
+		if len(r.destinations()) == 0 {
+			return nil, fmt.Errorf("channels file %s: route for tag %q has no webhook", path, tag)
+		}
+		err = r.destinations().validate()
+		if err != nil {
+			return nil, fmt.Errorf("channels file %s: route for tag %q: %w", path, tag, err)
+		}
+		err = validateWebhookType(r.Type)
+		if err != nil {
+			return nil, fmt.Errorf("channels file %s: route for tag %q: %w", path, tag, err)
+		}
+		if r.Forum && r.Type != "" && r.Type != webhookTypeDiscord {
+			return nil, fmt.Errorf("channels file %s: route for tag %q: forum is only for Discord webhooks", path, tag)
+		}
+		if len(r.Headers) > 0 && r.Type != webhookTypeHTTP {
+			return nil, fmt.Errorf("channels file %s: route for tag %q: headers are only for routes of type %s", path, tag, webhookTypeHTTP)
+		}
+	}
+
+	// searches are checked as channels, so share their settings and validation
+	searchNames := make(map[string]bool)
+	for _, s := range cf.Searches {
+		err = s.validate()
+		if err != nil {
+			return nil, fmt.Errorf("channels file %s: %w", path, err)
+		}
+		if searchNames[s.Name] {
+			return nil, fmt.Errorf("channels file %s: more than one search named %s", path, s.Name)
+		}
+		searchNames[s.Name] = true
+		cf.Channels = append(cf.Channels, s.channel())
+	}
+
+	for i, c := range cf.Channels {
+		if c.ID == "" {
+			return nil, fmt.Errorf("channels file %s: channel #%d (%q) has no id", path, i+1, c.Name)
+		}
+		if c.Name == "" {
+			return nil, fmt.Errorf("channels file %s: channel #%d (%s) has no name", path, i+1, c.ID)
+		}
+		if c.search == nil {
+			c.ID, err = validatePlatform(c)
+			if err != nil {
+				return nil, fmt.Errorf("channels file %s: channel %s: %w", path, c.Name, err)
+			}
+			cf.Channels[i].ID = c.ID
+		}
+
+		if c.CheckInterval < 0 {
+			return nil, fmt.Errorf("channels file %s: channel %s has negative check_interval %s", path, c.Name, c.CheckInterval)
+		}
+		if c.MinViews < 0 {
+			return nil, fmt.Errorf("channels file %s: channel %s has negative min_views %d", path, c.Name, c.MinViews)
+		}
+		if c.MinViews > 0 && c.isPeerTube() {
+			return nil, fmt.Errorf("channels file %s: channel %s: min_views is only supported for YouTube channels", path, c.Name)
+		}
+		if c.Streams && (c.isPeerTube() || c.isPlaylist()) {
+			return nil, fmt.Errorf("channels file %s: channel %s: streams is only supported for YouTube channels", path, c.Name)
+		}
+		err = c.LiveWebhook.validate()
+		if err != nil {
+			return nil, fmt.Errorf("channels file %s: channel %s live_webhook: %w", path, c.Name, err)
+		}
+		if c.Live != "" {
+			err = validateLivePolicy(c.Live)
+			if err != nil {
+				return nil, fmt.Errorf("channels file %s: channel %s live: %w", path, c.Name, err)
+			}
+		}
+		if c.PostStyle != "" {
+			err = validatePostStyle(c.PostStyle)
+			if err != nil {
+				return nil, fmt.Errorf("channels file %s: channel %s post_style: %w", path, c.Name, err)
+			}
+		}
+		if c.BackfillMode != "" {
+			err = validateBackfillMode(c.BackfillMode)
+			if err != nil {
+				return nil, fmt.Errorf("channels file %s: channel %s backfill_mode: %w", path, c.Name, err)
+			}
+		}
+		if c.Lookback != 0 {
+			err = validateLookback(c.Lookback)
+			if err != nil {
+				return nil, fmt.Errorf("channels file %s: channel %s lookback: %w", path, c.Name, err)
+			}
+		}
+
+		// parse per-channel settings
+		if c.MessageTemplate != "" {
+			cf.Channels[i].messageTemplate, err = parseMessageTemplate(fmt.Sprintf("for channel %s", c.Name), c.MessageTemplate)
+			if err != nil {
+				return nil, fmt.Errorf("channels file %s: %w", path, err)
+			}
+		}
+		cf.Channels[i].source = channelSourceFile
+
+		// route to the webhook for the first tag with one, every tag must have a route
+		for _, tag := range c.Tags {
+			r, ok := cf.Routes[tag]
+			if !ok {
+				return nil, fmt.Errorf("channels file %s: channel %s has tag %q with no route", path, c.Name, tag)
+			}
+			if cf.Channels[i].routeTag == "" {
+				cf.Channels[i].routeTag, cf.Channels[i].routeWebhooks, cf.Channels[i].routeForum = tag, r.destinations(), r.Forum
+				cf.Channels[i].routeType, cf.Channels[i].routeHeaders = r.Type, r.Headers
+				cf.Channels[i].routeSuppress = r.SuppressEmbeds
+			}
+		}
+		cf.Channels[i].embedColor, cf.Channels[i].embedColorAuto, err = parseEmbedColor(c.EmbedColor)
+		if err != nil {
+			return nil, fmt.Errorf("channels file %s: channel %s embed_color: %w", path, c.Name, err)
+		}
+		cf.Channels[i].titleInclude, err = compilePatterns(c.TitleInclude)
+		if err != nil {
+			return nil, fmt.Errorf("channels file %s: channel %s title_include: %w", path, c.Name, err)
+		}
+		cf.Channels[i].titleExclude, err = compilePatterns(c.TitleExclude)
+		if err != nil {
+			return nil, fmt.Errorf("channels file %s: channel %s title_exclude: %w", path, c.Name, err)
+		}
+	}
+
+	return cf.Channels, nil
+}
+
+// loadChannelsFileFromFlags loads the file given by --channels-file, if any
+func loadChannelsFileFromFlags(cliContext *cli.Context) ([]channel, error) {
+	path := cliContext.Path("channels-file")
+	if path == "" {
+		return nil, nil
+	}
+	return loadChannelsFile(path)
+}
+
+// loadChannels resolves any @handles or channel URLs in fileChannels, then
+// merges them with the channels stored in the database. Channels seeded from
+// the built-in list are left out unless builtin is set.
+func loadChannels(ctx context.Context, db store.Queries, service *youtube.Service, fileChannels []channel, builtin bool) ([]channel, error) {
+	for i, c := range fileChannels {
+		if c.search != nil || c.isPeerTube() {
+			continue
+		}
+		cId, _, err := resolveChannelId(ctx, service, string(c.ID))
+		if err != nil {
+			return nil, err
+		}
+		fileChannels[i].ID = cId
+	}
+
+	storedChannels, err := dbChannels(db)
+	if err != nil {
+		return nil, fmt.Errorf("error reading channels from db: %w", err)
+	}
+	if !builtin {
+		n := 0
+		for _, c := range storedChannels {
+			if c.source != channelSourceBuiltin {
+				storedChannels[n] = c
+				n++
+			}
+		}
+		storedChannels = storedChannels[:n]
+	}
+
+	channels, err := mergeChannels(storedChannels, fileChannels)
+	if err != nil {
+		return nil, err
+	}
+
+	// log where the channels came from
+	bySource := make(map[string][]string)
+	for _, c := range channels {
+		bySource[c.source] = append(bySource[c.source], string(c.Name))
+	}
+	for _, source := range []string{channelSourceBuiltin, channelSourceDB, channelSourceFile} {
+		if len(bySource[source]) > 0 {
+			log.Info().Str("source", source).Strs("channels", bySource[source]).Msg("channels configured")
+		}
+	}
+
+	return channels, nil
+}
+
+// mergeChannels returns the channels to monitor: the channels from the
+// database, overridden by any channels from --channels-file (the file wins for
+// duplicate IDs, but a channel disabled in either place stays disabled)
+func mergeChannels(dbChannels, fileChannels []channel) ([]channel, error) {
+
+	byId := make(map[channelId]channel)
+	for _, c := range dbChannels {
+		byId[c.ID] = c
+	}
+	for _, c := range fileChannels {
+		if dbc, ok := byId[c.ID]; ok && !dbc.enabled() {
+			c.Enabled = dbc.Enabled
+		}
+		byId[c.ID] = c
+	}
+
+	if len(byId) == 0 {
+		return nil, errors.New("no channels configured")
+	}
+
+	channels := make([]channel, 0, len(byId))
+	for _, c := range byId {
+		channels = append(channels, c)
+	}
+	sort.Slice(channels, func(i, j int) bool { return channels[i].Name < channels[j].Name })
+
+	return channels, nil
+}
+
+// enabled returns whether the channel should be checked
+func (c channel) enabled() bool {
+	return c.Enabled == nil || *c.Enabled
+}
+
+// isPlaylist returns whether the channel is actually a playlist, whose videos
+// are posted when they're added to it rather than when they're published
+func (c channel) isPlaylist() bool {
+	return isPlaylistId(string(c.ID))
+}
+
+// displayName returns the channel's title on YouTube if known, otherwise its
+// configured name
+func (c channel) displayName() channelName {
+	if c.title != "" {
+		return c.title
+	}
+	return c.Name
+}
+
+const (
+	minLookback = time.Hour
+	maxLookback = 30 * 24 * time.Hour
+)
+
+// live stream policies
+const (
+	livePolicyInclude  = "include"  // post live streams like any other video
+	livePolicyExclude  = "exclude"  // never post live streams
+	livePolicyAnnounce = "announce" // post live streams with the live message template
+)
+
+// backfill modes, for videos found on a channel's first check
+const (
+	backfillModePost = "post" // post them like any other video
+	backfillModeSkip = "skip" // record them as skipped without posting
+	backfillModeAsk  = "ask"  // ask on the terminal whether to post each one
+)
+
+// sources of new videos
+const (
+	sourceAPI = "api" // the YouTube Data API
+	sourceRSS = "rss" // each channel's Atom feed, which needs no API key
+)
+
+// settings holds the global channel settings from the command line
+type settings struct {
+	webhooks      []string // every video is posted to each of them
+	liveWebhooks  []string // live streams and premieres are posted to these instead, if set
+	alertWebhook  string   // problems in each cycle are posted here, if set
+	telegramToken string   // Telegram bot token, for posting to Telegram chats
+	alertLevel    string   // the least severe problems posted to alertWebhook
+
+	mastodonServer     string   // base URL of the Mastodon instance, for posting statuses
+	mastodonToken      string   // access token of the account statuses are posted by
+	mastodonVisibility string   // visibility of posted statuses
+	mastodonHashtags   []string // added to each status, each with its #
+
+	matrixHomeserver string // base URL of the Matrix homeserver, for posting to rooms
+	matrixToken      string // access token of the account messages are posted by
+
+	sinkHMACSecret string // signs the body of each post to an HTTP sink, if set
+	eventFileFsync bool   // sync event files to disk after writing each event
+
+	source     string
+	useSearch  bool // find new videos with Search.list rather than the uploads playlist
+	maxResults int  // most videos fetched per channel check
+
+	verifyBeforePost  bool // check a video is still public before posting it, if it wasn't just fetched with its status
+	trackTitleChanges bool // edit posted messages when their video's title changes soon after
+	waitForMessage    bool // post with ?wait=true to get the ID of the posted message
+	createThreads     bool // start a thread under each posted message, using botToken
+	botToken          string
+
+	messageTemplate  *template.Template
+	checkInterval    time.Duration
+	adaptiveInterval bool
+	lookback         time.Duration
+	retention        time.Duration // how long videos are kept in videos_posted
+
+	skipShorts        bool
+	shortsMaxDuration time.Duration
+
+	postStyle      string
+	urlStyle       string
+	timestampStyle string
+	suppressEmbeds bool // stop Discord previewing links in posts
+
+	skipAgeRestricted bool
+
+	live                string
+	liveMessageTemplate *template.Template
+
+	premiereMessageTemplate *template.Template
+	premiereLiveMessage     bool
+
+	streamMessageTemplate *template.Template
+
+	blockKeywords []string
+
+	backfillMode string
+
+	reuploadAction string
+
+	postDelay   time.Duration
+	minVideoAge time.Duration
+
+	minViewsMaxAge time.Duration
+
+	maxPostsPerRun int // 0 for no limit
+	maxFailures    int // consecutive failed checks before a channel is skipped, 0 to never skip
+
+	quietHours *quietHours // nil if there are none
+
+	digest    string        // empty for no digest, or digestDaily
+	digestAt  time.Duration // time of day the digest is sent, since midnight in digestLoc
+	digestLoc *time.Location
+
+	report        string        // empty for no scheduled report, or reportWeekly
+	reportAt      time.Duration // time of day the report is sent, since midnight in reportLoc
+	reportLoc     *time.Location
+	reportWebhook string // reports are posted here
+}
+
+// splitWebhooks returns the webhooks given to a repeatable --webhook, each of
+// which can also be a comma separated list
+func splitWebhooks(values []string) []string {
+	var webhooks []string
+	for _, v := range values {
+		for _, webhook := range strings.Split(v, ",") {
+			if webhook = strings.TrimSpace(webhook); webhook != "" {
+				webhooks = append(webhooks, webhook)
+			}
+		}
+	}
+	return webhooks
+}
+
+// loadSettings parses and validates the global channel settings
+func loadSettings(cliContext *cli.Context) (*settings, error) {
+	var err error
+	s := &settings{
+		webhooks:      splitWebhooks(cliContext.StringSlice("webhook")),
+		liveWebhooks:  splitWebhooks(cliContext.StringSlice("live-webhook")),
+		alertWebhook:  cliContext.String("alert-webhook"),
+		alertLevel:    cliContext.String("alert-level"),
+		telegramToken: cliContext.String("telegram-token"),
+		source:        cliContext.String("source"),
+		useSearch:     cliContext.Bool("use-search"),
+	}
+	err = webhookList(s.liveWebhooks).validate()
+	if err != nil {
+		return nil, fmt.Errorf("--live-webhook: %w", err)
+	}
+	if s.alertWebhook != "" {
+		err = validateWebhook(s.alertWebhook)
+		if err != nil {
+			return nil, fmt.Errorf("--alert-webhook: %w", err)
+		}
+	}
+	err = validateAlertLevel(s.alertLevel)
+	if err != nil {
+		return nil, fmt.Errorf("--alert-level: %w", err)
+	}
+
+	// a Telegram chat is posted to along with the webhooks
+	if chatId := cliContext.String("telegram-chat-id"); chatId != "" {
+		err = validateTelegramChatId(chatId)
+		if err != nil {
+			return nil, fmt.Errorf("--telegram-chat-id: %w", err)
+		}
+		if s.telegramToken == "" {
+			return nil, errors.New("--telegram-chat-id needs --telegram-token")
+		}
+		s.webhooks = append(s.webhooks, telegramDestination(chatId))
+	}
+
+	// so is a Mastodon account
+	s.mastodonServer = strings.TrimSuffix(cliContext.String("mastodon-server"), "/")
+	s.mastodonToken = cliContext.String("mastodon-token")
+	if (s.mastodonServer == "") != (s.mastodonToken == "") {
+		return nil, errors.New("--mastodon-server and --mastodon-token must be given together")
+	}
+	if s.mastodonServer != "" {
+		err = validateServerURL(s.mastodonServer)
+		if err != nil {
+			return nil, fmt.Errorf("--mastodon-server: %w", err)
+		}
+		s.webhooks = append(s.webhooks, mastodonDestination)
+	}
+	s.mastodonVisibility = cliContext.String("mastodon-visibility")
+	err = validateMastodonVisibility(s.mastodonVisibility)
+	if err != nil {
+		return nil, fmt.Errorf("--mastodon-visibility: %w", err)
+	}
+	s.mastodonHashtags, err = mastodonHashtags(splitWebhooks(cliContext.StringSlice("mastodon-hashtags")))
+	if err != nil {
+		return nil, fmt.Errorf("--mastodon-hashtags: %w", err)
+	}
+
+	// and so is a Matrix room
+	s.matrixHomeserver = strings.TrimSuffix(cliContext.String("matrix-homeserver"), "/")
+	s.matrixToken = cliContext.String("matrix-token")
+	if (s.matrixHomeserver == "") != (s.matrixToken == "") {
+		return nil, errors.New("--matrix-homeserver and --matrix-token must be given together")
+	}
+	if s.matrixHomeserver != "" {
+		err = validateServerURL(s.matrixHomeserver)
+		if err != nil {
+			return nil, fmt.Errorf("--matrix-homeserver: %w", err)
+		}
+	}
+	if roomId := cliContext.String("matrix-room"); roomId != "" {
+		err = validateMatrixRoomId(roomId)
+		if err != nil {
+			return nil, fmt.Errorf("--matrix-room: %w", err)
+		}
+		if s.matrixHomeserver == "" {
+			return nil, errors.New("--matrix-room needs --matrix-homeserver and --matrix-token")
+		}
+		s.webhooks = append(s.webhooks, matrixDestination(roomId))
+	}
+
+	s.sinkHMACSecret = cliContext.String("sink-hmac-secret")
+
+	// and so are events written to a file
+	if path := cliContext.String("event-file"); path != "" {
+		err = validateEventFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("--event-file: %w", err)
+		}
+		s.webhooks = append(s.webhooks, fileDestination(path))
+	}
+	s.eventFileFsync = cliContext.Bool("event-file-fsync")
+	if s.source != sourceAPI && s.source != sourceRSS {
+		return nil, fmt.Errorf("unknown --source %q, must be %s or %s", s.source, sourceAPI, sourceRSS)
+	}
+
+	s.verifyBeforePost = cliContext.Bool("verify-before-post")
+	s.trackTitleChanges = cliContext.Bool("track-title-changes")
+	s.waitForMessage = cliContext.Bool("wait-for-message")
+	if s.trackTitleChanges && !s.waitForMessage {
+		return nil, errors.New("--track-title-changes needs --wait-for-message, to know which messages to edit")
+	}
+	s.createThreads = cliContext.Bool("create-threads")
+	s.botToken = cliContext.String("bot-token")
+	// a Discord channel can be posted to with the bot rather than a webhook,
+	// e.g. to publish to the servers following an announcement channel
+	if channelId := cliContext.String("discord-channel-id"); channelId != "" {
+		err = validateDiscordChannelId(channelId)
+		if err != nil {
+			return nil, fmt.Errorf("--discord-channel-id: %w", err)
+		}
+		if s.botToken == "" {
+			return nil, errors.New("--discord-channel-id needs --bot-token")
+		}
+		s.webhooks = append(s.webhooks, discordChannelDestination(channelId))
+	}
+	if s.createThreads && s.botToken == "" {
+		return nil, errors.New("--create-threads needs --bot-token, as webhooks can't start threads")
+	}
+	if s.createThreads && !s.waitForMessage {
+		return nil, errors.New("--create-threads needs --wait-for-message, to know which message to start the thread under")
+	}
+	if s.trackTitleChanges && s.source == sourceRSS && len(apiKeysFromFlags(cliContext)) == 0 {
+		return nil, errors.New("--track-title-changes needs --apikey to look up titles")
+	}
+
+	s.maxResults = cliContext.Int("max-results")
+	if s.maxResults <= 0 {
+		return nil, fmt.Errorf("--max-results must be positive, got %d", s.maxResults)
+	}
+
+	s.messageTemplate, err = parseMessageTemplate("--message-template", cliContext.String("message-template"))
+	if err != nil {
+		return nil, err
+	}
+
+	s.checkInterval = cliContext.Duration("check-interval")
+	if s.checkInterval <= 0 {
+		return nil, fmt.Errorf("--check-interval must be positive, got %s", s.checkInterval)
+	}
+
+	s.adaptiveInterval = cliContext.Bool("adaptive-interval")
+
+	s.lookback = cliContext.Duration("lookback")
+	err = validateLookback(s.lookback)
+	if err != nil {
+		return nil, fmt.Errorf("--lookback: %w", err)
+	}
+
+	s.retention, err = retentionFromFlags(cliContext)
+	if err != nil {
+		return nil, err
+	}
+
+	s.skipShorts = cliContext.Bool("skip-shorts")
+	s.shortsMaxDuration = cliContext.Duration("shorts-max-duration")
+
+	s.postStyle = cliContext.String("post-style")
+	s.suppressEmbeds = cliContext.Bool("suppress-embeds")
+	err = validatePostStyle(s.postStyle)
+	if err != nil {
+		return nil, fmt.Errorf("--post-style: %w", err)
+	}
+
+	s.urlStyle = cliContext.String("url-style")
+	err = validateURLStyle(s.urlStyle)
+	if err != nil {
+		return nil, fmt.Errorf("--url-style: %w", err)
+	}
+
+	s.timestampStyle = cliContext.String("timestamp-style")
+	err = validateTimestampStyle(s.timestampStyle)
+	if err != nil {
+		return nil, fmt.Errorf("--timestamp-style: %w", err)
+	}
+
+	s.skipAgeRestricted = cliContext.Bool("skip-age-restricted")
+	if s.skipAgeRestricted && s.source == sourceRSS && len(apiKeysFromFlags(cliContext)) == 0 {
+		return nil, errors.New("--skip-age-restricted needs --apikey to look up videos' age restrictions")
+	}
+
+	s.live = cliContext.String("live")
+	err = validateLivePolicy(s.live)
+	if err != nil {
+		return nil, fmt.Errorf("--live: %w", err)
+	}
+	s.liveMessageTemplate, err = parseMessageTemplate("--live-message-template", cliContext.String("live-message-template"))
+	if err != nil {
+		return nil, err
+	}
+
+	s.premiereMessageTemplate, err = parseMessageTemplate("--premiere-message-template", cliContext.String("premiere-message-template"))
+	if err != nil {
+		return nil, err
+	}
+	s.premiereLiveMessage = cliContext.Bool("premiere-live-message")
+
+	s.streamMessageTemplate, err = parseMessageTemplate("--stream-message-template", cliContext.String("stream-message-template"))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, k := range cliContext.StringSlice("block-keyword") {
+		if k == "" {
+			return nil, errors.New("--block-keyword must not be empty")
+		}
+		s.blockKeywords = append(s.blockKeywords, k)
+	}
+
+	s.postDelay = cliContext.Duration("post-delay")
+	if s.postDelay < 0 {
+		return nil, fmt.Errorf("--post-delay must not be negative, got %s", s.postDelay)
+	}
+
+	s.maxPostsPerRun = cliContext.Int("max-posts-per-run")
+	if s.maxPostsPerRun < 0 {
+		return nil, fmt.Errorf("--max-posts-per-run must not be negative, got %d", s.maxPostsPerRun)
+	}
+
+	s.maxFailures = cliContext.Int("max-consecutive-failures")
+	if s.maxFailures < 0 {
+		return nil, fmt.Errorf("--max-consecutive-failures must not be negative, got %d", s.maxFailures)
+	}
+
+	s.minVideoAge = cliContext.Duration("min-video-age")
+	if s.minVideoAge < 0 {
+		return nil, fmt.Errorf("--min-video-age must not be negative, got %s", s.minVideoAge)
+	}
+
+	s.minViewsMaxAge = cliContext.Duration("min-views-max-age")
+	if s.minViewsMaxAge <= 0 {
+		return nil, fmt.Errorf("--min-views-max-age must be positive, got %s", s.minViewsMaxAge)
+	}
+
+	loc := time.Local
+	if tz := cliContext.String("timezone"); tz != "" {
+		loc, err = time.LoadLocation(tz)
+		if err != nil {
+			return nil, fmt.Errorf("--timezone: %w", err)
+		}
+	}
+	if q := cliContext.String("quiet-hours"); q != "" {
+		s.quietHours, err = parseQuietHours(q, loc)
+		if err != nil {
+			return nil, fmt.Errorf("--quiet-hours: %w", err)
+		}
+	}
+
+	s.digest = cliContext.String("digest")
+	err = validateDigest(s.digest)
+	if err != nil {
+		return nil, fmt.Errorf("--digest: %w", err)
+	}
+	s.digestAt, err = parseClock(cliContext.String("digest-time"))
+	if err != nil {
+		return nil, fmt.Errorf("--digest-time: %w", err)
+	}
+	s.digestLoc = loc
+
+	s.report = cliContext.String("report")
+	err = validateReport(s.report)
+	if err != nil {
+		return nil, fmt.Errorf("--report: %w", err)
+	}
+	s.reportAt, err = parseClock(cliContext.String("report-time"))
+	if err != nil {
+		return nil, fmt.Errorf("--report-time: %w", err)
+	}
+	s.reportLoc = loc
+	s.reportWebhook = cliContext.String("report-webhook")
+	if s.reportWebhook != "" {
+		err = validateWebhook(s.reportWebhook)
+		if err != nil {
+			return nil, fmt.Errorf("--report-webhook: %w", err)
+		}
+	} else if s.report != "" {
+		return nil, errors.New("--report needs --report-webhook")
+	}
+
+	s.backfillMode = cliContext.String("backfill-mode")
+	err = validateBackfillMode(s.backfillMode)
+	if err != nil {
+		return nil, fmt.Errorf("--backfill-mode: %w", err)
+	}
+
+	s.reuploadAction = cliContext.String("reupload-action")
+	err = validateReuploadAction(s.reuploadAction)
+	if err != nil {
+		return nil, fmt.Errorf("--reupload-action: %w", err)
+	}
+
+	return s, nil
+}
+
+// validateLookback checks a lookback window is within sensible bounds
+func validateLookback(d time.Duration) error {
+	if d < minLookback || d > maxLookback {
+		return fmt.Errorf("lookback %s must be between %s and %s", d, minLookback, maxLookback)
+	}
+	return nil
+}
+
+// validateLivePolicy checks a live stream policy is one we know about
+func validateLivePolicy(p string) error {
+	switch p {
+	case livePolicyInclude, livePolicyExclude, livePolicyAnnounce:
+		return nil
+	}
+	return fmt.Errorf("unknown live policy %q, must be %s, %s or %s", p, livePolicyInclude, livePolicyExclude, livePolicyAnnounce)
+}
+
+// validateBackfillMode checks a backfill mode is one we know about
+func validateBackfillMode(m string) error {
+	switch m {
+	case backfillModePost, backfillModeSkip, backfillModeAsk:
+		return nil
+	}
+	return fmt.Errorf("unknown backfill mode %q, must be %s, %s or %s", m, backfillModePost, backfillModeSkip, backfillModeAsk)
+}
+
+// applySettings fills in any per-channel settings not set in the channels file from the global settings
+func applySettings(channels []channel, s *settings) {
+	for i := range channels {
+		c := &channels[i]
+		if c.messageTemplate == nil {
+			c.messageTemplate = s.messageTemplate
+		}
+		if c.CheckInterval == 0 {
+			c.CheckInterval = s.checkInterval
+		}
+		if c.Lookback == 0 {
+			c.Lookback = s.lookback
+		}
+		c.skipShorts = s.skipShorts
+		if c.SkipShorts != nil {
+			c.skipShorts = *c.SkipShorts
+		}
+		if c.Live == "" {
+			c.Live = s.live
+		}
+		if c.BackfillMode == "" {
+			c.BackfillMode = s.backfillMode
+		}
+		c.postStyle = s.postStyle
+		if c.PostStyle != "" {
+			c.postStyle = c.PostStyle
+		}
+		c.timestampStyle = s.timestampStyle
+		c.suppressEmbeds = s.suppressEmbeds
+		if c.routeSuppress != nil {
+			c.suppressEmbeds = *c.routeSuppress
+		}
+		c.digest = s.digest != "" && (c.Digest == nil || *c.Digest)
+	}
+}
+
+// sampleChannelsFile returns an example channels file built from the built-in channel list
+func sampleChannelsFile() ([]byte, error) {
+	cf := channelsFile{}
+	for cN, cId := range channelIds {
+		cf.Channels = append(cf.Channels, channel{Name: cN, ID: cId})
+	}
+	sort.Slice(cf.Channels, func(i, j int) bool { return cf.Channels[i].Name < cf.Channels[j].Name })
+
+	var buf bytes.Buffer
+	buf.WriteString("# ytbot channels file, see --channels-file\n---\n")
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	err := enc.Encode(cf)
+	if err != nil {
+		return nil, err
+	}
+	err = enc.Close()
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// runConfigInit writes a sample channels file to stdout, or to --out if given
+func runConfigInit(cliContext *cli.Context) error {
+	b, err := sampleChannelsFile()
+	if err != nil {
+		return err
+	}
+
+	out := cliContext.Path("out")
+	if out == "" {
+		_, err = os.Stdout.Write(b)
+		return err
+	}
+
+	// don't clobber an existing config
+	f, err := os.OpenFile(out, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(b)
+	return err
+}
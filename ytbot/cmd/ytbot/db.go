@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+
+	"pw-ytbot/store"
+)
+
+// journal modes for --db-journal-mode
+const (
+	journalModeWAL    = "wal"    // concurrent readers alongside a writer, the default
+	journalModeDelete = "delete" // sqlite's own default, for network filesystems where WAL is unsafe
+)
+
+// validateJournalMode checks a --db-journal-mode is a known journal mode
+func validateJournalMode(s string) error {
+	switch s {
+	case journalModeWAL, journalModeDelete:
+		return nil
+	}
+	return fmt.Errorf("unknown journal mode %q, must be %s or %s", s, journalModeWAL, journalModeDelete)
+}
+
+// dbOptions are which database to use and how connections to it are set up
+type dbOptions struct {
+	driver      string        // --db-driver
+	path        string        // --dbfile, for sqlite
+	dsn         string        // --db-dsn, for postgres
+	journalMode string        // --db-journal-mode
+	busyTimeout time.Duration // --db-busy-timeout, how long to wait for another connection's lock
+}
+
+// dbOptionsFromFlags returns the dbOptions given by --db-driver, --dbfile or
+// --db-dsn, --db-journal-mode and --db-busy-timeout
+func dbOptionsFromFlags(cliContext *cli.Context) (dbOptions, error) {
+	opts := dbOptions{
+		driver:      strings.ToLower(cliContext.String("db-driver")),
+		path:        cliContext.Path("dbfile"),
+		dsn:         cliContext.String("db-dsn"),
+		journalMode: strings.ToLower(cliContext.String("db-journal-mode")),
+		busyTimeout: cliContext.Duration("db-busy-timeout"),
+	}
+	var err error
+	switch opts.driver {
+	case store.DriverSQLite:
+		err = checkFlagsSet(cliContext, "dbfile")
+	case store.DriverPostgres:
+		err = checkFlagsSet(cliContext, "db-dsn")
+	default:
+		err = fmt.Errorf("unknown --db-driver %q, must be %s or %s", opts.driver, store.DriverSQLite, store.DriverPostgres)
+	}
+	if err != nil {
+		return dbOptions{}, err
+	}
+	err = validateJournalMode(opts.journalMode)
+	if err != nil {
+		return dbOptions{}, fmt.Errorf("invalid --db-journal-mode: %w", err)
+	}
+	if opts.busyTimeout < 0 {
+		return dbOptions{}, fmt.Errorf("--db-busy-timeout can't be negative, got %s", opts.busyTimeout)
+	}
+	return opts, nil
+}
+
+// name describes the database for errors and logs, without the password a
+// postgres connection string may have
+func (o dbOptions) name() string {
+	if o.driver != store.DriverPostgres {
+		return o.path
+	}
+	if u, err := url.Parse(o.dsn); err == nil && u.Host != "" {
+		return u.Redacted()
+	}
+	return "postgres database"
+}
+
+// openDB opens the database, migrating its schema to the latest version. A
+// new channels table is seeded from the built-in channel list if seedBuiltin
+// is set.
+func openDB(opts dbOptions, seedBuiltin bool) (store.Store, error) {
+	builtin := make([]store.Channel, 0, len(channelIds))
+	for cN, cId := range channelIds {
+		builtin = append(builtin, store.Channel{ID: string(cId), Name: string(cN), Enabled: true, Source: channelSourceBuiltin})
+	}
+	dsn := opts.path
+	if opts.driver == store.DriverPostgres {
+		dsn = opts.dsn
+	}
+	return store.Open(opts.driver, dsn, store.Options{
+		JournalMode: opts.journalMode,
+		BusyTimeout: opts.busyTimeout,
+		Builtin:     builtin,
+		SeedBuiltin: seedBuiltin,
+	})
+}
+
+// kinds of post recorded in videos_posted.post_type
+const (
+	postTypeVideo    = "video"    // posted as a normal video
+	postTypePremiere = "premiere" // posted as an upcoming premiere, not yet as live
+	postTypeStream   = "stream"   // announced as a scheduled stream, see upcoming_streams
+	postTypeSkipped  = "skipped"  // deliberately not posted
+
+	postTypeSkippedPrivate = "skipped_private" // not posted as it was private, deleted or still processing
+
+	postTypeQueued      = "queued"      // waiting in pending_posts to be delivered
+	postTypeUndelivered = "undelivered" // posting failed maxDeliveryAttempts times, given up on
+	postTypeDigest      = "digest"      // collected in digest_items for the next --digest
+	postTypeBackfilled  = "backfilled"  // recorded by ytbot backfill without posting
+)
+
+// recordVideoMessage records the Discord message a video was posted as, and
+// any thread started under it. For a video posted to several webhooks, that's
+// the latest one.
+func recordVideoMessage(db store.Queries, videoId string, m postedMessage) error {
+	return db.SetVideoMessage(videoId, m.ID, m.ChannelID, m.ThreadID)
+}
+
+// recordVideo records a video as handled so it is never posted (again), or
+// updates how it was posted if it already has been
+func recordVideo(db store.Queries, videoId, postType string) error {
+	platform := platformYouTube
+	if isPeerTubeId(videoId) {
+		platform = platformPeerTube
+	}
+	return db.MarkPosted(videoId, postType, platform)
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+	"unicode/utf8"
+
+	"github.com/rs/zerolog/log"
+
+	"pw-ytbot/store"
+)
+
+// digestDaily sends a digest of the day's uploads once a day, for --digest
+const digestDaily = "daily"
+
+// validateDigest checks a --digest is empty or a known schedule
+func validateDigest(s string) error {
+	switch s {
+	case "", digestDaily:
+		return nil
+	}
+	return fmt.Errorf("unknown digest %q, must be %s", s, digestDaily)
+}
+
+// digestItem is an upload waiting in digest_items for the next digest to a webhook
+type digestItem struct {
+	VideoID      string
+	Webhook      string
+	Forum        bool // the webhook posts to a forum channel
+	ChannelID    channelId
+	ChannelTitle string
+	Title        string
+	URL          string
+}
+
+// addToDigest collects one of a channel's uploads for the next digest to each
+// of the webhooks, and records it so it isn't found and collected again
+func (b *bot) addToDigest(db store.Queries, c channel, v video, url string, webhooks []string) error {
+	title := v.ChannelTitle
+	if title == "" {
+		title = string(c.displayName())
+	}
+	for _, webhook := range webhooks {
+		err := db.AddDigestItem(store.DigestItem{
+			VideoID:      v.ID,
+			Webhook:      webhook,
+			Forum:        c.postsToForum(),
+			ChannelID:    string(c.ID),
+			ChannelTitle: title,
+			Title:        v.Title,
+			URL:          url,
+		})
+		if err != nil {
+			return fmt.Errorf("error adding video to digest in db: %w", err)
+		}
+	}
+	err := recordVideo(db, v.ID, postTypeDigest)
+	if err != nil {
+		return fmt.Errorf("error inserting video into db: %w", err)
+	}
+	return nil
+}
+
+// digestItems returns the uploads waiting for the next digest, oldest first
+func digestItems(db store.Queries) ([]digestItem, error) {
+	stored, err := db.DigestItems()
+	if err != nil {
+		return nil, err
+	}
+	var items []digestItem
+	for _, it := range stored {
+		items = append(items, digestItem{
+			VideoID:      it.VideoID,
+			Webhook:      it.Webhook,
+			Forum:        it.Forum,
+			ChannelID:    channelId(it.ChannelID),
+			ChannelTitle: it.ChannelTitle,
+			Title:        it.Title,
+			URL:          it.URL,
+		})
+	}
+	return items, nil
+}
+
+// digestDueAt returns when the latest digest was due, at or before now
+func (s *settings) digestDueAt(now time.Time) time.Time {
+	now = now.In(s.digestLoc)
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, s.digestLoc)
+	if now.Before(day.Add(s.digestAt)) {
+		day = day.AddDate(0, 0, -1)
+	}
+	return day.Add(s.digestAt)
+}
+
+// sendDigest posts the uploads collected since the last digest, once the
+// digest is due. Each message's uploads are removed from digest_items as
+// Discord accepts it, and the digest is recorded in digests once they've all
+// been posted, so a restart neither sends a digest twice nor loses one that
+// failed part way. A due digest with nothing in it is recorded too, so
+// uploads found later wait for the next one.
+func (b *bot) sendDigest(ctx context.Context) error {
+	dueAt := b.settings.digestDueAt(time.Now())
+	sent, err := b.db.DigestSent(dueAt)
+	if err != nil {
+		return fmt.Errorf("error querying db for digests: %w", err)
+	}
+	if sent {
+		return nil
+	}
+
+	items, err := digestItems(b.db)
+	if err != nil {
+		return fmt.Errorf("error reading digest items from db: %w", err)
+	}
+	log.Info().Time("due_at", dueAt).Int("videos", len(items)).Msg("sending digest")
+
+	// one digest per webhook, in the order their uploads were found
+	var webhooks []string
+	byWebhook := make(map[string][]digestItem)
+	for _, it := range items {
+		if _, ok := byWebhook[it.Webhook]; !ok {
+			webhooks = append(webhooks, it.Webhook)
+		}
+		byWebhook[it.Webhook] = append(byWebhook[it.Webhook], it)
+	}
+
+	var errs []error
+	for _, webhook := range webhooks {
+		log := log.With().Str("webhook", redactWebhook(webhook)).Logger()
+		for _, p := range digestPayloads(byWebhook[webhook], b.settings.postStyle) {
+			if ctx.Err() != nil {
+				return nil
+			}
+			_, err := b.notifierFor(webhook).send(ctx, webhook, p.payload, false)
+			if err != nil {
+				log.Error().AnErr("err", err).Msg("error posting digest to webhook")
+				errs = append(errs, err)
+				break
+			}
+			for _, it := range p.items {
+				err = b.db.DeleteDigestItem(it.VideoID, it.Webhook)
+				if err != nil {
+					return fmt.Errorf("error deleting digest item from db: %w", err)
+				}
+				err = b.db.MarkDelivered(it.VideoID, it.Webhook)
+				if err != nil {
+					return fmt.Errorf("error recording delivery in db: %w", err)
+				}
+			}
+			log.Info().Int("videos", len(p.items)).Msg("posted digest")
+
+			if !sleepContext(ctx, b.settings.postDelay) {
+				return nil
+			}
+		}
+	}
+	// what failed to post stays collected, and the digest is tried again next cycle
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	err = b.db.RecordDigest(dueAt, len(items))
+	if err != nil {
+		return fmt.Errorf("error recording digest in db: %w", err)
+	}
+	return nil
+}
+
+// digestPayload is one message of a digest, and the uploads in it
+type digestPayload struct {
+	payload webhookPayload
+	items   []digestItem
+}
+
+// digestPayloads builds the messages of a digest of items to one webhook,
+// with a line per upload in the text post style, or an embed field per
+// upload in the embed style. Digests too long for one message are split
+// into several to fit Discord's limits.
+func digestPayloads(items []digestItem, style string) []digestPayload {
+	if len(items) == 0 {
+		return nil
+	}
+	heading := fmt.Sprintf("Daily digest: %d new videos", len(items))
+	if len(items) == 1 {
+		heading = "Daily digest: 1 new video"
+	}
+
+	var payloads []digestPayload
+	if style == postStyleEmbed {
+		var (
+			e     embed
+			size  int
+			batch []digestItem
+		)
+		flush := func() {
+			payloads = append(payloads, digestPayload{payload: newWebhookPayload("", ""), items: batch})
+			payloads[len(payloads)-1].payload.Embeds = []embed{e}
+			batch = nil
+		}
+		for _, it := range items {
+			name := limitRunes(it.ChannelTitle, discordMaxEmbedFieldName)
+			if name == "" {
+				name = string(it.ChannelID)
+			}
+			value := fmt.Sprintf("[%s](%s)", limitRunes(escapeMarkdown(it.Title), discordMaxEmbedFieldValue-len(it.URL)-4), it.URL)
+			fieldSize := utf8.RuneCountInString(name) + utf8.RuneCountInString(value)
+			if len(batch) > 0 && (len(e.Fields) == discordMaxEmbedFields || size+fieldSize > discordMaxEmbedChars) {
+				flush()
+			}
+			if len(batch) == 0 {
+				e = embed{Title: heading, Timestamp: time.Now().UTC().Format(time.RFC3339)}
+				size = utf8.RuneCountInString(heading)
+			}
+			e.Fields = append(e.Fields, embedField{Name: name, Value: value})
+			size += fieldSize
+			batch = append(batch, it)
+		}
+		flush()
+	} else {
+		var (
+			content string
+			batch   []digestItem
+		)
+		content = "**" + heading + "**"
+		for _, it := range items {
+			line := limitRunes(fmt.Sprintf("**%s**: [%s](<%s>)", escapeMarkdown(it.ChannelTitle), escapeMarkdown(it.Title), it.URL), discordMaxContent)
+			if len(batch) > 0 && utf8.RuneCountInString(content)+1+utf8.RuneCountInString(line) > discordMaxContent {
+				payloads = append(payloads, digestPayload{payload: newWebhookPayload(content, ""), items: batch})
+				content, batch = "", nil
+			}
+			if content != "" {
+				content += "\n"
+			}
+			content += line
+			batch = append(batch, it)
+		}
+		payloads = append(payloads, digestPayload{payload: newWebhookPayload(content, ""), items: batch})
+	}
+
+	// a post to a forum channel has to start a forum post
+	if items[0].Forum {
+		name := "Daily digest, " + time.Now().Format("2 January 2006")
+		for i := range payloads {
+			payloads[i].payload.ThreadName = name
+		}
+	}
+	return payloads
+}
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/urfave/cli/v2"
+)
+
+// validateWebhook checks that s looks like a usable webhook URL
+func validateWebhook(s string) error {
+	if isTelegramDestination(s) {
+		return validateTelegramChatId(strings.TrimPrefix(s, telegramDestinationPrefix))
+	}
+	if s == mastodonDestination {
+		return nil
+	}
+	if isFileDestination(s) {
+		return validateEventFile(strings.TrimPrefix(s, fileDestinationPrefix))
+	}
+	if isDiscordChannelDestination(s) {
+		return validateDiscordChannelId(strings.TrimPrefix(s, discordChannelDestinationPrefix))
+	}
+	if isMatrixDestination(s) {
+		return validateMatrixRoomId(strings.TrimPrefix(s, matrixDestinationPrefix))
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "https" && u.Scheme != "http" {
+		return fmt.Errorf("webhook URL scheme must be http or https, got %q", u.Scheme)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("webhook URL %q has no host", redactWebhook(s))
+	}
+	return nil
+}
+
+// redactWebhook strips the token (last path element) from a webhook URL so it
+// can be logged. Telegram chats, Mastodon, event files, Discord channels
+// posted to with the bot and Matrix rooms have no token, but Telegram's API
+// URLs do.
+func redactWebhook(s string) string {
+	if isTelegramDestination(s) || s == mastodonDestination || isFileDestination(s) || isDiscordChannelDestination(s) || isMatrixDestination(s) {
+		return s
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return "<invalid webhook URL>"
+	}
+	if u.Host == "api.telegram.org" {
+		return telegramAPI + "/<redacted>"
+	}
+	u.Path = path.Dir(u.Path)
+	u.RawQuery = ""
+	return strings.TrimSuffix(u.String(), "/") + "/<redacted>"
+}
+
+// redactWebhooks redacts each of a list of webhooks for logging
+func redactWebhooks(webhooks []string) []string {
+	redacted := make([]string, len(webhooks))
+	for i, webhook := range webhooks {
+		redacted[i] = redactWebhook(webhook)
+	}
+	return redacted
+}
+
+type (
+	// webhookPayload is the JSON body sent to a Discord webhook. It is only
+	// ever marshalled with encoding/json, so titles with quotes, backslashes
+	// or newlines can't break it.
+	webhookPayload struct {
+		Content         string           `json:"content,omitempty"`
+		Username        string           `json:"username,omitempty"`   // overrides the webhook's name
+		AvatarURL       string           `json:"avatar_url,omitempty"` // overrides the webhook's avatar
+		Embeds          []embed          `json:"embeds,omitempty"`
+		AllowedMentions *allowedMentions `json:"allowed_mentions,omitempty"`
+		ThreadName      string           `json:"thread_name,omitempty"` // starts a post in a forum channel
+		Flags           int              `json:"flags,omitempty"`       // message flags, e.g. discordFlagSuppressEmbeds
+
+		Event  *videoEvent    `json:"-"` // the video posted, for HTTP sinks, which are sent it rather than the message
+		Render *messageRender `json:"-"` // what the message was rendered from, for re-rendering it when the title changes
+	}
+
+	// allowedMentions restricts which mentions in the content actually ping
+	allowedMentions struct {
+		Parse []string `json:"parse"`
+		Roles []string `json:"roles,omitempty"`
+	}
+
+	// embed is a Discord message embed
+	embed struct {
+		Title       string       `json:"title,omitempty"`
+		Description string       `json:"description,omitempty"`
+		URL         string       `json:"url,omitempty"`
+		Timestamp   string       `json:"timestamp,omitempty"` // RFC 3339
+		Color       int          `json:"color,omitempty"`
+		Author      *embedAuthor `json:"author,omitempty"`
+		Thumbnail   *embedImage  `json:"thumbnail,omitempty"`
+		Image       *embedImage  `json:"image,omitempty"`
+		Footer      *embedFooter `json:"footer,omitempty"`
+		Fields      []embedField `json:"fields,omitempty"`
+	}
+
+	embedAuthor struct {
+		Name    string `json:"name"`
+		URL     string `json:"url,omitempty"`
+		IconURL string `json:"icon_url,omitempty"`
+	}
+
+	embedImage struct {
+		URL string `json:"url"`
+	}
+
+	embedFooter struct {
+		Text    string `json:"text"`
+		IconURL string `json:"icon_url,omitempty"`
+	}
+
+	embedField struct {
+		Name   string `json:"name"`
+		Value  string `json:"value"`
+		Inline bool   `json:"inline,omitempty"`
+	}
+)
+
+// discordFlagSuppressEmbeds is the message flag that stops Discord adding
+// previews of the links in a message
+const discordFlagSuppressEmbeds = 1 << 2
+
+// newWebhookPayload builds a payload for content, mentioning roleId first if set.
+// Only the configured role is allowed to ping, never @everyone/@here or users.
+func newWebhookPayload(content, roleId string) webhookPayload {
+	p := webhookPayload{
+		Content:         content,
+		AllowedMentions: &allowedMentions{Parse: []string{}},
+	}
+	if roleId != "" {
+		p.Content = fmt.Sprintf("<@&%s> %s", roleId, content)
+		p.AllowedMentions.Roles = []string{roleId}
+	}
+	return p
+}
+
+// postWebhook sends payload to a Discord webhook. The request isn't cancelled
+// with ctx, so a post that has started always finishes and can be recorded.
+func postWebhook(ctx context.Context, webhook string, payload any) (*http.Response, error) {
+	method := http.MethodPost
+	if m, ok := payload.(methodPayload); ok {
+		method = m.method()
+	}
+	return sendWebhook(ctx, method, webhook, payload)
+}
+
+// headerPayload is a payload sent with headers of its own, e.g. to authorize
+// the request with a token that shouldn't be logged with the payload
+type headerPayload interface {
+	headers() http.Header
+}
+
+// methodPayload is a payload sent with a method other than POST
+type methodPayload interface {
+	method() string
+}
+
+const (
+	// webhookRetries is how many times a webhook request rate limited by Discord is retried
+	webhookRetries = 3
+
+	// webhookMaxRetryAfter caps how long to wait when Discord rate limits a webhook request
+	webhookMaxRetryAfter = time.Minute
+)
+
+// sendWebhook sends payload to a Discord webhook URL with method, without
+// being cancelled by ctx. Requests Discord rate limits are retried after the
+// wait it asks for, unless ctx is done. The response body is read in full so
+// it can still be used once the connection is closed.
+func sendWebhook(ctx context.Context, method, u string, payload any) (*http.Response, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	client := http.Client{
+		Timeout: 30 * time.Second,
+	}
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(context.WithoutCancel(ctx), method, u, bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if h, ok := payload.(headerPayload); ok {
+			for k, v := range h.headers() {
+				req.Header[k] = v
+			}
+		}
+		res, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		body, err := io.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		res.Body = io.NopCloser(bytes.NewReader(body))
+
+		log.Debug().
+			Str("status", res.Status).
+			Str("bucket", res.Header.Get("X-RateLimit-Bucket")).
+			Str("limit", res.Header.Get("X-RateLimit-Limit")).
+			Str("remaining", res.Header.Get("X-RateLimit-Remaining")).
+			Str("reset_after", res.Header.Get("X-RateLimit-Reset-After")).
+			Str("scope", res.Header.Get("X-RateLimit-Scope")).
+			Msg("webhook response")
+		if res.StatusCode != http.StatusTooManyRequests || attempt == webhookRetries {
+			return res, nil
+		}
+
+		wait := min(retryAfter(res.Header, body), webhookMaxRetryAfter)
+		log.Warn().Str("webhook", redactWebhook(u)).Int("attempt", attempt+1).Dur("wait", wait).Msg("rate limited, retrying")
+		if !sleepContext(ctx, wait) {
+			return res, nil
+		}
+	}
+}
+
+// discordError returns an error for an unexpected response from Discord,
+// including the start of the body, where Discord explains what was wrong
+func discordError(res *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 {
+		return fmt.Errorf("unexpected http response %s", res.Status)
+	}
+	return fmt.Errorf("unexpected http response %s: %s", res.Status, body)
+}
+
+// retryAfter returns how long Discord asks to wait before retrying a rate
+// limited request, from the Retry-After header or the retry_after in the
+// body, both in seconds. Telegram gives it in the body's parameters.
+func retryAfter(header http.Header, body []byte) time.Duration {
+	var limited struct {
+		RetryAfter float64 `json:"retry_after"`
+		Parameters struct {
+			RetryAfter float64 `json:"retry_after"`
+		} `json:"parameters"`
+	}
+	seconds, err := strconv.ParseFloat(header.Get("Retry-After"), 64)
+	if err != nil && json.Unmarshal(body, &limited) == nil {
+		seconds = max(limited.RetryAfter, limited.Parameters.RetryAfter)
+	}
+	if seconds <= 0 {
+		return time.Second
+	}
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// dryRunPost logs the payload that would be posted to a webhook instead of posting it
+func dryRunPost(ctx context.Context, webhook string, payload any) (*http.Response, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	log.Info().Str("webhook", redactWebhook(webhook)).RawJSON("payload", data).Msg("dry run, not posting")
+	return &http.Response{StatusCode: http.StatusNoContent, Status: "204 No Content (dry run)"}, nil
+}
+
+// webhookInfo is the metadata Discord returns for a GET on a webhook URL
+type webhookInfo struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	ChannelID string `json:"channel_id"`
+	GuildID   string `json:"guild_id"`
+}
+
+// getWebhookInfo looks up a webhook's name and the channel it posts to
+func getWebhookInfo(ctx context.Context, webhook string) (webhookInfo, error) {
+	var info webhookInfo
+	req, err := http.NewRequestWithContext(ctx, "GET", webhook, nil)
+	if err != nil {
+		return info, err
+	}
+	client := http.Client{
+		Timeout: 30 * time.Second,
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return info, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return info, fmt.Errorf("unexpected http response %s", res.Status)
+	}
+	err = json.NewDecoder(res.Body).Decode(&info)
+	return info, err
+}
+
+// runWebhookTest checks each --webhook is the one intended, then posts a test message to it
+func runWebhookTest(cliContext *cli.Context) error {
+	err := checkFlagsSet(cliContext, "webhook")
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for i, webhook := range splitWebhooks(cliContext.StringSlice("webhook")) {
+		if i > 0 {
+			fmt.Println()
+		}
+		err = testWebhook(cliContext.Context, webhook)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", redactWebhook(webhook), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// testWebhook shows which channel a webhook posts to, and posts a test message to it
+func testWebhook(ctx context.Context, webhook string) error {
+	err := validateWebhook(webhook)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("webhook:     %s\n", redactWebhook(webhook))
+	if isSlackWebhook(webhook) {
+		return testSlackWebhook(ctx, webhook)
+	}
+
+	info, err := getWebhookInfo(ctx, webhook)
+	if err != nil {
+		return fmt.Errorf("error looking up webhook: %w", err)
+	}
+	fmt.Printf("name:        %s\n", info.Name)
+	fmt.Printf("channel id:  %s\n", info.ChannelID)
+	fmt.Printf("guild id:    %s\n", info.GuildID)
+
+	start := time.Now()
+	res, err := postWebhook(ctx, webhook, newWebhookPayload("ytbot connectivity test, please ignore", ""))
+	if err != nil {
+		return fmt.Errorf("error posting test message: %w", err)
+	}
+	fmt.Printf("status:      %s\n", res.Status)
+	fmt.Printf("round trip:  %s\n", time.Since(start).Round(time.Millisecond))
+	for _, h := range []string{"X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset-After", "X-RateLimit-Bucket"} {
+		if v := res.Header.Get(h); v != "" {
+			fmt.Printf("%-24s %s\n", strings.ToLower(h)+":", v)
+		}
+	}
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("test message not posted: %s", res.Status)
+	}
+	return nil
+}
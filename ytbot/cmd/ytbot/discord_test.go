@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestNewWebhookPayload(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		roleId  string
+		want    map[string]any
+	}{
+		{
+			name:    "no role",
+			content: "New video",
+			want: map[string]any{
+				"content":          "New video",
+				"allowed_mentions": map[string]any{"parse": []any{}},
+			},
+		},
+		{
+			name:    "role",
+			content: "New video",
+			roleId:  "123456789",
+			want: map[string]any{
+				"content":          "<@&123456789> New video",
+				"allowed_mentions": map[string]any{"parse": []any{}, "roles": []any{"123456789"}},
+			},
+		},
+		{
+			name:    "mentions in content don't ping",
+			content: `@everyone "quoted" \ title`,
+			want: map[string]any{
+				"content":          `@everyone "quoted" \ title`,
+				"allowed_mentions": map[string]any{"parse": []any{}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := json.Marshal(newWebhookPayload(tt.content, tt.roleId))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got map[string]any
+			err = json.Unmarshal(b, &got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("payload = %s, want %v", b, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// discordChannelDestinationPrefix marks a destination as a Discord channel
+	// posted to with --bot-token rather than a webhook URL, followed by the
+	// channel's ID
+	discordChannelDestinationPrefix = "discord:"
+
+	// discordChannelTypeAnnouncement is the type of announcement channels,
+	// whose messages can be published to the servers following them
+	discordChannelTypeAnnouncement = 5
+)
+
+// Discord API error codes that mean the bot can't do something in a channel
+const (
+	discordErrorMissingAccess      = 50001
+	discordErrorMissingPermissions = 50013
+)
+
+// discordChannelIdPattern matches a Discord channel's ID, a snowflake
+var discordChannelIdPattern = regexp.MustCompile(`^\d{17,20}$`)
+
+// discordChannelDestination returns the destination posting to a Discord
+// channel with the bot, which is kept with webhook URLs, e.g. in the outbox
+func discordChannelDestination(channelId string) string {
+	return discordChannelDestinationPrefix + channelId
+}
+
+// isDiscordChannelDestination returns whether a destination is a Discord
+// channel posted to with the bot
+func isDiscordChannelDestination(s string) bool {
+	return strings.HasPrefix(s, discordChannelDestinationPrefix)
+}
+
+// validateDiscordChannelId checks a Discord channel ID looks usable
+func validateDiscordChannelId(id string) error {
+	if !discordChannelIdPattern.MatchString(id) {
+		return fmt.Errorf("invalid Discord channel ID %q, must be a number", id)
+	}
+	return nil
+}
+
+// checkBotToken checks --bot-token is set if any channel's route posts to a
+// Discord channel with the bot
+func checkBotToken(channels []channel, s *settings) error {
+	if s.botToken != "" {
+		return nil
+	}
+	for _, c := range channels {
+		for _, d := range c.routeWebhooks {
+			if isDiscordChannelDestination(d) {
+				return fmt.Errorf("route for tag %q posts to a Discord channel with the bot, which needs --bot-token", c.routeTag)
+			}
+		}
+	}
+	return nil
+}
+
+// botRequest is the body of a Discord bot API request, authorized with the
+// bot's token, which isn't logged with it
+type botRequest struct {
+	body  any
+	token string
+}
+
+func (r botRequest) MarshalJSON() ([]byte, error) {
+	return json.Marshal(r.body)
+}
+
+func (r botRequest) headers() http.Header {
+	return http.Header{"Authorization": {"Bot " + r.token}}
+}
+
+type (
+	// botMessage is a message posted with the bot, which takes the same
+	// content, embeds and mentions as a webhook
+	botMessage struct {
+		Content         string           `json:"content,omitempty"`
+		Embeds          []embed          `json:"embeds,omitempty"`
+		AllowedMentions *allowedMentions `json:"allowed_mentions,omitempty"`
+		Flags           int              `json:"flags,omitempty"`
+	}
+
+	// botForumPost starts a post in a forum channel with the bot
+	botForumPost struct {
+		Name    string     `json:"name"`
+		Message botMessage `json:"message"`
+	}
+)
+
+// botNotifier posts to Discord channels with --bot-token rather than a
+// webhook. Messages posted to announcement channels are published, so the
+// servers following the channel get them too, which webhook messages can't be.
+type botNotifier struct {
+	b *bot
+}
+
+func (n botNotifier) send(ctx context.Context, destination string, payload webhookPayload, wait bool) (*http.Response, error) {
+	token := n.b.settings.botToken
+	if token == "" {
+		return nil, errors.New("posting to a Discord channel with the bot needs --bot-token")
+	}
+	channelId := strings.TrimPrefix(destination, discordChannelDestinationPrefix)
+	payload = fitPayload(payload, destination)
+	message := botMessage{Content: payload.Content, Embeds: payload.Embeds, AllowedMentions: payload.AllowedMentions, Flags: payload.Flags}
+
+	// forum channels take a new post rather than a message
+	u := fmt.Sprintf("%s/channels/%s/messages", discordAPI, channelId)
+	var body any = message
+	if payload.ThreadName != "" {
+		u = fmt.Sprintf("%s/channels/%s/threads", discordAPI, channelId)
+		body = botForumPost{Name: payload.ThreadName, Message: message}
+	}
+	res, err := n.b.post(ctx, u, botRequest{body: body, token: token})
+	if err != nil {
+		return nil, err
+	}
+	if n.b.dryRun {
+		return res, nil
+	}
+	if res.StatusCode/100 != 2 {
+		return nil, botError(res, channelId, "post to")
+	}
+	if payload.ThreadName != "" {
+		return res, nil
+	}
+
+	var m postedMessage
+	err = json.NewDecoder(res.Body).Decode(&m)
+	if err != nil || m.ID == "" {
+		log.Warn().AnErr("err", err).Str("discord_channel_id", channelId).Msg("posted message not returned, not publishing it")
+		return res, nil
+	}
+	n.publish(ctx, channelId, m.ID)
+	return res, nil
+}
+
+func (botNotifier) tracksMessages() bool {
+	return false
+}
+
+// publish crossposts a message to the servers following its channel, if it's
+// an announcement channel. Failing to is only logged, as the message has been
+// posted either way.
+func (n botNotifier) publish(ctx context.Context, channelId, messageId string) {
+	log := log.With().Str("discord_channel_id", channelId).Str("message_id", messageId).Logger()
+	announcement, err := n.b.isAnnouncementChannel(ctx, channelId)
+	if err != nil {
+		log.Warn().AnErr("err", err).Msg("error looking up Discord channel, not publishing message")
+		return
+	}
+	if !announcement {
+		return
+	}
+
+	u := fmt.Sprintf("%s/channels/%s/messages/%s/crosspost", discordAPI, channelId, messageId)
+	res, err := n.b.post(ctx, u, botRequest{body: struct{}{}, token: n.b.settings.botToken})
+	if err == nil && res.StatusCode/100 != 2 {
+		err = botError(res, channelId, "publish messages in")
+	}
+	if err != nil {
+		log.Error().AnErr("err", err).Msg("error publishing message to following servers")
+		return
+	}
+	log.Info().Msg("published message to following servers")
+}
+
+// isAnnouncementChannel returns whether a Discord channel is an announcement
+// channel, looking it up with the bot the first time it's asked about
+func (b *bot) isAnnouncementChannel(ctx context.Context, channelId string) (bool, error) {
+	b.discordChannelsMu.Lock()
+	defer b.discordChannelsMu.Unlock()
+	if t, ok := b.discordChannelTypes[channelId]; ok {
+		return t == discordChannelTypeAnnouncement, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/channels/%s", discordAPI, channelId), nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Authorization", "Bot "+b.settings.botToken)
+	client := http.Client{
+		Timeout: 30 * time.Second,
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		return false, botError(res, channelId, "view")
+	}
+
+	var c struct {
+		Type int `json:"type"`
+	}
+	err = json.NewDecoder(res.Body).Decode(&c)
+	if err != nil {
+		return false, fmt.Errorf("error reading channel: %w", err)
+	}
+	if b.discordChannelTypes == nil {
+		b.discordChannelTypes = make(map[string]int)
+	}
+	b.discordChannelTypes[channelId] = c.Type
+	return c.Type == discordChannelTypeAnnouncement, nil
+}
+
+// botError returns an error for an unexpected response to a bot API request
+// to do something in a channel. When the bot isn't allowed to, it says which
+// permissions it needs, as Discord only says they're missing.
+func botError(res *http.Response, channelId, action string) error {
+	body, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+	body = bytes.TrimSpace(body)
+	err := fmt.Errorf("unexpected http response %s", res.Status)
+	if len(body) > 0 {
+		err = fmt.Errorf("unexpected http response %s: %s", res.Status, body)
+	}
+
+	var discordErr struct {
+		Code int `json:"code"`
+	}
+	_ = json.Unmarshal(body, &discordErr)
+	switch discordErr.Code {
+	case discordErrorMissingAccess:
+		return fmt.Errorf("bot can't %s channel %s, it needs to be in the server with the View Channel permission there: %w", action, channelId, err)
+	case discordErrorMissingPermissions:
+		return fmt.Errorf("bot isn't allowed to %s channel %s, it needs the View Channel and Send Messages permissions there, and Manage Messages to publish in an announcement channel: %w", action, channelId, err)
+	}
+	return err
+}
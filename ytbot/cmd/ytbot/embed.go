@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// how videos are posted to Discord, for --post-style
+const (
+	postStyleText  = "text"  // the rendered message, left to Discord to unfurl the link
+	postStyleEmbed = "embed" // the rendered message along with an embed of the video
+)
+
+// validatePostStyle checks a post style is one we know about
+func validatePostStyle(s string) error {
+	switch s {
+	case postStyleText, postStyleEmbed:
+		return nil
+	}
+	return fmt.Errorf("unknown post style %q, must be %s or %s", s, postStyleText, postStyleEmbed)
+}
+
+// videoEmbed returns an embed linking to a video, with its thumbnail, its
+// channel as the author, and its playlist, duration, definition and when it
+// was published in the footer
+func videoEmbed(d messageData) embed {
+	e := embed{
+		Title: d.Title,
+		URL:   d.URL,
+		Image: &embedImage{URL: d.Thumbnail},
+	}
+	if e.Title == "" {
+		e.Title = d.URL
+	}
+	if d.ChannelTitle != "" {
+		e.Author = &embedAuthor{Name: d.ChannelTitle}
+	}
+	var footer []string
+	for _, s := range []string{d.PlaylistTitle, d.Duration, d.Definition} {
+		if s != "" {
+			footer = append(footer, s)
+		}
+	}
+	if len(footer) > 0 {
+		e.Footer = &embedFooter{Text: strings.Join(footer, " · ")}
+	}
+	// Discord shows the timestamp in the footer, in each viewer's timezone
+	if t, err := time.Parse(time.RFC3339, d.Published); err == nil {
+		e.Timestamp = t.UTC().Format(time.RFC3339)
+	}
+	return e
+}
+
+// payload builds the payload posting content for one of the channel's
+// videos, with an embed of the video in the embed post style. Videos without
+// a thumbnail are posted as text, as the embed would be little use. Posts to
+// a forum channel are named after the video.
+func (c channel) payload(content string, v video, url string) webhookPayload {
+	p := newWebhookPayload(content, c.MentionRoleId)
+	if c.postsToForum() {
+		p.ThreadName = forumPostName(v)
+	}
+	if c.postStyle != postStyleEmbed {
+		return p
+	}
+	if v.Thumbnail == "" {
+		log.Debug().Str("video_id", v.ID).Msg("video has no thumbnail, posting as text rather than an embed")
+		return p
+	}
+	e := videoEmbed(c.messageData(v, url))
+	e.Color = c.embedColor
+	p.Embeds = []embed{e}
+	if c.suppressEmbeds {
+		p.Flags = discordFlagSuppressEmbeds
+	}
+	return p
+}
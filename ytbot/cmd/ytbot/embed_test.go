@@ -0,0 +1,61 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestChannelPayloadSuppressEmbeds(t *testing.T) {
+	tmpl, err := parseMessageTemplate("test", defaultMessageTemplate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := video{
+		ID:           "abcdefghijk",
+		ChannelTitle: "Mentour Pilot",
+		Title:        "Why this 737 nearly crashed",
+		Thumbnail:    "https://i.ytimg.com/vi/abcdefghijk/hqdefault.jpg",
+	}
+	url := v.URL()
+	tests := []struct {
+		name        string
+		c           channel
+		wantFlags   int
+		wantWrapped bool // the link in the content is in <>
+		wantEmbed   bool
+	}{
+		{"text", channel{postStyle: postStyleText}, 0, false, false},
+		{"text, suppressed", channel{postStyle: postStyleText, suppressEmbeds: true}, 0, true, false},
+		{"embed", channel{postStyle: postStyleEmbed}, 0, false, true},
+		{"embed, suppressed", channel{postStyle: postStyleEmbed, suppressEmbeds: true}, 1 << 2, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content, err := tt.c.renderMessage(tmpl, v, url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			p := tt.c.payload(content, v, url)
+
+			if p.Flags != tt.wantFlags {
+				t.Errorf("flags = %b, want %b", p.Flags, tt.wantFlags)
+			}
+
+			wrapped := strings.Contains(p.Content, "<"+url+">")
+			if wrapped != tt.wantWrapped {
+				t.Errorf("content %q has link in <> = %t, want %t", p.Content, wrapped, tt.wantWrapped)
+			}
+			if !strings.Contains(p.Content, url) {
+				t.Errorf("content %q doesn't link to %s", p.Content, url)
+			}
+
+			if got := len(p.Embeds) > 0; got != tt.wantEmbed {
+				t.Fatalf("has embed = %t, want %t", got, tt.wantEmbed)
+			}
+			// the embed links to the video itself, which is never wrapped
+			if tt.wantEmbed && p.Embeds[0].URL != url {
+				t.Errorf("embed url = %q, want %q", p.Embeds[0].URL, url)
+			}
+		})
+	}
+}
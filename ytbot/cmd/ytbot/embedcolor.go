@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg" // YouTube's thumbnails
+	_ "image/png"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// embedColorAuto is the embed_color that works out a channel's colour from a thumbnail
+	embedColorAuto = "auto"
+
+	// thumbnailMaxSize is the most of a thumbnail downloaded to work out its colour
+	thumbnailMaxSize = 5 << 20
+)
+
+// parseEmbedColor parses a channel's embed_color, a hex colour with or
+// without its #, or auto
+func parseEmbedColor(s string) (int, bool, error) {
+	switch s {
+	case "":
+		return 0, false, nil
+	case embedColorAuto:
+		return 0, true, nil
+	}
+	hex := strings.TrimPrefix(s, "#")
+	color, err := strconv.ParseUint(hex, 16, 24)
+	if err != nil || len(hex) != 6 {
+		return 0, false, fmt.Errorf("invalid colour %q, must be hex like #ff8800, or %s", s, embedColorAuto)
+	}
+	return int(color), false, nil
+}
+
+// embedColor returns the colour of the channel's embeds for videos: its
+// embed_color, or with auto, the colour of a thumbnail. Channels with auto
+// get their colour from the thumbnail of the first of the videos that has
+// one, the first time they're checked or posted from, which is kept in
+// channel_meta. If it can't be worked out, Discord's default is used, and
+// it's tried again with the next videos.
+//
+// Working out the colour downloads the thumbnail, so it must not be called
+// with a transaction open, which would hold up the database's other writers.
+func (b *bot) embedColor(ctx context.Context, c channel, videos []video) int {
+	if !c.embedColorAuto || c.postStyle != postStyleEmbed {
+		return c.embedColor
+	}
+	i := slices.IndexFunc(videos, func(v video) bool { return v.Thumbnail != "" })
+	if i < 0 {
+		return c.embedColor
+	}
+	v := videos[i]
+	color, ok, err := b.db.EmbedColor(string(c.ID))
+	if err != nil {
+		log.Debug().AnErr("err", err).Str("channel_id", string(c.ID)).Msg("error querying db for embed colour")
+		return 0
+	}
+	if ok {
+		return color
+	}
+
+	rgb, err := thumbnailColor(ctx, v.Thumbnail)
+	if err != nil {
+		log.Debug().AnErr("err", err).Str("channel_id", string(c.ID)).Str("video_id", v.ID).Msg("error working out embed colour from thumbnail, using the default")
+		return 0
+	}
+	log.Info().Str("channel_id", string(c.ID)).Str("video_id", v.ID).Str("embed_color", fmt.Sprintf("#%06x", rgb)).Msg("worked out channel's embed colour from thumbnail")
+	err = b.db.SetEmbedColor(string(c.ID), rgb)
+	if err != nil {
+		log.Debug().AnErr("err", err).Str("channel_id", string(c.ID)).Msg("error recording embed colour in db")
+	}
+	return rgb
+}
+
+// thumbnailColor downloads a thumbnail and returns its dominant colour
+func thumbnailColor(ctx context.Context, u string) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return 0, err
+	}
+	client := http.Client{
+		Timeout: 30 * time.Second,
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("unexpected http response %s", res.Status)
+	}
+	img, _, err := image.Decode(io.LimitReader(res.Body, thumbnailMaxSize))
+	if err != nil {
+		return 0, err
+	}
+	return dominantColor(img)
+}
+
+// dominantColor returns the most common colour in an image, the average of
+// the pixels in the fullest bucket of a coarse histogram. Pixels that are
+// nearly black or white are left out, as thumbnails are often letterboxed.
+func dominantColor(img image.Image) (int, error) {
+	type bucket struct {
+		r, g, b, n int
+	}
+	var buckets [8 * 8 * 8]bucket
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += 2 {
+		for x := bounds.Min.X; x < bounds.Max.X; x += 2 {
+			r, g, b, _ := img.At(x, y).RGBA()
+			r, g, b = r>>8, g>>8, b>>8
+			if max(r, g, b) < 32 || min(r, g, b) > 224 {
+				continue
+			}
+			bk := &buckets[r>>5<<6|g>>5<<3|b>>5]
+			bk.r += int(r)
+			bk.g += int(g)
+			bk.b += int(b)
+			bk.n++
+		}
+	}
+
+	fullest := &buckets[0]
+	for i := range buckets {
+		if buckets[i].n > fullest.n {
+			fullest = &buckets[i]
+		}
+	}
+	if fullest.n == 0 {
+		return 0, errors.New("image has no colour")
+	}
+	return fullest.r/fullest.n<<16 | fullest.g/fullest.n<<8 | fullest.b/fullest.n, nil
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"pw-ytbot/store"
+)
+
+// errNotModified is returned when a channel's or playlist's videos haven't
+// changed since the etag stored for them, so there is nothing to look at
+var errNotModified = errors.New("not modified since last check")
+
+// listEtag returns the etag stored for a channel's or playlist's items, or ""
+// to fetch them whatever, e.g. when reposting a video
+func (b *bot) listEtag(cId channelId) (string, error) {
+	if b.repost != "" {
+		return "", nil
+	}
+	etag, err := b.db.ListEtag(string(cId))
+	if err != nil {
+		return "", fmt.Errorf("error querying db: %w", err)
+	}
+	return etag, nil
+}
+
+// saveListEtag stores the etag of a channel's or playlist's items, so the
+// next check can skip them if they haven't changed
+func saveListEtag(db store.Queries, cId channelId, etag string) error {
+	return db.SetListEtag(string(cId), etag)
+}
+
+// forgetListEtag removes the etag stored for a channel's or playlist's items,
+// so the next check looks at them again even if they haven't changed
+func forgetListEtag(db store.Queries, cId channelId) error {
+	return db.ForgetListEtag(string(cId))
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// fileDestinationPrefix marks a destination as a file events are written
+	// to rather than a webhook URL, followed by the file's path
+	fileDestinationPrefix = "file:"
+
+	// eventFileStdout is the path that writes events to stdout rather than a file
+	eventFileStdout = "-"
+)
+
+// fileDestination returns the destination writing events to a file, or to
+// stdout for -, which is kept with webhook URLs, e.g. in the outbox
+func fileDestination(path string) string {
+	return fileDestinationPrefix + path
+}
+
+// isFileDestination returns whether a destination is an event file
+func isFileDestination(s string) bool {
+	return strings.HasPrefix(s, fileDestinationPrefix)
+}
+
+// validateEventFile checks an event file's path is given, and is in a
+// directory that exists
+func validateEventFile(path string) error {
+	switch path {
+	case "":
+		return errors.New("no event file path")
+	case eventFileStdout:
+		return nil
+	}
+	dir, err := os.Stat(filepath.Dir(path))
+	if err != nil {
+		return fmt.Errorf("event file %s: %w", path, err)
+	}
+	if !dir.IsDir() {
+		return fmt.Errorf("event file %s isn't in a directory", path)
+	}
+	return nil
+}
+
+// fileNotifier writes each video as a line of JSON, in the same videoEvent
+// schema as HTTP sinks, to a file or stdout, for local development and for
+// piping into other tools. Files are only ever appended to.
+type fileNotifier struct {
+	b *bot
+}
+
+func (n fileNotifier) send(ctx context.Context, destination string, payload webhookPayload, wait bool) (*http.Response, error) {
+	path := strings.TrimPrefix(destination, fileDestinationPrefix)
+	if payload.Event == nil {
+		// e.g. a digest, which is a summary rather than a video
+		log.Info().Str("webhook", destination).Msg("event files are only written videos, not writing")
+		return &http.Response{StatusCode: http.StatusNoContent, Status: "204 No Content (not written)", Body: http.NoBody}, nil
+	}
+	if n.b.dryRun {
+		return n.b.post(ctx, destination, payload.Event)
+	}
+
+	line, err := json.Marshal(payload.Event)
+	if err != nil {
+		return nil, err
+	}
+	line = append(line, '\n')
+	err = n.b.writeEvent(path, line)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Response{StatusCode: http.StatusNoContent, Status: "204 No Content (written)", Body: http.NoBody}, nil
+}
+
+func (fileNotifier) tracksMessages() bool {
+	return false
+}
+
+// writeEvent appends a line to an event file, or writes it to stdout, one at
+// a time so lines are never interleaved. With --event-file-fsync, it's synced
+// to disk before it counts as delivered.
+func (b *bot) writeEvent(path string, line []byte) error {
+	b.eventFileMu.Lock()
+	defer b.eventFileMu.Unlock()
+
+	if path == eventFileStdout {
+		_, err := os.Stdout.Write(line)
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(line)
+	if err == nil && b.settings.eventFileFsync {
+		err = f.Sync()
+	}
+	return errors.Join(err, f.Close())
+}
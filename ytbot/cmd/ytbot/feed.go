@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"pw-ytbot/store"
+)
+
+// feedURL is where YouTube publishes each channel's and playlist's Atom feed of recent videos
+const feedURL = "https://www.youtube.com/feeds/videos.xml"
+
+// atomFeed is the part of a channel's Atom feed we use
+type atomFeed struct {
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomEntry struct {
+	ID        string `xml:"id"`
+	VideoID   string `xml:"http://www.youtube.com/xml/schemas/2015 videoId"`
+	ChannelID string `xml:"http://www.youtube.com/xml/schemas/2015 channelId"`
+	Title     string `xml:"title"`
+	Published string `xml:"published"`
+	Link      struct {
+		Href string `xml:"href,attr"`
+	} `xml:"link"`
+	Author struct {
+		Name string `xml:"name"`
+	} `xml:"author"`
+	Thumbnail struct {
+		URL string `xml:"url,attr"`
+	} `xml:"http://search.yahoo.com/mrss/ group>thumbnail"`
+}
+
+// video converts a feed entry into a video
+func (e atomEntry) video() video {
+	id := e.VideoID
+	if id == "" {
+		id = strings.TrimPrefix(e.ID, "yt:video:")
+	}
+	return video{
+		ID:                   id,
+		ChannelID:            channelId(e.ChannelID),
+		ChannelTitle:         e.Author.Name,
+		Title:                e.Title,
+		PublishedAt:          e.Published,
+		LiveBroadcastContent: broadcastNone,
+		Thumbnail:            e.Thumbnail.URL,
+		Short:                strings.Contains(e.Link.Href, "/shorts/"),
+		PrivacyStatus:        "public", // feeds only list public videos
+	}
+}
+
+// feedCache holds the caching headers from the last fetch of a channel's feed
+type feedCache struct {
+	etag, lastModified string
+}
+
+// youtubeFeedURL returns the URL of a YouTube channel's or playlist's feed
+func youtubeFeedURL(cId channelId) string {
+	if isPlaylistId(string(cId)) {
+		return feedURL + "?playlist_id=" + string(cId)
+	}
+	return feedURL + "?channel_id=" + string(cId)
+}
+
+// fetchFeed fetches the feed at u into feed along with its new caching
+// headers, returning false if it hasn't changed since the fetch cache is from
+func fetchFeed(ctx context.Context, u string, cache feedCache, feed any) (bool, feedCache, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return false, cache, err
+	}
+	if cache.etag != "" {
+		req.Header.Set("If-None-Match", cache.etag)
+	}
+	if cache.lastModified != "" {
+		req.Header.Set("If-Modified-Since", cache.lastModified)
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	res, err := client.Do(req)
+	if err != nil {
+		return false, cache, err
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusNotModified:
+		return false, cache, nil
+	case http.StatusOK:
+	default:
+		return false, cache, fmt.Errorf("unexpected http response %s", res.Status)
+	}
+
+	err = xml.NewDecoder(res.Body).Decode(feed)
+	if err != nil {
+		return false, cache, fmt.Errorf("error parsing feed: %w", err)
+	}
+	return true, feedCache{etag: res.Header.Get("ETag"), lastModified: res.Header.Get("Last-Modified")}, nil
+}
+
+// fetchChannelFeed fetches a channel's feed from u into feed, using and
+// updating the caching headers stored for it. It returns false if the feed
+// hasn't changed since it was last fetched.
+func (b *bot) fetchChannelFeed(ctx context.Context, cId channelId, u string, feed any) (bool, error) {
+	var (
+		cache feedCache
+		err   error
+	)
+	cache.etag, cache.lastModified, err = b.db.FeedCache(string(cId))
+	if err != nil {
+		return false, fmt.Errorf("error querying db: %w", err)
+	}
+
+	modified, newCache, err := fetchFeed(ctx, u, cache, feed)
+	if err != nil {
+		return false, fmt.Errorf("error fetching channel feed: %w", err)
+	}
+	if !modified {
+		log.Debug().Str("channel_id", string(cId)).Msg("channel feed not modified")
+		return false, nil
+	}
+
+	if newCache != cache {
+		err = b.db.SetFeedCache(string(cId), newCache.etag, newCache.lastModified)
+		if err != nil {
+			return false, fmt.Errorf("error updating feed cache in db: %w", err)
+		}
+	}
+	return true, nil
+}
+
+// feedVideos returns up to limit of a channel's videos published after t from
+// its feed, or of the videos in a playlist's feed that haven't been posted yet
+func (b *bot) feedVideos(ctx context.Context, cId channelId, t time.Time, limit int) ([]video, error) {
+	var feed atomFeed
+	modified, err := b.fetchChannelFeed(ctx, cId, youtubeFeedURL(cId), &feed)
+	if err != nil || !modified {
+		return nil, err
+	}
+
+	// entries are newest first
+	var videos []video
+	for _, e := range feed.Entries {
+		if len(videos) == limit {
+			break
+		}
+		published, err := time.Parse(time.RFC3339, e.Published)
+		if err != nil {
+			log.Warn().AnErr("err", err).Str("channel_id", string(cId)).Str("entry_id", e.ID).Msg("error parsing feed entry publish time, skipping")
+			continue
+		}
+		if !isPlaylistId(string(cId)) {
+			if published.After(t) {
+				videos = append(videos, e.video())
+			}
+			continue
+		}
+
+		// playlists get old videos added to them, so go by what's been posted
+		v := e.video()
+		isNew, err := b.newToPlaylist(cId, v.ID)
+		if err != nil {
+			return nil, err
+		}
+		if isNew {
+			videos = append(videos, v)
+		}
+	}
+	return videos, nil
+}
+
+// forgetFeedCache removes the caching headers stored for a channel's feed
+func forgetFeedCache(db store.Queries, cId channelId) error {
+	return db.ForgetFeedCache(string(cId))
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// compilePatterns compiles a list of regular expressions
+func compilePatterns(patterns []string) ([]*regexp.Regexp, error) {
+	res := make([]*regexp.Regexp, 0, len(patterns))
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", p, err)
+		}
+		res = append(res, re)
+	}
+	return res, nil
+}
+
+// filterTitle returns why a video with this title shouldn't be posted for
+// channel c, or an empty string if it should be
+func (c *channel) filterTitle(title string) string {
+	if len(c.titleInclude) > 0 {
+		matched := false
+		for _, re := range c.titleInclude {
+			if re.MatchString(title) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return "title matches no title_include pattern"
+		}
+	}
+	for _, re := range c.titleExclude {
+		if re.MatchString(title) {
+			return fmt.Sprintf("title matches title_exclude pattern %q", re.String())
+		}
+	}
+	return ""
+}
+
+// blockedKeyword returns the first of keywords found in title, ignoring case,
+// or an empty string if there are none
+func blockedKeyword(title string, keywords []string) string {
+	title = strings.ToLower(title)
+	for _, k := range keywords {
+		if strings.Contains(title, strings.ToLower(k)) {
+			return k
+		}
+	}
+	return ""
+}
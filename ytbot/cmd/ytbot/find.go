@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/rs/zerolog/log"
+	"github.com/urfave/cli/v2"
+)
+
+// channelMatch is a channel found by channel find
+type channelMatch struct {
+	id            channelId
+	title, handle string
+	subscribers   string // empty if the channel hides it
+}
+
+func runChannelFind(cliContext *cli.Context) error {
+	if cliContext.NArg() != 1 {
+		return errors.New("expected exactly one search query")
+	}
+	query := cliContext.Args().First()
+	limit := cliContext.Int("limit")
+	if limit <= 0 || limit > maxIdsPerCall {
+		return fmt.Errorf("--limit must be between 1 and %d, got %d", maxIdsPerCall, limit)
+	}
+	add := cliContext.Int("add")
+	if add < 0 {
+		return fmt.Errorf("--add must be the number of a result, got %d", add)
+	}
+
+	db, err := openDBFromFlags(cliContext)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	quota, err := newQuotaTracker(cliContext, db)
+	if err != nil {
+		return err
+	}
+	service, err := newYoutubeService(cliContext, quota)
+	if err != nil {
+		return err
+	}
+
+	log.Warn().Msg("searching for channels costs 100 quota units")
+	response, err := service.Search.List([]string{"snippet"}).Q(query).Type("channel").MaxResults(int64(limit)).Context(cliContext.Context).Do()
+	if err != nil {
+		return fmt.Errorf("error searching for channels: %w", err)
+	}
+	if len(response.Items) == 0 {
+		fmt.Printf("no channels found for %q\n", query)
+		return nil
+	}
+
+	// search results don't have handles or subscriber counts, so look the channels up
+	var ids []string
+	for _, item := range response.Items {
+		ids = append(ids, item.Snippet.ChannelId)
+	}
+	channels, err := service.Channels.List([]string{"id", "snippet", "statistics"}).Id(ids...).MaxResults(maxIdsPerCall).Context(cliContext.Context).Do()
+	if err != nil {
+		return fmt.Errorf("error looking up channels: %w", err)
+	}
+	byId := make(map[string]channelMatch)
+	for _, item := range channels.Items {
+		m := channelMatch{id: channelId(item.Id), title: item.Snippet.Title, handle: item.Snippet.CustomUrl}
+		if item.Statistics != nil && !item.Statistics.HiddenSubscriberCount {
+			m.subscribers = strconv.FormatUint(item.Statistics.SubscriberCount, 10)
+		}
+		byId[item.Id] = m
+	}
+	var matches []channelMatch
+	for _, id := range ids {
+		m, ok := byId[id]
+		if !ok {
+			continue
+		}
+		if m.subscribers == "" {
+			m.subscribers = "hidden"
+		}
+		matches = append(matches, m)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "#\tID\tTITLE\tHANDLE\tSUBSCRIBERS")
+	for i, m := range matches {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", i+1, m.id, m.title, m.handle, m.subscribers)
+	}
+	err = w.Flush()
+	if err != nil || add == 0 {
+		return err
+	}
+
+	if add > len(matches) {
+		return fmt.Errorf("--add %d is out of range, there are %d results", add, len(matches))
+	}
+	m := matches[add-1]
+	c := channel{ID: m.id, Name: channelName(m.title)}
+	if name := cliContext.String("name"); name != "" {
+		c.Name = channelName(name)
+	}
+	err = addChannel(db, c)
+	if err != nil {
+		return fmt.Errorf("error adding channel %s: %w", c.ID, err)
+	}
+	fmt.Printf("added channel %s (%s)\n", c.ID, c.Name)
+	return nil
+}
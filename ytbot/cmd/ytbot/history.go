@@ -0,0 +1,284 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/urfave/cli/v2"
+
+	"pw-ytbot/store"
+)
+
+// historyVersion is the version of the history export format, bumped whenever
+// its fields change in a way older versions of ytbot can't import
+const historyVersion = 1
+
+// history is the posting history exported by ytbot db export, every
+// videos_posted record, for importing into another instance's database
+type history struct {
+	Version    int            `json:"version"`
+	ExportedAt string         `json:"exported_at"`
+	Videos     []historyVideo `json:"videos"`
+}
+
+// historyVideo is a videos_posted record. Fields that weren't recorded for
+// the video are empty.
+type historyVideo struct {
+	ID               string `json:"id"`
+	Platform         string `json:"platform"`
+	PostType         string `json:"post_type"`
+	DatePosted       string `json:"date_posted"`
+	ChannelID        string `json:"channel_id,omitempty"`
+	ChannelTitle     string `json:"channel_title,omitempty"`
+	Title            string `json:"title,omitempty"`
+	PublishedAt      string `json:"published_at,omitempty"`
+	PostedAt         string `json:"posted_at,omitempty"`
+	Webhook          string `json:"webhook,omitempty"`
+	DiscordMessageID string `json:"discord_message_id,omitempty"`
+	DiscordChannelID string `json:"discord_channel_id,omitempty"`
+	DiscordThreadID  string `json:"discord_thread_id,omitempty"`
+}
+
+// historyColumns are the videos_posted columns in a history export, in the
+// order of historyVideo's fields and of the columns of a CSV export
+var historyColumns = []string{
+	"id", "platform", "post_type", "date_posted", "channel_id", "channel_title", "title",
+	"published_at", "posted_at", "webhook", "discord_message_id", "discord_channel_id", "discord_thread_id",
+}
+
+// fields returns pointers to the video's fields, in the order of historyColumns
+func (v *historyVideo) fields() []*string {
+	return []*string{
+		&v.ID, &v.Platform, &v.PostType, &v.DatePosted, &v.ChannelID, &v.ChannelTitle, &v.Title,
+		&v.PublishedAt, &v.PostedAt, &v.Webhook, &v.DiscordMessageID, &v.DiscordChannelID, &v.DiscordThreadID,
+	}
+}
+
+// historyFormat returns the format of a history file, format if it's set,
+// otherwise the file's extension, defaulting to json
+func historyFormat(format, path string) (string, error) {
+	if format == "" {
+		format = strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), ".")
+	}
+	switch format {
+	case "", "json":
+		return "json", nil
+	case "csv":
+		return "csv", nil
+	}
+	return "", fmt.Errorf("unknown history format %q, must be json or csv", format)
+}
+
+// exportHistory reads every videos_posted record, oldest first
+func exportHistory(db store.Queries) (history, error) {
+	h := history{Version: historyVersion, ExportedAt: time.Now().UTC().Format(time.RFC3339), Videos: []historyVideo{}}
+	videos, err := db.Videos()
+	if err != nil {
+		return h, fmt.Errorf("error reading videos_posted from db: %w", err)
+	}
+	for _, v := range videos {
+		h.Videos = append(h.Videos, historyVideo(v))
+	}
+	return h, nil
+}
+
+// writeCSV writes the history as CSV, with a header row of historyColumns.
+// The version isn't included, as CSV exports are for spreadsheets rather
+// than importing.
+func (h history) writeCSV(out io.Writer) error {
+	w := csv.NewWriter(out)
+	err := w.Write(historyColumns)
+	if err != nil {
+		return err
+	}
+	for _, v := range h.Videos {
+		record := make([]string, 0, len(historyColumns))
+		for _, f := range v.fields() {
+			record = append(record, *f)
+		}
+		err = w.Write(record)
+		if err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// importResult is how many records of an import were in each case
+type importResult struct {
+	inserted    int // not in the database before
+	updated     int // in the database, with fields it didn't have filled in
+	skipped     int // in the database already, with nothing to change
+	conflicting int // in the database with different values, which were kept
+}
+
+// importHistory adds the videos in h to videos_posted, in one transaction.
+// Videos already in the database keep their values, only having the fields
+// they don't have filled in from h, so importing the same history again
+// changes nothing.
+func importHistory(db store.Store, h history) (importResult, error) {
+	var r importResult
+	if h.Version == 0 {
+		return r, errors.New("history has no version, it must be from ytbot db export")
+	}
+	if h.Version != historyVersion {
+		return r, fmt.Errorf("history version %d isn't supported, this version of ytbot imports version %d", h.Version, historyVersion)
+	}
+	for i, v := range h.Videos {
+		if v.ID == "" || v.PostType == "" || v.DatePosted == "" {
+			return r, fmt.Errorf("video %d in history is missing its id, post_type or date_posted", i+1)
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return r, err
+	}
+	defer tx.Rollback()
+
+	for _, v := range h.Videos {
+		if v.Platform == "" {
+			v.Platform = platformYouTube
+			if isPeerTubeId(v.ID) {
+				v.Platform = platformPeerTube
+			}
+		}
+
+		found, ok, err := tx.Video(v.ID)
+		if err != nil {
+			return r, fmt.Errorf("error reading video %s from db: %w", v.ID, err)
+		}
+		if !ok {
+			err = tx.WriteVideo(store.Video(v))
+			if err != nil {
+				return r, fmt.Errorf("error inserting video %s in db: %w", v.ID, err)
+			}
+			r.inserted++
+			continue
+		}
+
+		merged, changed, conflicts := mergeHistoryVideo(historyVideo(found), v)
+		if changed {
+			err = tx.WriteVideo(store.Video(merged))
+			if err != nil {
+				return r, fmt.Errorf("error updating video %s in db: %w", v.ID, err)
+			}
+		}
+		switch {
+		case len(conflicts) > 0:
+			log.Warn().Str("videoId", v.ID).Strs("columns", conflicts).Msg("video differs from the history, keeping the database's values")
+			r.conflicting++
+		case changed:
+			r.updated++
+		default:
+			r.skipped++
+		}
+	}
+	return r, tx.Commit()
+}
+
+// mergeHistoryVideo fills in the fields existing doesn't have from imported,
+// returning the result, whether it differs from existing, and the columns
+// both have different values for
+func mergeHistoryVideo(existing, imported historyVideo) (historyVideo, bool, []string) {
+	merged := existing
+	var changed bool
+	var conflicts []string
+	importedFields := imported.fields()
+	for i, f := range merged.fields() {
+		theirs := *importedFields[i]
+		if theirs == "" || theirs == *f {
+			continue
+		}
+		if *f == "" {
+			*f = theirs
+			changed = true
+		} else {
+			conflicts = append(conflicts, historyColumns[i])
+		}
+	}
+	return merged, changed, conflicts
+}
+
+// runDBExport writes the posting history to --out, or stdout, as JSON or CSV
+func runDBExport(cliContext *cli.Context) error {
+	out := cliContext.Path("out")
+	format, err := historyFormat(cliContext.String("format"), out)
+	if err != nil {
+		return err
+	}
+	db, err := openDBFromFlags(cliContext)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	h, err := exportHistory(db)
+	if err != nil {
+		return err
+	}
+
+	w := io.Writer(os.Stdout)
+	if out != "" {
+		// the history has webhook URLs, which are secrets
+		f, err := os.OpenFile(out, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	if format == "csv" {
+		err = h.writeCSV(w)
+	} else {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(h)
+	}
+	if err != nil {
+		return err
+	}
+	if out != "" {
+		log.Info().Int("videos", len(h.Videos)).Str("file", out).Msg("exported posting history")
+	}
+	return nil
+}
+
+// runDBImport adds the videos in a history exported by ytbot db export to
+// the database, and prints how many were inserted, updated, skipped and conflicting
+func runDBImport(cliContext *cli.Context) error {
+	if cliContext.NArg() != 1 {
+		return errors.New("expected exactly one history file")
+	}
+	path := cliContext.Args().First()
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var h history
+	err = json.NewDecoder(f).Decode(&h)
+	if err != nil {
+		return fmt.Errorf("error parsing %s, which must be a JSON history from ytbot db export: %w", path, err)
+	}
+
+	db, err := openDBFromFlags(cliContext)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	r, err := importHistory(db, h)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%d inserted, %d updated, %d skipped, %d conflicting of %d videos\n", r.inserted, r.updated, r.skipped, r.conflicting, len(h.Videos))
+	return nil
+}
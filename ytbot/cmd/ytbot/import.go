@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"github.com/urfave/cli/v2"
+)
+
+// parseSubscriptionsCSV parses a Google Takeout subscriptions.csv, which has
+// a header row of "Channel Id,Channel Url,Channel Title". Malformed rows are
+// logged with their line number and skipped.
+func parseSubscriptionsCSV(r io.Reader) ([]channel, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("error reading CSV header: %w", err)
+	}
+	idCol, titleCol := -1, -1
+	for i, h := range header {
+		switch strings.ToLower(strings.TrimSpace(strings.TrimPrefix(h, "\ufeff"))) {
+		case "channel id":
+			idCol = i
+		case "channel title":
+			titleCol = i
+		}
+	}
+	if idCol < 0 || titleCol < 0 {
+		return nil, errors.New(`CSV header must contain "Channel Id" and "Channel Title" columns`)
+	}
+
+	var channels []channel
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				log.Warn().AnErr("err", parseErr.Err).Int("line", parseErr.StartLine).Msg("skipping malformed row")
+				continue
+			}
+			return nil, err
+		}
+		line, _ := cr.FieldPos(0)
+		if len(record) <= idCol || len(record) <= titleCol {
+			log.Warn().Int("line", line).Msg("skipping row with missing columns")
+			continue
+		}
+		c := channel{ID: channelId(strings.TrimSpace(record[idCol])), Name: channelName(strings.TrimSpace(record[titleCol]))}
+		if !isChannelId(string(c.ID)) {
+			log.Warn().Int("line", line).Str("id", string(c.ID)).Msg("skipping row with invalid channel ID")
+			continue
+		}
+		if c.Name == "" {
+			c.Name = channelName(c.ID)
+		}
+		channels = append(channels, c)
+	}
+	return channels, nil
+}
+
+type (
+	// opml is the subset of an OPML subscriptions export we need
+	opml struct {
+		Outlines []opmlOutline `xml:"body>outline"`
+	}
+
+	opmlOutline struct {
+		Title    string        `xml:"title,attr"`
+		Text     string        `xml:"text,attr"`
+		XMLURL   string        `xml:"xmlUrl,attr"`
+		Outlines []opmlOutline `xml:"outline"`
+	}
+)
+
+// parseSubscriptionsOPML parses an OPML export of YouTube channel feeds, as
+// produced by YouTube's old subscription manager and most feed readers.
+// Entries without a YouTube channel feed URL are logged and skipped.
+func parseSubscriptionsOPML(r io.Reader) ([]channel, error) {
+	var doc opml
+	err := xml.NewDecoder(r).Decode(&doc)
+	if err != nil {
+		return nil, fmt.Errorf("invalid OPML: %w", err)
+	}
+
+	var channels []channel
+	var walk func(outlines []opmlOutline)
+	walk = func(outlines []opmlOutline) {
+		for _, o := range outlines {
+			if len(o.Outlines) > 0 {
+				walk(o.Outlines)
+			}
+			if o.XMLURL == "" {
+				continue
+			}
+			name := o.Title
+			if name == "" {
+				name = o.Text
+			}
+			u, err := url.Parse(o.XMLURL)
+			cId := ""
+			if err == nil {
+				cId = u.Query().Get("channel_id")
+			}
+			if !isChannelId(cId) {
+				log.Warn().Str("title", name).Str("url", o.XMLURL).Msg("skipping entry that isn't a YouTube channel feed")
+				continue
+			}
+			if name == "" {
+				name = cId
+			}
+			channels = append(channels, channel{ID: channelId(cId), Name: channelName(name)})
+		}
+	}
+	walk(doc.Outlines)
+	return channels, nil
+}
+
+func runChannelImport(cliContext *cli.Context) error {
+	path := cliContext.Path("file")
+	format := cliContext.String("format")
+	if format == "" {
+		format = strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), ".")
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var imported []channel
+	switch format {
+	case "csv":
+		imported, err = parseSubscriptionsCSV(f)
+	case "opml", "xml":
+		imported, err = parseSubscriptionsOPML(f)
+	default:
+		return fmt.Errorf("unknown import format %q, must be csv or opml", format)
+	}
+	if err != nil {
+		return fmt.Errorf("error parsing %s: %w", path, err)
+	}
+
+	db, err := openDBFromFlags(cliContext)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	// deduplicate against channels already configured
+	existing, err := dbChannels(db)
+	if err != nil {
+		return err
+	}
+	fileChannels, err := loadChannelsFileFromFlags(cliContext)
+	if err != nil {
+		return err
+	}
+	known := make(map[channelId]bool)
+	for _, c := range append(existing, fileChannels...) {
+		known[c.ID] = true
+	}
+
+	added := 0
+	for _, c := range imported {
+		if known[c.ID] {
+			fmt.Printf("already configured: %s (%s)\n", c.ID, c.Name)
+			continue
+		}
+		known[c.ID] = true
+		if cliContext.Bool("dry-run") {
+			fmt.Printf("would add: %s (%s)\n", c.ID, c.Name)
+			added++
+			continue
+		}
+		err = addChannel(db, c)
+		if err != nil {
+			return fmt.Errorf("error adding channel %s: %w", c.ID, err)
+		}
+		fmt.Printf("added: %s (%s)\n", c.ID, c.Name)
+		added++
+	}
+
+	if cliContext.Bool("dry-run") {
+		fmt.Printf("%d of %d channels would be added\n", added, len(imported))
+	} else {
+		fmt.Printf("%d of %d channels added\n", added, len(imported))
+	}
+	return nil
+}
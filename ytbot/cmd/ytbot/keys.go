@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/urfave/cli/v2"
+)
+
+// apiKeysFromFlags returns the API keys given by --apikey, which can be
+// repeated or comma-separated
+func apiKeysFromFlags(cliContext *cli.Context) []string {
+	var keys []string
+	for _, k := range cliContext.StringSlice("apikey") {
+		for _, k := range strings.Split(k, ",") {
+			if k = strings.TrimSpace(k); k != "" {
+				keys = append(keys, k)
+			}
+		}
+	}
+	return keys
+}
+
+// checkAPIKeySet returns an error if no --apikey was given
+func checkAPIKeySet(cliContext *cli.Context) error {
+	if len(apiKeysFromFlags(cliContext)) == 0 {
+		return errors.New("required flag \"apikey\" not set")
+	}
+	return nil
+}
+
+// apiKeyRing hands out API keys in order, moving on to the next once a key's
+// quota is exceeded until the quota resets. It is safe to share between
+// goroutines.
+type apiKeyRing struct {
+	keys []string
+
+	mu        sync.Mutex
+	exhausted map[int]string // key index to the quota day its quota ran out
+}
+
+func newAPIKeyRing(keys []string) *apiKeyRing {
+	return &apiKeyRing{keys: keys, exhausted: make(map[int]string)}
+}
+
+// current returns the first key whose quota hasn't run out today, and its
+// index, or false if they all have
+func (r *apiKeyRing) current() (int, string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	today := quotaDay(time.Now())
+	for i, k := range r.keys {
+		if r.exhausted[i] != today {
+			return i, k, true
+		}
+	}
+	return 0, "", false
+}
+
+// exhaust marks a key's quota as run out until it resets
+func (r *apiKeyRing) exhaust(i int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.exhausted[i] = quotaDay(time.Now())
+}
+
+// keyTransport is an http.RoundTripper that adds an API key to each call,
+// moving on to the next key when YouTube says the current one's quota has
+// run out. Only the index of the key is ever logged.
+type keyTransport struct {
+	keys      *apiKeyRing
+	transport http.RoundTripper
+}
+
+func (t *keyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	call, _ := quotaCost(req)
+	for {
+		i, key, ok := t.keys.current()
+		if !ok {
+			return nil, fmt.Errorf("%w: every API key's quota is exceeded", errQuotaExhausted)
+		}
+
+		r := req.Clone(req.Context())
+		q := r.URL.Query()
+		q.Set("key", key)
+		r.URL.RawQuery = q.Encode()
+		res, err := t.transport.RoundTrip(r)
+		if err != nil {
+			return nil, err
+		}
+		log.Debug().Int("api_key", i).Str("call", call).Int("status", res.StatusCode).Msg("api call")
+
+		if !quotaExceeded(res) {
+			return res, nil
+		}
+		t.keys.exhaust(i)
+		if _, _, ok := t.keys.current(); !ok {
+			return res, nil
+		}
+		res.Body.Close()
+		log.Warn().Int("api_key", i).Msg("API key's quota exceeded, switching to the next key until it resets")
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"unicode/utf8"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Discord's limits on a message, in characters unless they say otherwise
+const (
+	discordMaxContent = 2000
+
+	discordMaxEmbeds           = 10
+	discordMaxEmbedTitle       = 256
+	discordMaxEmbedDescription = 4096
+	discordMaxEmbedFields      = 25
+	discordMaxEmbedFieldName   = 256
+	discordMaxEmbedFieldValue  = 1024
+	discordMaxEmbedFooter      = 2048
+	discordMaxEmbedAuthor      = 256
+	discordMaxEmbedChars       = 6000 // across the titles, descriptions, fields, footers and authors of every embed
+)
+
+// fitDiscordLimits returns a payload shortened to fit Discord's limits, so a
+// long title or description is posted cut short rather than rejected, and
+// whether anything had to be. Text is cut at a character rather than a byte,
+// ending with …, and what doesn't fit at all, like an 11th embed, is left out.
+// Once each part fits, embeds over the total limit lose their descriptions
+// first, then their last fields, then the rest of their text.
+func fitDiscordLimits(p webhookPayload) (webhookPayload, bool) {
+	fitted := false
+	fit := func(s *string, n int) {
+		if utf8.RuneCountInString(*s) > n {
+			*s = limitRunes(*s, n)
+			fitted = true
+		}
+	}
+
+	fit(&p.Content, discordMaxContent)
+	fit(&p.ThreadName, threadNameMaxLength)
+	if len(p.Embeds) == 0 {
+		return p, fitted
+	}
+
+	// copied, as the caller's embeds may be posted elsewhere too
+	embeds := p.Embeds[:min(len(p.Embeds), discordMaxEmbeds)]
+	fitted = fitted || len(embeds) < len(p.Embeds)
+	p.Embeds = make([]embed, len(embeds))
+	for i, e := range embeds {
+		fit(&e.Title, discordMaxEmbedTitle)
+		fit(&e.Description, discordMaxEmbedDescription)
+		if e.Footer != nil {
+			footer := *e.Footer
+			fit(&footer.Text, discordMaxEmbedFooter)
+			e.Footer = &footer
+		}
+		if e.Author != nil {
+			author := *e.Author
+			fit(&author.Name, discordMaxEmbedAuthor)
+			e.Author = &author
+		}
+		fields := e.Fields[:min(len(e.Fields), discordMaxEmbedFields)]
+		fitted = fitted || len(fields) < len(e.Fields)
+		e.Fields = make([]embedField, len(fields))
+		for j, f := range fields {
+			fit(&f.Name, discordMaxEmbedFieldName)
+			fit(&f.Value, discordMaxEmbedFieldValue)
+			e.Fields[j] = f
+		}
+		p.Embeds[i] = e
+	}
+
+	// then take what's over the total from the last embeds first
+	over := embedsLength(p.Embeds) - discordMaxEmbedChars
+	if over <= 0 {
+		return p, fitted
+	}
+	shorten := func(s *string) {
+		n := utf8.RuneCountInString(*s)
+		if over <= 0 || n == 0 {
+			return
+		}
+		*s = trimRunes(*s, n-over)
+		over -= n - utf8.RuneCountInString(*s)
+	}
+	for i := len(p.Embeds) - 1; i >= 0 && over > 0; i-- {
+		shorten(&p.Embeds[i].Description)
+	}
+	for i := len(p.Embeds) - 1; i >= 0 && over > 0; i-- {
+		e := &p.Embeds[i]
+		for len(e.Fields) > 0 && over > 0 {
+			f := e.Fields[len(e.Fields)-1]
+			over -= utf8.RuneCountInString(f.Name) + utf8.RuneCountInString(f.Value)
+			e.Fields = e.Fields[:len(e.Fields)-1]
+		}
+	}
+	for i := len(p.Embeds) - 1; i >= 0 && over > 0; i-- {
+		e := &p.Embeds[i]
+		if e.Footer != nil {
+			shorten(&e.Footer.Text)
+		}
+		if e.Author != nil {
+			shorten(&e.Author.Name)
+		}
+		shorten(&e.Title)
+	}
+	return p, true
+}
+
+// fitPayload fits a payload to Discord's limits before it's posted to
+// destination, logging when it had to be shortened
+func fitPayload(payload webhookPayload, destination string) webhookPayload {
+	payload, fitted := fitDiscordLimits(payload)
+	if fitted {
+		log.Warn().Str("webhook", redactWebhook(destination)).Msg("message is over Discord's limits, shortened it to fit")
+	}
+	return payload
+}
+
+// embedsLength returns the characters in embeds that count towards
+// discordMaxEmbedChars
+func embedsLength(embeds []embed) int {
+	var n int
+	for _, e := range embeds {
+		n += utf8.RuneCountInString(e.Title) + utf8.RuneCountInString(e.Description)
+		for _, f := range e.Fields {
+			n += utf8.RuneCountInString(f.Name) + utf8.RuneCountInString(f.Value)
+		}
+		if e.Footer != nil {
+			n += utf8.RuneCountInString(e.Footer.Text)
+		}
+		if e.Author != nil {
+			n += utf8.RuneCountInString(e.Author.Name)
+		}
+	}
+	return n
+}
+
+// limitRunes shortens s to at most n characters, ending it with … if it had to be
+func limitRunes(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:max(n-1, 0)]) + "…"
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestLimitRunes(t *testing.T) {
+	tests := []struct {
+		name string
+		s    string
+		n    int
+		want string
+	}{
+		{"ascii under", "abc", 4, "abc"},
+		{"ascii at", "abcd", 4, "abcd"},
+		{"ascii over", "abcde", 4, "abc…"},
+		{"emoji at", "😱😱😱😱", 4, "😱😱😱😱"},
+		{"emoji over", "😱😱😱😱😱", 4, "😱😱😱…"},
+		{"cjk at", "中文中文", 4, "中文中文"},
+		{"cjk over", "中文中文中", 4, "中文中…"},
+		{"mixed over", "a😱中b", 3, "a😱…"},
+		{"one", "😱😱", 1, "…"},
+		{"zero", "😱", 0, "…"},
+		{"empty", "", 0, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := limitRunes(tt.s, tt.n)
+			if got != tt.want {
+				t.Errorf("limitRunes(%q, %d) = %q, want %q", tt.s, tt.n, got, tt.want)
+			}
+			if !utf8.ValidString(got) {
+				t.Errorf("limitRunes(%q, %d) = %q, which isn't valid UTF-8", tt.s, tt.n, got)
+			}
+		})
+	}
+}
+
+func TestFitDiscordLimits(t *testing.T) {
+	// each is one character but several bytes, so only counting characters fits them right
+	const emoji, cjk = "😱", "中"
+	tests := []struct {
+		name        string
+		payload     webhookPayload
+		get         func(webhookPayload) string
+		limit       int
+		wantFitted  bool
+		wantShorter bool
+	}{
+		{
+			name:    "content of emoji at limit",
+			payload: webhookPayload{Content: strings.Repeat(emoji, discordMaxContent)},
+			get:     func(p webhookPayload) string { return p.Content },
+			limit:   discordMaxContent,
+		},
+		{
+			name:        "content of emoji over limit",
+			payload:     webhookPayload{Content: strings.Repeat(emoji, discordMaxContent+1)},
+			get:         func(p webhookPayload) string { return p.Content },
+			limit:       discordMaxContent,
+			wantFitted:  true,
+			wantShorter: true,
+		},
+		{
+			name:    "content of cjk at limit",
+			payload: webhookPayload{Content: strings.Repeat(cjk, discordMaxContent)},
+			get:     func(p webhookPayload) string { return p.Content },
+			limit:   discordMaxContent,
+		},
+		{
+			name:        "content of cjk over limit",
+			payload:     webhookPayload{Content: strings.Repeat(cjk, discordMaxContent+1)},
+			get:         func(p webhookPayload) string { return p.Content },
+			limit:       discordMaxContent,
+			wantFitted:  true,
+			wantShorter: true,
+		},
+		{
+			name:    "embed title of emoji at limit",
+			payload: webhookPayload{Embeds: []embed{{Title: strings.Repeat(emoji, discordMaxEmbedTitle)}}},
+			get:     func(p webhookPayload) string { return p.Embeds[0].Title },
+			limit:   discordMaxEmbedTitle,
+		},
+		{
+			name:        "embed title of emoji over limit",
+			payload:     webhookPayload{Embeds: []embed{{Title: strings.Repeat(emoji, discordMaxEmbedTitle+1)}}},
+			get:         func(p webhookPayload) string { return p.Embeds[0].Title },
+			limit:       discordMaxEmbedTitle,
+			wantFitted:  true,
+			wantShorter: true,
+		},
+		{
+			name:    "embed title of cjk at limit",
+			payload: webhookPayload{Embeds: []embed{{Title: strings.Repeat(cjk, discordMaxEmbedTitle)}}},
+			get:     func(p webhookPayload) string { return p.Embeds[0].Title },
+			limit:   discordMaxEmbedTitle,
+		},
+		{
+			name:        "embed title of cjk over limit",
+			payload:     webhookPayload{Embeds: []embed{{Title: strings.Repeat(cjk, discordMaxEmbedTitle+1)}}},
+			get:         func(p webhookPayload) string { return p.Embeds[0].Title },
+			limit:       discordMaxEmbedTitle,
+			wantFitted:  true,
+			wantShorter: true,
+		},
+		{
+			name:    "embed description of emoji at limit",
+			payload: webhookPayload{Embeds: []embed{{Description: strings.Repeat(emoji, discordMaxEmbedDescription)}}},
+			get:     func(p webhookPayload) string { return p.Embeds[0].Description },
+			limit:   discordMaxEmbedDescription,
+		},
+		{
+			name:        "embed description of emoji over limit",
+			payload:     webhookPayload{Embeds: []embed{{Description: strings.Repeat(emoji, discordMaxEmbedDescription+1)}}},
+			get:         func(p webhookPayload) string { return p.Embeds[0].Description },
+			limit:       discordMaxEmbedDescription,
+			wantFitted:  true,
+			wantShorter: true,
+		},
+		{
+			name:    "embed description of cjk at limit",
+			payload: webhookPayload{Embeds: []embed{{Description: strings.Repeat(cjk, discordMaxEmbedDescription)}}},
+			get:     func(p webhookPayload) string { return p.Embeds[0].Description },
+			limit:   discordMaxEmbedDescription,
+		},
+		{
+			name:        "embed description of cjk over limit",
+			payload:     webhookPayload{Embeds: []embed{{Description: strings.Repeat(cjk, discordMaxEmbedDescription+1)}}},
+			get:         func(p webhookPayload) string { return p.Embeds[0].Description },
+			limit:       discordMaxEmbedDescription,
+			wantFitted:  true,
+			wantShorter: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := tt.get(tt.payload)
+			p, fitted := fitDiscordLimits(tt.payload)
+			if fitted != tt.wantFitted {
+				t.Errorf("fitted = %t, want %t", fitted, tt.wantFitted)
+			}
+			got := tt.get(p)
+			if !utf8.ValidString(got) {
+				t.Fatal("fitted text isn't valid UTF-8")
+			}
+			if n := utf8.RuneCountInString(got); n != tt.limit {
+				t.Errorf("fitted text is %d characters, want %d", n, tt.limit)
+			}
+			if !tt.wantShorter {
+				if got != before {
+					t.Error("text at the limit was changed")
+				}
+				return
+			}
+			if !strings.HasSuffix(got, "…") {
+				t.Error("shortened text doesn't end with …")
+			}
+			if !strings.HasPrefix(before, strings.TrimSuffix(got, "…")) {
+				t.Error("shortened text isn't the start of the original")
+			}
+			// the caller's payload may be posted elsewhere, so isn't changed
+			if tt.get(tt.payload) != before {
+				t.Error("the original payload was changed")
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"pw-ytbot/store"
+)
+
+// runLock stops two instances of the bot using the same database at once,
+// which could otherwise both decide a video hasn't been posted yet. It is a
+// row in the run_lock table, kept fresh by a heartbeat so a lock left behind
+// by a crashed instance can be taken over once it is older than the timeout.
+type runLock struct {
+	db      store.Store
+	holder  string
+	timeout time.Duration
+	lost    context.CancelCauseFunc // stops the run if the lock is lost
+	stop    context.CancelFunc
+	done    chan struct{}
+}
+
+// errRunLockLost stops a run whose run lock was taken over by another
+// instance, or couldn't be kept fresh for long enough that it may have been
+var errRunLockLost = errors.New("lost the run lock, another instance may be running")
+
+// runLockLost returns errRunLockLost if ctx was cancelled as the run lock was lost
+func runLockLost(ctx context.Context) error {
+	if errors.Is(context.Cause(ctx), errRunLockLost) {
+		return errRunLockLost
+	}
+	return nil
+}
+
+// acquireRunLock takes the run lock, returning false if another instance
+// holds it. The timeout must be at least a second. If the lock is lost while
+// it's held, lost is called with errRunLockLost, to stop the run before it
+// posts alongside the instance that took it over.
+func acquireRunLock(db store.Store, timeout time.Duration, lost context.CancelCauseFunc) (*runLock, bool, error) {
+	hostname, _ := os.Hostname()
+	l := &runLock{
+		db:      db,
+		holder:  fmt.Sprintf("%s:%d:%d", hostname, os.Getpid(), time.Now().UnixNano()),
+		timeout: timeout,
+		lost:    lost,
+	}
+
+	// take the lock if nobody has it, or if its holder stopped heartbeating
+	acquired, err := db.AcquireLock(l.holder, timeout)
+	if err != nil {
+		return nil, false, err
+	}
+	if !acquired {
+		holder, heartbeat, held, err := db.LockHolder()
+		if err == nil && held {
+			log.Debug().Str("holder", holder).Time("heartbeat", heartbeat).Msg("run lock held")
+		}
+		return nil, false, nil
+	}
+	log.Debug().Str("holder", l.holder).Msg("acquired run lock")
+
+	// heartbeat until released
+	ctx, stop := context.WithCancel(context.Background())
+	l.stop = stop
+	l.done = make(chan struct{})
+	go l.heartbeat(ctx)
+
+	return l, true, nil
+}
+
+// heartbeat keeps the lock fresh until ctx is cancelled. It gives up, and
+// stops the run, once another instance has the lock, or once the lock has
+// gone a whole timeout without a heartbeat, as another instance may take it
+// over then.
+func (l *runLock) heartbeat(ctx context.Context) {
+	defer close(l.done)
+	t := time.NewTicker(l.timeout / 3)
+	defer t.Stop()
+	last := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			err := l.db.HeartbeatLock(l.holder)
+			if errors.Is(err, store.ErrNoRowChanged) {
+				log.Error().Str("holder", l.holder).Msg("run lock taken over by another instance, stopping")
+				l.lost(errRunLockLost)
+				return
+			}
+			if err != nil {
+				log.Error().AnErr("err", err).Msg("error updating run lock heartbeat")
+				if time.Since(last) >= l.timeout {
+					log.Error().Dur("lock_timeout", l.timeout).Msg("run lock went stale, stopping")
+					l.lost(errRunLockLost)
+					return
+				}
+				continue
+			}
+			last = time.Now()
+		}
+	}
+}
+
+// release gives up the run lock, once the run is over
+func (l *runLock) release() {
+	l.stop()
+	<-l.done
+	defer l.lost(context.Canceled)
+	err := l.db.ReleaseLock(l.holder)
+	if err != nil {
+		log.Error().AnErr("err", err).Msg("error releasing run lock")
+		return
+	}
+	log.Debug().Str("holder", l.holder).Msg("released run lock")
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"pw-ytbot/store"
+)
+
+func TestRunLockTakenOverStopsRun(t *testing.T) {
+	opts := dbOptions{driver: store.DriverSQLite, path: filepath.Join(t.TempDir(), "ytbot.db"), journalMode: journalModeWAL, busyTimeout: 5 * time.Second}
+	db, err := openDB(opts, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ctx, lost := context.WithCancelCause(context.Background())
+	lock, ok, err := acquireRunLock(db, time.Second, lost)
+	if err != nil || !ok {
+		t.Fatalf("acquireRunLock = %v, %v, want true", ok, err)
+	}
+
+	// another instance takes the lock over, as it would once the lock went stale
+	other, err := openDB(opts, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	err = other.ReleaseLock(lock.holder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok, err = other.AcquireLock("other", time.Minute)
+	if err != nil || !ok {
+		t.Fatalf("taking the lock over = %v, %v, want true", ok, err)
+	}
+
+	// the next heartbeat finds the lock gone and stops the run
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("run not stopped after its lock was taken over")
+	}
+	err = runLockLost(ctx)
+	if err != errRunLockLost {
+		t.Errorf("runLockLost = %v, want %v", err, errRunLockLost)
+	}
+
+	// releasing leaves the other instance's lock alone
+	lock.release()
+	holder, _, held, err := other.LockHolder()
+	if err != nil || !held || holder != "other" {
+		t.Errorf("LockHolder after release = %q, %v, %v, want other", holder, held, err)
+	}
+}
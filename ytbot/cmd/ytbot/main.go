@@ -1,24 +1,23 @@
 package main
 
 import (
-	"bytes"
 	"context"
-	"database/sql"
+	"errors"
 	"fmt"
-	"html"
-	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/urfave/cli/v2"
 
-	"google.golang.org/api/option"
-	"google.golang.org/api/youtube/v3"
-
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 
-	_ "modernc.org/sqlite"
+	"pw-ytbot/store"
+
+	// the docker image has no time zone database
+	_ "time/tzdata"
 )
 
 var (
@@ -31,23 +30,718 @@ var (
 			`authenticates the feeder based on API key (UUID) check against atc.plane.watch, ` +
 			`routes data to feed-in containers.`,
 		Flags: []cli.Flag{
+			&cli.StringSliceFlag{
+				Name:    "apikey",
+				Usage:   "Google Cloud API Key, optional with --source rss. Repeat or comma-separate to fail over to further keys when one's quota runs out",
+				EnvVars: []string{"YTBOT_GC_API_KEY"},
+			},
 			&cli.StringFlag{
-				Name:     "apikey",
-				Usage:    "Google Cloud API Key",
-				EnvVars:  []string{"YTBOT_GC_API_KEY"},
-				Required: true,
+				Name:    "db-driver",
+				Usage:   "Database to store state in, sqlite or postgres",
+				EnvVars: []string{"YTBOT_DB_DRIVER"},
+				Value:   store.DriverSQLite,
 			},
 			&cli.PathFlag{
-				Name:     "dbfile",
-				Usage:    "Path to sqlite3 file for storage",
-				EnvVars:  []string{"YTBOT_DBFILE"},
-				Required: true,
+				Name:    "dbfile",
+				Usage:   "Path to sqlite3 file for storage, with --db-driver sqlite",
+				EnvVars: []string{"YTBOT_DBFILE"},
+			},
+			&cli.StringFlag{
+				Name:    "db-dsn",
+				Usage:   "Postgres connection string, a postgres:// URL or key=value pairs, with --db-driver postgres",
+				EnvVars: []string{"YTBOT_DB_DSN"},
+			},
+			&cli.StringFlag{
+				Name:    "db-journal-mode",
+				Usage:   "SQLite journal mode, wal or delete for network filesystems where WAL is unsafe",
+				EnvVars: []string{"YTBOT_DB_JOURNAL_MODE"},
+				Value:   journalModeWAL,
+			},
+			&cli.DurationFlag{
+				Name:    "db-busy-timeout",
+				Usage:   "How long to wait for the database while another connection is writing to it, before failing with database is locked",
+				EnvVars: []string{"YTBOT_DB_BUSY_TIMEOUT"},
+				Value:   5 * time.Second,
+			},
+			&cli.StringSliceFlag{
+				Name:    "webhook",
+				Usage:   "Discord Webhook for posting video, repeat or comma-separate to post to several",
+				EnvVars: []string{"YTBOT_WEBHOOK"},
+			},
+			&cli.Float64Flag{
+				Name:    "api-rate",
+				Usage:   "Maximum YouTube API calls per second",
+				EnvVars: []string{"YTBOT_API_RATE"},
+				Value:   0.5,
+			},
+			&cli.IntFlag{
+				Name:    "daily-quota-budget",
+				Usage:   "Most YouTube API quota units to use per day, after which channels are left until the quota resets",
+				EnvVars: []string{"YTBOT_DAILY_QUOTA_BUDGET"},
+				Value:   10000,
+			},
+			&cli.DurationFlag{
+				Name:    "api-jitter",
+				Usage:   "Up to this much random extra delay before each YouTube API call",
+				EnvVars: []string{"YTBOT_API_JITTER"},
+				Value:   500 * time.Millisecond,
+			},
+			&cli.StringFlag{
+				Name:    "message-template",
+				Usage:   "Go text/template for posted messages, with {{.ChannelTitle}}, {{.PlaylistTitle}}, {{.VideoID}}, {{.Title}}, {{.URL}} and {{.Published}}",
+				EnvVars: []string{"YTBOT_MESSAGE_TEMPLATE"},
+				Value:   defaultMessageTemplate,
+			},
+			&cli.DurationFlag{
+				Name:    "check-interval",
+				Usage:   "How long to wait after checking a channel before checking it again",
+				EnvVars: []string{"YTBOT_CHECK_INTERVAL"},
+				Value:   12 * time.Hour,
+			},
+			&cli.BoolFlag{
+				Name:    "adaptive-interval",
+				Usage:   "Check each channel at half the median time between its recent uploads (1h to 48h), instead of --check-interval",
+				EnvVars: []string{"YTBOT_ADAPTIVE_INTERVAL"},
+			},
+			&cli.StringFlag{
+				Name:    "source",
+				Usage:   "Where to find new videos: api (the YouTube Data API) or rss (channel feeds, no API key needed)",
+				EnvVars: []string{"YTBOT_SOURCE"},
+				Value:   sourceAPI,
+			},
+			&cli.BoolFlag{
+				Name:    "use-search",
+				Usage:   "Find new videos with the search API (100 quota units per check) instead of the channel's uploads playlist (2 units)",
+				EnvVars: []string{"YTBOT_USE_SEARCH"},
+			},
+			&cli.IntFlag{
+				Name:    "max-results",
+				Usage:   "Most new videos to fetch per channel check",
+				EnvVars: []string{"YTBOT_MAX_RESULTS"},
+				Value:   10,
+			},
+			&cli.BoolFlag{
+				Name:    "verify-before-post",
+				Usage:   "Check a video is still public before posting it, unless its status was fetched along with it",
+				EnvVars: []string{"YTBOT_VERIFY_BEFORE_POST"},
+				Value:   true,
+			},
+			&cli.BoolFlag{
+				Name:    "track-title-changes",
+				Usage:   "Edit a posted message when its video's title changes, for up to 24 hours and 3 edits after posting",
+				EnvVars: []string{"YTBOT_TRACK_TITLE_CHANGES"},
+			},
+			&cli.BoolFlag{
+				Name:    "wait-for-message",
+				Usage:   "Post with ?wait=true so Discord returns the posted message, whose ID is kept for editing or deleting it later",
+				Value:   true,
+				EnvVars: []string{"YTBOT_WAIT_FOR_MESSAGE"},
+			},
+			&cli.BoolFlag{
+				Name:    "create-threads",
+				Usage:   "Start a thread for discussion under each posted message, named after the video, using --bot-token",
+				EnvVars: []string{"YTBOT_CREATE_THREADS"},
+			},
+			&cli.StringFlag{
+				Name:    "bot-token",
+				Usage:   "Discord bot token, for --create-threads and --discord-channel-id. The bot needs the Create Public Threads permission in the webhooks' channels",
+				EnvVars: []string{"YTBOT_BOT_TOKEN"},
+			},
+			&cli.StringFlag{
+				Name:    "discord-channel-id",
+				Usage:   "Discord channel to post videos to with --bot-token, along with --webhook, publishing them if it's an announcement channel",
+				EnvVars: []string{"YTBOT_DISCORD_CHANNEL_ID"},
+			},
+			&cli.BoolFlag{
+				Name:    "skip-shorts",
+				Usage:   "Don't post videos at or under --shorts-max-duration long",
+				EnvVars: []string{"YTBOT_SKIP_SHORTS"},
+			},
+			&cli.DurationFlag{
+				Name:    "shorts-max-duration",
+				Usage:   "Videos at or under this long are considered shorts",
+				EnvVars: []string{"YTBOT_SHORTS_MAX_DURATION"},
+				Value:   65 * time.Second,
+			},
+			&cli.StringFlag{
+				Name:    "post-style",
+				Usage:   "How videos are posted: text (the message, leaving Discord to preview the link) or embed (the message along with an embed of the video's title, channel and thumbnail)",
+				EnvVars: []string{"YTBOT_POST_STYLE"},
+				Value:   postStyleText,
+			},
+			&cli.BoolFlag{
+				Name:    "suppress-embeds",
+				Usage:   "Stop Discord previewing the video's link, by putting it in <> in text posts, or setting the SUPPRESS_EMBEDS flag on embed posts",
+				EnvVars: []string{"YTBOT_SUPPRESS_EMBEDS"},
+			},
+			&cli.StringFlag{
+				Name:    "url-style",
+				Usage:   "How videos are linked to: short (youtu.be), long (youtube.com/watch) or auto (/shorts/ and /live/ where they apply, otherwise short)",
+				EnvVars: []string{"YTBOT_URL_STYLE"},
+				Value:   urlStyleShort,
+			},
+			&cli.StringFlag{
+				Name:    "timestamp-style",
+				Usage:   "How {{.PublishedTimestamp}} shows in Discord: R (relative, e.g. 3 hours ago), F (long date and time) or f (short date and time)",
+				EnvVars: []string{"YTBOT_TIMESTAMP_STYLE"},
+				Value:   timestampStyleRelative,
+			},
+			&cli.BoolFlag{
+				Name:    "skip-age-restricted",
+				Usage:   "Don't post age-restricted videos",
+				EnvVars: []string{"YTBOT_SKIP_AGE_RESTRICTED"},
+			},
+			&cli.StringFlag{
+				Name:    "live",
+				Usage:   "What to do with live streams: include (post like any other video), exclude, or announce (post with --live-message-template)",
+				EnvVars: []string{"YTBOT_LIVE"},
+				Value:   livePolicyInclude,
+			},
+			&cli.StringFlag{
+				Name:    "live-message-template",
+				Usage:   "Go text/template for live stream announcements, see --message-template",
+				EnvVars: []string{"YTBOT_LIVE_MESSAGE_TEMPLATE"},
+				Value:   defaultLiveMessageTemplate,
+			},
+			&cli.StringFlag{
+				Name:    "premiere-message-template",
+				Usage:   "Go text/template for upcoming premieres, see --message-template, with {{.Scheduled}} for the start time",
+				EnvVars: []string{"YTBOT_PREMIERE_MESSAGE_TEMPLATE"},
+				Value:   defaultPremiereMessageTemplate,
+			},
+			&cli.StringFlag{
+				Name:    "stream-message-template",
+				Usage:   "Go text/template for scheduled live streams on channels with streams enabled, see --message-template, with {{.ScheduledAt}} for the start time",
+				EnvVars: []string{"YTBOT_STREAM_MESSAGE_TEMPLATE"},
+				Value:   defaultStreamMessageTemplate,
+			},
+			&cli.StringSliceFlag{
+				Name:    "live-webhook",
+				Usage:   "Discord Webhook for live streams and premieres, instead of --webhook, repeat or comma-separate to post to several",
+				EnvVars: []string{"YTBOT_LIVE_WEBHOOK"},
+			},
+			&cli.StringFlag{
+				Name:    "telegram-token",
+				Usage:   "Telegram bot token, for posting to --telegram-chat-id and routes' telegram_chat_id",
+				EnvVars: []string{"YTBOT_TELEGRAM_TOKEN"},
+			},
+			&cli.StringFlag{
+				Name:    "telegram-chat-id",
+				Usage:   "Telegram chat, by ID or @channelname, to post videos to along with --webhook",
+				EnvVars: []string{"YTBOT_TELEGRAM_CHAT_ID"},
+			},
+			&cli.StringFlag{
+				Name:    "mastodon-server",
+				Usage:   "Mastodon instance, e.g. https://mastodon.social, to post videos to along with --webhook, as the account of --mastodon-token",
+				EnvVars: []string{"YTBOT_MASTODON_SERVER"},
+			},
+			&cli.StringFlag{
+				Name:    "mastodon-token",
+				Usage:   "Mastodon access token, with the write:statuses scope, for posting to --mastodon-server",
+				EnvVars: []string{"YTBOT_MASTODON_TOKEN"},
+			},
+			&cli.StringFlag{
+				Name:    "mastodon-visibility",
+				Usage:   "Visibility of Mastodon statuses, public or unlisted",
+				EnvVars: []string{"YTBOT_MASTODON_VISIBILITY"},
+				Value:   mastodonVisibilityPublic,
+			},
+			&cli.StringSliceFlag{
+				Name:    "mastodon-hashtags",
+				Usage:   "Hashtags added to each Mastodon status, repeat or comma-separate to add several",
+				EnvVars: []string{"YTBOT_MASTODON_HASHTAGS"},
+			},
+			&cli.StringFlag{
+				Name:    "matrix-homeserver",
+				Usage:   "Matrix homeserver, e.g. https://matrix.org, of the account of --matrix-token",
+				EnvVars: []string{"YTBOT_MATRIX_HOMESERVER"},
+			},
+			&cli.StringFlag{
+				Name:    "matrix-token",
+				Usage:   "Matrix access token, for posting to --matrix-room and routes' matrix_room",
+				EnvVars: []string{"YTBOT_MATRIX_TOKEN"},
+			},
+			&cli.StringFlag{
+				Name:    "matrix-room",
+				Usage:   "Matrix room, by ID like !abc123:matrix.org, to post videos to along with --webhook",
+				EnvVars: []string{"YTBOT_MATRIX_ROOM"},
+			},
+			&cli.StringFlag{
+				Name:    "sink-hmac-secret",
+				Usage:   "Secret for signing posts to routes of type http, with an HMAC-SHA256 of the body in the X-Ytbot-Signature header",
+				EnvVars: []string{"YTBOT_SINK_HMAC_SECRET"},
+			},
+			&cli.StringFlag{
+				Name:    "event-file",
+				Usage:   "File to append each video to as a line of JSON, along with --webhook, or - for stdout",
+				EnvVars: []string{"YTBOT_EVENT_FILE"},
+			},
+			&cli.BoolFlag{
+				Name:    "event-file-fsync",
+				Usage:   "Sync event files to disk after each video, before it counts as delivered",
+				EnvVars: []string{"YTBOT_EVENT_FILE_FSYNC"},
+			},
+			&cli.StringFlag{
+				Name:    "alert-webhook",
+				Usage:   "Discord Webhook for an ops channel, where problems in each run are posted as one rollup",
+				EnvVars: []string{"YTBOT_ALERT_WEBHOOK"},
+			},
+			&cli.StringFlag{
+				Name:    "alert-level",
+				Usage:   "Least severe problems posted to --alert-webhook: warn (also quota exhaustion, failed posts and channels skipped after failing) or error",
+				EnvVars: []string{"YTBOT_ALERT_LEVEL"},
+				Value:   alertLevelWarn,
+			},
+			&cli.BoolFlag{
+				Name:    "premiere-live-message",
+				Usage:   "When an announced premiere starts, post again using --live-message-template",
+				EnvVars: []string{"YTBOT_PREMIERE_LIVE_MESSAGE"},
+			},
+			&cli.StringSliceFlag{
+				Name:    "block-keyword",
+				Usage:   "Never post videos with this in their title, ignoring case (can be given multiple times)",
+				EnvVars: []string{"YTBOT_BLOCK_KEYWORDS"},
+			},
+			&cli.StringFlag{
+				Name:    "reupload-action",
+				Usage:   "What to do with a video whose title is nearly the same as one posted from its channel in the last 72 hours: none, skip or annotate",
+				EnvVars: []string{"YTBOT_REUPLOAD_ACTION"},
+				Value:   reuploadActionNone,
+			},
+			&cli.StringFlag{
+				Name:    "backfill-mode",
+				Usage:   "What to do with videos found on a channel's first check: post, skip or ask",
+				EnvVars: []string{"YTBOT_BACKFILL_MODE"},
+				Value:   backfillModeSkip,
+			},
+			&cli.DurationFlag{
+				Name:    "lookback",
+				Usage:   "Only consider videos published within this long (between 1h and 720h)",
+				EnvVars: []string{"YTBOT_LOOKBACK"},
+				Value:   48 * time.Hour,
+			},
+			&cli.StringFlag{
+				Name:    "retention",
+				Usage:   "How long posted videos are remembered, in days like 30d or a duration like 900h, longer than any channel's lookback",
+				EnvVars: []string{"YTBOT_RETENTION"},
+				Value:   defaultRetention,
+			},
+			&cli.DurationFlag{
+				Name:    "post-delay",
+				Usage:   "How long to wait after posting a video before posting the next",
+				EnvVars: []string{"YTBOT_POST_DELAY"},
+				Value:   10 * time.Second,
+			},
+			&cli.IntFlag{
+				Name:    "max-posts-per-run",
+				Usage:   "Stop posting after this many posts in a run, leaving the rest for the next run (0 for no limit)",
+				EnvVars: []string{"YTBOT_MAX_POSTS_PER_RUN"},
+			},
+			&cli.IntFlag{
+				Name:    "max-consecutive-failures",
+				Usage:   "Skip a channel after this many failed checks in a row, retrying it once a day (0 to never skip)",
+				EnvVars: []string{"YTBOT_MAX_CONSECUTIVE_FAILURES"},
+				Value:   5,
+			},
+			&cli.DurationFlag{
+				Name:    "min-video-age",
+				Usage:   "Don't post videos until they were published at least this long ago",
+				EnvVars: []string{"YTBOT_MIN_VIDEO_AGE"},
+			},
+			&cli.DurationFlag{
+				Name:    "min-views-max-age",
+				Usage:   "Give up on videos that haven't reached their channel's min_views once they were published this long ago",
+				EnvVars: []string{"YTBOT_MIN_VIEWS_MAX_AGE"},
+				Value:   7 * 24 * time.Hour,
+			},
+			&cli.StringFlag{
+				Name:    "quiet-hours",
+				Usage:   "Daily time range, e.g. 00:00-07:00, during which videos are queued and posted afterwards",
+				EnvVars: []string{"YTBOT_QUIET_HOURS"},
+			},
+			&cli.StringFlag{
+				Name:    "timezone",
+				Usage:   "IANA time zone for --quiet-hours, --digest-time and --report-time, e.g. Australia/Perth (default local time)",
+				EnvVars: []string{"YTBOT_TIMEZONE"},
+			},
+			&cli.StringFlag{
+				Name:    "digest",
+				Usage:   "Collect new uploads into one summary post instead of posting each, one of: daily",
+				EnvVars: []string{"YTBOT_DIGEST"},
 			},
 			&cli.StringFlag{
-				Name:     "webhook",
-				Usage:    "Discord Webhook for posting video",
-				EnvVars:  []string{"YTBOT_WEBHOOK"},
-				Required: true,
+				Name:    "digest-time",
+				Usage:   "Time of day, e.g. 18:00, the --digest is posted",
+				Value:   "09:00",
+				EnvVars: []string{"YTBOT_DIGEST_TIME"},
+			},
+			&cli.StringFlag{
+				Name:    "report",
+				Usage:   "Post a report of what was posted from each channel to --report-webhook, one of: weekly (on Sundays)",
+				EnvVars: []string{"YTBOT_REPORT"},
+			},
+			&cli.StringFlag{
+				Name:    "report-time",
+				Usage:   "Time of day, e.g. 18:00, the --report is posted",
+				Value:   "09:00",
+				EnvVars: []string{"YTBOT_REPORT_TIME"},
+			},
+			&cli.StringFlag{
+				Name:    "report-webhook",
+				Usage:   "Discord webhook reports are posted to, e.g. for a mods channel",
+				EnvVars: []string{"YTBOT_REPORT_WEBHOOK"},
+			},
+			&cli.BoolFlag{
+				Name:    "dry-run",
+				Usage:   "Check channels as usual, but log what would be posted instead of posting it, and don't record anything",
+				EnvVars: []string{"YTBOT_DRY_RUN"},
+			},
+			&cli.BoolFlag{
+				Name:    "no-builtin-channels",
+				Usage:   "Ignore the built-in channel list, only monitoring channels from the database and --channels-file",
+				EnvVars: []string{"YTBOT_NO_BUILTIN_CHANNELS"},
+			},
+			&cli.BoolFlag{
+				Name:    "daemon",
+				Usage:   "Keep running, checking channels every --poll-interval. SIGHUP reloads channels",
+				EnvVars: []string{"YTBOT_DAEMON"},
+			},
+			&cli.DurationFlag{
+				Name:    "poll-interval",
+				Usage:   "In daemon mode, how long to wait between check cycles",
+				EnvVars: []string{"YTBOT_POLL_INTERVAL"},
+				Value:   30 * time.Minute,
+			},
+			&cli.DurationFlag{
+				Name:    "lock-timeout",
+				Usage:   "How long since its last heartbeat before another instance's run lock is treated as stale",
+				EnvVars: []string{"YTBOT_LOCK_TIMEOUT"},
+				Value:   10 * time.Minute,
+			},
+			&cli.DurationFlag{
+				Name:    "max-runtime",
+				Usage:   "Give up on a check cycle that takes longer than this, e.g. due to a hung API call",
+				EnvVars: []string{"YTBOT_MAX_RUNTIME"},
+				Value:   30 * time.Minute,
+			},
+			&cli.DurationFlag{
+				Name:    "audit-interval",
+				Usage:   "How often to look for posts of videos that have been deleted or made private in daemon mode, 0 to never",
+				EnvVars: []string{"YTBOT_AUDIT_INTERVAL"},
+			},
+			&cli.BoolFlag{
+				Name:    "delete-dead-posts",
+				Usage:   "Delete the posts of videos that are no longer available, instead of flagging them",
+				EnvVars: []string{"YTBOT_DELETE_DEAD_POSTS"},
+			},
+			&cli.StringFlag{
+				Name:    "admin-listen",
+				Usage:   "Address to serve the admin HTTP endpoint on in daemon mode, e.g. 127.0.0.1:8080",
+				EnvVars: []string{"YTBOT_ADMIN_LISTEN"},
+			},
+			&cli.StringFlag{
+				Name:    "websub-listen",
+				Usage:   "Address to receive WebSub notifications of new videos on in daemon mode, e.g. :8090",
+				EnvVars: []string{"YTBOT_WEBSUB_LISTEN"},
+			},
+			&cli.StringFlag{
+				Name:    "websub-callback",
+				Usage:   "Public URL the WebSub hub sends notifications to, which must reach --websub-listen",
+				EnvVars: []string{"YTBOT_WEBSUB_CALLBACK"},
+			},
+			&cli.StringFlag{
+				Name:    "websub-secret",
+				Usage:   "Secret the WebSub hub signs notifications with, so forged notifications are ignored",
+				EnvVars: []string{"YTBOT_WEBSUB_SECRET"},
+			},
+			&cli.PathFlag{
+				Name:    "channels-file",
+				Usage:   "Path to YAML file listing channels to monitor (overrides stored channels for duplicate IDs)",
+				EnvVars: []string{"YTBOT_CHANNELS_FILE"},
+			},
+		},
+		Commands: []*cli.Command{
+			{
+				Name:  "webhook",
+				Usage: "Manage the Discord webhooks",
+				Subcommands: []*cli.Command{
+					{
+						Name:   "test",
+						Usage:  "Show which channel each --webhook posts to, and post a test message to it",
+						Action: runWebhookTest,
+					},
+				},
+			},
+			{
+				Name:      "post",
+				Usage:     "Post a single video now, using its channel's settings if the channel is configured",
+				ArgsUsage: "<video ID>",
+				Action:    runPost,
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "force",
+						Usage: "Post the video even if it has already been posted",
+					},
+				},
+			},
+			{
+				Name:   "check",
+				Usage:  "Check a single channel now, logging each decision about its videos",
+				Action: runCheck,
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "channel",
+						Usage:    "Channel ID, playlist ID, @handle or channel URL of a configured channel, or search:<name> for a search",
+						Required: true,
+					},
+					&cli.BoolFlag{
+						Name:  "force",
+						Usage: "Check the channel even if it is disabled or isn't due to be checked yet",
+					},
+					&cli.StringFlag{
+						Name:  "repost",
+						Usage: "ID of a video to post even if it has already been posted",
+					},
+				},
+			},
+			{
+				Name:   "backfill",
+				Usage:  "Record a channel's recent videos as posted without posting them, to swallow a backlog after an outage",
+				Action: runBackfill,
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "channel",
+						Usage: "Channel ID, playlist ID, @handle or channel URL of a configured channel, or search:<name> for a search",
+					},
+					&cli.BoolFlag{
+						Name:  "all-channels",
+						Usage: "Backfill every enabled channel instead of --channel",
+					},
+					&cli.StringFlag{
+						Name:  "before",
+						Usage: "Only backfill videos published before this date like 2024-02-01, or time like 2024-02-01T12:00:00Z (default now)",
+					},
+					&cli.BoolFlag{
+						Name:  "dry-run",
+						Usage: "List the videos that would be backfilled without recording anything",
+					},
+				},
+			},
+			{
+				Name:  "outbox",
+				Usage: "Manage posts waiting to be delivered to Discord",
+				Subcommands: []*cli.Command{
+					{
+						Name:   "list",
+						Usage:  "List the queued posts, oldest first",
+						Action: runOutboxList,
+					},
+					{
+						Name:   "flush",
+						Usage:  "Deliver every queued post now, ignoring quiet hours and --max-posts-per-run",
+						Action: runOutboxFlush,
+					},
+				},
+			},
+			{
+				Name:  "channel",
+				Usage: "Manage monitored channels",
+				Subcommands: []*cli.Command{
+					{
+						Name:      "add",
+						Usage:     "Add a channel to monitor by channel ID, @handle or channel URL, or a playlist by playlist ID or URL",
+						ArgsUsage: "<id|@handle|url>",
+						Action:    runChannelAdd,
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:  "name",
+								Usage: "Display name for the channel (defaults to the channel's title, or the ID)",
+							},
+						},
+					},
+					{
+						Name:      "find",
+						Usage:     "Search YouTube for channels by name, costing 100 quota units, and optionally add one",
+						ArgsUsage: "<query>",
+						Action:    runChannelFind,
+						Flags: []cli.Flag{
+							&cli.IntFlag{
+								Name:  "limit",
+								Usage: "How many channels to show",
+								Value: 10,
+							},
+							&cli.IntFlag{
+								Name:  "add",
+								Usage: "Add the channel with this number in the results",
+							},
+							&cli.StringFlag{
+								Name:  "name",
+								Usage: "Display name for the channel added with --add (defaults to the channel's title)",
+							},
+						},
+					},
+					{
+						Name:      "remove",
+						Usage:     "Stop monitoring a channel (posted video history is kept)",
+						ArgsUsage: "<id>",
+						Action:    runChannelRemove,
+					},
+					{
+						Name:   "list",
+						Usage:  "List monitored channels",
+						Action: runChannelList,
+					},
+					{
+						Name:   "import",
+						Usage:  "Add channels from a Google Takeout subscriptions.csv or an OPML export",
+						Action: runChannelImport,
+						Flags: []cli.Flag{
+							&cli.PathFlag{
+								Name:     "file",
+								Usage:    "File to import",
+								Required: true,
+							},
+							&cli.StringFlag{
+								Name:  "format",
+								Usage: "Format of the file, csv or opml (defaults to the file's extension)",
+							},
+							&cli.BoolFlag{
+								Name:  "dry-run",
+								Usage: "Print what would be added without adding anything",
+							},
+						},
+					},
+					{
+						Name:   "cadence",
+						Usage:  "Show how often each channel uploads, and the check interval --adaptive-interval would use",
+						Action: runChannelCadence,
+					},
+					{
+						Name:   "verify",
+						Usage:  "Check every configured channel exists on YouTube",
+						Action: runChannelVerify,
+					},
+					{
+						Name:   "refresh",
+						Usage:  "Fetch every configured channel's title and details from YouTube now",
+						Action: runChannelRefresh,
+					},
+					{
+						Name:      "reset",
+						Usage:     "Clear a channel's failed checks, so it's checked again if it was skipped after repeated failures",
+						ArgsUsage: "<id>",
+						Action:    runChannelReset,
+					},
+					{
+						Name:      "enable",
+						Usage:     "Resume monitoring a disabled channel",
+						ArgsUsage: "<id>",
+						Action:    runChannelEnable,
+					},
+					{
+						Name:      "disable",
+						Usage:     "Stop monitoring a channel without removing it",
+						ArgsUsage: "<id>",
+						Action:    runChannelDisable,
+					},
+				},
+			},
+			{
+				Name:  "config",
+				Usage: "Configuration helpers",
+				Subcommands: []*cli.Command{
+					{
+						Name:   "init",
+						Usage:  "Print a sample channels file",
+						Action: runConfigInit,
+						Flags: []cli.Flag{
+							&cli.PathFlag{
+								Name:  "out",
+								Usage: "Write the sample to this file instead of stdout",
+							},
+						},
+					},
+				},
+			},
+			{
+				Name:   "quota",
+				Usage:  "Show the YouTube API quota used today by each kind of call",
+				Action: runQuota,
+			},
+			{
+				Name:   "report",
+				Usage:  "Show how many videos were posted from each channel, and optionally post it to --report-webhook",
+				Action: runReport,
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "since",
+						Usage: "How far back the report goes, in days like 7d or a duration like 36h, at most --retention",
+						Value: "7d",
+					},
+					&cli.BoolFlag{
+						Name:  "post",
+						Usage: "Post the report to --report-webhook as well as printing it",
+					},
+				},
+			},
+			{
+				Name:   "audit",
+				Usage:  "Flag (or delete) the posts of videos that have been deleted or made private",
+				Action: runAudit,
+			},
+			{
+				Name:  "db",
+				Usage: "Database maintenance",
+				Subcommands: []*cli.Command{
+					{
+						Name:   "version",
+						Usage:  "Show the database's schema version and when each migration was applied",
+						Action: runDBVersion,
+					},
+					{
+						Name:   "prune",
+						Usage:  "Remove old records from the database now, as happens at the end of each run, and vacuum it",
+						Action: runDBPrune,
+						Flags: []cli.Flag{
+							&cli.BoolFlag{
+								Name:  "dry-run",
+								Usage: "Only show how many records would be removed from each table",
+							},
+						},
+					},
+					{
+						Name:   "export",
+						Usage:  "Export the posting history, every video posted or skipped, e.g. to move the bot to a new host",
+						Action: runDBExport,
+						Flags: []cli.Flag{
+							&cli.PathFlag{
+								Name:  "out",
+								Usage: "Write the history to this file instead of stdout",
+							},
+							&cli.StringFlag{
+								Name:  "format",
+								Usage: "Format of the history, json or csv, which can't be imported (defaults to --out's extension, or json)",
+							},
+						},
+					},
+					{
+						Name:      "import",
+						Usage:     "Add the videos in a JSON history from ytbot db export, so they aren't posted again",
+						ArgsUsage: "<file>",
+						Action:    runDBImport,
+					},
+					{
+						Name:   "stats",
+						Usage:  "Show how many rows each table has, how many videos were posted from each channel recently, and the database's size and schema version",
+						Action: runDBStats,
+						Flags: []cli.Flag{
+							&cli.BoolFlag{
+								Name:  "json",
+								Usage: "Print the stats as JSON",
+							},
+						},
+					},
+				},
 			},
 		},
 	}
@@ -83,176 +777,194 @@ func main() {
 	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr, TimeFormat: time.UnixDate})
 	zerolog.SetGlobalLevel(zerolog.DebugLevel)
 
-	// run & final exit
-	err := app.Run(os.Args)
+	// exit codes are handled below rather than by cli
+	app.ExitErrHandler = func(*cli.Context, error) {}
+
+	// run & final exit: 1 if some things failed, 2 if nothing could be done
+	err := app.RunContext(shutdownContext(), os.Args)
 	if err != nil {
-		// log.Err(err).Msg("finished with error")
-		os.Exit(1)
-	} else {
-		// log.Info().Msg("finished without error")
+		log.Err(err).Msg("finished with error")
+		code := 2
+		var exitErr cli.ExitCoder
+		if errors.As(err, &exitErr) {
+			code = exitErr.ExitCode()
+		}
+		os.Exit(code)
 	}
-
+	log.Info().Msg("finished without error")
 }
 
-func runApp(cliContext *cli.Context) error {
-
-	log.Info().Msg("started")
+// shutdownContext returns a context that is cancelled by SIGINT or SIGTERM, so
+// the bot can finish posting the current video and exit. A second signal exits
+// immediately.
+func shutdownContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-sigs
+		log.Info().Str("signal", sig.String()).Msg("shutting down, signal again to exit immediately")
+		cancel()
+		sig = <-sigs
+		log.Warn().Str("signal", sig.String()).Msg("exiting immediately")
+		os.Exit(1)
+	}()
+	return ctx
+}
 
-	// open database
-	log := log.With().Str("db", cliContext.Path("dbfile")).Logger()
-	log.Debug().Msg("opening sqlite database")
-	db, err := sql.Open("sqlite", cliContext.Path("dbfile"))
-	if err != nil {
-		return err
+// checkFlagsSet returns an error naming the first of the given flags that has no value
+func checkFlagsSet(cliContext *cli.Context, names ...string) error {
+	for _, name := range names {
+		if cliContext.String(name) == "" {
+			return fmt.Errorf("required flag \"%s\" not set", name)
+		}
 	}
-	defer db.Close()
+	return nil
+}
 
-	// create videos_posted table if required
-	log.Debug().Msg("creating videos_posted table if required")
-	_, err = db.Exec(
-		`CREATE TABLE IF NOT EXISTS videos_posted (
-			id TEXT PRIMARY KEY UNIQUE,
-			date_posted TEXT NOT NULL
-		 ) WITHOUT ROWID;`)
-	if err != nil {
-		log.Fatal().AnErr("err", err).Msg("error creating table videos_posted")
+func runApp(cliContext *cli.Context) error {
+	maxRuntime := cliContext.Duration("max-runtime")
+	if maxRuntime <= 0 {
+		return fmt.Errorf("--max-runtime must be greater than 0")
 	}
-
-	// create channel_check times
-	log.Debug().Msg("creating channel_check_times table if required")
-	_, err = db.Exec(
-		`CREATE TABLE IF NOT EXISTS channel_check_times (
-			id TEXT PRIMARY KEY UNIQUE,
-			date_checked TEXT NOT NULL
-		 ) WITHOUT ROWID;`)
-	if err != nil {
-		log.Fatal().AnErr("err", err).Msg("error creating table channel_check_times")
+	if cliContext.IsSet("admin-listen") && !cliContext.Bool("daemon") {
+		return fmt.Errorf("--admin-listen requires --daemon")
+	}
+	auditInterval := cliContext.Duration("audit-interval")
+	if auditInterval < 0 {
+		return fmt.Errorf("--audit-interval must not be negative")
+	}
+	if auditInterval > 0 && !cliContext.Bool("daemon") {
+		return fmt.Errorf("--audit-interval requires --daemon, use the audit command otherwise")
+	}
+	if cliContext.IsSet("websub-listen") {
+		if !cliContext.Bool("daemon") {
+			return fmt.Errorf("--websub-listen requires --daemon")
+		}
+		err := checkFlagsSet(cliContext, "websub-callback")
+		if err != nil {
+			return err
+		}
 	}
 
-	// prep youtube connection
-	ctx := context.Background()
-	service, err := youtube.NewService(ctx, option.WithAPIKey(cliContext.String("apikey")))
+	b, err := newBot(cliContext)
+	if errors.Is(err, errAnotherInstance) {
+		log.Info().Msg("another instance is running")
+		return nil
+	}
 	if err != nil {
-		log.Fatal().AnErr("err", err).Msg("Error creating new YouTube client")
+		return err
+	}
+	defer b.close()
+
+	// tell systemd we're up, and that we're stopping when we return
+	sdNotify("READY=1")
+	defer sdNotify("STOPPING=1")
+
+	// give up on a cycle that runs too long, e.g. due to a hung API call
+	ctx := cliContext.Context
+	runCycle := func(req checkRequest) {
+		ctx, cancel := context.WithTimeout(ctx, maxRuntime)
+		defer cancel()
+
+		// a check requested for a single channel is done whether it's due or not
+		b.only, b.force = req.channel, req.channel != ""
+		defer func() { b.only, b.force = "", false }()
+		b.runCycle(ctx)
 	}
 
-	// for each tracked channel...
-	for cN, cId := range channelIds {
-
-		// published videos past 24 hours
-		publishedAfter := time.Now().Add(-(time.Hour * 48))
-		publishedAfterStr := publishedAfter.Format("2006-01-02T15:04:05Z")
-
-		log := log.With().
-			Str("channel_name", string(cN)).
-			Str("channel_id", string(cId)).
-			Time("cutoff_date", publishedAfter).
-			Logger()
-
-		// check if channel was checked within 12 hours
-		r, err := db.Query(`SELECT * FROM channel_check_times WHERE id=?;`, cId)
+	if !cliContext.Bool("daemon") {
+		runCycle(checkRequest{})
+		err = runLockLost(ctx)
 		if err != nil {
-			log.Fatal().AnErr("err", err).Msg("error querying db")
-		}
-		if r.Next() {
-			log.Debug().Msg("channel checked less than 12 hours ago, skipping")
-			r.Close()
-			continue
+			return err
 		}
-		r.Close()
+		return b.stats.err()
+	}
 
-		// put in db
-		_, err = db.Query(`INSERT INTO channel_check_times (id, date_checked) VALUES (?, datetime('now'));`, cId)
+	// in daemon mode, start a cycle early when asked to by the admin endpoint
+	trigger := make(chan checkRequest)
+	if addr := cliContext.String("admin-listen"); addr != "" {
+		err = b.serveAdmin(ctx, addr, trigger)
 		if err != nil {
-			log.Fatal().AnErr("err", err).Msg("error inserting video into db")
+			return fmt.Errorf("error serving admin endpoint: %w", err)
 		}
+	}
 
-		log.Info().Msg("checking for new videos")
+	// in daemon mode, reload channels on SIGHUP
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			log.Info().Msg("received SIGHUP, reloading channels")
+			err := b.reloadChannels()
+			if err != nil {
+				log.Error().AnErr("err", err).Msg("error reloading channels, keeping current channels")
+			}
+		}
+	}()
 
-		// Make the API call to YouTube.
-		call := service.Search.List([]string{"snippet"}).
-			MaxResults(1).ChannelId(string(cId)).ChannelType("any").Order("date").Type("video").PublishedAfter(publishedAfterStr)
-		response, err := call.Do()
+	// with --websub-listen, check channels as soon as YouTube tells us they have
+	// a new video, polling as a fallback for missed notifications
+	pushed := make(chan channelId, 100)
+	if addr := cliContext.String("websub-listen"); addr != "" {
+		err = b.serveWebSub(ctx, addr, cliContext.String("websub-callback"), cliContext.String("websub-secret"), pushed)
 		if err != nil {
-			panic(err)
+			return fmt.Errorf("error serving websub callback: %w", err)
 		}
+	}
 
-		// Iterate through each item
-		for _, item := range response.Items {
-
-			log := log.With().
-				Str("kind", item.Id.Kind).
-				Str("video_id", item.Id.VideoId).
-				Str("title", html.UnescapeString(item.Snippet.Title)).
-				Logger()
-
-			// If item is a video
-			if item.Id.Kind == "youtube#video" {
-
-				// check if item has already been posted
-				r, err := db.Query(`SELECT * FROM videos_posted WHERE id=?;`, item.Id.VideoId)
-				if err != nil {
-					log.Fatal().AnErr("err", err).Msg("error querying db")
-				}
-				if r.Next() {
-					log.Debug().Msg("item already posted")
-
-				} else {
-
-					// post video
-					log.Debug().Msg("posting item")
-
-					// webhook here
-					data := fmt.Sprintf(`{"content": "New video from **%s**\nhttps://youtu.be/%s"}`, html.UnescapeString(item.Snippet.ChannelTitle), item.Id.VideoId)
-					whReq, err := http.NewRequest("POST", cliContext.String("webhook"), bytes.NewReader([]byte(data)))
-					if err != nil {
-						log.Fatal().AnErr("err", err).Msg("error preparing http request")
-					}
-					whReq.Header.Set("Content-Type", "application/json")
-					whClient := http.Client{
-						Timeout: 30 * time.Second,
-					}
-					whRes, err := whClient.Do(whReq)
-					if err != nil {
-						log.Fatal().AnErr("err", err).Msg("error preparing http request")
-					}
-					if whRes.StatusCode != http.StatusNoContent {
-						log.Error().Str("status", whRes.Status).Msg("unexpected http response code")
-					}
-
-					// put in db
-					_, err = db.Query(`INSERT INTO videos_posted (id, date_posted) VALUES (?, datetime('now'));`, item.Id.VideoId)
-					if err != nil {
-						log.Fatal().AnErr("err", err).Msg("error inserting video into db")
-					}
-
-				}
-				err = r.Close()
-				if err != nil {
-					log.Fatal().AnErr("err", err).Msg("error closing rows after SELECT")
-				}
+	var (
+		req       checkRequest
+		next      time.Time
+		lastAudit time.Time
+	)
+	for {
+		runCycle(req)
+		if ctx.Err() != nil {
+			return runLockLost(ctx)
+		}
+		// only tell systemd's watchdog the bot is alive when a cycle got
+		// something done, so a bot stuck failing every cycle is restarted
+		if b.stats.healthy() {
+			sdNotify("WATCHDOG=1")
+		} else {
+			log.Warn().Msg("cycle failed, not notifying the watchdog")
+		}
 
+		// with --audit-interval, flag the posts of videos taken down since
+		if auditInterval > 0 && req.channel == "" && time.Since(lastAudit) >= auditInterval {
+			lastAudit = time.Now()
+			auditCtx, cancel := context.WithTimeout(ctx, maxRuntime)
+			result, err := b.auditPosts(auditCtx)
+			cancel()
+			if err != nil {
+				log.Error().AnErr("err", err).Msg("error auditing posts")
 			} else {
-				log.Debug().Msg("skipping as item is not video")
+				log.Info().Int("checked", result.checked).Int("dead", result.dead).Msg("audited posts")
+			}
+			if ctx.Err() != nil {
+				return runLockLost(ctx)
 			}
-			time.Sleep(time.Second * 10)
 		}
-	}
 
-	// clean up database
-	log.Debug().Msg("cleaning db")
-	_, err = db.Exec(`DELETE FROM videos_posted WHERE date_posted < datetime('now','-30 days');`)
-	if err != nil {
-		log.Fatal().AnErr("err", err).Msg("error deleting old videos_posted video records from db")
-	}
-	_, err = db.Exec(`DELETE FROM channel_check_times WHERE date_checked < datetime('now','-12 hours');`)
-	if err != nil {
-		log.Fatal().AnErr("err", err).Msg("error deleting old channel_check_times records from db")
-	}
-	_, err = db.Exec(`VACUUM;`)
-	if err != nil {
-		log.Fatal().AnErr("err", err).Msg("error vacuuming db")
+		// checking a single channel doesn't put off the next full cycle
+		if req.channel == "" {
+			next = time.Now().Add(cliContext.Duration("poll-interval"))
+			log.Info().Dur("poll_interval", cliContext.Duration("poll-interval")).Msg("waiting for next cycle")
+		}
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return runLockLost(ctx)
+		case <-timer.C:
+			req = checkRequest{}
+		case req = <-trigger:
+			timer.Stop()
+		case cId := <-pushed:
+			timer.Stop()
+			req = checkRequest{channel: cId}
+		}
 	}
-
-	return nil
 }
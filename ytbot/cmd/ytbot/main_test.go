@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+
+	"pw-ytbot/store"
+)
+
+// testChannelId is the channel the fake YouTube API has videos for
+const testChannelId = "UCwpHKudUkP5tNgmMdexB3ow"
+
+func TestMain(m *testing.M) {
+	// the check subcommand turns on debug logging, which a disabled logger ignores
+	log.Logger = zerolog.Nop()
+	m.Run()
+}
+
+// fakeVideo is a video on the fake YouTube API
+type fakeVideo struct {
+	id        string
+	title     string
+	published time.Time
+}
+
+// fakeYouTube stands in for the YouTube Data API, serving a channel's uploads
+// playlist, newest first as YouTube does, and the videos in it
+type fakeYouTube struct {
+	videos []fakeVideo
+}
+
+func (f *fakeYouTube) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var response any
+	switch strings.TrimPrefix(r.URL.Path, "/youtube/v3/") {
+	case "channels":
+		response = map[string]any{
+			"items": []any{map[string]any{
+				"id":             testChannelId,
+				"snippet":        map[string]any{"title": "Mentour Pilot"},
+				"contentDetails": map[string]any{"relatedPlaylists": map[string]any{"uploads": "UU" + testChannelId[2:]}},
+			}},
+		}
+	case "playlistItems":
+		var items []any
+		for i := len(f.videos) - 1; i >= 0; i-- {
+			v := f.videos[i]
+			items = append(items, map[string]any{
+				"contentDetails": map[string]any{"videoId": v.id, "videoPublishedAt": v.published.Format(time.RFC3339)},
+			})
+		}
+		response = map[string]any{"etag": fmt.Sprintf("etag-%d", len(f.videos)), "items": items}
+	case "videos":
+		ids := strings.Split(strings.Join(r.URL.Query()["id"], ","), ",")
+		var items []any
+		for _, v := range f.videos {
+			for _, id := range ids {
+				if id != v.id {
+					continue
+				}
+				items = append(items, map[string]any{
+					"id": v.id,
+					"snippet": map[string]any{
+						"channelId":            testChannelId,
+						"channelTitle":         "Mentour Pilot",
+						"title":                v.title,
+						"publishedAt":          v.published.Format(time.RFC3339),
+						"liveBroadcastContent": "none",
+					},
+					"status":         map[string]any{"privacyStatus": "public", "uploadStatus": "processed"},
+					"contentDetails": map[string]any{"duration": "PT12M3S"},
+				})
+			}
+		}
+		response = map[string]any{"items": items}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// fakeWebhook stands in for a Discord webhook, recording the content of each
+// post in the order they're made
+type fakeWebhook struct {
+	mu    sync.Mutex
+	posts []string
+}
+
+func (f *fakeWebhook) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var p webhookPayload
+	err := json.NewDecoder(r.Body).Decode(&p)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	f.mu.Lock()
+	f.posts = append(f.posts, p.Content)
+	n := len(f.posts)
+	f.mu.Unlock()
+	json.NewEncoder(w).Encode(map[string]any{"id": fmt.Sprint(1000 + n), "channel_id": "2000"})
+}
+
+// postedVideos returns the IDs of the videos posted, in order
+func (f *fakeWebhook) postedVideos(videos []fakeVideo) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var ids []string
+	for _, content := range f.posts {
+		for _, v := range videos {
+			if strings.Contains(content, v.id) {
+				ids = append(ids, v.id)
+			}
+		}
+	}
+	return ids
+}
+
+// redirectTransport sends requests for the YouTube API to a fake instead
+type redirectTransport struct {
+	host      string
+	transport http.RoundTripper
+}
+
+func (t redirectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if strings.HasSuffix(req.URL.Host, "googleapis.com") {
+		req = req.Clone(req.Context())
+		req.URL.Scheme, req.URL.Host = "http", t.host
+	}
+	return t.transport.RoundTrip(req)
+}
+
+// useFakeYouTube points the YouTube API client at api for the rest of the test
+func useFakeYouTube(t *testing.T, api http.Handler) {
+	srv := httptest.NewServer(api)
+	t.Cleanup(srv.Close)
+	u, _ := url.Parse(srv.URL)
+	orig := http.DefaultTransport
+	http.DefaultTransport = redirectTransport{host: u.Host, transport: orig}
+	t.Cleanup(func() { http.DefaultTransport = orig })
+}
+
+// testBotArgs returns the global flags for a bot using dbfile, posting to
+// webhook, and monitoring only testChannelId, through the fake YouTube API
+func testBotArgs(dbfile, webhook string) []string {
+	return []string{
+		"ytbot",
+		"--apikey", "test-key",
+		"--dbfile", dbfile,
+		"--webhook", webhook,
+		"--no-builtin-channels",
+		"--backfill-mode", "post",
+		"--post-delay", "0",
+		"--api-jitter", "0",
+		"--api-rate", "1000",
+	}
+}
+
+// addTestChannel adds testChannelId to the database in dir, returning the database's path
+func addTestChannel(t *testing.T, dir string) string {
+	t.Helper()
+	dbfile := filepath.Join(dir, "ytbot.db")
+	db, err := openDB(dbOptions{driver: store.DriverSQLite, path: dbfile, journalMode: journalModeWAL, busyTimeout: 5 * time.Second}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	err = addChannel(db, channel{ID: testChannelId, Name: "Mentour Pilot"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dbfile
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// kinds of markdown token, for translating Discord markdown to other platforms
+const (
+	mdText      = iota // plain text
+	mdLiteral          // a character escaped with a backslash, to show as written
+	mdLink             // a masked link, [text](url)
+	mdURL              // a link, bare or in <> which stops Discord embedding it
+	mdTimestamp        // a timestamp, <t:unix:style>
+	mdMention          // a user, role or channel mention
+	mdBold             // ** around bold text
+	mdItalic           // * or _ around italic text
+	mdUnderline        // __ around underlined text
+	mdStrike           // ~~ around struck through text
+	mdSpoiler          // || around a spoiler
+	mdCode             // ` around code
+	mdQuote            // > starting a quoted line
+)
+
+// mdToken is a piece of Discord markdown
+type mdToken struct {
+	kind  int
+	text  string // the text, literal character or link text, which is markdown itself
+	url   string // for links
+	unix  int64  // for timestamps
+	style string // for timestamps, empty for Discord's default
+}
+
+var (
+	// discordLinkPattern matches a masked link, with the URL in <> if its embed is suppressed
+	discordLinkPattern = regexp.MustCompile(`^\[((?:\\.|[^\]\\])*)\]\(<?(https?://[^\s()<>]+)>?\)`)
+
+	// discordTimestampPattern matches a timestamp token, with its style if it has one
+	discordTimestampPattern = regexp.MustCompile(`^<t:(-?\d+)(?::([tTdDfFR]))?>`)
+
+	// discordMentionPattern matches a user, role or channel mention
+	discordMentionPattern = regexp.MustCompile(`^<(?:@[!&]?|#)\d+> ?`)
+
+	// discordURLPattern matches a link, bare or in <> which stops Discord embedding it
+	discordURLPattern = regexp.MustCompile(`^(?:<(https?://[^\s<>]+)>|(https?://[^\s<>]+))`)
+)
+
+// discordMarkdown splits Discord markdown into tokens, which other platforms'
+// formatting is written from. Markers like ** are tokens of their own, with
+// no attempt to pair them up, as each platform has a counterpart for most.
+func discordMarkdown(s string) []mdToken {
+	var tokens []mdToken
+	var text strings.Builder
+	add := func(t mdToken) {
+		if text.Len() > 0 {
+			tokens = append(tokens, mdToken{kind: mdText, text: text.String()})
+			text.Reset()
+		}
+		tokens = append(tokens, t)
+	}
+
+	lineStart := true
+	for i := 0; i < len(s); {
+		rest := s[i:]
+		n := 1
+		switch {
+		case rest[0] == '\\' && len(rest) > 1 && strings.ContainsRune("\\*_~`|>[]()", rune(rest[1])):
+			add(mdToken{kind: mdLiteral, text: rest[1:2]})
+			n = 2
+		case rest[0] == '[' && discordLinkPattern.MatchString(rest):
+			m := discordLinkPattern.FindStringSubmatch(rest)
+			add(mdToken{kind: mdLink, text: m[1], url: m[2]})
+			n = len(m[0])
+		case rest[0] == '<' && discordTimestampPattern.MatchString(rest):
+			m := discordTimestampPattern.FindStringSubmatch(rest)
+			unix, _ := strconv.ParseInt(m[1], 10, 64)
+			add(mdToken{kind: mdTimestamp, unix: unix, style: m[2]})
+			n = len(m[0])
+		case rest[0] == '<' && discordMentionPattern.MatchString(rest):
+			add(mdToken{kind: mdMention, text: strings.TrimSpace(discordMentionPattern.FindString(rest))})
+			n = len(discordMentionPattern.FindString(rest))
+		case (rest[0] == '<' || rest[0] == 'h') && discordURLPattern.MatchString(rest):
+			m := discordURLPattern.FindStringSubmatch(rest)
+			add(mdToken{kind: mdURL, url: m[1] + m[2]})
+			n = len(m[0])
+		case strings.HasPrefix(rest, "**"):
+			add(mdToken{kind: mdBold})
+			n = 2
+		case strings.HasPrefix(rest, "__"):
+			add(mdToken{kind: mdUnderline})
+			n = 2
+		case strings.HasPrefix(rest, "~~"):
+			add(mdToken{kind: mdStrike})
+			n = 2
+		case strings.HasPrefix(rest, "||"):
+			add(mdToken{kind: mdSpoiler})
+			n = 2
+		case rest[0] == '*' || rest[0] == '_':
+			add(mdToken{kind: mdItalic})
+		case rest[0] == '`':
+			add(mdToken{kind: mdCode})
+		case rest[0] == '>' && lineStart:
+			add(mdToken{kind: mdQuote})
+		default:
+			_, n = utf8.DecodeRuneInString(rest)
+			text.WriteString(rest[:n])
+		}
+		lineStart = rest[0] == '\n'
+		i += n
+	}
+	if text.Len() > 0 {
+		tokens = append(tokens, mdToken{kind: mdText, text: text.String()})
+	}
+	return tokens
+}
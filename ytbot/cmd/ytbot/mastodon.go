@@ -0,0 +1,326 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// mastodonDestination is the destination posting to the Mastodon account
+	// given by --mastodon-server and --mastodon-token, which is kept with
+	// webhook URLs, e.g. in the outbox
+	mastodonDestination = "mastodon"
+
+	// mastodonDefaultMaxChars is the most characters in a status on instances
+	// that don't say, Mastodon's own default
+	mastodonDefaultMaxChars = 500
+
+	// mastodonURLLength is how many characters Mastodon counts each link in a
+	// status as, however long it is
+	mastodonURLLength = 23
+)
+
+// status visibilities, for --mastodon-visibility
+const (
+	mastodonVisibilityPublic   = "public"   // shown on the instance's public timelines
+	mastodonVisibilityUnlisted = "unlisted" // shown to followers and on the profile only
+)
+
+// mastodonHashtagPattern matches a hashtag, with or without its #
+var mastodonHashtagPattern = regexp.MustCompile(`^#?[\p{L}\p{N}_]+$`)
+
+// mastodonURLPattern matches a link in a status, as Mastodon counts them
+var mastodonURLPattern = regexp.MustCompile(`https?://\S+`)
+
+// validateMastodonVisibility checks a --mastodon-visibility is one we know about
+func validateMastodonVisibility(s string) error {
+	switch s {
+	case mastodonVisibilityPublic, mastodonVisibilityUnlisted:
+		return nil
+	}
+	return fmt.Errorf("unknown visibility %q, must be %s or %s", s, mastodonVisibilityPublic, mastodonVisibilityUnlisted)
+}
+
+// validateServerURL checks a server's base URL, like --mastodon-server's, is usable
+func validateServerURL(s string) error {
+	u, err := url.Parse(s)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "https" && u.Scheme != "http" {
+		return fmt.Errorf("URL scheme must be http or https, got %q", u.Scheme)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("URL %q has no host", s)
+	}
+	return nil
+}
+
+// mastodonHashtags validates hashtags, returning them each with a #
+func mastodonHashtags(tags []string) ([]string, error) {
+	hashtags := make([]string, len(tags))
+	for i, tag := range tags {
+		if !mastodonHashtagPattern.MatchString(tag) {
+			return nil, fmt.Errorf("invalid hashtag %q, must be letters, numbers and underscores", tag)
+		}
+		hashtags[i] = "#" + strings.TrimPrefix(tag, "#")
+	}
+	return hashtags, nil
+}
+
+// checkMastodonAccount checks --mastodon-server and --mastodon-token are set
+// if any channel's route posts to Mastodon
+func checkMastodonAccount(channels []channel, s *settings) error {
+	if s.mastodonServer != "" {
+		return nil
+	}
+	for _, c := range channels {
+		for _, d := range c.routeWebhooks {
+			if d == mastodonDestination {
+				return fmt.Errorf("route for tag %q posts to Mastodon, which needs --mastodon-server and --mastodon-token", c.routeTag)
+			}
+		}
+	}
+	return nil
+}
+
+// mastodonStatus is the JSON body of a request posting a status
+type mastodonStatus struct {
+	Status     string `json:"status"`
+	Visibility string `json:"visibility"`
+
+	token string // access token, sent in the Authorization header rather than the body
+}
+
+// headers authorizes the request with the account's token, and gives it an
+// idempotency key so a retried post that did get through isn't posted twice
+func (s mastodonStatus) headers() http.Header {
+	sum := sha256.Sum256([]byte(s.Status))
+	return http.Header{
+		"Authorization":   {"Bearer " + s.token},
+		"Idempotency-Key": {hex.EncodeToString(sum[:16])},
+	}
+}
+
+// mastodonNotifier posts statuses to the Mastodon account given by
+// --mastodon-server and --mastodon-token, translating Discord payloads into a
+// plain text status with the video's title, channel and link, and the
+// --mastodon-hashtags.
+type mastodonNotifier struct {
+	b *bot
+}
+
+func (n mastodonNotifier) send(ctx context.Context, destination string, payload webhookPayload, wait bool) (*http.Response, error) {
+	s := n.b.settings
+	if s.mastodonServer == "" || s.mastodonToken == "" {
+		return nil, errors.New("posting to Mastodon needs --mastodon-server and --mastodon-token")
+	}
+	res, err := n.b.post(ctx, s.mastodonServer+"/api/v1/statuses", mastodonStatus{
+		Status:     mastodonStatusText(payload, s.mastodonHashtags, n.b.mastodonMaxChars(ctx)),
+		Visibility: s.mastodonVisibility,
+		token:      s.mastodonToken,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if n.b.dryRun {
+		return res, nil
+	}
+
+	if res.StatusCode != http.StatusOK {
+		var result struct {
+			Error string `json:"error"`
+		}
+		if json.NewDecoder(res.Body).Decode(&result) != nil || result.Error == "" {
+			return nil, fmt.Errorf("unexpected http response %s", res.Status)
+		}
+		return nil, fmt.Errorf("unexpected http response %s: %s", res.Status, result.Error)
+	}
+	return res, nil
+}
+
+func (mastodonNotifier) tracksMessages() bool {
+	return false
+}
+
+// mastodonMaxChars returns the most characters the Mastodon instance allows
+// in a status, looked up the first time it's needed. If the instance can't
+// be asked, Mastodon's default is assumed, and it's asked again next time.
+func (b *bot) mastodonMaxChars(ctx context.Context) int {
+	b.mastodonMu.Lock()
+	defer b.mastodonMu.Unlock()
+	if b.mastodonLimit > 0 {
+		return b.mastodonLimit
+	}
+
+	limit, err := getMastodonMaxChars(ctx, b.settings.mastodonServer)
+	if err != nil {
+		log.Warn().AnErr("err", err).Int("max_chars", mastodonDefaultMaxChars).Msg("error looking up Mastodon instance's status length limit, assuming the default")
+		return mastodonDefaultMaxChars
+	}
+	log.Debug().Int("max_chars", limit).Msg("looked up Mastodon instance's status length limit")
+	b.mastodonLimit = limit
+	return limit
+}
+
+// getMastodonMaxChars asks a Mastodon instance how many characters it allows
+// in a status, which instances don't have to say
+func getMastodonMaxChars(ctx context.Context, server string) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server+"/api/v2/instance", nil)
+	if err != nil {
+		return 0, err
+	}
+	client := http.Client{
+		Timeout: 30 * time.Second,
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("unexpected http response %s", res.Status)
+	}
+
+	var instance struct {
+		Configuration struct {
+			Statuses struct {
+				MaxCharacters int `json:"max_characters"`
+			} `json:"statuses"`
+		} `json:"configuration"`
+	}
+	err = json.NewDecoder(res.Body).Decode(&instance)
+	if err != nil {
+		return 0, err
+	}
+	if instance.Configuration.Statuses.MaxCharacters <= 0 {
+		return mastodonDefaultMaxChars, nil
+	}
+	return instance.Configuration.Statuses.MaxCharacters, nil
+}
+
+// mastodonStatusText translates a Discord payload into a plain text status:
+// the video's title, its channel and its link, from the payload's embed, or
+// from the content in the text post style, followed by the hashtags. If it's
+// longer than maxChars, the title is shortened first, then hashtags are left
+// out, then the channel is shortened, so the link always fits.
+func mastodonStatusText(p webhookPayload, hashtags []string, maxChars int) string {
+	title, channel, link := payloadVideo(p)
+
+	// the shortest a title is cut to before hashtags are left out to fit
+	const minTitle = 20
+	status := func(title, channel string, hashtags []string) string {
+		text := strings.Join(nonEmpty(title, channel, link), "\n")
+		if len(hashtags) > 0 {
+			text += "\n\n" + strings.Join(hashtags, " ")
+		}
+		return text
+	}
+	// make room for at least the start of the title, leaving out hashtags,
+	// then shortening the channel
+	for {
+		over := mastodonLength(status(limitRunes(title, minTitle), channel, hashtags)) - maxChars
+		if over <= 0 {
+			break
+		}
+		if len(hashtags) > 0 {
+			hashtags = hashtags[:len(hashtags)-1]
+			continue
+		}
+		if channel == "" {
+			break
+		}
+		channel = trimRunes(channel, utf8.RuneCountInString(channel)-over)
+	}
+
+	// then shorten the title to fit what's left
+	text := status(title, channel, hashtags)
+	if over := mastodonLength(text) - maxChars; over > 0 {
+		text = status(trimRunes(title, utf8.RuneCountInString(title)-over), channel, hashtags)
+	}
+	return text
+}
+
+// payloadVideo returns the title, channel and link of the video a Discord
+// payload posts, from its embed, or from the content in the text post style,
+// without its formatting. The title is empty if it's just the link, and the
+// link is empty if the payload doesn't have one, like an alert.
+func payloadVideo(p webhookPayload) (title, channel, link string) {
+	for _, e := range p.Embeds {
+		if e.URL != "" {
+			title, link = e.Title, e.URL
+			if e.Author != nil {
+				channel = e.Author.Name
+			}
+			break
+		}
+	}
+	if title == link {
+		title = ""
+	}
+	if link == "" {
+		title, link = mastodonPlainText(p.Content)
+	}
+	return title, channel, link
+}
+
+// trimRunes shortens s to n runes like limitRunes, or to nothing rather than
+// just an ellipsis
+func trimRunes(s string, n int) string {
+	if n <= 1 {
+		return ""
+	}
+	return limitRunes(s, n)
+}
+
+// mastodonLength returns the length of a status as Mastodon counts it, with
+// each link counting as mastodonURLLength characters
+func mastodonLength(s string) int {
+	n := utf8.RuneCountInString(s)
+	for _, u := range mastodonURLPattern.FindAllString(s, -1) {
+		n += mastodonURLLength - utf8.RuneCountInString(u)
+	}
+	return n
+}
+
+// mastodonPlainText reduces Discord markdown to plain text, as statuses have
+// no formatting, returning it without its links, along with the first link,
+// so it can be written out in full. Masked links keep their text, timestamps
+// are written out in UTC, and mentions are dropped.
+func mastodonPlainText(s string) (string, string) {
+	var sb strings.Builder
+	var link string
+	for _, t := range discordMarkdown(s) {
+		switch t.kind {
+		case mdText, mdLiteral:
+			sb.WriteString(t.text)
+		case mdLink:
+			text, _ := mastodonPlainText(t.text)
+			sb.WriteString(text)
+			if link == "" {
+				link = t.url
+			}
+		case mdURL:
+			if link == "" {
+				link = t.url
+			}
+		case mdTimestamp:
+			sb.WriteString(time.Unix(t.unix, 0).UTC().Format("2 January 2006 15:04 UTC"))
+		}
+	}
+	// tidy up where links were taken out
+	text := strings.Join(strings.Fields(sb.String()), " ")
+	return strings.TrimRight(text, " :-–—"), link
+}
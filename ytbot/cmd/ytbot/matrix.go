@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	// matrixDestinationPrefix marks a destination as a Matrix room rather than
+	// a webhook URL, followed by the room's ID
+	matrixDestinationPrefix = "matrix:"
+
+	// matrixFormatHTML is the format of a message's formatted_body
+	matrixFormatHTML = "org.matrix.custom.html"
+)
+
+// matrixRoomIdPattern matches a Matrix room's ID, like !abc123:matrix.org
+var matrixRoomIdPattern = regexp.MustCompile(`^![^:\s]+:\S+$`)
+
+// matrixDestination returns the destination posting to a Matrix room, which
+// is kept with webhook URLs, e.g. in the outbox
+func matrixDestination(roomId string) string {
+	return matrixDestinationPrefix + roomId
+}
+
+// isMatrixDestination returns whether a destination is a Matrix room
+func isMatrixDestination(s string) bool {
+	return strings.HasPrefix(s, matrixDestinationPrefix)
+}
+
+// validateMatrixRoomId checks a Matrix room ID looks usable. Aliases like
+// #room:matrix.org can't be posted to, only the room ID they point at.
+func validateMatrixRoomId(id string) error {
+	if !matrixRoomIdPattern.MatchString(id) {
+		return fmt.Errorf("invalid Matrix room ID %q, must be like !abc123:matrix.org, see the room's settings", id)
+	}
+	return nil
+}
+
+// checkMatrixAccount checks --matrix-homeserver and --matrix-token are set if
+// any channel's route posts to a Matrix room
+func checkMatrixAccount(channels []channel, s *settings) error {
+	if s.matrixHomeserver != "" {
+		return nil
+	}
+	for _, c := range channels {
+		for _, d := range c.routeWebhooks {
+			if isMatrixDestination(d) {
+				return fmt.Errorf("route for tag %q posts to Matrix, which needs --matrix-homeserver and --matrix-token", c.routeTag)
+			}
+		}
+	}
+	return nil
+}
+
+// matrixMessage is the JSON body of an m.room.message event, sent with PUT
+// to a URL with its transaction ID
+type matrixMessage struct {
+	MsgType       string `json:"msgtype"`
+	Body          string `json:"body"`
+	Format        string `json:"format,omitempty"`
+	FormattedBody string `json:"formatted_body,omitempty"`
+
+	token string // access token, sent in the Authorization header rather than the body
+}
+
+func (m matrixMessage) headers() http.Header {
+	return http.Header{"Authorization": {"Bearer " + m.token}}
+}
+
+// method sends the event with PUT, as the transaction ID is in the URL
+func (matrixMessage) method() string {
+	return http.MethodPut
+}
+
+// matrixNotifier posts to Matrix rooms with the client-server API as the
+// account of --matrix-token, translating Discord payloads into messages with
+// the video's title linking to it, and its channel.
+type matrixNotifier struct {
+	b *bot
+}
+
+func (n matrixNotifier) send(ctx context.Context, destination string, payload webhookPayload, wait bool) (*http.Response, error) {
+	s := n.b.settings
+	if s.matrixHomeserver == "" || s.matrixToken == "" {
+		return nil, errors.New("posting to Matrix needs --matrix-homeserver and --matrix-token")
+	}
+	roomId := strings.TrimPrefix(destination, matrixDestinationPrefix)
+	m := matrixText(payload)
+	m.token = s.matrixToken
+
+	u := fmt.Sprintf("%s/_matrix/client/v3/rooms/%s/send/m.room.message/%s",
+		s.matrixHomeserver, url.PathEscape(roomId), matrixTxnId(roomId, payload, m))
+	res, err := n.b.post(ctx, u, m)
+	if err != nil {
+		return nil, err
+	}
+	if n.b.dryRun {
+		return res, nil
+	}
+
+	if res.StatusCode != http.StatusOK {
+		var result struct {
+			ErrCode string `json:"errcode"`
+			Error   string `json:"error"`
+		}
+		if json.NewDecoder(res.Body).Decode(&result) != nil || result.ErrCode == "" {
+			return nil, fmt.Errorf("unexpected http response %s", res.Status)
+		}
+		if result.ErrCode == "M_FORBIDDEN" {
+			return nil, fmt.Errorf("unexpected http response %s: %s: %s, the account needs to have joined room %s", res.Status, result.ErrCode, result.Error, roomId)
+		}
+		return nil, fmt.Errorf("unexpected http response %s: %s: %s", res.Status, result.ErrCode, result.Error)
+	}
+	return res, nil
+}
+
+func (matrixNotifier) tracksMessages() bool {
+	return false
+}
+
+// matrixTxnId returns the transaction ID of a message to a room. Matrix
+// ignores a message sent again with the same transaction ID, so it's derived
+// from the video, and how it's posted, rather than being random, so a retry
+// of a post that did get through isn't posted twice. Posts that aren't of a
+// video, like digests, are identified by their text.
+func matrixTxnId(roomId string, payload webhookPayload, m matrixMessage) string {
+	key := "message\n" + m.Body
+	if e := payload.Event; e != nil {
+		key = fmt.Sprintf("video\n%s\n%s\n%t", e.VideoID, e.Type, e.Live)
+	}
+	sum := sha256.Sum256([]byte(roomId + "\n" + key))
+	return "ytbot-" + hex.EncodeToString(sum[:16])
+}
+
+// matrixText translates a Discord payload into a Matrix message. A video is
+// its title linking to it and its channel, and other posts, like digests,
+// have their links and line breaks kept, and each embed's title, description
+// and fields. The body is plain text, for clients that don't show HTML.
+func matrixText(p webhookPayload) matrixMessage {
+	m := matrixMessage{MsgType: "m.text", Format: matrixFormatHTML}
+	if title, channel, link := payloadVideo(p); p.Event != nil && link != "" {
+		if title == "" {
+			title = link
+		}
+		m.Body = strings.Join(nonEmpty(title, channel, link), "\n")
+		m.FormattedBody = fmt.Sprintf(`<a href="%s">%s</a>`, html.EscapeString(link), html.EscapeString(title))
+		if channel != "" {
+			m.FormattedBody += "<br>" + html.EscapeString(channel)
+		}
+		return m
+	}
+
+	var plain, formatted []string
+	add := func(s string) {
+		if s == "" {
+			return
+		}
+		text, h := matrixMarkdown(s)
+		plain = append(plain, text)
+		formatted = append(formatted, h)
+	}
+	add(p.Content)
+	for _, e := range p.Embeds {
+		if e.URL != "" {
+			add(fmt.Sprintf("[%s](%s)", escapeMarkdown(e.Title), e.URL))
+		} else {
+			add(e.Title)
+		}
+		add(e.Description)
+		for _, f := range e.Fields {
+			add(escapeMarkdown(f.Name) + ": " + f.Value)
+		}
+	}
+	m.Body = strings.Join(plain, "\n")
+	m.FormattedBody = strings.Join(formatted, "<br>")
+	return m
+}
+
+// matrixMarkdown translates Discord markdown into plain text and HTML, with
+// its links kept. Formatting like bold is dropped, as Discord's markers
+// aren't paired up, timestamps are written out in UTC, and mentions are
+// dropped.
+func matrixMarkdown(s string) (string, string) {
+	var plain, formatted strings.Builder
+	for _, t := range discordMarkdown(s) {
+		switch t.kind {
+		case mdText, mdLiteral:
+			plain.WriteString(t.text)
+			formatted.WriteString(strings.ReplaceAll(html.EscapeString(t.text), "\n", "<br>"))
+		case mdLink:
+			text, h := matrixMarkdown(t.text)
+			fmt.Fprintf(&plain, "%s (%s)", text, t.url)
+			fmt.Fprintf(&formatted, `<a href="%s">%s</a>`, html.EscapeString(t.url), h)
+		case mdURL:
+			plain.WriteString(t.url)
+			fmt.Fprintf(&formatted, `<a href="%s">%s</a>`, html.EscapeString(t.url), html.EscapeString(t.url))
+		case mdTimestamp:
+			date := time.Unix(t.unix, 0).UTC().Format("2 January 2006 15:04 UTC")
+			plain.WriteString(date)
+			formatted.WriteString(date)
+		}
+	}
+	return plain.String(), formatted.String()
+}
+
+// nonEmpty returns the strings that aren't empty
+func nonEmpty(s ...string) []string {
+	var out []string
+	for _, v := range s {
+		if v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
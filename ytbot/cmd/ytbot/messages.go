@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"pw-ytbot/store"
+)
+
+// messageRetention is how long posted messages are kept track of, so they
+// can be edited or deleted later
+const messageRetention = 30 * 24 * time.Hour
+
+// webhookURLWait returns a webhook URL that makes Discord respond with the
+// message it posted, so its ID can be kept
+func webhookURLWait(webhook string) (string, error) {
+	u, err := url.Parse(webhook)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	q.Set("wait", "true")
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// webhookURLThread returns a webhook URL for messages in a thread, which is
+// needed to edit or delete them
+func webhookURLThread(webhook, threadId string) (string, error) {
+	u, err := url.Parse(webhook)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	q.Set("thread_id", threadId)
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// webhookMessageURL returns the URL of a message posted by a webhook, keeping
+// any query such as a thread ID
+func webhookMessageURL(webhook, messageId string) (string, error) {
+	u, err := url.Parse(webhook)
+	if err != nil {
+		return "", err
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/messages/" + messageId
+	q := u.Query()
+	q.Del("wait")
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// postedMessage is what's kept of a message Discord returns for ?wait=true
+type postedMessage struct {
+	ID        string `json:"id"`
+	ChannelID string `json:"channel_id"`
+	ThreadID  string `json:"-"` // started under the message with --create-threads
+}
+
+// postMessage posts payload to a webhook for a video, with the webhook's
+// notifier. Unless --wait-for-message is turned off, it asks Discord for the
+// message it posts, which is recorded so it can be edited or deleted later,
+// and returned. A response other than success is returned as an error.
+// Failing to record the message is only logged, as it has been posted either
+// way.
+func (b *bot) postMessage(ctx context.Context, webhook string, payload webhookPayload, v video) (postedMessage, error) {
+	n := b.notifierFor(webhook)
+	wait := b.settings.waitForMessage && n.tracksMessages()
+	res, err := n.send(ctx, webhook, payload, wait)
+	if err != nil {
+		return postedMessage{}, err
+	}
+
+	// a dry run responds with 204 rather than the message
+	if !wait || res.StatusCode != http.StatusOK {
+		return postedMessage{}, nil
+	}
+	m, err := b.recordMessage(res, v, webhook, payload)
+	if err != nil {
+		log.Warn().AnErr("err", err).Str("video_id", v.ID).Msg("error recording posted message, it won't be edited or deleted later")
+	}
+	if b.settings.createThreads && m.ID != "" && m.ThreadID == "" {
+		m.ThreadID = b.startThread(ctx, m, v, webhook)
+	}
+	return m, nil
+}
+
+// recordMessage records a message posted with ?wait=true, returning it. A
+// post to a forum channel is the first message of a new thread, which
+// Discord returns as the message's channel, so it's recorded with a webhook
+// URL for the thread.
+func (b *bot) recordMessage(res *http.Response, v video, webhook string, payload webhookPayload) (postedMessage, error) {
+	var message postedMessage
+	err := json.NewDecoder(res.Body).Decode(&message)
+	if err != nil {
+		return message, fmt.Errorf("error reading posted message: %w", err)
+	}
+	if message.ID == "" {
+		return message, errors.New("posted message has no id")
+	}
+	if payload.ThreadName != "" && message.ChannelID != "" {
+		message.ThreadID = message.ChannelID
+		webhook, err = webhookURLThread(webhook, message.ThreadID)
+		if err != nil {
+			return message, err
+		}
+	}
+	render, err := marshalRender(payload.Render)
+	if err != nil {
+		return message, err
+	}
+	err = b.db.TrackMessage(store.TrackedMessage{
+		VideoID:   v.ID,
+		Webhook:   webhook,
+		MessageID: message.ID,
+		Title:     v.Title,
+		Content:   payload.Content,
+		ThreadID:  message.ThreadID,
+		Render:    render,
+	})
+	return message, err
+}
+
+// editMessage replaces the content, and any embeds, of a message posted by a webhook
+func (b *bot) editMessage(ctx context.Context, webhook, messageId string, payload webhookPayload) error {
+	u, err := webhookMessageURL(webhook, messageId)
+	if err != nil {
+		return err
+	}
+	if b.dryRun {
+		log.Info().Str("webhook", redactWebhook(webhook)).Str("message_id", messageId).Str("content", payload.Content).Msg("dry run, not editing message")
+		return nil
+	}
+
+	// edits never ping, but don't let the new content ping anyone either
+	payload.AllowedMentions = &allowedMentions{Parse: []string{}}
+	res, err := sendWebhook(ctx, http.MethodPatch, u, fitPayload(payload, webhook))
+	if err != nil {
+		return err
+	}
+	switch res.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusNotFound:
+		return errMessageGone
+	}
+	return discordError(res)
+}
+
+// errMessageGone is returned when editing or deleting a message that has
+// already been deleted
+var errMessageGone = errors.New("message has already been deleted")
+
+// deleteMessage deletes a message posted by a webhook
+func (b *bot) deleteMessage(ctx context.Context, webhook, messageId string) error {
+	u, err := webhookMessageURL(webhook, messageId)
+	if err != nil {
+		return err
+	}
+	if b.dryRun {
+		log.Info().Str("webhook", redactWebhook(webhook)).Str("message_id", messageId).Msg("dry run, not deleting message")
+		return nil
+	}
+
+	req, err := http.NewRequestWithContext(context.WithoutCancel(ctx), http.MethodDelete, u, nil)
+	if err != nil {
+		return err
+	}
+	client := http.Client{
+		Timeout: 30 * time.Second,
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	switch res.StatusCode {
+	case http.StatusNoContent:
+		return nil
+	case http.StatusNotFound:
+		return errMessageGone
+	}
+	return discordError(res)
+}
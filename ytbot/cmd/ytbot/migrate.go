@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/urfave/cli/v2"
+)
+
+// runDBVersion prints the database's schema version, and when each migration was applied
+func runDBVersion(cliContext *cli.Context) error {
+	db, err := openDBFromFlags(cliContext)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	applied, err := db.Migrations()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tAPPLIED AT\tDESCRIPTION")
+	var version int
+	for _, m := range applied {
+		version = m.Version
+		fmt.Fprintf(w, "%d\t%s\t%s\n", m.Version, m.AppliedAt, m.Description)
+	}
+	err = w.Flush()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("\nschema version %d, latest %d\n", version, db.LatestVersion())
+	return nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+)
+
+// kinds of webhook a route can post to, for its type in the channels file
+const (
+	webhookTypeDiscord = "discord"
+	webhookTypeSlack   = "slack"
+	webhookTypeHTTP    = "http" // an HTTP sink, which is sent each video as JSON
+)
+
+// validateWebhookType checks a route's type is empty or a known kind of webhook
+func validateWebhookType(s string) error {
+	switch s {
+	case "", webhookTypeDiscord, webhookTypeSlack, webhookTypeHTTP:
+		return nil
+	}
+	return fmt.Errorf("unknown webhook type %q, must be %s, %s or %s", s, webhookTypeDiscord, webhookTypeSlack, webhookTypeHTTP)
+}
+
+// notifier posts messages to one kind of webhook. Posts are built as
+// Discord payloads, and translated by notifiers for other platforms.
+type notifier interface {
+	// send posts payload to a webhook, returning the response once it's been
+	// accepted, or an error. With wait, the response has the posted message,
+	// if the webhook supports it.
+	send(ctx context.Context, webhook string, payload webhookPayload, wait bool) (*http.Response, error)
+
+	// tracksMessages returns whether messages posted to the webhook can be
+	// kept track of, to edit or delete them later
+	tracksMessages() bool
+}
+
+// notifierFor returns the notifier for a webhook: Telegram for a Telegram
+// chat, Mastodon for the Mastodon account, a file for an event file, the bot
+// for a Discord channel, Matrix for a Matrix room, Slack for Slack's incoming
+// webhook URLs, or the webhook's route's type if it has one, otherwise Discord
+func (b *bot) notifierFor(webhook string) notifier {
+	if isTelegramDestination(webhook) {
+		return telegramNotifier{b: b}
+	}
+	if webhook == mastodonDestination {
+		return mastodonNotifier{b: b}
+	}
+	if isFileDestination(webhook) {
+		return fileNotifier{b: b}
+	}
+	if isDiscordChannelDestination(webhook) {
+		return botNotifier{b: b}
+	}
+	if isMatrixDestination(webhook) {
+		return matrixNotifier{b: b}
+	}
+	if isSlackWebhook(webhook) {
+		return slackNotifier{b: b}
+	}
+	for _, c := range b.currentChannels() {
+		if !slices.Contains(c.routeWebhooks, webhook) {
+			continue
+		}
+		switch c.routeType {
+		case webhookTypeSlack:
+			return slackNotifier{b: b}
+		case webhookTypeHTTP:
+			return httpSink{b: b, header: c.routeHeaders}
+		}
+	}
+	return discordNotifier{b: b}
+}
+
+// isSlackWebhook returns whether a webhook URL is one of Slack's incoming webhooks
+func isSlackWebhook(webhook string) bool {
+	u, err := url.Parse(webhook)
+	return err == nil && u.Host == "hooks.slack.com"
+}
+
+// discordNotifier posts to Discord webhooks
+type discordNotifier struct {
+	b *bot
+}
+
+func (n discordNotifier) send(ctx context.Context, webhook string, payload webhookPayload, wait bool) (*http.Response, error) {
+	payload = fitPayload(payload, webhook)
+	u := webhook
+	if wait {
+		var err error
+		u, err = webhookURLWait(webhook)
+		if err != nil {
+			return nil, fmt.Errorf("invalid webhook: %w", err)
+		}
+	}
+	res, err := n.b.post(ctx, u, payload)
+	if err != nil {
+		return nil, err
+	}
+	// 200 with the message when waiting for it, otherwise 204
+	if res.StatusCode/100 != 2 {
+		return nil, discordError(res)
+	}
+	return res, nil
+}
+
+func (discordNotifier) tracksMessages() bool {
+	return true
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"net"
+	"os"
+
+	"github.com/rs/zerolog/log"
+)
+
+// sdNotify sends a state change such as READY=1 to systemd, for units with
+// Type=notify. It does nothing unless systemd has set NOTIFY_SOCKET.
+func sdNotify(state string) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return
+	}
+	// abstract sockets are given with a leading @
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		log.Error().AnErr("err", err).Str("state", state).Msg("error notifying systemd")
+		return
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	if err != nil {
+		log.Error().AnErr("err", err).Str("state", state).Msg("error notifying systemd")
+		return
+	}
+	log.Debug().Str("state", state).Msg("notified systemd")
+}
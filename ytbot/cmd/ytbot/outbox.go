@@ -0,0 +1,433 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/urfave/cli/v2"
+
+	"pw-ytbot/store"
+)
+
+// maxDeliveryAttempts is how many times posting a video can fail before it's given up on
+const maxDeliveryAttempts = 5
+
+// outboxRecheckAfter is how long a post can wait in the outbox, e.g. through
+// quiet hours, before its video is checked to still exist before posting it
+const outboxRecheckAfter = time.Hour
+
+// pendingPost is a post in the outbox, rendered and waiting to be delivered
+type pendingPost struct {
+	VideoID       string
+	ChannelID     channelId
+	Webhook       string
+	Content       string // rendered message, including any prefix
+	MentionRoleId string
+	Embeds        []embed        // in the embed post style
+	ThreadName    string         // for posts to a forum channel
+	Flags         int            // message flags, e.g. discordFlagSuppressEmbeds
+	PostType      string         // recorded in videos_posted once it's delivered
+	Title         string         // for keeping the message up to date with the video's title
+	Event         *videoEvent    // sent to HTTP sinks instead of the message
+	Render        *messageRender // what the message was rendered from, kept with it once it's posted
+	Attempts      int            // failed attempts to deliver it
+	QueuedAt      time.Time
+}
+
+// queuePost adds a post to the pending_posts table
+func queuePost(db store.Queries, p pendingPost) error {
+	var embeds, event, render string
+	if len(p.Embeds) > 0 {
+		data, err := json.Marshal(p.Embeds)
+		if err != nil {
+			return err
+		}
+		embeds = string(data)
+	}
+	if p.Event != nil {
+		data, err := json.Marshal(p.Event)
+		if err != nil {
+			return err
+		}
+		event = string(data)
+	}
+	if p.Render != nil {
+		data, err := json.Marshal(p.Render)
+		if err != nil {
+			return err
+		}
+		render = string(data)
+	}
+	return db.QueuePost(store.PendingPost{
+		VideoID:       p.VideoID,
+		ChannelID:     string(p.ChannelID),
+		Webhook:       p.Webhook,
+		Content:       p.Content,
+		MentionRoleID: p.MentionRoleId,
+		Embeds:        embeds,
+		ThreadName:    p.ThreadName,
+		Flags:         p.Flags,
+		PostType:      p.PostType,
+		Title:         p.Title,
+		Event:         event,
+		Render:        render,
+	})
+}
+
+// pendingPosts returns the queued posts, oldest first
+func pendingPosts(db store.Queries) ([]pendingPost, error) {
+	queued, err := db.PendingPosts()
+	if err != nil {
+		return nil, err
+	}
+
+	var posts []pendingPost
+	for _, q := range queued {
+		p := pendingPost{
+			VideoID:       q.VideoID,
+			ChannelID:     channelId(q.ChannelID),
+			Webhook:       q.Webhook,
+			Content:       q.Content,
+			MentionRoleId: q.MentionRoleID,
+			ThreadName:    q.ThreadName,
+			Flags:         q.Flags,
+			PostType:      q.PostType,
+			Title:         q.Title,
+			Attempts:      q.Attempts,
+			QueuedAt:      q.QueuedAt,
+		}
+		if q.Embeds != "" {
+			err = json.Unmarshal([]byte(q.Embeds), &p.Embeds)
+			if err != nil {
+				return nil, fmt.Errorf("invalid embeds queued for video %s: %w", p.VideoID, err)
+			}
+		}
+		if q.Event != "" {
+			err = json.Unmarshal([]byte(q.Event), &p.Event)
+			if err != nil {
+				return nil, fmt.Errorf("invalid event queued for video %s: %w", p.VideoID, err)
+			}
+		}
+		if q.Render != "" {
+			err = json.Unmarshal([]byte(q.Render), &p.Render)
+			if err != nil {
+				return nil, fmt.Errorf("invalid render queued for video %s: %w", p.VideoID, err)
+			}
+		}
+		posts = append(posts, p)
+	}
+	return posts, nil
+}
+
+// deletePendingPost removes a post to one webhook from the pending_posts table
+func deletePendingPost(db store.Queries, videoId, webhook string) error {
+	return db.DeletePendingPost(videoId, webhook)
+}
+
+// enqueue adds a post to the outbox for each of the webhooks, and records its
+// video as queued so it isn't found and queued again before it's delivered
+func (b *bot) enqueue(db store.Queries, p pendingPost, webhooks []string) error {
+	for _, webhook := range webhooks {
+		p.Webhook = webhook
+		if b.dryRun {
+			p.QueuedAt = time.Now().UTC().Truncate(time.Second)
+			b.outbox = append(b.outbox, p)
+		}
+		err := queuePost(db, p)
+		if err != nil {
+			return fmt.Errorf("error queueing post in db: %w", err)
+		}
+	}
+	err := recordVideo(db, p.VideoID, postTypeQueued)
+	if err != nil {
+		return fmt.Errorf("error inserting video into db: %w", err)
+	}
+	return nil
+}
+
+// dequeue removes a post to one webhook from the outbox, returning whether
+// the video has posts to other webhooks still waiting
+func (b *bot) dequeue(p pendingPost) (bool, error) {
+	for i, q := range b.outbox {
+		if q.VideoID == p.VideoID && q.Webhook == p.Webhook {
+			b.outbox = append(b.outbox[:i], b.outbox[i+1:]...)
+			break
+		}
+	}
+	err := deletePendingPost(b.db, p.VideoID, p.Webhook)
+	if err != nil {
+		return false, fmt.Errorf("error deleting pending post from db: %w", err)
+	}
+	posts, err := b.outboxPosts("")
+	if err != nil {
+		return false, err
+	}
+	for _, q := range posts {
+		if q.VideoID == p.VideoID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// outboxPosts returns the posts waiting in the outbox for a channel, or for
+// every channel if cId is empty, oldest first
+func (b *bot) outboxPosts(cId channelId) ([]pendingPost, error) {
+	posts, err := pendingPosts(b.db)
+	if err != nil {
+		return nil, fmt.Errorf("error reading pending posts from db: %w", err)
+	}
+	posts = append(posts, b.outbox...)
+	if cId == "" {
+		return posts, nil
+	}
+	var channelPosts []pendingPost
+	for _, p := range posts {
+		if p.ChannelID == cId {
+			channelPosts = append(channelPosts, p)
+		}
+	}
+	return channelPosts, nil
+}
+
+// deliver posts what's waiting in the outbox for a channel, or for every
+// channel if cId is empty, oldest first, until --max-posts-per-run is
+// reached. Each post's video is recorded in videos_posted once Discord
+// accepts it for any of its webhooks, while posts to the others are left
+// to succeed or fail on their own. Videos that waited a while are checked to
+// still exist first.
+func (b *bot) deliver(ctx context.Context, cId channelId) error {
+	posts, err := b.outboxPosts(cId)
+	if err != nil {
+		return err
+	}
+	if len(posts) == 0 {
+		return nil
+	}
+	log.Debug().Int("posts", len(posts)).Msg("delivering queued posts")
+
+	// without an API key, or for videos not on YouTube, assume they still exist
+	exists := make(map[string]bool)
+	var ids []string
+	for _, p := range posts {
+		if exists[p.VideoID] || slices.Contains(ids, p.VideoID) {
+			continue
+		}
+		if b.service != nil && !isPeerTubeId(p.VideoID) && time.Since(p.QueuedAt) > outboxRecheckAfter {
+			ids = append(ids, p.VideoID)
+		} else {
+			exists[p.VideoID] = true
+		}
+	}
+	if len(ids) > 0 {
+		found, err := existingVideos(ctx, b.service, ids)
+		if err != nil {
+			return fmt.Errorf("error checking queued videos still exist: %w", err)
+		}
+		for id := range found {
+			exists[id] = true
+		}
+	}
+
+	for _, p := range posts {
+		if ctx.Err() != nil {
+			return nil
+		}
+		if !b.canPost() {
+			log.Info().Int("max_posts_per_run", b.settings.maxPostsPerRun).Msg("post limit reached, leaving queued posts for a later run")
+			return nil
+		}
+		log := log.With().
+			Str("video_id", p.VideoID).
+			Str("channel_id", string(p.ChannelID)).
+			Str("post_type", p.PostType).
+			Time("queued_at", p.QueuedAt).
+			Str("webhook", redactWebhook(p.Webhook)).
+			Logger()
+
+		if !exists[p.VideoID] {
+			log.Info().Msg("queued video no longer exists, dropping")
+			err = b.dropPost(p, postTypeSkipped)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		log.Info().Msg("posting item")
+		payload := newWebhookPayload(p.Content, p.MentionRoleId)
+		payload.Embeds = p.Embeds
+		payload.ThreadName = p.ThreadName
+		payload.Flags = p.Flags
+		payload.Event = p.Event
+		payload.Render = p.Render
+		m, err := b.postMessage(ctx, p.Webhook, payload, video{ID: p.VideoID, Title: p.Title})
+		if err != nil {
+			log.Error().AnErr("err", err).Msg("error posting to webhook")
+			b.postFailed(alertLevelWarn, p, fmt.Errorf("error posting video %s: %w", p.VideoID, err))
+			err = b.deliveryFailed(p)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		b.stats.posted++
+
+		err = b.delivered(p, m)
+		if err != nil {
+			return err
+		}
+
+		// pace posts, only after actually posting
+		if !sleepContext(ctx, b.settings.postDelay) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// delivered records a post as delivered and removes it from the outbox, in
+// one transaction so a crash can't leave its video recorded as posted with
+// the post still queued, or the other way round
+func (b *bot) delivered(p pendingPost, m postedMessage) error {
+	tx, err := b.db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting db transaction: %w", err)
+	}
+	defer tx.Rollback()
+	err = recordVideo(tx, p.VideoID, p.PostType)
+	if err != nil {
+		return fmt.Errorf("error updating video in db: %w", err)
+	}
+	err = tx.MarkDelivered(p.VideoID, p.Webhook)
+	if err != nil {
+		return fmt.Errorf("error recording delivery in db: %w", err)
+	}
+	if m.ID != "" {
+		err = recordVideoMessage(tx, p.VideoID, m)
+		if err != nil {
+			return fmt.Errorf("error recording message in db: %w", err)
+		}
+	}
+	err = deletePendingPost(tx, p.VideoID, p.Webhook)
+	if err != nil {
+		return fmt.Errorf("error deleting pending post from db: %w", err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("error committing db transaction: %w", err)
+	}
+	for i, q := range b.outbox {
+		if q.VideoID == p.VideoID && q.Webhook == p.Webhook {
+			b.outbox = append(b.outbox[:i], b.outbox[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+// deliveryFailed counts a failed attempt to deliver a post, leaving it in the
+// outbox to try again on the next delivery, and gives up on it once posting
+// it has failed maxDeliveryAttempts times
+func (b *bot) deliveryFailed(p pendingPost) error {
+	attempts := p.Attempts + 1
+	for i := range b.outbox {
+		if b.outbox[i].VideoID == p.VideoID && b.outbox[i].Webhook == p.Webhook {
+			b.outbox[i].Attempts = attempts
+		}
+	}
+	err := b.db.SetPendingPostAttempts(p.VideoID, p.Webhook, attempts)
+	if err != nil {
+		return fmt.Errorf("error recording failed post in db: %w", err)
+	}
+	if attempts < maxDeliveryAttempts {
+		return nil
+	}
+	log.Warn().Str("video_id", p.VideoID).Str("webhook", redactWebhook(p.Webhook)).Int("attempts", attempts).Msg("posting video keeps failing, giving up on it")
+	b.postFailed(alertLevelError, p, fmt.Errorf("gave up posting video %s to %s after %d attempts", p.VideoID, redactWebhook(p.Webhook), attempts))
+	return b.dropPost(p, postTypeUndelivered)
+}
+
+// dropPost removes a post from the outbox without delivering it. Once the
+// video has no posts left waiting, it's recorded with postType so it isn't
+// queued again, unless it was posted to another webhook.
+func (b *bot) dropPost(p pendingPost, postType string) error {
+	waiting, err := b.dequeue(p)
+	if err != nil {
+		return err
+	}
+	if waiting {
+		return nil
+	}
+	postedType, _, err := b.db.IsPosted(p.VideoID)
+	if err != nil {
+		return fmt.Errorf("error querying db: %w", err)
+	}
+	if postedType != postTypeQueued {
+		return nil
+	}
+	err = recordVideo(b.db, p.VideoID, postType)
+	if err != nil {
+		return fmt.Errorf("error updating video in db: %w", err)
+	}
+	// an announced stream that's never posted isn't followed until it goes live
+	if p.PostType == postTypeStream {
+		err = forgetStream(b.db, p.VideoID)
+		if err != nil {
+			return fmt.Errorf("error deleting stream from db: %w", err)
+		}
+	}
+	return nil
+}
+
+func runOutboxList(cliContext *cli.Context) error {
+	db, err := openDBFromFlags(cliContext)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	posts, err := pendingPosts(db)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VIDEO ID\tCHANNEL ID\tWEBHOOK\tTYPE\tQUEUED AT\tATTEMPTS\tTITLE")
+	for _, p := range posts {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\t%s\n", p.VideoID, p.ChannelID, redactWebhook(p.Webhook), p.PostType, p.QueuedAt.Format(store.TimeFormat), p.Attempts, p.Title)
+	}
+	return w.Flush()
+}
+
+// runOutboxFlush delivers everything in the outbox now, regardless of quiet
+// hours and --max-posts-per-run
+func runOutboxFlush(cliContext *cli.Context) error {
+	b, err := newBot(cliContext)
+	if err != nil {
+		return err
+	}
+	defer b.close()
+	b.settings.maxPostsPerRun = 0
+
+	posts, err := b.outboxPosts("")
+	if err != nil {
+		return err
+	}
+	err = b.deliver(cliContext.Context, "")
+	if err != nil {
+		return err
+	}
+	left, err := b.outboxPosts("")
+	if err != nil {
+		return err
+	}
+	fmt.Printf("posted %d of %d queued posts, %d left in the outbox\n", b.stats.posted, len(posts), len(left))
+	return nil
+}
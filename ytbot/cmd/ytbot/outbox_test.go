@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/urfave/cli/v2"
+
+	"pw-ytbot/store"
+)
+
+// crashAfterCheck checks a channel like the check subcommand, then stops as a
+// crash would: without delivering the posts it queued or releasing the run lock
+func crashAfterCheck(cliContext *cli.Context) error {
+	b, err := newBot(cliContext)
+	if err != nil {
+		return err
+	}
+	for _, c := range b.currentChannels() {
+		if c.ID == channelId(cliContext.String("channel")) {
+			_, err = b.checkChannel(cliContext.Context, c)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	b.lock.stop()
+	<-b.lock.done
+	return b.db.Close()
+}
+
+// crashCheckSubcommand makes the check subcommand crash after checking,
+// until the function it returns is called or the test ends
+func crashCheckSubcommand(t *testing.T) func() {
+	for _, cmd := range app.Commands {
+		if cmd.Name == "check" {
+			action := cmd.Action
+			cmd.Action = crashAfterCheck
+			restore := func() { cmd.Action = action }
+			t.Cleanup(restore)
+			return restore
+		}
+	}
+	t.Fatal("no check subcommand")
+	return nil
+}
+
+func TestOutboxRecoversFromCrash(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	api := &fakeYouTube{videos: []fakeVideo{
+		{id: "aaaaaaaaaaa", title: "First", published: now.Add(-2 * time.Hour)},
+		{id: "bbbbbbbbbbb", title: "Second", published: now.Add(-1 * time.Hour)},
+	}}
+	useFakeYouTube(t, api)
+	webhook := &fakeWebhook{}
+	srv := httptest.NewServer(webhook)
+	defer srv.Close()
+
+	dbfile := addTestChannel(t, t.TempDir())
+	args := append(testBotArgs(dbfile, srv.URL+"/api/webhooks/1/token"), "check", "--channel", testChannelId)
+
+	// crash between queueing the posts and delivering them
+	restore := crashCheckSubcommand(t)
+	err := app.RunContext(context.Background(), args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restore()
+	if got := webhook.postedVideos(api.videos); len(got) > 0 {
+		t.Fatalf("posted %v before crashing, want nothing", got)
+	}
+	db, err := openDB(dbOptions{driver: store.DriverSQLite, path: dbfile, journalMode: journalModeWAL, busyTimeout: 5 * time.Second}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	queued, err := db.PendingPosts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queued) != len(api.videos) {
+		t.Fatalf("%d posts queued before crashing, want %d", len(queued), len(api.videos))
+	}
+
+	// the crashed run's lock goes stale, then the next run takes over
+	_, heartbeat, held, err := db.LockHolder()
+	if err != nil || !held {
+		t.Fatalf("run lock after crash: held %v, %v", held, err)
+	}
+	time.Sleep(time.Until(heartbeat.Add(2 * time.Second)))
+	args = append(testBotArgs(dbfile, srv.URL+"/api/webhooks/1/token"), "--lock-timeout", "1s", "check", "--channel", testChannelId)
+	for run := 1; run <= 2; run++ {
+		err = app.RunContext(context.Background(), args)
+		if err != nil {
+			t.Fatalf("run %d after crash: %v", run, err)
+		}
+		got := webhook.postedVideos(api.videos)
+		want := []string{"aaaaaaaaaaa", "bbbbbbbbbbb"}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("run %d after crash: posted %v, want %v each exactly once", run, got, want)
+		}
+	}
+
+	queued, err = db.PendingPosts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queued) != 0 {
+		t.Errorf("%d posts still queued, want none", len(queued))
+	}
+	postType, _, err := db.IsPosted("aaaaaaaaaaa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if postType != postTypeVideo {
+		t.Errorf("video recorded as %q, want %q", postType, postTypeVideo)
+	}
+}
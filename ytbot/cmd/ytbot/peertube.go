@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// platforms a channel in the channels file can be on
+const (
+	platformYouTube  = "youtube"
+	platformPeerTube = "peertube"
+)
+
+// peertubeIdPrefix starts the IDs given to PeerTube channels and their
+// videos, so they can't be mistaken for YouTube ones
+const peertubeIdPrefix = platformPeerTube + ":"
+
+// isPeerTubeId returns true if s is the ID of a PeerTube channel or video
+func isPeerTubeId(s string) bool {
+	return strings.HasPrefix(s, peertubeIdPrefix)
+}
+
+// isPeerTube returns whether the channel is on a PeerTube instance rather than YouTube
+func (c channel) isPeerTube() bool {
+	return isPeerTubeId(string(c.ID))
+}
+
+// validatePlatform checks a channel's platform and feed settings, returning
+// the channel's ID prefixed for its platform
+func validatePlatform(c channel) (channelId, error) {
+	switch c.Platform {
+	case "", platformYouTube:
+		if c.Feed != "" {
+			return "", fmt.Errorf("feed is only used for %s channels", platformPeerTube)
+		}
+		return c.ID, nil
+	case platformPeerTube:
+		u, err := url.Parse(c.Feed)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return "", fmt.Errorf("%s channel needs the http(s) URL of its feed, got %q", platformPeerTube, c.Feed)
+		}
+		if isPeerTubeId(string(c.ID)) {
+			return c.ID, nil
+		}
+		return channelId(peertubeIdPrefix + string(c.ID)), nil
+	}
+	return "", fmt.Errorf("unknown platform %q, must be %s or %s", c.Platform, platformYouTube, platformPeerTube)
+}
+
+type (
+	// peertubeFeed is the part of a PeerTube channel's feed we use, which is
+	// RSS by default or Atom with ?format=atom
+	peertubeFeed struct {
+		Title   string          `xml:"title"`
+		Entries []peertubeEntry `xml:"entry"`
+		Channel struct {
+			Title string          `xml:"title"`
+			Items []peertubeEntry `xml:"item"`
+		} `xml:"channel"`
+	}
+
+	// peertubeEntry is an Atom entry or RSS item in a PeerTube feed
+	peertubeEntry struct {
+		ID        string `xml:"id"`
+		GUID      string `xml:"guid"`
+		Title     string `xml:"title"`
+		Published string `xml:"published"`
+		PubDate   string `xml:"pubDate"`
+		Link      struct {
+			Href string `xml:"href,attr"`
+			URL  string `xml:",chardata"`
+		} `xml:"link"`
+		Author struct {
+			Name string `xml:"name"`
+		} `xml:"author"`
+		Creator   string `xml:"http://purl.org/dc/elements/1.1/ creator"`
+		Thumbnail struct {
+			URL string `xml:"url,attr"`
+		} `xml:"http://search.yahoo.com/mrss/ thumbnail"`
+		GroupThumbnail struct {
+			URL string `xml:"url,attr"`
+		} `xml:"http://search.yahoo.com/mrss/ group>thumbnail"`
+	}
+)
+
+// published returns when the entry was published
+func (e peertubeEntry) published() (time.Time, error) {
+	if e.Published != "" {
+		return time.Parse(time.RFC3339, e.Published)
+	}
+	for _, layout := range []string{time.RFC1123Z, time.RFC1123} {
+		t, err := time.Parse(layout, e.PubDate)
+		if err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid publish time %q", e.PubDate)
+}
+
+// video converts a feed entry into a video, identified by its ID or URL on
+// its instance with the PeerTube prefix
+func (e peertubeEntry) video(channelTitle string, published time.Time) video {
+	link := strings.TrimSpace(e.Link.Href)
+	if link == "" {
+		link = strings.TrimSpace(e.Link.URL)
+	}
+	id := strings.TrimSpace(e.ID)
+	if id == "" {
+		id = strings.TrimSpace(e.GUID)
+	}
+	if id == "" {
+		id = link
+	}
+	if e.Author.Name != "" {
+		channelTitle = e.Author.Name
+	} else if e.Creator != "" {
+		channelTitle = e.Creator
+	}
+	thumbnail := e.Thumbnail.URL
+	if thumbnail == "" {
+		thumbnail = e.GroupThumbnail.URL
+	}
+	return video{
+		ID:                   peertubeIdPrefix + id,
+		ChannelTitle:         channelTitle,
+		Title:                strings.TrimSpace(e.Title),
+		PublishedAt:          published.UTC().Format(time.RFC3339),
+		LiveBroadcastContent: broadcastNone,
+		Thumbnail:            thumbnail,
+		Link:                 link,
+		PrivacyStatus:        "public", // feeds only list public videos
+	}
+}
+
+// peertubeVideos returns up to limit of a PeerTube channel's videos published
+// after t, from its feed
+func (b *bot) peertubeVideos(ctx context.Context, c channel, t time.Time, limit int) ([]video, error) {
+	var feed peertubeFeed
+	modified, err := b.fetchChannelFeed(ctx, c.ID, c.Feed, &feed)
+	if err != nil || !modified {
+		return nil, err
+	}
+
+	entries, title := feed.Entries, feed.Title
+	if len(entries) == 0 {
+		entries, title = feed.Channel.Items, feed.Channel.Title
+	}
+
+	// entries are newest first
+	var videos []video
+	for _, e := range entries {
+		if len(videos) == limit {
+			break
+		}
+		published, err := e.published()
+		if err != nil {
+			log.Warn().AnErr("err", err).Str("channel_id", string(c.ID)).Str("entry_title", e.Title).Msg("error parsing feed entry publish time, skipping")
+			continue
+		}
+		if published.After(t) {
+			videos = append(videos, e.video(strings.TrimSpace(title), published))
+		}
+	}
+	return videos, nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/urfave/cli/v2"
+)
+
+// runPost posts a single video by ID, as the bot would have if it had found it
+func runPost(cliContext *cli.Context) error {
+	if cliContext.NArg() != 1 {
+		return errors.New("expected exactly one video ID")
+	}
+	videoId := cliContext.Args().First()
+
+	b, err := newBot(cliContext)
+	if err != nil {
+		return err
+	}
+	defer b.close()
+	ctx := cliContext.Context
+	if b.service == nil {
+		return fmt.Errorf("looking up a video needs --apikey")
+	}
+
+	postedType, posted, err := b.db.IsPosted(videoId)
+	if err != nil {
+		return err
+	}
+	if posted && !cliContext.Bool("force") {
+		return fmt.Errorf("video %s has already been handled (%s), use --force to post it again", videoId, postedType)
+	}
+
+	response, err := b.service.Videos.List([]string{"snippet"}).Id(videoId).Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("error looking up video %s: %w", videoId, err)
+	}
+	if len(response.Items) == 0 {
+		return fmt.Errorf("video %s not found, it may be private or deleted", videoId)
+	}
+	v := videoFromVideo(response.Items[0])
+
+	// use the channel's settings if it is configured, otherwise the global ones
+	c := channel{ID: v.ChannelID, Name: channelName(v.ChannelTitle), suppressEmbeds: b.settings.suppressEmbeds}
+	for _, cc := range b.currentChannels() {
+		if cc.ID == v.ChannelID {
+			c = cc
+			break
+		}
+	}
+	if c.messageTemplate == nil {
+		c.messageTemplate = b.settings.messageTemplate
+	}
+	if c.postStyle == "" {
+		c.postStyle = b.settings.postStyle
+	}
+	if c.timestampStyle == "" {
+		c.timestampStyle = b.settings.timestampStyle
+	}
+
+	webhooks, destination := b.webhooksFor(c)
+	if len(webhooks) == 0 {
+		return fmt.Errorf("no %s webhook set", destination)
+	}
+	err = webhooks.validate()
+	if err != nil {
+		return fmt.Errorf("invalid %s webhook: %w", destination, err)
+	}
+	url := b.videoURL(v)
+	content, err := c.renderMessage(c.messageTemplate, v, url)
+	if err != nil {
+		return err
+	}
+
+	// record the video once any webhook has it, and carry on to the others
+	var errs []error
+	c.embedColor = b.embedColor(ctx, c, []video{v})
+	payload := c.payload(content, v, url)
+	payload.Event = c.videoEvent(v, url, postTypeVideo)
+	payload.Render = c.messageRender(c.messageTemplate, v, url, "", payload)
+	for _, webhook := range webhooks {
+		m, err := b.postMessage(ctx, webhook, payload, v)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("error posting video %s to %s: %w", videoId, redactWebhook(webhook), err))
+			continue
+		}
+		err = recordVideo(b.db, v.ID, postTypeVideo)
+		if err != nil {
+			return fmt.Errorf("error recording video %s: %w", videoId, err)
+		}
+		err = recordVideoDetails(b.db, c, v)
+		if err != nil {
+			return fmt.Errorf("error recording details of video %s: %w", videoId, err)
+		}
+		err = b.db.MarkDelivered(v.ID, webhook)
+		if err != nil {
+			return fmt.Errorf("error recording delivery of video %s: %w", videoId, err)
+		}
+		if m.ID != "" {
+			err = recordVideoMessage(b.db, v.ID, m)
+			if err != nil {
+				return fmt.Errorf("error recording message for video %s: %w", videoId, err)
+			}
+		}
+		fmt.Printf("posted %s (%s) to %s webhook %s\n", v.ID, v.Title, destination, redactWebhook(webhook))
+	}
+	return errors.Join(errs...)
+}
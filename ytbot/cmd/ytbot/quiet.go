@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// quietHours is a daily time range during which videos are queued rather than posted
+type quietHours struct {
+	start, end time.Duration // since midnight
+	loc        *time.Location
+}
+
+// parseQuietHours parses a range like "00:00-07:00" in loc. The range may wrap
+// past midnight, e.g. "22:00-06:00".
+func parseQuietHours(s string, loc *time.Location) (*quietHours, error) {
+	from, to, ok := strings.Cut(s, "-")
+	if !ok {
+		return nil, fmt.Errorf("quiet hours %q must be like 00:00-07:00", s)
+	}
+	start, err := parseClock(from)
+	if err != nil {
+		return nil, fmt.Errorf("quiet hours %q: %w", s, err)
+	}
+	end, err := parseClock(to)
+	if err != nil {
+		return nil, fmt.Errorf("quiet hours %q: %w", s, err)
+	}
+	if start == end {
+		return nil, fmt.Errorf("quiet hours %q start and end are the same", s)
+	}
+	return &quietHours{start: start, end: end, loc: loc}, nil
+}
+
+// parseClock parses a time of day like "07:00" into the time since midnight
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q, must be HH:MM", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// contains returns whether t falls within the quiet hours. A nil quietHours
+// contains no times.
+func (q *quietHours) contains(t time.Time) bool {
+	if q == nil {
+		return false
+	}
+	t = t.In(q.loc)
+	sinceMidnight := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+	if q.start < q.end {
+		return sinceMidnight >= q.start && sinceMidnight < q.end
+	}
+	return sinceMidnight >= q.start || sinceMidnight < q.end
+}
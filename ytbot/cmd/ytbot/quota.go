@@ -0,0 +1,205 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/urfave/cli/v2"
+
+	"pw-ytbot/store"
+)
+
+// quotaLocation is where the YouTube API's daily quota resets at midnight
+var quotaLocation = mustLoadLocation("America/Los_Angeles")
+
+// errQuotaExhausted is returned for API calls that would go over --daily-quota-budget
+var errQuotaExhausted = errors.New("daily API quota budget exhausted")
+
+func mustLoadLocation(name string) *time.Location {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		panic(err)
+	}
+	return loc
+}
+
+// quotaCost returns the quota units an API call costs, and the kind of call it
+// is, from the request's URL path, e.g. /youtube/v3/search
+func quotaCost(req *http.Request) (string, int) {
+	call := req.URL.Path[strings.LastIndex(req.URL.Path, "/")+1:]
+	if call == "search" {
+		return call, 100
+	}
+	return call, 1
+}
+
+// quotaDay returns the quota day t falls in
+func quotaDay(t time.Time) string {
+	return t.In(quotaLocation).Format(time.DateOnly)
+}
+
+// quotaTracker records the quota used by API calls in the quota_usage table,
+// refusing calls once the day's budget is used up. It is safe to share
+// between goroutines.
+type quotaTracker struct {
+	db     store.Queries
+	budget int
+
+	mu       sync.Mutex
+	day      string
+	used     int  // units used on day, including calls not written to the database in dry-run mode
+	exceeded bool // YouTube said the quota for day has run out, whatever our count says
+}
+
+// newQuotaTracker creates a quotaTracker with --daily-quota-budget
+func newQuotaTracker(cliContext *cli.Context, db store.Queries) (*quotaTracker, error) {
+	budget := cliContext.Int("daily-quota-budget")
+	if budget <= 0 {
+		return nil, fmt.Errorf("--daily-quota-budget must be positive, got %d", budget)
+	}
+	return &quotaTracker{db: db, budget: budget}, nil
+}
+
+// refresh loads the units used so far today, if the day has changed. q.mu must be held.
+func (q *quotaTracker) refresh() error {
+	day := quotaDay(time.Now())
+	if day == q.day {
+		return nil
+	}
+	used, err := q.db.QuotaUsed(day)
+	if err != nil {
+		return fmt.Errorf("error reading quota usage from db: %w", err)
+	}
+	q.day, q.used, q.exceeded = day, used, false
+	return nil
+}
+
+// spend records a call, returning errQuotaExhausted instead if it would go
+// over the budget
+func (q *quotaTracker) spend(call string, units int) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	err := q.refresh()
+	if err != nil {
+		return err
+	}
+	if q.exceeded {
+		return fmt.Errorf("%w: YouTube reported the quota exceeded", errQuotaExhausted)
+	}
+	if q.used+units > q.budget {
+		return fmt.Errorf("%w: %d of %d units used today", errQuotaExhausted, q.used, q.budget)
+	}
+	err = q.db.SpendQuota(q.day, call, units)
+	if err != nil {
+		return fmt.Errorf("error recording quota usage in db: %w", err)
+	}
+	q.used += units
+	return nil
+}
+
+// exhausted returns whether a call of the given cost would go over the budget
+func (q *quotaTracker) exhausted(units int) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.refresh() != nil {
+		return false
+	}
+	return q.exceeded || q.used+units > q.budget
+}
+
+// exceed stops any more calls being made until the quota resets
+func (q *quotaTracker) exceed() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.exceeded = true
+}
+
+// checkCost estimates the quota units a channel check uses
+func (b *bot) checkCost(c channel) int {
+	cost := 2
+	switch {
+	case c.search != nil:
+		cost = 100
+	case c.isPeerTube(), b.settings.source == sourceRSS:
+		cost = 0
+	case b.settings.useSearch:
+		cost = 100
+	}
+	if c.Streams {
+		// searching for upcoming streams, and looking them up
+		cost += 101
+	}
+	return cost
+}
+
+// quotaTransport is an http.RoundTripper that records the quota each API call
+// uses, refusing calls over the budget or once YouTube says the quota has run
+// out
+type quotaTransport struct {
+	quota     *quotaTracker
+	transport http.RoundTripper
+}
+
+func (t *quotaTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	err := t.quota.spend(quotaCost(req))
+	if err != nil {
+		return nil, err
+	}
+	res, err := t.transport.RoundTrip(req)
+	if errors.Is(err, errQuotaExhausted) {
+		t.quota.exceed()
+		return nil, err
+	}
+	if err != nil {
+		return nil, err
+	}
+	if quotaExceeded(res) {
+		res.Body.Close()
+		t.quota.exceed()
+		return nil, fmt.Errorf("%w: YouTube reported the quota exceeded", errQuotaExhausted)
+	}
+	return res, nil
+}
+
+// quotaExceeded returns whether YouTube refused a call as the quota has run out
+func quotaExceeded(res *http.Response) bool {
+	reasons := apiErrorReasons(res)
+	return slices.Contains(reasons, "quotaExceeded") || slices.Contains(reasons, "dailyLimitExceeded")
+}
+
+// runQuota prints the API quota used today by each kind of call
+func runQuota(cliContext *cli.Context) error {
+	db, err := openDBFromFlags(cliContext)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	day := quotaDay(time.Now())
+	usage, err := db.QuotaUsage(day)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CALL\tCALLS\tUNITS")
+	var total int
+	for _, u := range usage {
+		total += u.Units
+		fmt.Fprintf(w, "%s\t%d\t%d\n", u.Call, u.Calls, u.Units)
+	}
+	fmt.Fprintf(w, "total\t\t%d\n", total)
+	err = w.Flush()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("\n%d of %d units used on %s (Pacific time)\n", total, cliContext.Int("daily-quota-budget"), day)
+	return nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// rateLimiter spaces out calls so there is at least interval between them,
+// plus up to jitter extra so calls don't land in a regular burst. It is safe
+// to share between goroutines.
+type rateLimiter struct {
+	interval time.Duration
+	jitter   time.Duration
+
+	mu   sync.Mutex
+	next time.Time // earliest time the next call may go
+}
+
+func newRateLimiter(callsPerSecond float64, jitter time.Duration) *rateLimiter {
+	return &rateLimiter{
+		interval: time.Duration(float64(time.Second) / callsPerSecond),
+		jitter:   jitter,
+	}
+}
+
+// wait blocks until the next call may go, returning the request's context
+// error if it is cancelled first
+func (l *rateLimiter) wait(req *http.Request) error {
+	l.mu.Lock()
+	now := time.Now()
+	at := l.next
+	if at.Before(now) {
+		at = now
+	}
+	l.next = at.Add(l.interval)
+	l.mu.Unlock()
+
+	d := at.Sub(now)
+	if l.jitter > 0 {
+		d += time.Duration(rand.Int63n(int64(l.jitter)))
+	}
+	if !sleepContext(req.Context(), d) {
+		return req.Context().Err()
+	}
+	return nil
+}
+
+// rateLimitedTransport is an http.RoundTripper that waits for a rateLimiter
+// before each request
+type rateLimitedTransport struct {
+	limiter   *rateLimiter
+	transport http.RoundTripper
+}
+
+func (t *rateLimitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	err := t.limiter.wait(req)
+	if err != nil {
+		return nil, err
+	}
+	return t.transport.RoundTrip(req)
+}
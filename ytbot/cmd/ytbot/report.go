@@ -0,0 +1,337 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/urfave/cli/v2"
+
+	"pw-ytbot/store"
+)
+
+const (
+	// reportWeekly sends a report of the week's posts every Sunday, for --report
+	reportWeekly = "weekly"
+
+	// reportMaxChannelName is the longest a channel's name is in a report's
+	// embed, so a field for every channel fits in Discord's limits
+	reportMaxChannelName = 100
+)
+
+// validateReport checks a --report is empty or a known schedule
+func validateReport(s string) error {
+	switch s {
+	case "", reportWeekly:
+		return nil
+	}
+	return fmt.Errorf("unknown report %q, must be %s", s, reportWeekly)
+}
+
+// parseSince parses how far back a report goes, a number of days like 7d, or
+// a duration like 36h, which can be at most retention as older posts aren't kept
+func parseSince(s string, retention time.Duration) (time.Duration, error) {
+	d, err := parseDays(s)
+	if err != nil {
+		return 0, err
+	}
+	if d <= 0 || d > retention {
+		return 0, fmt.Errorf("%s must be more than 0 and at most --retention, %s, as older posts aren't kept", s, retention)
+	}
+	return d, nil
+}
+
+// channelActivity is what was posted from one channel in a report's period
+type channelActivity struct {
+	ChannelID channelId
+	Name      channelName
+	Videos    int // including premieres and videos collected into digests
+	Streams   int
+}
+
+func (a channelActivity) posted() int {
+	return a.Videos + a.Streams
+}
+
+// report summarizes what was posted between two times, from videos_posted
+type report struct {
+	From, To    time.Time
+	Channels    []channelActivity // busiest first
+	Skipped     int               // videos deliberately not posted
+	Undelivered int               // posts given up on after failing
+}
+
+// posted returns how many videos were posted from every channel
+func (r report) posted() int {
+	var n int
+	for _, a := range r.Channels {
+		n += a.posted()
+	}
+	return n
+}
+
+// newReport counts the videos posted between from and to from each channel,
+// naming channels with names, or by their ID if they aren't in it. Videos
+// recorded before channels were recorded with them are counted under an
+// unknown channel. Videos are counted by when they were posted, or for those
+// that weren't, or were recorded before posting times were, when they were
+// recorded.
+func newReport(db store.Queries, from, to time.Time, names map[channelId]channelName) (report, error) {
+	r := report{From: from, To: to}
+	counts, err := db.PostCounts(from, to)
+	if err != nil {
+		return r, err
+	}
+
+	byChannel := make(map[channelId]*channelActivity)
+	for _, c := range counts {
+		cId, postType, n := channelId(c.ChannelID), c.PostType, c.Videos
+		switch postType {
+		case postTypeSkipped, postTypeSkippedPrivate, postTypeBackfilled:
+			r.Skipped += n
+			continue
+		case postTypeUndelivered:
+			r.Undelivered += n
+			continue
+		case postTypeQueued:
+			// not posted yet
+			continue
+		}
+
+		a, ok := byChannel[cId]
+		if !ok {
+			a = &channelActivity{ChannelID: cId, Name: names[cId]}
+			switch {
+			case cId == "":
+				a.Name = "(unknown channel)"
+			case a.Name == "":
+				a.Name = channelName(cId)
+			}
+			byChannel[cId] = a
+		}
+		if postType == postTypeStream {
+			a.Streams += n
+		} else {
+			a.Videos += n
+		}
+	}
+
+	for _, a := range byChannel {
+		r.Channels = append(r.Channels, *a)
+	}
+	sort.Slice(r.Channels, func(i, j int) bool {
+		if r.Channels[i].posted() != r.Channels[j].posted() {
+			return r.Channels[i].posted() > r.Channels[j].posted()
+		}
+		return r.Channels[i].Name < r.Channels[j].Name
+	})
+	return r, nil
+}
+
+// reportChannelNames returns the names to show in reports for channels: their
+// titles on YouTube where they're known, otherwise their configured names
+func reportChannelNames(db store.Queries, channels []channel) (map[channelId]channelName, error) {
+	names := make(map[channelId]channelName)
+	for name, cId := range channelIds {
+		names[cId] = name
+	}
+	for _, c := range channels {
+		names[c.ID] = c.displayName()
+	}
+	titles, err := channelTitles(db)
+	if err != nil {
+		return nil, fmt.Errorf("error reading channel titles from db: %w", err)
+	}
+	for cId, title := range titles {
+		names[cId] = title
+	}
+	return names, nil
+}
+
+// summary returns a line summing up the report, e.g. for the top of a post
+func (r report) summary() string {
+	posted := r.posted()
+	if posted == 0 {
+		return "No videos posted"
+	}
+	text := fmt.Sprintf("%s posted from %s", plural(posted, "video"), plural(len(r.Channels), "channel"))
+	busiest := r.Channels[0]
+	if len(r.Channels) == 1 || busiest.posted() > r.Channels[1].posted() {
+		text += fmt.Sprintf(" (the busiest was %s with %d)", busiest.Name, busiest.posted())
+	}
+	return text
+}
+
+// writeTable writes the report as a table, for operators at a terminal
+func (r report) writeTable(out io.Writer) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CHANNEL\tID\tVIDEOS\tSTREAMS\tTOTAL")
+	var videos, streams int
+	for _, a := range r.Channels {
+		videos += a.Videos
+		streams += a.Streams
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\n", a.Name, a.ChannelID, a.Videos, a.Streams, a.posted())
+	}
+	fmt.Fprintf(w, "total\t\t%d\t%d\t%d\n", videos, streams, videos+streams)
+	err := w.Flush()
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(out, "\n%s from %s to %s, and %d skipped, %d undelivered\n",
+		r.summary(), r.From.Format(time.DateTime), r.To.Format(time.DateTime), r.Skipped, r.Undelivered)
+	return err
+}
+
+// payload returns the report as a post with an embed, with a field for each
+// channel, busiest first, as many as fit in one embed
+func (r report) payload(title string) webhookPayload {
+	e := embed{
+		Title:       title,
+		Description: escapeMarkdown(r.summary()),
+		Footer:      &embedFooter{Text: fmt.Sprintf("%d skipped, %d undelivered", r.Skipped, r.Undelivered)},
+		Timestamp:   r.To.UTC().Format(time.RFC3339),
+	}
+	for i, a := range r.Channels {
+		if i == discordMaxEmbedFields-1 && len(r.Channels) > discordMaxEmbedFields {
+			e.Fields = append(e.Fields, embedField{Name: "…", Value: fmt.Sprintf("and %s more", plural(len(r.Channels)-i, "channel"))})
+			break
+		}
+		value := plural(a.Videos, "video")
+		if a.Streams > 0 {
+			value += ", " + plural(a.Streams, "stream")
+		}
+		e.Fields = append(e.Fields, embedField{Name: limitRunes(string(a.Name), reportMaxChannelName), Value: value, Inline: true})
+	}
+
+	p := newWebhookPayload("", "")
+	p.Embeds = []embed{e}
+	return p
+}
+
+// plural returns n and a noun, adding an s to it unless there's one
+func plural(n int, noun string) string {
+	if n == 1 {
+		return "1 " + noun
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
+
+// reportDueAt returns when the latest weekly report was due, on a Sunday at
+// or before now
+func (s *settings) reportDueAt(now time.Time) time.Time {
+	now = now.In(s.reportLoc)
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, s.reportLoc)
+	day = day.AddDate(0, 0, -int(day.Weekday()))
+	if now.Before(day.Add(s.reportAt)) {
+		day = day.AddDate(0, 0, -7)
+	}
+	return day.Add(s.reportAt)
+}
+
+// sendReport posts the weekly report to --report-webhook once it's due,
+// covering the week up to when it was due, and records it in reports so it
+// isn't sent twice. A report that fails to post is tried again next cycle.
+func (b *bot) sendReport(ctx context.Context) error {
+	due := b.settings.reportDueAt(time.Now())
+	sent, err := b.db.ReportSent(due)
+	if err != nil {
+		return fmt.Errorf("error querying db for reports: %w", err)
+	}
+	if sent {
+		return nil
+	}
+
+	names, err := reportChannelNames(b.db, b.currentChannels())
+	if err != nil {
+		return err
+	}
+	r, err := newReport(b.db, due.AddDate(0, 0, -7), due, names)
+	if err != nil {
+		return fmt.Errorf("error reading posts from db: %w", err)
+	}
+	log.Info().Time("due_at", due).Int("videos", r.posted()).Msg("sending weekly report")
+
+	webhook := b.settings.reportWebhook
+	title := "Weekly report, week to " + due.Format("2 January 2006")
+	_, err = b.notifierFor(webhook).send(ctx, webhook, r.payload(title), false)
+	if err != nil {
+		return fmt.Errorf("error posting weekly report to %s: %w", redactWebhook(webhook), err)
+	}
+
+	err = b.db.RecordReport(due, r.posted())
+	if err != nil {
+		return fmt.Errorf("error recording report in db: %w", err)
+	}
+	return nil
+}
+
+// runReport prints a report of what was posted since --since, and with
+// --post, posts it to --report-webhook too
+func runReport(cliContext *cli.Context) error {
+	retention, err := retentionFromFlags(cliContext)
+	if err != nil {
+		return err
+	}
+	since, err := parseSince(cliContext.String("since"), retention)
+	if err != nil {
+		return fmt.Errorf("--since: %w", err)
+	}
+	to := time.Now()
+	from := to.Add(-since)
+
+	if !cliContext.Bool("post") {
+		db, err := openDBFromFlags(cliContext)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		channels, err := dbChannels(db)
+		if err != nil {
+			return err
+		}
+		names, err := reportChannelNames(db, channels)
+		if err != nil {
+			return err
+		}
+		r, err := newReport(db, from, to, names)
+		if err != nil {
+			return err
+		}
+		return r.writeTable(os.Stdout)
+	}
+
+	b, err := newBot(cliContext)
+	if err != nil {
+		return err
+	}
+	defer b.close()
+	webhook := b.settings.reportWebhook
+	if webhook == "" {
+		return fmt.Errorf("posting the report needs --report-webhook")
+	}
+	names, err := reportChannelNames(b.db, b.currentChannels())
+	if err != nil {
+		return err
+	}
+	r, err := newReport(b.db, from, to, names)
+	if err != nil {
+		return err
+	}
+	err = r.writeTable(os.Stdout)
+	if err != nil {
+		return err
+	}
+
+	title := fmt.Sprintf("Report, %s to %s", from.Format("2 January 2006"), to.Format("2 January 2006"))
+	_, err = b.notifierFor(webhook).send(cliContext.Context, webhook, r.payload(title), false)
+	if err != nil {
+		return fmt.Errorf("error posting report to %s: %w", redactWebhook(webhook), err)
+	}
+	fmt.Printf("posted report to %s\n", redactWebhook(webhook))
+	return nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/urfave/cli/v2"
+
+	"pw-ytbot/store"
+)
+
+// defaultRetention is how long videos are kept in videos_posted, for --retention
+const defaultRetention = "30d"
+
+// searchPostRetention is how long search_posts records are kept, for counting
+// each search's posts today
+const searchPostRetention = 48 * time.Hour
+
+// parseDays parses a number of days like 30d, or a duration like 36h
+func parseDays(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q, must be days like 7d or a duration like 36h", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q, must be days like 7d or a duration like 36h", s)
+	}
+	return d, nil
+}
+
+// retentionFromFlags returns how long videos are kept in videos_posted, from --retention
+func retentionFromFlags(cliContext *cli.Context) (time.Duration, error) {
+	d, err := parseDays(cliContext.String("retention"))
+	if err != nil {
+		return 0, fmt.Errorf("--retention: %w", err)
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("--retention must be positive, got %s", cliContext.String("retention"))
+	}
+	return d, nil
+}
+
+// validateRetention checks videos are kept for longer than the furthest back
+// any channel's check can look, its lookback or the catch-up after downtime,
+// so a video is never forgotten while it can still be found and posted again
+func validateRetention(retention time.Duration, channels []channel) error {
+	furthest, longest := maxCatchUp, "the catch-up after downtime"
+	for _, c := range channels {
+		if c.Lookback > furthest {
+			furthest, longest = c.Lookback, fmt.Sprintf("the lookback of channel %s", c.displayName())
+		}
+	}
+	if retention <= furthest {
+		return fmt.Errorf("--retention %s must be longer than the furthest back a check can look, %s for %s", retention, furthest, longest)
+	}
+	return nil
+}
+
+// dbRetention returns how long rows are kept in each of the pruned tables,
+// videos_posted's for --retention
+func (s *settings) dbRetention() store.Retention {
+	return store.Retention{Videos: s.retention, SearchPosts: searchPostRetention, Messages: messageRetention}
+}
+
+// runDBPrune removes old rows from the database now, as the bot does at the
+// end of each cycle, and prints how many were removed from each table. Like
+// the bot, it refuses a --retention no longer than the furthest back any
+// channel's check can look.
+func runDBPrune(cliContext *cli.Context) error {
+	s, err := loadSettings(cliContext)
+	if err != nil {
+		return err
+	}
+	fileChannels, err := loadChannelsFileFromFlags(cliContext)
+	if err != nil {
+		return err
+	}
+	db, err := openDBFromFlags(cliContext)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	storedChannels, err := dbChannels(db)
+	if err != nil {
+		return err
+	}
+	channels := append(storedChannels, fileChannels...)
+	applySettings(channels, s)
+	err = validateRetention(s.retention, channels)
+	if err != nil {
+		return err
+	}
+
+	// a dry run only counts the rows it would remove
+	dryRun := cliContext.Bool("dry-run")
+	pruner := db
+	if dryRun {
+		pruner = db.DryRun()
+	}
+	counts, err := pruner.Prune(s.dbRetention())
+	if err != nil {
+		return err
+	}
+
+	heading := "REMOVED"
+	if dryRun {
+		heading = "WOULD REMOVE"
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "TABLE\t%s\n", heading)
+	for _, c := range counts {
+		fmt.Fprintf(w, "%s\t%d\n", c.Table, c.Rows)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateRetention(t *testing.T) {
+	tests := []struct {
+		name      string
+		retention time.Duration
+		lookbacks []time.Duration
+		wantErr   string
+	}{
+		{"longer than catch-up", maxCatchUp + time.Hour, []time.Duration{48 * time.Hour}, ""},
+		{"catch-up", maxCatchUp, []time.Duration{48 * time.Hour}, "the catch-up after downtime"},
+		{"shorter than catch-up", 7 * 24 * time.Hour, nil, "the catch-up after downtime"},
+		{"longer than lookbacks", 60 * 24 * time.Hour, []time.Duration{48 * time.Hour, 45 * 24 * time.Hour}, ""},
+		{"lookback", 45 * 24 * time.Hour, []time.Duration{48 * time.Hour, 45 * 24 * time.Hour}, "the lookback of channel"},
+		{"shorter than lookback", 30 * 24 * time.Hour, []time.Duration{45 * 24 * time.Hour}, "the lookback of channel"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var channels []channel
+			for _, l := range tt.lookbacks {
+				channels = append(channels, channel{ID: testChannelId, Name: "Mentour Pilot", Lookback: l})
+			}
+			err := validateRetention(tt.retention, channels)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("validateRetention(%s) = %v, want nil", tt.retention, err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("validateRetention(%s) = %v, want error about %s", tt.retention, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDBPruneValidatesRetention(t *testing.T) {
+	dbfile := addTestChannel(t, t.TempDir())
+	tests := []struct {
+		name    string
+		flags   []string
+		wantErr bool
+	}{
+		{"default", nil, false},
+		{"shorter than catch-up", []string{"--retention", "7d"}, true},
+		{"shorter than lookback", []string{"--lookback", "720h", "--retention", "21d"}, true},
+		{"longer than lookback", []string{"--lookback", "720h", "--retention", "31d"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := append([]string{"ytbot", "--dbfile", dbfile}, tt.flags...)
+			err := app.RunContext(context.Background(), append(args, "db", "prune", "--dry-run"))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("db prune %v: error = %v, want error %t", tt.flags, err, tt.wantErr)
+			}
+		})
+	}
+}
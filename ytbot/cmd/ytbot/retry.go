@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"math/rand"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// apiRetries is how many times a failed API call is retried
+	apiRetries = 3
+
+	// apiRetryBackoff is how long to wait before the first retry, doubling for each one after
+	apiRetryBackoff = time.Second
+)
+
+// apiErrorReasons returns the reasons given in a YouTube API error response,
+// e.g. quotaExceeded, leaving the body to be read again
+func apiErrorReasons(res *http.Response) []string {
+	if res.StatusCode < 400 {
+		return nil
+	}
+	body, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	res.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return nil
+	}
+
+	var apiErr struct {
+		Error struct {
+			Errors []struct {
+				Reason string `json:"reason"`
+			} `json:"errors"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(body, &apiErr) != nil {
+		return nil
+	}
+	var reasons []string
+	for _, e := range apiErr.Error.Errors {
+		reasons = append(reasons, e.Reason)
+	}
+	return reasons
+}
+
+// retryable returns whether a failed API call is worth trying again
+func retryable(res *http.Response) bool {
+	if res.StatusCode >= 500 {
+		return true
+	}
+	reasons := apiErrorReasons(res)
+	return slices.Contains(reasons, "rateLimitExceeded") || slices.Contains(reasons, "userRateLimitExceeded")
+}
+
+// retryTransport is an http.RoundTripper that retries API calls that fail with
+// a server error or because they were rate limited, with exponential backoff
+// and jitter. Only requests without a body, as all list calls are, are retried.
+type retryTransport struct {
+	transport http.RoundTripper
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	backoff := apiRetryBackoff
+	for attempt := 0; ; attempt++ {
+		res, err := t.transport.RoundTrip(req)
+		hasBody := req.Body != nil && req.Body != http.NoBody
+		if err != nil || attempt == apiRetries || hasBody || !retryable(res) {
+			return res, err
+		}
+
+		wait := backoff + time.Duration(rand.Int63n(int64(backoff/2)))
+		log.Warn().Str("status", res.Status).Str("path", req.URL.Path).Int("attempt", attempt+1).Dur("wait", wait).Msg("YouTube API call failed, retrying")
+		io.Copy(io.Discard, res.Body)
+		res.Body.Close()
+		if !sleepContext(req.Context(), wait) {
+			return nil, req.Context().Err()
+		}
+		backoff *= 2
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	"pw-ytbot/store"
+)
+
+// what to do with a video that looks like a re-upload of one posted recently,
+// for --reupload-action
+const (
+	reuploadActionNone     = "none"     // post it like any other video
+	reuploadActionSkip     = "skip"     // don't post it
+	reuploadActionAnnotate = "annotate" // post it, marked as a re-upload
+)
+
+// a video is a re-upload of one posted from the same channel within
+// reuploadWindow whose title is at least reuploadSimilarity alike
+const (
+	reuploadWindow     = 72 * time.Hour
+	reuploadSimilarity = 0.9
+
+	reuploadAnnotation = "(re-upload)"
+)
+
+// validateReuploadAction checks a re-upload action is one we know about
+func validateReuploadAction(a string) error {
+	switch a {
+	case reuploadActionNone, reuploadActionSkip, reuploadActionAnnotate:
+		return nil
+	}
+	return fmt.Errorf("unknown re-upload action %q, must be %s, %s or %s", a, reuploadActionNone, reuploadActionSkip, reuploadActionAnnotate)
+}
+
+// normalizeTitle lower cases a title and drops its punctuation and extra
+// spaces, so e.g. "Engine Failure!" and "engine failure" are the same
+func normalizeTitle(title string) string {
+	title = strings.Map(func(r rune) rune {
+		switch {
+		case unicode.IsLetter(r), unicode.IsNumber(r):
+			return unicode.ToLower(r)
+		case unicode.IsSpace(r):
+			return ' '
+		}
+		return -1
+	}, title)
+	return strings.Join(strings.Fields(title), " ")
+}
+
+// titleNumbers returns the numbers in a normalized title, which tell apart
+// the parts of a series whose titles are otherwise the same
+func titleNumbers(title string) string {
+	return strings.Join(strings.FieldsFunc(title, func(r rune) bool { return !unicode.IsNumber(r) }), " ")
+}
+
+// titleSimilarity returns how alike two normalized titles are, from 0 to 1,
+// as 1 minus their Levenshtein distance over the longer title's length
+func titleSimilarity(a, b string) float64 {
+	ra, rb := []rune(a), []rune(b)
+	longest := max(len(ra), len(rb))
+	if longest == 0 {
+		return 1
+	}
+
+	// two rows of the edit distance matrix are enough
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return 1 - float64(prev[len(rb)])/float64(longest)
+}
+
+// findReupload returns the ID of a video posted from the same channel within
+// reuploadWindow whose title is alike enough to v's that v is probably a
+// re-upload of it, or an empty string if there isn't one
+func findReupload(db store.Queries, cId channelId, v video) (string, float64, error) {
+	recent, err := db.RecentlyPosted(string(cId), v.ID, reuploadWindow, postTypeVideo, postTypePremiere)
+	if err != nil {
+		return "", 0, err
+	}
+
+	title := normalizeTitle(v.Title)
+	for _, r := range recent {
+		posted := normalizeTitle(r.Title)
+		if titleNumbers(posted) != titleNumbers(title) {
+			continue
+		}
+		if s := titleSimilarity(title, posted); s >= reuploadSimilarity {
+			return r.ID, s, nil
+		}
+	}
+	return "", 0, nil
+}
+
+// recordVideoDetails records which channel a posted video came from, its
+// title and when it was published, for spotting re-uploads and for reports
+func recordVideoDetails(db store.Queries, c channel, v video) error {
+	channelTitle := v.ChannelTitle
+	if channelTitle == "" {
+		channelTitle = string(c.displayName())
+	}
+	return db.SetVideoDetails(v.ID, store.VideoDetails{
+		ChannelID:    string(c.ID),
+		Title:        v.Title,
+		ChannelTitle: channelTitle,
+		PublishedAt:  v.publishedTime(),
+	})
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"hash/fnv"
+	"time"
+
+	"pw-ytbot/store"
+)
+
+// checkOffset returns a channel's stable offset within interval, so that
+// channels sharing an interval are spread evenly across it rather than all
+// becoming due at once
+func checkOffset(cId channelId, interval time.Duration) time.Duration {
+	h := fnv.New64a()
+	h.Write([]byte(cId))
+	return time.Duration(h.Sum64() % uint64(interval))
+}
+
+// nextCheckAt returns when a channel is next due to be checked after now: the
+// first of its slots, every interval from its offset, that is after now
+func nextCheckAt(cId channelId, interval time.Duration, now time.Time) time.Time {
+	offset := checkOffset(cId, interval)
+	since := now.Sub(time.Unix(0, 0).Add(offset))
+	return now.Add(interval - since%interval).UTC().Truncate(time.Second)
+}
+
+// checkDueAt returns when a channel is due to be checked, from its row in
+// channel_check_times: its scheduled next check, or for channels last checked
+// before checks were scheduled, an interval after their last check
+func checkDueAt(ct store.CheckTime, interval time.Duration) time.Time {
+	if !ct.NextCheckAt.IsZero() {
+		return ct.NextCheckAt
+	}
+	return ct.DateChecked.Add(interval)
+}
+
+// maxCatchUp is the furthest back a check looks for videos missed while the
+// bot wasn't running, unless the channel's lookback is longer
+const maxCatchUp = 14 * 24 * time.Hour
+
+// catchUpFrom returns when a check should look for videos from: the start of
+// the lookback window, or when the newest video seen was published if that's
+// earlier, but no earlier than maxCatchUp ago
+func catchUpFrom(lastSeen time.Time, lookback time.Duration, now time.Time) time.Time {
+	from := now.Add(-lookback)
+	if lastSeen.Before(from) {
+		from = lastSeen
+	}
+	if floor := now.Add(-max(lookback, maxCatchUp)); from.Before(floor) {
+		from = floor
+	}
+	return from
+}
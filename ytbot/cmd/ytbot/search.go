@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"pw-ytbot/store"
+)
+
+// searchIdPrefix starts the IDs given to searches, so they can be scheduled,
+// filtered and posted like channels
+const searchIdPrefix = "search:"
+
+// defaultSearchCheckInterval is how often searches are checked if they don't
+// set check_interval, as each check costs 100 quota units
+const defaultSearchCheckInterval = time.Hour
+
+type (
+	// searchEntry is a search query across all of YouTube, as listed in the
+	// searches section of the channels file
+	searchEntry struct {
+		Name     string `yaml:"name"`
+		Query    string `yaml:"query"`
+		Region   string `yaml:"region,omitempty"`   // ISO 3166-1 alpha-2 country code
+		Language string `yaml:"language,omitempty"` // ISO 639-1 language code
+		Enabled  *bool  `yaml:"enabled,omitempty"`
+
+		Webhook         webhookList   `yaml:"webhook,omitempty"`
+		MentionRoleId   string        `yaml:"mention_role_id,omitempty"`
+		Prefix          string        `yaml:"prefix,omitempty"`
+		MessageTemplate string        `yaml:"message_template,omitempty"`
+		CheckInterval   time.Duration `yaml:"check_interval,omitempty"`
+		Lookback        time.Duration `yaml:"lookback,omitempty"`
+		TitleInclude    []string      `yaml:"title_include,omitempty"`
+		TitleExclude    []string      `yaml:"title_exclude,omitempty"`
+		Tags            []string      `yaml:"tags,omitempty"`
+		MaxPostsPerDay  int           `yaml:"max_posts_per_day,omitempty"`
+		MinViews        int64         `yaml:"min_views,omitempty"`
+		PostStyle       string        `yaml:"post_style,omitempty"`
+		Digest          *bool         `yaml:"digest,omitempty"`
+		EmbedColor      string        `yaml:"embed_color,omitempty"`
+	}
+
+	// searchQuery is what a channel made from a searchEntry searches for
+	searchQuery struct {
+		query, region, language string
+		maxPostsPerDay          int // 0 for no limit
+	}
+)
+
+// isSearchId returns true if s is the ID given to a search
+func isSearchId(s string) bool {
+	return strings.HasPrefix(s, searchIdPrefix)
+}
+
+// channel returns the channel a search is checked as
+func (s searchEntry) channel() channel {
+	c := channel{
+		Name:            channelName(s.Name),
+		ID:              channelId(searchIdPrefix + s.Name),
+		Enabled:         s.Enabled,
+		Webhook:         s.Webhook,
+		MentionRoleId:   s.MentionRoleId,
+		Prefix:          s.Prefix,
+		MessageTemplate: s.MessageTemplate,
+		CheckInterval:   s.CheckInterval,
+		Lookback:        s.Lookback,
+		TitleInclude:    s.TitleInclude,
+		TitleExclude:    s.TitleExclude,
+		Tags:            s.Tags,
+		MinViews:        s.MinViews,
+		PostStyle:       s.PostStyle,
+		Digest:          s.Digest,
+		EmbedColor:      s.EmbedColor,
+		search: &searchQuery{
+			query:          s.Query,
+			region:         s.Region,
+			language:       s.Language,
+			maxPostsPerDay: s.MaxPostsPerDay,
+		},
+	}
+	if c.CheckInterval == 0 {
+		c.CheckInterval = defaultSearchCheckInterval
+	}
+	return c
+}
+
+// validate checks a search entry from the channels file
+func (s searchEntry) validate() error {
+	switch {
+	case s.Name == "":
+		return fmt.Errorf("search for %q has no name", s.Query)
+	case strings.TrimSpace(s.Query) == "":
+		return fmt.Errorf("search %s has no query", s.Name)
+	case s.MaxPostsPerDay < 0:
+		return fmt.Errorf("search %s has negative max_posts_per_day %d", s.Name, s.MaxPostsPerDay)
+	case s.Region != "" && len(s.Region) != 2:
+		return fmt.Errorf("search %s region %q isn't a two letter country code", s.Name, s.Region)
+	}
+	return nil
+}
+
+// queryVideos returns up to limit of the most recent videos matching a
+// search's query that were published after t
+func (b *bot) queryVideos(ctx context.Context, q *searchQuery, t time.Time, limit int) ([]video, error) {
+	if b.service == nil {
+		return nil, errors.New("searches need --apikey")
+	}
+
+	call := b.service.Search.List([]string{"snippet"}).Q(q.query)
+	if q.region != "" {
+		call = call.RegionCode(q.region)
+	}
+	if q.language != "" {
+		call = call.RelevanceLanguage(q.language)
+	}
+	return searchResults(ctx, call, t, limit)
+}
+
+// searchPostsToday returns how many videos a search has posted today (UTC)
+func searchPostsToday(db store.Queries, sId channelId) (int, error) {
+	return db.SearchPostsToday(string(sId))
+}
+
+// recordSearchPost records that a search posted a video, for max_posts_per_day
+func recordSearchPost(db store.Queries, sId channelId, videoId string) error {
+	return db.RecordSearchPost(string(sId), videoId)
+}
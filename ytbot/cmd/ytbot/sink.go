@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// videoEventVersion is the version of the videoEvent schema, bumped if a
+	// change to it would break receivers
+	videoEventVersion = 1
+
+	// sinkRetries is how many times a post to an HTTP sink that fails with a
+	// server error or times out is retried, before it's left in the outbox
+	sinkRetries = 3
+
+	// sinkRetryBackoff is how long to wait before the first retry, doubling for each one after
+	sinkRetryBackoff = time.Second
+
+	// sinkSignatureHeader is the header with the body's HMAC-SHA256, with --sink-hmac-secret
+	sinkSignatureHeader = "X-Ytbot-Signature"
+)
+
+type (
+	// videoEvent is the JSON document posted to HTTP sinks for each video.
+	// It's documented in the README, so fields can be added, but not changed
+	// or removed without bumping videoEventVersion.
+	videoEvent struct {
+		Version     int             `json:"version"`
+		Type        string          `json:"type"` // the post type, video, premiere or stream
+		Live        bool            `json:"live"` // the video is live now
+		VideoID     string          `json:"video_id"`
+		Title       string          `json:"title"`
+		ChannelID   string          `json:"channel_id"`
+		ChannelName string          `json:"channel_name"`
+		URL         string          `json:"url"`
+		PublishedAt string          `json:"published_at,omitempty"` // RFC 3339
+		Thumbnails  videoThumbnails `json:"thumbnails"`
+		DetectedAt  string          `json:"detected_at"` // RFC 3339, when ytbot found the video
+	}
+
+	// videoThumbnails are the URLs of a video's thumbnails, in the sizes known
+	videoThumbnails struct {
+		Default string `json:"default,omitempty"`
+		Medium  string `json:"medium,omitempty"`
+		High    string `json:"high,omitempty"`
+	}
+)
+
+// videoEvent returns the event posted to HTTP sinks for one of the channel's
+// videos, linking to it with url
+func (c channel) videoEvent(v video, url, postType string) *videoEvent {
+	e := &videoEvent{
+		Version:     videoEventVersion,
+		Type:        postType,
+		VideoID:     v.ID,
+		Title:       v.Title,
+		ChannelID:   string(v.ChannelID),
+		ChannelName: v.ChannelTitle,
+		URL:         url,
+		Live:        v.LiveBroadcastContent == broadcastLive,
+		Thumbnails:  videoThumbnails{High: v.Thumbnail},
+		DetectedAt:  time.Now().UTC().Format(time.RFC3339),
+	}
+	if e.ChannelID == "" {
+		e.ChannelID = string(c.ID)
+	}
+	if e.ChannelName == "" {
+		e.ChannelName = string(c.displayName())
+	}
+	if t := v.publishedTime(); !t.IsZero() {
+		e.PublishedAt = t.UTC().Format(time.RFC3339)
+	}
+	// YouTube has each size at a URL of its own
+	if !isPeerTubeId(v.ID) {
+		e.Thumbnails = videoThumbnails{
+			Default: fmt.Sprintf("https://i.ytimg.com/vi/%s/default.jpg", v.ID),
+			Medium:  fmt.Sprintf("https://i.ytimg.com/vi/%s/mqdefault.jpg", v.ID),
+			High:    fmt.Sprintf("https://i.ytimg.com/vi/%s/hqdefault.jpg", v.ID),
+		}
+	}
+	return e
+}
+
+// sinkRequest is a video's event posted to an HTTP sink, with the route's
+// headers and a signature if there's a secret. The body is just the event.
+type sinkRequest struct {
+	event  *videoEvent
+	header map[string]string
+	secret string
+}
+
+func (r sinkRequest) MarshalJSON() ([]byte, error) {
+	return json.Marshal(r.event)
+}
+
+// headers returns the route's headers, and the signature of the body with
+// the secret, so receivers can check the request came from ytbot
+func (r sinkRequest) headers() http.Header {
+	h := make(http.Header)
+	for k, v := range r.header {
+		h.Set(k, v)
+	}
+	if r.secret != "" {
+		body, err := json.Marshal(r)
+		if err == nil {
+			h.Set(sinkSignatureHeader, "sha256="+sinkSignature(body, r.secret))
+		}
+	}
+	return h
+}
+
+// sinkSignature returns the hex HMAC-SHA256 of a request body with secret
+func sinkSignature(body []byte, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// httpSink posts each video as a videoEvent to the URLs of a route with
+// type http, for custom integrations. Any 2xx response is success, and
+// server errors and timeouts are retried with backoff.
+type httpSink struct {
+	b      *bot
+	header map[string]string // the route's headers, e.g. for an auth token
+}
+
+func (n httpSink) send(ctx context.Context, u string, payload webhookPayload, wait bool) (*http.Response, error) {
+	if payload.Event == nil {
+		// e.g. a digest, which is a summary rather than a video
+		log.Info().Str("webhook", redactWebhook(u)).Msg("HTTP sinks are only sent videos, not sending")
+		return &http.Response{StatusCode: http.StatusNoContent, Status: "204 No Content (not sent)", Body: http.NoBody}, nil
+	}
+	r := sinkRequest{event: payload.Event, header: n.header, secret: n.b.settings.sinkHMACSecret}
+
+	backoff := sinkRetryBackoff
+	for attempt := 0; ; attempt++ {
+		res, err := n.b.post(ctx, u, r)
+		switch {
+		case err == nil && res.StatusCode/100 == 2:
+			return res, nil
+		case err == nil && res.StatusCode < 500:
+			return nil, sinkError(res)
+		case err != nil && !isTimeout(err):
+			return nil, err
+		case attempt == sinkRetries:
+			if err != nil {
+				return nil, err
+			}
+			return nil, sinkError(res)
+		}
+
+		wait := backoff + time.Duration(rand.Int63n(int64(backoff/2)))
+		l := log.Warn().Str("webhook", redactWebhook(u)).Int("attempt", attempt+1).Dur("wait", wait)
+		if err != nil {
+			l = l.AnErr("err", err)
+		} else {
+			l = l.Str("status", res.Status)
+		}
+		l.Msg("HTTP sink failed, retrying")
+		if !sleepContext(ctx, wait) {
+			return nil, ctx.Err()
+		}
+		backoff *= 2
+	}
+}
+
+func (httpSink) tracksMessages() bool {
+	return false
+}
+
+// sinkError returns an error for a response from an HTTP sink that wasn't a
+// success, with the start of its body
+func sinkError(res *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(res.Body, 256))
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 {
+		return fmt.Errorf("unexpected http response %s", res.Status)
+	}
+	return fmt.Errorf("unexpected http response %s: %s", res.Status, body)
+}
+
+// isTimeout returns whether an error is a request timing out
+func isTimeout(err error) bool {
+	var t interface{ Timeout() bool }
+	return errors.As(err, &t) && t.Timeout()
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Slack's limits on a message's blocks
+const (
+	slackMaxSectionText  = 3000
+	slackMaxFieldText    = 2000
+	slackMaxSectionField = 10
+)
+
+type (
+	// slackPayload is the JSON body sent to a Slack incoming webhook. The
+	// text is shown in notifications, and in the channel if there are no blocks.
+	slackPayload struct {
+		Text   string       `json:"text"`
+		Blocks []slackBlock `json:"blocks,omitempty"`
+	}
+
+	// slackBlock is a Slack layout block, a section, image or context
+	slackBlock struct {
+		Type      string      `json:"type"`
+		Text      *slackText  `json:"text,omitempty"`      // section
+		Fields    []slackText `json:"fields,omitempty"`    // section
+		Accessory *slackImage `json:"accessory,omitempty"` // section
+		ImageURL  string      `json:"image_url,omitempty"` // image
+		AltText   string      `json:"alt_text,omitempty"`  // image
+		Elements  []slackText `json:"elements,omitempty"`  // context
+	}
+
+	// slackText is a Slack text object, in mrkdwn or plain_text
+	slackText struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+
+	// slackImage is a Slack image element, shown beside a section's text
+	slackImage struct {
+		Type     string `json:"type"`
+		ImageURL string `json:"image_url"`
+		AltText  string `json:"alt_text"`
+	}
+)
+
+// slackNotifier posts to Slack incoming webhooks, translating Discord payloads
+// into Slack's format. Slack doesn't return the message it posts, so they
+// can't be edited or deleted later.
+type slackNotifier struct {
+	b *bot
+}
+
+func (n slackNotifier) send(ctx context.Context, webhook string, payload webhookPayload, wait bool) (*http.Response, error) {
+	res, err := n.b.post(ctx, webhook, slackPayloadFor(payload))
+	if err != nil {
+		return nil, err
+	}
+	if n.b.dryRun {
+		return res, nil
+	}
+	return res, slackResponseError(res)
+}
+
+func (slackNotifier) tracksMessages() bool {
+	return false
+}
+
+// slackResponseError returns nil if Slack accepted a post, which it does
+// with a 200 and a body of just "ok", otherwise an error including the body,
+// where Slack says what was wrong
+func slackResponseError(res *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+	body = bytes.TrimSpace(body)
+	switch {
+	case res.StatusCode == http.StatusOK && string(body) == "ok":
+		return nil
+	case len(body) == 0:
+		return fmt.Errorf("unexpected http response %s", res.Status)
+	}
+	return fmt.Errorf("unexpected http response %s: %s", res.Status, body)
+}
+
+// testSlackWebhook posts a test message to a Slack incoming webhook, which
+// unlike Discord's can't be looked up first
+func testSlackWebhook(ctx context.Context, webhook string) error {
+	fmt.Println("platform:    slack")
+	start := time.Now()
+	res, err := postWebhook(ctx, webhook, slackPayloadFor(newWebhookPayload("ytbot connectivity test, please ignore", "")))
+	if err != nil {
+		return fmt.Errorf("error posting test message: %w", err)
+	}
+	fmt.Printf("status:      %s\n", res.Status)
+	fmt.Printf("round trip:  %s\n", time.Since(start).Round(time.Millisecond))
+	err = slackResponseError(res)
+	if err != nil {
+		return fmt.Errorf("test message not posted: %w", err)
+	}
+	return nil
+}
+
+// slackPayloadFor translates a Discord payload into Slack's format: the
+// content as a section of mrkdwn, and each embed as a section linking to it,
+// with its image, fields, and footer and timestamp as context. Role mentions
+// are left out, as they're Discord roles, as are forum post names.
+func slackPayloadFor(p webhookPayload) slackPayload {
+	sp := slackPayload{Text: slackMarkdown(p.Content)}
+	if sp.Text != "" {
+		sp.Blocks = append(sp.Blocks, slackSection(sp.Text))
+	}
+	for _, e := range p.Embeds {
+		var lines []string
+		if e.Author != nil && e.Author.Name != "" {
+			lines = append(lines, slackLiteral(e.Author.Name))
+		}
+		switch {
+		case e.Title != "" && e.URL != "":
+			lines = append(lines, fmt.Sprintf("*<%s|%s>*", e.URL, slackLiteral(e.Title)))
+		case e.Title != "":
+			lines = append(lines, "*"+slackLiteral(e.Title)+"*")
+		}
+		if e.Description != "" {
+			lines = append(lines, slackMarkdown(e.Description))
+		}
+		if len(lines) > 0 {
+			section := slackSection(strings.Join(lines, "\n"))
+			if e.Thumbnail != nil {
+				section.Accessory = &slackImage{Type: "image", ImageURL: e.Thumbnail.URL, AltText: e.Title}
+			}
+			sp.Blocks = append(sp.Blocks, section)
+		}
+		if e.Image != nil {
+			sp.Blocks = append(sp.Blocks, slackBlock{Type: "image", ImageURL: e.Image.URL, AltText: e.Title})
+		}
+
+		// a section only holds so many fields
+		for len(e.Fields) > 0 {
+			n := min(len(e.Fields), slackMaxSectionField)
+			section := slackBlock{Type: "section"}
+			for _, f := range e.Fields[:n] {
+				text := fmt.Sprintf("*%s*\n%s", slackLiteral(f.Name), slackMarkdown(f.Value))
+				section.Fields = append(section.Fields, slackText{Type: "mrkdwn", Text: limitRunes(text, slackMaxFieldText)})
+			}
+			sp.Blocks = append(sp.Blocks, section)
+			e.Fields = e.Fields[n:]
+		}
+
+		var footer []string
+		if e.Footer != nil && e.Footer.Text != "" {
+			footer = append(footer, slackLiteral(e.Footer.Text))
+		}
+		if t, err := time.Parse(time.RFC3339, e.Timestamp); err == nil {
+			footer = append(footer, slackDate(t.Unix(), timestampStyleShortDateTime))
+		}
+		if len(footer) > 0 {
+			sp.Blocks = append(sp.Blocks, slackBlock{Type: "context", Elements: []slackText{{Type: "mrkdwn", Text: strings.Join(footer, " • ")}}})
+		}
+
+		// without content, the embed's title is what notifications show
+		if sp.Text == "" {
+			sp.Text = slackEscape(e.Title)
+		}
+	}
+	return sp
+}
+
+// slackSection returns a section block of mrkdwn text, shortened to fit
+func slackSection(text string) slackBlock {
+	return slackBlock{Type: "section", Text: &slackText{Type: "mrkdwn", Text: limitRunes(text, slackMaxSectionText)}}
+}
+
+// slackEscaper escapes the characters Slack treats as control characters in text
+var slackEscaper = strings.NewReplacer(
+	`&`, `&amp;`,
+	`<`, `&lt;`,
+	`>`, `&gt;`,
+)
+
+// slackEscape escapes text for Slack
+func slackEscape(s string) string {
+	return slackEscaper.Replace(s)
+}
+
+// slackLiterals are lookalikes for the characters Slack formats text with,
+// which unlike Discord it has no way of escaping
+var slackLiterals = strings.NewReplacer(
+	`*`, `∗`,
+	`_`, `ˍ`,
+	`~`, `∼`,
+	"`", `ˋ`,
+)
+
+// slackLiteral returns plain text, such as an embed's title, or a character
+// escaped in Discord markdown, as it's written in Slack. Formatting
+// characters are swapped for lookalikes, so e.g. a * in a title doesn't end
+// the bold text around it.
+func slackLiteral(s string) string {
+	return slackEscape(slackLiterals.Replace(s))
+}
+
+// slackMarkdown translates Discord markdown into Slack's mrkdwn. Bold (**)
+// becomes Slack's *, italics with * becomes _, strikethrough (~~) becomes ~,
+// masked links become Slack's <url|text> and timestamps become Slack dates.
+// Characters escaped for Discord with a backslash are shown as written, and
+// underlines, spoilers and mentions, which Slack doesn't have, are dropped.
+func slackMarkdown(s string) string {
+	var sb strings.Builder
+	for _, t := range discordMarkdown(s) {
+		switch t.kind {
+		case mdText:
+			sb.WriteString(slackEscape(t.text))
+		case mdLiteral:
+			sb.WriteString(slackLiteral(t.text))
+		case mdLink:
+			fmt.Fprintf(&sb, "<%s|%s>", t.url, strings.ReplaceAll(slackMarkdown(t.text), "|", "¦"))
+		case mdURL:
+			sb.WriteString("<" + t.url + ">")
+		case mdTimestamp:
+			sb.WriteString(slackDate(t.unix, t.style))
+		case mdBold:
+			sb.WriteByte('*')
+		case mdItalic:
+			sb.WriteByte('_')
+		case mdStrike:
+			sb.WriteByte('~')
+		case mdCode:
+			sb.WriteByte('`')
+		case mdQuote:
+			// block quotes are written the same way
+			sb.WriteByte('>')
+		}
+	}
+	return sb.String()
+}
+
+// slackDateFormats are the Slack date formats closest to each Discord timestamp style
+var slackDateFormats = map[string]string{
+	"t": "{time}",
+	"T": "{time_secs}",
+	"d": "{date_num}",
+	"D": "{date_long}",
+	"f": "{date_long} {time}",
+	"F": "{date_long_full} {time}",
+	"R": "{date_pretty} {time}",
+}
+
+// slackDate formats a Unix time as a Slack date, which like a Discord
+// timestamp each viewer sees in their own time zone, in the format closest
+// to a Discord timestamp style
+func slackDate(unix int64, style string) string {
+	format, ok := slackDateFormats[style]
+	if !ok {
+		format = slackDateFormats["f"]
+	}
+	fallback := time.Unix(unix, 0).UTC().Format("2 January 2006 15:04 UTC")
+	return fmt.Sprintf("<!date^%d^%s|%s>", unix, format, fallback)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSlackMarkdown(t *testing.T) {
+	tests := []struct {
+		name string
+		s    string
+		want string
+	}{
+		{"plain", "New video", "New video"},
+		{"bold", "**Mentour Pilot**", "*Mentour Pilot*"},
+		{"italics with asterisks", "*new*", "_new_"},
+		{"italics with underscores", "_new_", "_new_"},
+		{"strikethrough", "~~old~~", "~old~"},
+		{"code", "`FL350`", "`FL350`"},
+		{"quote", "> quoted", "> quoted"},
+		{"escaped for slack", "A & B <C>", "A &amp; B &lt;C&gt;"},
+		{"escaped markdown", `\*\*not bold\*\* \_ \~ \` + "`" + ` \| \\`, "∗∗not bold∗∗ ˍ ∼ ˋ | \\"},
+		{"escaped greater than", `\> not a quote`, "&gt; not a quote"},
+		{"masked link", "[Watch](https://youtu.be/abcdefghijk)", "<https://youtu.be/abcdefghijk|Watch>"},
+		{"masked link with pipe", "[A | B](https://youtu.be/abcdefghijk)", "<https://youtu.be/abcdefghijk|A ¦ B>"},
+		{"masked link with suppressed embed", "[Watch](<https://youtu.be/abcdefghijk>)", "<https://youtu.be/abcdefghijk|Watch>"},
+		{"bare link", "https://youtu.be/abcdefghijk", "<https://youtu.be/abcdefghijk>"},
+		{"suppressed link", "<https://youtu.be/abcdefghijk>", "<https://youtu.be/abcdefghijk>"},
+		{"timestamp", "<t:1709366400:R>", "<!date^1709366400^{date_pretty} {time}|2 March 2024 08:00 UTC>"},
+		{"role mention", "<@&123456789> New video", "New video"},
+		{"spoiler", "||spoiler||", "spoiler"},
+		{
+			"message",
+			"New video from **Mentour Pilot**\n**Why this 737 \\*nearly\\* crashed**\nhttps://youtu.be/abcdefghijk",
+			"New video from *Mentour Pilot*\n*Why this 737 ∗nearly∗ crashed*\n<https://youtu.be/abcdefghijk>",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := slackMarkdown(tt.s)
+			if got != tt.want {
+				t.Errorf("slackMarkdown(%q) = %q, want %q", tt.s, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSlackPayloadFor(t *testing.T) {
+	p := newWebhookPayload("New video from **Mentour Pilot**\n**A\\_B & <C>**", "123456789")
+	p.ThreadName = "A_B & <C>"
+	p.Embeds = []embed{{
+		Title:     "A_B & *C*",
+		URL:       "https://youtu.be/abcdefghijk",
+		Author:    &embedAuthor{Name: "Mentour Pilot"},
+		Image:     &embedImage{URL: "https://i.ytimg.com/vi/abcdefghijk/hqdefault.jpg"},
+		Footer:    &embedFooter{Text: "12:03 · HD"},
+		Timestamp: "2024-03-02T08:00:00Z",
+	}}
+	want := slackPayload{
+		Text: "New video from *Mentour Pilot*\n*AˍB &amp; &lt;C&gt;*",
+		Blocks: []slackBlock{
+			{Type: "section", Text: &slackText{Type: "mrkdwn", Text: "New video from *Mentour Pilot*\n*AˍB &amp; &lt;C&gt;*"}},
+			{Type: "section", Text: &slackText{Type: "mrkdwn", Text: "Mentour Pilot\n*<https://youtu.be/abcdefghijk|AˍB &amp; ∗C∗>*"}},
+			{Type: "image", ImageURL: "https://i.ytimg.com/vi/abcdefghijk/hqdefault.jpg", AltText: "A_B & *C*"},
+			{Type: "context", Elements: []slackText{{Type: "mrkdwn", Text: "12:03 · HD • <!date^1709366400^{date_long} {time}|2 March 2024 08:00 UTC>"}}},
+		},
+	}
+	got := slackPayloadFor(p)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("slackPayloadFor() = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/urfave/cli/v2"
+
+	"pw-ytbot/store"
+)
+
+// tableStats is how many rows a table has
+type tableStats struct {
+	Table string `json:"table"`
+	Rows  int64  `json:"rows"`
+}
+
+// channelStats is how many videos were posted from a channel recently
+type channelStats struct {
+	ChannelID  channelId   `json:"channel_id"`
+	Name       channelName `json:"name"`
+	Last7Days  int         `json:"last_7_days"`
+	Last30Days int         `json:"last_30_days"`
+}
+
+// dbStats describes what's in the database, for ytbot db stats
+type dbStats struct {
+	SchemaVersion int            `json:"schema_version"`
+	FileSize      int64          `json:"file_size"`     // bytes, of the whole database on Postgres
+	UsedSize      int64          `json:"used_size"`     // bytes, without free pages on sqlite
+	OldestPosted  string         `json:"oldest_posted"` // empty if nothing has been posted
+	NewestPosted  string         `json:"newest_posted"` // empty if nothing has been posted
+	Tables        []tableStats   `json:"tables"`        // by name
+	Channels      []channelStats `json:"channels"`      // those posted from in the last 30 days, by name
+
+	latestVersion int // the schema version this ytbot migrates to
+}
+
+// newDBStats counts the rows in every table and the videos posted from each
+// channel in the last 7 and 30 days, naming channels with names
+func newDBStats(db store.Store, names map[channelId]channelName) (dbStats, error) {
+	var s dbStats
+	st, err := db.Stats()
+	if err != nil {
+		return s, err
+	}
+	s.SchemaVersion, s.latestVersion = st.SchemaVersion, db.LatestVersion()
+	s.FileSize, s.UsedSize = st.Size, st.UsedSize
+	s.OldestPosted, s.NewestPosted = st.OldestPosted, st.NewestPosted
+	for _, t := range st.Tables {
+		s.Tables = append(s.Tables, tableStats{Table: t.Table, Rows: t.Rows})
+	}
+
+	counts, err := db.ChannelPostCounts(7*24*time.Hour, 30*24*time.Hour)
+	if err != nil {
+		return s, fmt.Errorf("error counting posted videos in db: %w", err)
+	}
+	for _, pc := range counts {
+		c := channelStats{ChannelID: channelId(pc.ChannelID), Last7Days: pc.Posted[0], Last30Days: pc.Posted[1]}
+		c.Name = names[c.ChannelID]
+		switch {
+		case c.ChannelID == "":
+			c.Name = "(unknown channel)"
+		case c.Name == "":
+			c.Name = channelName(c.ChannelID)
+		}
+		s.Channels = append(s.Channels, c)
+	}
+	sort.Slice(s.Channels, func(i, j int) bool {
+		if s.Channels[i].Name != s.Channels[j].Name {
+			return s.Channels[i].Name < s.Channels[j].Name
+		}
+		return s.Channels[i].ChannelID < s.Channels[j].ChannelID
+	})
+	return s, nil
+}
+
+// writeTable writes the stats as aligned text tables
+func (s dbStats) writeTable(out io.Writer) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TABLE\tROWS")
+	for _, t := range s.Tables {
+		fmt.Fprintf(w, "%s\t%d\n", t.Table, t.Rows)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "CHANNEL\tID\tLAST 7 DAYS\tLAST 30 DAYS")
+	for _, c := range s.Channels {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\n", c.Name, c.ChannelID, c.Last7Days, c.Last30Days)
+	}
+	err := w.Flush()
+	if err != nil {
+		return err
+	}
+
+	oldest, newest := s.OldestPosted, s.NewestPosted
+	if oldest == "" {
+		oldest, newest = "never", "never"
+	}
+	_, err = fmt.Fprintf(out, "\nschema version %d, latest %d\nfile size %d bytes, %d without free pages\noldest post %s, newest %s\n",
+		s.SchemaVersion, s.latestVersion, s.FileSize, s.UsedSize, oldest, newest)
+	return err
+}
+
+// runDBStats prints what's in the database: how many rows each table has, how
+// many videos were posted from each channel recently, and its size and schema version
+func runDBStats(cliContext *cli.Context) error {
+	db, err := openDBFromFlags(cliContext)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	channels, err := dbChannels(db)
+	if err != nil {
+		return err
+	}
+	names, err := reportChannelNames(db, channels)
+	if err != nil {
+		return err
+	}
+	s, err := newDBStats(db, names)
+	if err != nil {
+		return err
+	}
+
+	if cliContext.Bool("json") {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(s)
+	}
+	return s.writeTable(os.Stdout)
+}
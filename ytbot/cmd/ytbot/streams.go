@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"pw-ytbot/store"
+)
+
+// streamSearchWindow is how long ago a stream can have been scheduled and
+// still be found by the search for a channel's upcoming streams
+const streamSearchWindow = 30 * 24 * time.Hour
+
+// trackedStreams returns the IDs of a channel's announced streams that
+// haven't been seen live yet
+func trackedStreams(db store.Queries, cId channelId) ([]string, error) {
+	return db.UpcomingStreams(string(cId))
+}
+
+// recordStream records a scheduled stream as announced, to follow it until it
+// goes live
+func recordStream(db store.Queries, cId channelId, v video) error {
+	return db.RecordStream(string(cId), v.ID, v.ScheduledStart)
+}
+
+// recordStreamLive records that an announced stream went live
+func recordStreamLive(db store.Queries, videoId string) error {
+	return db.MarkStreamLive(videoId)
+}
+
+// forgetStream stops following an announced stream that finished or was
+// cancelled without being seen live
+func forgetStream(db store.Queries, videoId string) error {
+	return db.ForgetStream(videoId)
+}
+
+// upcomingStreams returns a channel's upcoming live streams, and those it
+// has announced that haven't been seen live yet, with their details. Streams
+// are found by searching, as they can be scheduled long before the channel's
+// recent uploads.
+func (b *bot) upcomingStreams(ctx context.Context, c channel) ([]video, error) {
+	tracked, err := trackedStreams(b.db, c.ID)
+	if err != nil {
+		return nil, fmt.Errorf("error querying db: %w", err)
+	}
+	call := b.service.Search.List([]string{"snippet"}).ChannelId(string(c.ID)).EventType(broadcastUpcoming)
+	videos, err := searchResults(ctx, call, time.Now().Add(-streamSearchWindow), maxIdsPerCall)
+	if err != nil {
+		return nil, fmt.Errorf("error searching for upcoming streams: %w", err)
+	}
+	for _, id := range tracked {
+		videos = append(videos, video{ID: id})
+	}
+	videos, err = withDetails(ctx, b.service, videos, func(video) bool { return true })
+	if err != nil {
+		return nil, fmt.Errorf("error getting stream details: %w", err)
+	}
+	return videos, nil
+}
+
+// announceStreams announces a channel's newly scheduled live streams, from
+// upcomingStreams, and posts again when an announced stream goes live,
+// writing to the check's transaction. Premieres are left to the channel's
+// check, as they're uploaded videos.
+func (b *bot) announceStreams(ctx context.Context, tx store.Tx, c channel, videos []video, webhooks webhookList, destination string, firstCheck bool) error {
+	log := log.With().Str("channel_id", string(c.ID)).Logger()
+
+	seen := make(map[string]bool)
+	for _, v := range videos {
+		if seen[v.ID] || !v.HasDetails {
+			continue
+		}
+		seen[v.ID] = true
+
+		log := log.With().
+			Str("video_id", v.ID).
+			Str("title", v.Title).
+			Str("live_broadcast_content", v.LiveBroadcastContent).
+			Logger()
+
+		if c.filterTitle(v.Title) != "" {
+			continue
+		}
+		postedType, posted, err := tx.IsPosted(v.ID)
+		if err != nil {
+			return fmt.Errorf("error querying db: %w", err)
+		}
+
+		messageTemplate := b.settings.streamMessageTemplate
+		switch {
+		case posted && postedType == postTypeStream:
+			if v.LiveBroadcastContent == broadcastUpcoming {
+				log.Debug().Msg("stream already announced")
+				continue
+			}
+			if v.LiveBroadcastContent != broadcastLive {
+				log.Info().Msg("announced stream finished or was cancelled without being seen live")
+				err = forgetStream(tx, v.ID)
+				if err != nil {
+					return fmt.Errorf("error deleting stream from db: %w", err)
+				}
+				continue
+			}
+			log.Info().Msg("announced stream has gone live")
+			messageTemplate = b.settings.liveMessageTemplate
+
+		case posted:
+			// already posted some other way, e.g. as a premiere
+			continue
+
+		case v.LiveBroadcastContent != broadcastUpcoming || v.Duration > 0:
+			// a premiere, or a stream that has already started
+			continue
+
+		case v.ScheduledStart.IsZero():
+			log.Error().Msg("stream start time not known, not announcing")
+			continue
+
+		case firstCheck && c.BackfillMode != backfillModePost:
+			log.Info().Str("backfill_mode", c.BackfillMode).Msg("not announcing stream found on channel's first check")
+			err = recordStream(tx, c.ID, v)
+			if err != nil {
+				return fmt.Errorf("error recording stream in db: %w", err)
+			}
+			err = recordVideo(tx, v.ID, postTypeStream)
+			if err != nil {
+				return fmt.Errorf("error inserting video into db: %w", err)
+			}
+			continue
+		}
+
+		if reason := notPostableReason(v, v.PrivacyStatus, v.UploadStatus); reason != "" {
+			log.Info().Str("reason", reason).Msg("not announcing stream that isn't public")
+			continue
+		}
+
+		url := b.videoURL(v)
+		content, err := c.renderMessage(messageTemplate, v, url)
+		if err != nil {
+			return fmt.Errorf("error rendering message template: %w", err)
+		}
+		log.Info().Msg("queueing stream message")
+		payload := c.payload(content, v, url)
+		payload.Render = c.messageRender(messageTemplate, v, url, "", payload)
+		itemWebhooks, itemDestination := b.routeItem(log, c, v, webhooks, destination)
+		if itemDestination != destination {
+			payload.ThreadName = ""
+		}
+		err = b.enqueue(tx, pendingPost{
+			VideoID:       v.ID,
+			ChannelID:     c.ID,
+			Content:       content,
+			MentionRoleId: c.MentionRoleId,
+			Embeds:        payload.Embeds,
+			ThreadName:    payload.ThreadName,
+			Flags:         payload.Flags,
+			PostType:      postTypeStream,
+			Title:         v.Title,
+			Event:         c.videoEvent(v, url, postTypeStream),
+			Render:        payload.Render,
+		}, itemWebhooks)
+		if err != nil {
+			return err
+		}
+		err = recordVideoDetails(tx, c, v)
+		if err != nil {
+			return fmt.Errorf("error recording video details in db: %w", err)
+		}
+
+		if v.LiveBroadcastContent == broadcastLive {
+			err = recordStreamLive(tx, v.ID)
+		} else {
+			err = recordStream(tx, c.ID, v)
+		}
+		if err != nil {
+			return fmt.Errorf("error recording stream in db: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// telegramAPI is the base URL of Telegram's Bot API
+	telegramAPI = "https://api.telegram.org"
+
+	// telegramDestinationPrefix marks a destination as a Telegram chat rather
+	// than a webhook URL, followed by the chat's ID
+	telegramDestinationPrefix = "telegram:"
+
+	// telegramMaxText is the most characters Telegram allows in a message
+	telegramMaxText = 4096
+)
+
+// telegramChatIdPattern matches a chat's numeric ID, or a public channel's @username
+var telegramChatIdPattern = regexp.MustCompile(`^(-?\d+|@[A-Za-z0-9_]{5,})$`)
+
+// telegramDestination returns the destination posting to a Telegram chat,
+// which is kept with webhook URLs, e.g. in the outbox
+func telegramDestination(chatId string) string {
+	return telegramDestinationPrefix + chatId
+}
+
+// isTelegramDestination returns whether a destination is a Telegram chat
+func isTelegramDestination(s string) bool {
+	return strings.HasPrefix(s, telegramDestinationPrefix)
+}
+
+// validateTelegramChatId checks a Telegram chat ID looks usable
+func validateTelegramChatId(id string) error {
+	if !telegramChatIdPattern.MatchString(id) {
+		return fmt.Errorf("invalid Telegram chat ID %q, must be a number or @channelname", id)
+	}
+	return nil
+}
+
+// checkTelegramToken checks --telegram-token is set if any channel's route
+// posts to Telegram
+func checkTelegramToken(channels []channel, s *settings) error {
+	if s.telegramToken != "" {
+		return nil
+	}
+	for _, c := range channels {
+		for _, d := range c.routeWebhooks {
+			if isTelegramDestination(d) {
+				return fmt.Errorf("route for tag %q posts to Telegram, which needs --telegram-token", c.routeTag)
+			}
+		}
+	}
+	return nil
+}
+
+// telegramMessage is the JSON body of a Bot API sendMessage request
+type telegramMessage struct {
+	ChatID    string `json:"chat_id"`
+	Text      string `json:"text"`
+	ParseMode string `json:"parse_mode"`
+}
+
+// telegramNotifier posts to Telegram chats with the Bot API and
+// --telegram-token, translating Discord payloads into MarkdownV2 messages.
+// Rate limited requests are retried like Discord's, after the retry_after
+// Telegram gives.
+type telegramNotifier struct {
+	b *bot
+}
+
+func (n telegramNotifier) send(ctx context.Context, destination string, payload webhookPayload, wait bool) (*http.Response, error) {
+	if n.b.settings.telegramToken == "" {
+		return nil, errors.New("posting to Telegram needs --telegram-token")
+	}
+	u := fmt.Sprintf("%s/bot%s/sendMessage", telegramAPI, n.b.settings.telegramToken)
+	res, err := n.b.post(ctx, u, telegramMessage{
+		ChatID:    strings.TrimPrefix(destination, telegramDestinationPrefix),
+		Text:      telegramText(payload),
+		ParseMode: "MarkdownV2",
+	})
+	if err != nil {
+		// don't let the token into logs along with the URL
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			urlErr.URL = redactWebhook(urlErr.URL)
+		}
+		return nil, err
+	}
+	if n.b.dryRun {
+		return res, nil
+	}
+
+	var result struct {
+		OK          bool   `json:"ok"`
+		Description string `json:"description"`
+	}
+	err = json.NewDecoder(res.Body).Decode(&result)
+	if err != nil || res.StatusCode != http.StatusOK || !result.OK {
+		if result.Description == "" {
+			return nil, fmt.Errorf("unexpected http response %s", res.Status)
+		}
+		return nil, fmt.Errorf("unexpected http response %s: %s", res.Status, result.Description)
+	}
+	return res, nil
+}
+
+func (telegramNotifier) tracksMessages() bool {
+	return false
+}
+
+// telegramText translates a Discord payload into a Telegram MarkdownV2
+// message: the content, then each embed's link, if the content doesn't
+// already have it, with its description and fields. Lines that would take it
+// past Telegram's limit are left out.
+func telegramText(p webhookPayload) string {
+	lines := []string{telegramMarkdown(p.Content)}
+	for _, e := range p.Embeds {
+		switch {
+		case e.URL != "" && !strings.Contains(p.Content, e.URL):
+			title := e.Title
+			if title == "" {
+				title = e.URL
+			}
+			lines = append(lines, fmt.Sprintf("[%s](%s)", telegramEscape(title), telegramEscapeURL(e.URL)))
+		case e.URL == "" && e.Title != "":
+			lines = append(lines, "*"+telegramEscape(e.Title)+"*")
+		}
+		if e.Description != "" {
+			lines = append(lines, telegramMarkdown(e.Description))
+		}
+		for _, f := range e.Fields {
+			lines = append(lines, fmt.Sprintf("*%s*: %s", telegramEscape(f.Name), telegramMarkdown(f.Value)))
+		}
+	}
+
+	var sb strings.Builder
+	for _, line := range lines {
+		if line == "" {
+			continue
+		}
+		if utf8.RuneCountInString(sb.String())+utf8.RuneCountInString(line)+3 > telegramMaxText {
+			sb.WriteString("\n…")
+			break
+		}
+		if sb.Len() > 0 {
+			sb.WriteByte('\n')
+		}
+		sb.WriteString(line)
+	}
+	return sb.String()
+}
+
+// telegramEscaper backslash-escapes the characters MarkdownV2 treats as markup
+var telegramEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	`_`, `\_`,
+	`*`, `\*`,
+	`[`, `\[`,
+	`]`, `\]`,
+	`(`, `\(`,
+	`)`, `\)`,
+	`~`, `\~`,
+	"`", "\\`",
+	`>`, `\>`,
+	`#`, `\#`,
+	`+`, `\+`,
+	`-`, `\-`,
+	`=`, `\=`,
+	`|`, `\|`,
+	`{`, `\{`,
+	`}`, `\}`,
+	`.`, `\.`,
+	`!`, `\!`,
+)
+
+// telegramEscape escapes text to show as written in a MarkdownV2 message
+func telegramEscape(s string) string {
+	return telegramEscaper.Replace(s)
+}
+
+// telegramEscapeURL escapes a link's URL, where MarkdownV2 only treats ) and \ as markup
+func telegramEscapeURL(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `)`, `\)`).Replace(s)
+}
+
+// telegramMarkdown translates Discord markdown into Telegram's MarkdownV2,
+// which has counterparts for most of it. Everything else is escaped, so e.g.
+// a title with a . or - in it doesn't break the message. Timestamps are
+// written out in UTC, as Telegram has nothing like them, and mentions are
+// dropped.
+func telegramMarkdown(s string) string {
+	var sb strings.Builder
+	for _, t := range discordMarkdown(s) {
+		switch t.kind {
+		case mdText, mdLiteral:
+			sb.WriteString(telegramEscape(t.text))
+		case mdLink:
+			fmt.Fprintf(&sb, "[%s](%s)", telegramMarkdown(t.text), telegramEscapeURL(t.url))
+		case mdURL:
+			sb.WriteString(telegramEscape(t.url))
+		case mdTimestamp:
+			sb.WriteString(telegramEscape(time.Unix(t.unix, 0).UTC().Format("2 January 2006 15:04 UTC")))
+		case mdBold:
+			sb.WriteByte('*')
+		case mdItalic:
+			sb.WriteByte('_')
+		case mdUnderline:
+			sb.WriteString("__")
+		case mdStrike:
+			sb.WriteByte('~')
+		case mdSpoiler:
+			sb.WriteString("||")
+		case mdCode:
+			sb.WriteByte('`')
+		case mdQuote:
+			sb.WriteByte('>')
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/template"
+)
+
+const (
+	// defaultMessageTemplate is the message posted for each new video
+	defaultMessageTemplate = "New video from **{{.ChannelTitle}}**\n**{{.Title}}**\n{{.URL}}"
+
+	// defaultLiveMessageTemplate is the message posted for live streams when the live policy is announce
+	defaultLiveMessageTemplate = "🔴 **{{.ChannelTitle}}** is live now\n{{.URL}}"
+
+	// defaultPremiereMessageTemplate is the message posted for upcoming premieres
+	defaultPremiereMessageTemplate = "📅 **{{.ChannelTitle}}** premieres {{.Scheduled}}\n{{.URL}}"
+
+	// defaultStreamMessageTemplate is the message posted when a channel with streams enabled schedules a live stream
+	defaultStreamMessageTemplate = "📅 **{{.ChannelTitle}}** has scheduled a stream for {{.ScheduledAt}}\n{{.URL}}"
+)
+
+// messageData is the data available to message templates
+type messageData struct {
+	ChannelTitle       string
+	VideoID            string
+	Title              string
+	URL                string
+	Published          string
+	PublishedTimestamp string // Discord timestamp in --timestamp-style, empty if not known
+	Scheduled          string
+	ScheduledAt        string
+	Thumbnail          string
+	Duration           string // e.g. 1:04:23, empty if not known
+	Definition         string // HD, or empty
+
+	PlaylistTitle string // only set for videos from a monitored playlist
+}
+
+// parseMessageTemplate parses and test-renders a message template, so errors can be reported at startup
+func parseMessageTemplate(name, text string) (*template.Template, error) {
+	t, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid message template %s: %w", name, err)
+	}
+
+	// a dry run catches references to fields that don't exist
+	err = t.Execute(io.Discard, messageData{})
+	if err != nil {
+		return nil, fmt.Errorf("invalid message template %s: %w", name, err)
+	}
+	return t, nil
+}
+
+// markdownEscaper backslash-escapes the characters Discord treats as markdown
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	`*`, `\*`,
+	`_`, `\_`,
+	`~`, `\~`,
+	"`", "\\`",
+	`|`, `\|`,
+	`>`, `\>`,
+)
+
+// escapeMarkdown escapes s so it shows as written in a Discord message,
+// rather than e.g. a title with ** in it breaking the bold channel title
+// around it. Titles are already HTML-unescaped when videos are fetched, so
+// it's always applied after that.
+func escapeMarkdown(s string) string {
+	return markdownEscaper.Replace(s)
+}
+
+// escaped returns d with the titles, which come from YouTube rather than
+// the template, escaped for Discord markdown
+func (d messageData) escaped() messageData {
+	d.ChannelTitle = escapeMarkdown(d.ChannelTitle)
+	d.Title = escapeMarkdown(d.Title)
+	d.PlaylistTitle = escapeMarkdown(d.PlaylistTitle)
+	return d
+}
+
+// renderMessage executes a message template
+func renderMessage(t *template.Template, d messageData) (string, error) {
+	var sb strings.Builder
+	err := t.Execute(&sb, d)
+	if err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"html"
+	"strings"
+	"testing"
+)
+
+func TestRenderMessageJSON(t *testing.T) {
+	tmpl, err := parseMessageTemplate("test", defaultMessageTemplate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name         string
+		channelTitle string
+		title        string
+		want         string
+	}{
+		{
+			name:         "quotes",
+			channelTitle: `The "Real" Pilot`,
+			title:        `"Mayday" - the call nobody wants to make`,
+			want:         "New video from **The \"Real\" Pilot**\n**\"Mayday\" - the call nobody wants to make**\nhttps://youtu.be/abcdefghijk",
+		},
+		{
+			name:         "emoji",
+			channelTitle: "Mentour Pilot ✈️",
+			title:        "🔥 Engine fire on takeoff 😱",
+			want:         "New video from **Mentour Pilot ✈️**\n**🔥 Engine fire on takeoff 😱**\nhttps://youtu.be/abcdefghijk",
+		},
+		{
+			name:         "backslashes",
+			channelTitle: `C:\Pilots\`,
+			title:        `Flaps 5 \ gear down \\ checklist`,
+			want:         "New video from **C:\\\\Pilots\\\\**\n**Flaps 5 \\\\ gear down \\\\\\\\ checklist**\nhttps://youtu.be/abcdefghijk",
+		},
+		{
+			name:         "CRLF",
+			channelTitle: "Mentour\r\nPilot",
+			title:        "Part 1\r\nPart 2",
+			want:         "New video from **Mentour\r\nPilot**\n**Part 1\r\nPart 2**\nhttps://youtu.be/abcdefghijk",
+		},
+		{
+			name:         "everything",
+			channelTitle: "\"✈️\"\\\r\n",
+			title:        "\"🔥\" \\ \r\n 中文",
+			want:         "New video from **\"✈️\"\\\\\r\n**\n**\"🔥\" \\\\ \r\n 中文**\nhttps://youtu.be/abcdefghijk",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := channel{title: channelName(tt.channelTitle)}
+			v := video{ID: "abcdefghijk", ChannelTitle: tt.channelTitle, Title: tt.title}
+			content, err := c.renderMessage(tmpl, v, v.URL())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if content != tt.want {
+				t.Errorf("renderMessage() = %q, want %q", content, tt.want)
+			}
+
+			// the payload must be valid JSON that Discord reads back as the same message
+			b, err := json.Marshal(newWebhookPayload(content, ""))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !json.Valid(b) {
+				t.Fatalf("payload %s isn't valid JSON", b)
+			}
+			var got webhookPayload
+			err = json.Unmarshal(b, &got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Content != tt.want {
+				t.Errorf("payload content = %q, want %q", got.Content, tt.want)
+			}
+			if strings.Contains(string(b), "\r") || strings.Contains(string(b), "\n") {
+				t.Errorf("payload %s has unescaped line breaks", b)
+			}
+		})
+	}
+}
+
+func TestEscapeMarkdown(t *testing.T) {
+	tests := []struct {
+		name string
+		s    string
+		want string
+	}{
+		{"plain", "Why this 737 nearly crashed", "Why this 737 nearly crashed"},
+		{"backslash", `a\b`, `a\\b`},
+		{"asterisk", "a*b", `a\*b`},
+		{"underscore", "a_b", `a\_b`},
+		{"tilde", "a~b", `a\~b`},
+		{"backtick", "a`b", "a\\`b"},
+		{"pipe", "a|b", `a\|b`},
+		{"greater than", "a>b", `a\>b`},
+		{"bold", "**bold**", `\*\*bold\*\*`},
+		{"italics", "_italics_", `\_italics\_`},
+		{"strikethrough", "~~gone~~", `\~\~gone\~\~`},
+		{"spoiler", "||spoiler||", `\|\|spoiler\|\|`},
+		{"code block", "```go```", "\\`\\`\\`go\\`\\`\\`"},
+		{"quote", "> quote", `\> quote`},
+		{"already escaped", `\*`, `\\\*`},
+		{"combination", "**A_B** ~~c|d~~ `e` > f\\", `\*\*A\_B\*\* \~\~c\|d\~\~ \` + "`e\\`" + ` \> f\\`},
+		{"unicode", "中文 *✈️* 😱", `中文 \*✈️\* 😱`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := escapeMarkdown(tt.s)
+			if got != tt.want {
+				t.Errorf("escapeMarkdown(%q) = %q, want %q", tt.s, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEscapeMarkdownAfterUnescape(t *testing.T) {
+	// the API gives some titles HTML-escaped, which must be unescaped first,
+	// or the escaped entities would show and their characters wouldn't be escaped
+	got := escapeMarkdown(html.UnescapeString("&#42;&#42;Fuel&#42;&#42; &amp; &lt;Flaps&gt; &#96;A&#95;B&#96;"))
+	want := "\\*\\*Fuel\\*\\* & <Flaps\\> \\`A\\_B\\`"
+	if got != want {
+		t.Errorf("escapeMarkdown() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// discordAPI is the base URL of Discord's bot API
+	discordAPI = "https://discord.com/api/v10"
+
+	// threadNameMaxLength is the longest name Discord allows a thread
+	threadNameMaxLength = 100
+)
+
+// threadName returns a thread name for a video's title, on one line and
+// shortened to fit
+func threadName(title string) string {
+	name := []rune(strings.Join(strings.Fields(title), " "))
+	if len(name) <= threadNameMaxLength {
+		return string(name)
+	}
+	return string(name[:threadNameMaxLength-1]) + "…"
+}
+
+// postsToForum returns whether the channel is routed to a forum channel,
+// where each video is posted as a new forum post
+func (c channel) postsToForum() bool {
+	return len(c.Webhook) == 0 && c.routeTag != "" && c.routeForum
+}
+
+// forumPostName returns the name of a video's post in a forum channel, which
+// Discord requires, falling back to the video ID if its title isn't known
+func forumPostName(v video) string {
+	if name := threadName(v.Title); name != "" {
+		return name
+	}
+	return v.ID
+}
+
+// startThread starts a thread for discussion under a posted message, named
+// after its video, returning the thread's ID. Failing to is only logged, as
+// the video has been posted either way.
+func (b *bot) startThread(ctx context.Context, m postedMessage, v video, webhook string) string {
+	log := log.With().
+		Str("video_id", v.ID).
+		Str("message_id", m.ID).
+		Str("discord_channel_id", m.ChannelID).
+		Logger()
+
+	name := threadName(v.Title)
+	if name == "" || m.ChannelID == "" {
+		log.Warn().Msg("video title or Discord channel not known, not starting a thread")
+		return ""
+	}
+	threadId, err := createThread(ctx, b.settings.botToken, m.ChannelID, m.ID, name)
+	if err != nil {
+		log.Error().AnErr("err", err).Msg("error starting thread for posted message")
+		return ""
+	}
+	log.Info().Str("thread_id", threadId).Msg("started thread for posted message")
+
+	err = b.db.SetMessageThread(v.ID, webhook, threadId)
+	if err != nil {
+		log.Warn().AnErr("err", err).Msg("error recording thread in db")
+	}
+	return threadId
+}
+
+// createThread starts a thread named name from a message, with a bot token
+func createThread(ctx context.Context, token, channelId, messageId, name string) (string, error) {
+	data, err := json.Marshal(struct {
+		Name string `json:"name"`
+	}{name})
+	if err != nil {
+		return "", err
+	}
+	u := fmt.Sprintf("%s/channels/%s/messages/%s/threads", discordAPI, channelId, messageId)
+	req, err := http.NewRequestWithContext(context.WithoutCancel(ctx), http.MethodPost, u, bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bot "+token)
+	client := http.Client{
+		Timeout: 30 * time.Second,
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		return "", discordError(res)
+	}
+
+	var thread struct {
+		ID string `json:"id"`
+	}
+	err = json.NewDecoder(res.Body).Decode(&thread)
+	if err != nil {
+		return "", fmt.Errorf("error reading thread: %w", err)
+	}
+	if thread.ID == "" {
+		return "", errors.New("thread has no id")
+	}
+	return thread.ID, nil
+}
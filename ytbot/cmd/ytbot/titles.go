@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"text/template"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// how long, and how many times, a posted message is kept up to date with its
+// video's title for --track-title-changes
+const (
+	titleTrackingPeriod = 24 * time.Hour
+	maxTitleEdits       = 3
+)
+
+// trackedMessage is a posted message being kept up to date with its video's title
+type trackedMessage struct {
+	videoId, webhook, messageId, title, content string
+	edits                                       int
+	render                                      *messageRender // nil for messages posted before renders were kept
+}
+
+// messageRender is what a message was rendered from, kept with it in
+// tracked_messages so it can be rendered again when its video's title
+// changes, shortening the new title to fit and rebuilding the embed as
+// posting the video with it would have
+type messageRender struct {
+	Template      string      `json:"template"`             // the message template's source
+	Data          messageData `json:"data"`                 // unescaped, with the video's full title
+	Prefix        string      `json:"prefix,omitempty"`     // the channel's prefix
+	Annotation    string      `json:"annotation,omitempty"` // e.g. reuploadAnnotation
+	MentionRoleId string      `json:"mention_role_id,omitempty"`
+	Embed         bool        `json:"embed,omitempty"` // posted with an embed of the video
+	EmbedColor    int         `json:"embed_color,omitempty"`
+	Flags         int         `json:"flags,omitempty"`
+}
+
+// messageRender returns what payload, the message posted for one of the
+// channel's videos, was rendered from with t
+func (c channel) messageRender(t *template.Template, v video, url, annotation string, payload webhookPayload) *messageRender {
+	r := &messageRender{
+		// the parsed template, printed back, renders the same as its source
+		Template:      t.Root.String(),
+		Data:          c.messageData(v, url),
+		Prefix:        c.Prefix,
+		Annotation:    annotation,
+		MentionRoleId: c.MentionRoleId,
+		Flags:         payload.Flags,
+	}
+	if len(payload.Embeds) > 0 {
+		r.Embed, r.EmbedColor = true, payload.Embeds[0].Color
+	}
+	return r
+}
+
+// payload renders the message again with a new title
+func (r messageRender) payload(title string) (webhookPayload, error) {
+	t, err := parseMessageTemplate("of tracked message", r.Template)
+	if err != nil {
+		return webhookPayload{}, err
+	}
+	d := r.Data
+	d.Title = title
+	content, err := renderFitting(t, d, r.Prefix, messageLimit(r.MentionRoleId))
+	if err != nil {
+		return webhookPayload{}, err
+	}
+	if r.Annotation != "" {
+		content += " " + r.Annotation
+	}
+	p := newWebhookPayload(content, r.MentionRoleId)
+	if r.Embed {
+		e := videoEmbed(d)
+		e.Color = r.EmbedColor
+		p.Embeds = []embed{e}
+	}
+	p.Flags = r.Flags
+	return p, nil
+}
+
+// marshalRender returns a render as stored in the db, NULL for none
+func marshalRender(r *messageRender) (string, error) {
+	if r == nil {
+		return "", nil
+	}
+	data, err := json.Marshal(r)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// updateTitles edits the messages posted for recent videos whose titles have
+// changed since, e.g. while the creator A/B tests them
+func (b *bot) updateTitles(ctx context.Context) error {
+	messages, err := b.db.MessagesToRetitle(titleTrackingPeriod, maxTitleEdits, peertubeIdPrefix)
+	if err != nil {
+		return fmt.Errorf("error querying db: %w", err)
+	}
+	var tracked []trackedMessage
+	for _, sm := range messages {
+		m := trackedMessage{videoId: sm.VideoID, webhook: sm.Webhook, messageId: sm.MessageID, title: sm.Title, content: sm.Content, edits: sm.Edits}
+		if sm.Render != "" {
+			err = json.Unmarshal([]byte(sm.Render), &m.render)
+			if err != nil {
+				return fmt.Errorf("invalid render of tracked message for video %s: %w", m.videoId, err)
+			}
+		}
+		tracked = append(tracked, m)
+	}
+	if len(tracked) == 0 {
+		return nil
+	}
+
+	// fetch the current titles in as few calls as possible
+	ids := make([]string, 0, len(tracked))
+	for _, m := range tracked {
+		ids = append(ids, m.videoId)
+	}
+	videos, err := videosById(ctx, b.service, ids)
+	if err != nil {
+		return fmt.Errorf("error getting videos: %w", err)
+	}
+	titles := make(map[string]string, len(videos))
+	for _, v := range videos {
+		titles[v.ID] = v.Title
+	}
+
+	for _, m := range tracked {
+		title, ok := titles[m.videoId]
+		if !ok || title == m.title {
+			continue
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+		log := log.With().
+			Str("video_id", m.videoId).
+			Str("old_title", m.title).
+			Str("new_title", title).
+			Int("edits", m.edits+1).
+			Logger()
+
+		content := m.content
+		if m.render == nil {
+			log.Info().Msg("video title changed, but its message was posted before messages could be rendered again, leaving it")
+		} else {
+			p, err := m.render.payload(title)
+			if err != nil {
+				log.Error().AnErr("err", err).Msg("error rendering message for changed title")
+				continue
+			}
+			m.render.Data.Title = title
+
+			// templates without the title, posted without an embed, leave nothing to edit
+			if p.Content != m.content || len(p.Embeds) > 0 {
+				err = b.editMessage(ctx, m.webhook, m.messageId, p)
+				if err != nil {
+					log.Error().AnErr("err", err).Msg("error editing message for changed title")
+					continue
+				}
+				content = p.Content
+				log.Info().Msg("video title changed, edited message")
+			}
+		}
+
+		render, err := marshalRender(m.render)
+		if err != nil {
+			return err
+		}
+		err = b.db.RetitleMessage(m.videoId, m.webhook, title, content, render)
+		if err != nil {
+			return fmt.Errorf("error updating tracked message in db: %w", err)
+		}
+
+		// pace edits like posts
+		if !sleepContext(ctx, b.settings.postDelay) {
+			return nil
+		}
+	}
+	return nil
+}
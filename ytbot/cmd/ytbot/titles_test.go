@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestMessageRenderPayload(t *testing.T) {
+	tmpl, err := parseMessageTemplate("test", defaultMessageTemplate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	long := strings.Repeat("Why this 737 *nearly* crashed | ", 80)
+	tests := []struct {
+		name       string
+		c          channel
+		annotation string
+		oldTitle   string
+		newTitle   string
+	}{
+		{
+			name:     "text",
+			c:        channel{postStyle: postStyleText},
+			oldTitle: "Why this 737 nearly crashed",
+			newTitle: "Why this 737 *nearly* crashed",
+		},
+		{
+			name:     "embed",
+			c:        channel{postStyle: postStyleEmbed, embedColor: 0xff0000, suppressEmbeds: true},
+			oldTitle: "Why this 737 nearly crashed",
+			newTitle: "Why did this 737 nearly crash?",
+		},
+		{
+			name:       "prefix, role and annotation",
+			c:          channel{Prefix: "✈️", MentionRoleId: "123456789", postStyle: postStyleText},
+			annotation: reuploadAnnotation,
+			oldTitle:   "Why this 737 nearly crashed",
+			newTitle:   "Why did this 737 nearly crash?",
+		},
+		{
+			name:     "shortened new title",
+			c:        channel{postStyle: postStyleEmbed},
+			oldTitle: "Why this 737 nearly crashed",
+			newTitle: long,
+		},
+		{
+			name:     "shortened old title",
+			c:        channel{MentionRoleId: "123456789", postStyle: postStyleText},
+			oldTitle: long,
+			newTitle: "Why this 737 nearly crashed",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := video{
+				ID:           "abcdefghijk",
+				ChannelTitle: "Mentour Pilot",
+				Title:        tt.oldTitle,
+				PublishedAt:  time.Date(2024, 3, 2, 8, 0, 0, 0, time.UTC).Format(time.RFC3339),
+				Thumbnail:    "https://i.ytimg.com/vi/abcdefghijk/hqdefault.jpg",
+				Duration:     12*time.Minute + 3*time.Second,
+			}
+			url := v.URL()
+			post := func(v video) webhookPayload {
+				content, err := tt.c.renderMessage(tmpl, v, url)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if tt.annotation != "" {
+					content += " " + tt.annotation
+				}
+				return tt.c.payload(content, v, url)
+			}
+
+			// the render is stored as JSON
+			data, err := json.Marshal(tt.c.messageRender(tmpl, v, url, tt.annotation, post(v)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var r messageRender
+			err = json.Unmarshal(data, &r)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := r.payload(tt.newTitle)
+			if err != nil {
+				t.Fatal(err)
+			}
+			v.Title = tt.newTitle
+			want := post(v)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("payload(%q) = %+v, want %+v", tt.newTitle, got, want)
+			}
+			if n := utf8.RuneCountInString(got.Content); n > discordMaxContent {
+				t.Errorf("content is %d characters, over Discord's %d", n, discordMaxContent)
+			}
+		})
+	}
+}
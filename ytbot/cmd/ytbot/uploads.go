@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"google.golang.org/api/youtube/v3"
+)
+
+// uploadsPlaylist returns a channel's uploads playlist ID from channel_meta,
+// looking it up on YouTube if it isn't there yet
+func (b *bot) uploadsPlaylist(ctx context.Context, cId channelId) (string, error) {
+	playlistId, err := b.db.UploadsPlaylist(string(cId))
+	if err != nil {
+		return "", fmt.Errorf("error querying db: %w", err)
+	}
+	if playlistId != "" {
+		return playlistId, nil
+	}
+
+	playlistId, err = uploadsPlaylistId(ctx, b.service, cId)
+	if err != nil {
+		return "", fmt.Errorf("error getting uploads playlist: %w", err)
+	}
+	log.Debug().Str("channel_id", string(cId)).Str("playlist_id", playlistId).Msg("found channel's uploads playlist")
+	err = b.db.SetUploadsPlaylist(string(cId), playlistId)
+	if err != nil {
+		return "", fmt.Errorf("error caching uploads playlist in db: %w", err)
+	}
+	return playlistId, nil
+}
+
+// recentVideos returns up to limit of a channel's videos published after t,
+// from its feed with --source rss, its uploads playlist or, with --use-search,
+// the search API. For a playlist it returns the videos not yet posted from it
+// instead, for a search the videos matching its query, and for a PeerTube
+// channel the videos in its feed.
+//
+// For uploads playlists and playlists it also returns the etag of the
+// playlist's items, or errNotModified if they haven't changed since the etag
+// stored by saveListEtag.
+func (b *bot) recentVideos(ctx context.Context, c channel, t time.Time, limit int) ([]video, string, error) {
+	cId := c.ID
+	if c.search != nil {
+		videos, err := b.queryVideos(ctx, c.search, t, limit)
+		return videos, "", err
+	}
+	if c.isPeerTube() {
+		videos, err := b.peertubeVideos(ctx, c, t, limit)
+		return videos, "", err
+	}
+	if b.settings.source == sourceRSS {
+		videos, err := b.feedVideos(ctx, cId, t, limit)
+		return videos, "", err
+	}
+	etag, err := b.listEtag(cId)
+	if err != nil {
+		return nil, "", err
+	}
+	if isPlaylistId(string(cId)) {
+		return b.playlistVideos(ctx, cId, limit, etag)
+	}
+	if b.settings.useSearch {
+		videos, err := b.searchVideos(ctx, cId, t, limit)
+		return videos, "", err
+	}
+
+	playlistId, err := b.uploadsPlaylist(ctx, cId)
+	if err != nil {
+		return nil, "", err
+	}
+	ids, etag, err := recentPlaylistVideoIds(ctx, b.service, playlistId, t, limit, etag)
+	if errors.Is(err, errNotModified) {
+		return nil, etag, err
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("error listing uploads playlist: %w", err)
+	}
+	if len(ids) == 0 {
+		return nil, etag, nil
+	}
+
+	// playlist items don't say whether a video is live or upcoming, so fetch the videos
+	videos, err := videosById(ctx, b.service, ids)
+	if err != nil {
+		return nil, "", fmt.Errorf("error getting videos: %w", err)
+	}
+	return videos, etag, nil
+}
+
+// playlistVideos returns up to limit of the videos in a playlist that haven't
+// been posted yet, however long ago they were published, as old videos are
+// often added to playlists. It also returns the etag of the playlist's items,
+// or errNotModified if they still match etag.
+func (b *bot) playlistVideos(ctx context.Context, playlistId channelId, limit int, etag string) ([]video, string, error) {
+	ids, etag, err := playlistVideoIds(ctx, b.service, string(playlistId), etag)
+	if errors.Is(err, errNotModified) {
+		return nil, etag, err
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("error listing playlist: %w", err)
+	}
+
+	var newIds []string
+	for _, id := range ids {
+		if len(newIds) == limit {
+			break
+		}
+		isNew, err := b.newToPlaylist(playlistId, id)
+		if err != nil {
+			return nil, "", err
+		}
+		if isNew {
+			newIds = append(newIds, id)
+		}
+	}
+	if len(newIds) == 0 {
+		return nil, etag, nil
+	}
+
+	videos, err := videosById(ctx, b.service, newIds)
+	if err != nil {
+		return nil, "", fmt.Errorf("error getting videos: %w", err)
+	}
+	return videos, etag, nil
+}
+
+// newToPlaylist returns whether a video found in a playlist still needs
+// looking at: it hasn't been dealt with on an earlier check, or is being reposted
+func (b *bot) newToPlaylist(playlistId channelId, videoId string) (bool, error) {
+	if videoId == b.repost {
+		return true, nil
+	}
+	seen, err := b.db.PlaylistItemSeen(string(playlistId), videoId)
+	if err != nil {
+		return false, fmt.Errorf("error querying db: %w", err)
+	}
+	return !seen, nil
+}
+
+// searchVideos returns up to limit of the most recent videos a channel
+// published after t, using the search API
+func (b *bot) searchVideos(ctx context.Context, cId channelId, t time.Time, limit int) ([]video, error) {
+	call := b.service.Search.List([]string{"snippet"}).ChannelId(string(cId)).ChannelType("any")
+	return searchResults(ctx, call, t, limit)
+}
+
+// searchResults pages through a search for up to limit of the most recent
+// videos published after t
+func searchResults(ctx context.Context, call *youtube.SearchListCall, t time.Time, limit int) ([]video, error) {
+	call = call.Order("date").Type("video").PublishedAfter(t.UTC().Format(time.RFC3339))
+
+	var (
+		videos    []video
+		pageToken string
+	)
+	for len(videos) < limit {
+		response, err := call.MaxResults(int64(min(limit-len(videos), maxIdsPerCall))).PageToken(pageToken).Context(ctx).Do()
+		if err != nil {
+			return nil, fmt.Errorf("error searching for videos: %w", err)
+		}
+
+		for _, item := range response.Items {
+			// skip anything that isn't a video
+			if item.Id.Kind != "youtube#video" {
+				log.Debug().Str("kind", item.Id.Kind).Str("item_id", item.Id.ChannelId+item.Id.PlaylistId).Msg("skipping as item is not video")
+				continue
+			}
+			videos = append(videos, videoFromSearchResult(item))
+		}
+		if response.NextPageToken == "" || len(response.Items) == 0 {
+			break
+		}
+		pageToken = response.NextPageToken
+	}
+	return videos, nil
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"slices"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"google.golang.org/api/youtube/v3"
+)
+
+// values of a video's snippet.liveBroadcastContent
+const (
+	broadcastNone     = "none"
+	broadcastLive     = "live"
+	broadcastUpcoming = "upcoming"
+)
+
+// how videos are linked to in posts, for --url-style
+const (
+	urlStyleShort = "short" // https://youtu.be/<id>
+	urlStyleLong  = "long"  // https://www.youtube.com/watch?v=<id>
+	urlStyleAuto  = "auto"  // /shorts/ or /live/ where they apply, otherwise short
+)
+
+// video is a video found on a monitored channel
+type video struct {
+	ID                   string
+	ChannelID            channelId
+	ChannelTitle         string
+	Title                string
+	PublishedAt          string
+	LiveBroadcastContent string
+	ScheduledStart       time.Time // only known for upcoming premieres/streams
+	Thumbnail            string    // URL of the video's thumbnail, if known
+	Short                bool      // linked to as a short in the channel's feed
+	Link                 string    // the video's URL, for videos that aren't on YouTube
+
+	// from the video's status, if it was fetched along with the video
+	PrivacyStatus string
+	UploadStatus  string
+
+	// the video was fetched by videosById, so its duration, scheduled start,
+	// age restriction, view count and status are known
+	HasDetails    bool
+	Duration      time.Duration // 0 for live streams and premieres that haven't finished
+	Definition    string        // definitionHD or definitionSD
+	AgeRestricted bool
+	ViewCount     int64
+}
+
+// videoFromSearchResult converts a search result into a video, unescaping the
+// HTML entities the search API puts in titles
+func videoFromSearchResult(item *youtube.SearchResult) video {
+	return video{
+		ID:                   item.Id.VideoId,
+		ChannelID:            channelId(item.Snippet.ChannelId),
+		ChannelTitle:         html.UnescapeString(item.Snippet.ChannelTitle),
+		Title:                html.UnescapeString(item.Snippet.Title),
+		PublishedAt:          item.Snippet.PublishedAt,
+		LiveBroadcastContent: item.Snippet.LiveBroadcastContent,
+		Thumbnail:            thumbnailURL(item.Snippet.Thumbnails),
+	}
+}
+
+// videoFromVideo converts a video resource into a video
+func videoFromVideo(item *youtube.Video) video {
+	v := video{
+		ID:                   item.Id,
+		ChannelID:            channelId(item.Snippet.ChannelId),
+		ChannelTitle:         item.Snippet.ChannelTitle,
+		Title:                item.Snippet.Title,
+		PublishedAt:          item.Snippet.PublishedAt,
+		LiveBroadcastContent: item.Snippet.LiveBroadcastContent,
+		Thumbnail:            thumbnailURL(item.Snippet.Thumbnails),
+	}
+	if item.Status != nil {
+		v.PrivacyStatus, v.UploadStatus = item.Status.PrivacyStatus, item.Status.UploadStatus
+	}
+	if item.Statistics != nil {
+		v.ViewCount = int64(item.Statistics.ViewCount)
+	}
+	if item.ContentDetails != nil && item.ContentDetails.ContentRating != nil {
+		v.AgeRestricted = item.ContentDetails.ContentRating.YtRating == "ytAgeRestricted"
+	}
+	if item.ContentDetails != nil && item.ContentDetails.Duration != "" {
+		d, err := parseISODuration(item.ContentDetails.Duration)
+		if err != nil {
+			log.Warn().AnErr("err", err).Str("video_id", item.Id).Msg("error parsing video duration")
+		}
+		v.Duration = d
+	}
+	if item.ContentDetails != nil {
+		v.Definition = item.ContentDetails.Definition
+	}
+	if item.LiveStreamingDetails != nil && v.LiveBroadcastContent == broadcastUpcoming {
+		t, err := time.Parse(time.RFC3339, item.LiveStreamingDetails.ScheduledStartTime)
+		if err == nil {
+			v.ScheduledStart = t
+		}
+	}
+	v.HasDetails = true
+	return v
+}
+
+// withDetails returns videos with the details of those that need them, but
+// weren't fetched with them, filled in from as few API calls as possible.
+// Videos that have been deleted or made private are given the "deleted"
+// privacy status. need reports whether a video needs its details.
+func withDetails(ctx context.Context, service *youtube.Service, videos []video, need func(video) bool) ([]video, error) {
+	var ids []string
+	for _, v := range videos {
+		if !v.HasDetails && need(v) {
+			ids = append(ids, v.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return videos, nil
+	}
+	fetched, err := videosById(ctx, service, ids)
+	if err != nil {
+		return videos, err
+	}
+	byId := make(map[string]video, len(fetched))
+	for _, v := range fetched {
+		byId[v.ID] = v
+	}
+
+	detailed := make([]video, len(videos))
+	for i, v := range videos {
+		switch f, ok := byId[v.ID]; {
+		case ok:
+			f.Short = v.Short
+			detailed[i] = f
+		case slices.Contains(ids, v.ID):
+			v.PrivacyStatus, v.UploadStatus = "deleted", ""
+			v.HasDetails = true
+			detailed[i] = v
+		default:
+			detailed[i] = v
+		}
+	}
+	return detailed, nil
+}
+
+// notPostableReason returns why a video shouldn't be posted given its status,
+// or an empty string if it can be. Live streams and premieres are only
+// checked for being public, as they aren't processed until they've finished.
+func notPostableReason(v video, privacyStatus, uploadStatus string) string {
+	switch {
+	case privacyStatus != "public":
+		return "video is " + privacyStatus
+	case v.LiveBroadcastContent == broadcastNone && uploadStatus != "" && uploadStatus != "processed":
+		return "video upload is " + uploadStatus
+	}
+	return ""
+}
+
+// thumbnailURL returns the URL of the largest of the usual thumbnail sizes
+func thumbnailURL(t *youtube.ThumbnailDetails) string {
+	if t == nil {
+		return ""
+	}
+	for _, th := range []*youtube.Thumbnail{t.High, t.Medium, t.Default} {
+		if th != nil {
+			return th.Url
+		}
+	}
+	return ""
+}
+
+// URL returns the link posted for the video
+func (v video) URL() string {
+	if v.Link != "" {
+		return v.Link
+	}
+	return "https://youtu.be/" + v.ID
+}
+
+// isLive returns whether the video is a live stream or premiere, upcoming or
+// live now
+func (v video) isLive() bool {
+	return v.LiveBroadcastContent == broadcastLive || v.LiveBroadcastContent == broadcastUpcoming
+}
+
+// styledURL returns the link posted for the video with --url-style. auto
+// links to shorts and live streams by their own paths, which Discord shows
+// better, and to other videos like short does.
+func (v video) styledURL(style string, shortsMaxDuration time.Duration) string {
+	if v.Link != "" {
+		return v.Link
+	}
+	switch style {
+	case urlStyleLong:
+		return "https://www.youtube.com/watch?v=" + v.ID
+	case urlStyleAuto:
+		switch {
+		case v.LiveBroadcastContent == broadcastLive:
+			return "https://www.youtube.com/live/" + v.ID
+		case v.Short, v.Duration > 0 && v.Duration <= shortsMaxDuration:
+			return "https://www.youtube.com/shorts/" + v.ID
+		}
+	}
+	return v.URL()
+}
+
+// validateURLStyle checks a --url-style is one of the known styles
+func validateURLStyle(s string) error {
+	switch s {
+	case urlStyleShort, urlStyleLong, urlStyleAuto:
+		return nil
+	}
+	return fmt.Errorf("unknown URL style %q, must be %s, %s or %s", s, urlStyleShort, urlStyleLong, urlStyleAuto)
+}
+
+// messageData returns the data made available to message templates
+func (v video) messageData() messageData {
+	return messageData{
+		ChannelTitle: v.ChannelTitle,
+		VideoID:      v.ID,
+		Title:        v.Title,
+		URL:          v.URL(),
+		Published:    v.PublishedAt,
+		Scheduled:    discordTimestamp(v.ScheduledStart, "R"),
+		ScheduledAt:  discordTimestamp(v.ScheduledStart, "F"),
+		Thumbnail:    v.Thumbnail,
+		Duration:     formatDuration(v.Duration),
+		Definition:   definitionLabel(v.Definition),
+	}
+}
+
+// a video's definition, as the API gives it
+const (
+	definitionHD = "hd"
+	definitionSD = "sd"
+)
+
+// definitionLabel returns how a video's definition is shown, HD for high
+// definition, or nothing otherwise. The API doesn't say whether a video is 4K.
+func definitionLabel(definition string) string {
+	if definition == definitionHD {
+		return "HD"
+	}
+	return ""
+}
+
+// formatDuration formats a video's duration like YouTube does, e.g. 4:05 or
+// 1:04:23, or returns an empty string if it isn't known
+func formatDuration(d time.Duration) string {
+	if d <= 0 {
+		return ""
+	}
+	s := int(d.Round(time.Second) / time.Second)
+	if s >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", s/3600, s/60%60, s%60)
+	}
+	return fmt.Sprintf("%d:%02d", s/60, s%60)
+}
+
+// Discord timestamp styles for --timestamp-style
+const (
+	timestampStyleRelative      = "R" // e.g. 3 hours ago
+	timestampStyleLongDateTime  = "F" // e.g. Tuesday, 23 January 2024 08:53
+	timestampStyleShortDateTime = "f" // e.g. 23 January 2024 08:53
+)
+
+// validateTimestampStyle checks a --timestamp-style is one of the known styles
+func validateTimestampStyle(s string) error {
+	switch s {
+	case timestampStyleRelative, timestampStyleLongDateTime, timestampStyleShortDateTime:
+		return nil
+	}
+	return fmt.Errorf("unknown timestamp style %q, must be %s, %s or %s", s, timestampStyleRelative, timestampStyleLongDateTime, timestampStyleShortDateTime)
+}
+
+// publishedTime returns when the video was published, or the zero time if
+// that isn't known or can't be parsed
+func (v video) publishedTime() time.Time {
+	t, err := time.Parse(time.RFC3339, v.PublishedAt)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// discordTimestamp formats t as a Discord timestamp token, which each viewer
+// sees in their own timezone, or returns an empty string for the zero time
+func discordTimestamp(t time.Time, style string) string {
+	if t.IsZero() {
+		return ""
+	}
+	return fmt.Sprintf("<t:%d:%s>", t.Unix(), style)
+}
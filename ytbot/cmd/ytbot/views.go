@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"pw-ytbot/store"
+)
+
+// withViewCandidates returns videos along with the videos found on earlier
+// checks of the channel that were waiting to reach its min_views, fetched in
+// as few API calls as possible. Candidates published longer ago than
+// --min-views-max-age are given up on and recorded as skipped instead.
+func (b *bot) withViewCandidates(ctx context.Context, c channel, videos []video) ([]video, error) {
+	candidates, err := b.db.ViewCandidates(string(c.ID))
+	if err != nil {
+		return nil, fmt.Errorf("error querying db: %w", err)
+	}
+
+	found := make(map[string]bool, len(videos))
+	for _, v := range videos {
+		found[v.ID] = true
+	}
+	var ids []string
+	for _, vc := range candidates {
+		if found[vc.VideoID] {
+			continue
+		}
+		published, err := time.Parse(time.RFC3339, vc.PublishedAt)
+		if err == nil && time.Since(published) > b.settings.minViewsMaxAge {
+			log.Info().Str("channel_id", string(c.ID)).Str("video_id", vc.VideoID).Int64("min_views", c.MinViews).Msg("video didn't reach min_views in time, giving up")
+			err = giveUpViewCandidate(b.db, c.ID, vc.VideoID)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		ids = append(ids, vc.VideoID)
+	}
+	if len(ids) == 0 {
+		return videos, nil
+	}
+
+	fetched, err := videosById(ctx, b.service, ids)
+	if err != nil {
+		return nil, fmt.Errorf("error getting videos waiting for views: %w", err)
+	}
+	for _, v := range fetched {
+		found[v.ID] = true
+	}
+	// forget candidates that have been deleted or made private
+	for _, id := range ids {
+		if !found[id] {
+			err = b.db.DeleteViewCandidate(string(c.ID), id)
+			if err != nil {
+				return nil, fmt.Errorf("error deleting video waiting for views from db: %w", err)
+			}
+		}
+	}
+	return append(videos, fetched...), nil
+}
+
+// recordViewCandidate records a video that hasn't reached its channel's
+// min_views yet, so it is looked at again on the channel's next check
+func recordViewCandidate(db store.Queries, cId channelId, v video) error {
+	return db.SetViewCandidate(string(cId), v.ID, v.PublishedAt, v.ViewCount)
+}
+
+// giveUpViewCandidate records a video that didn't reach its channel's
+// min_views in time as skipped, so it is never looked at again
+func giveUpViewCandidate(db store.Queries, cId channelId, videoId string) error {
+	err := recordVideo(db, videoId, postTypeSkipped)
+	if err != nil {
+		return fmt.Errorf("error inserting video into db: %w", err)
+	}
+	err = db.DeleteViewCandidate(string(cId), videoId)
+	if err != nil {
+		return fmt.Errorf("error deleting video waiting for views from db: %w", err)
+	}
+	return nil
+}
+
+// forgetViewCandidates removes a channel's videos waiting for views that have
+// since been posted or skipped
+func forgetViewCandidates(db store.Queries, cId channelId) error {
+	return db.ForgetPostedViewCandidates(string(cId))
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"pw-ytbot/store"
+)
+
+const (
+	// websubHub is the hub YouTube publishes channel feed updates to
+	websubHub = "https://pubsubhubbub.appspot.com/subscribe"
+
+	// websubTopic is the topic for a channel's feed, followed by its ID
+	websubTopic = "https://www.youtube.com/xml/feeds/videos.xml?channel_id="
+
+	// websubLease is the subscription lease asked for, which the hub may shorten
+	websubLease = 5 * 24 * time.Hour
+
+	// subscriptions are renewed this long before they expire, or if the hub
+	// hasn't verified them this long after they were requested
+	websubRenewBefore = 24 * time.Hour
+	websubVerifyWait  = time.Hour
+)
+
+// webSub receives WebSub notifications of new videos, passing the channels
+// they are for to pushed
+type webSub struct {
+	b        *bot
+	callback string
+	secret   string
+	pushed   chan<- channelId
+}
+
+// serveWebSub serves the --websub-listen callback until ctx is cancelled, and
+// keeps a subscription to each enabled channel's feed
+func (b *bot) serveWebSub(ctx context.Context, addr, callback, secret string, pushed chan<- channelId) error {
+	_, err := url.ParseRequestURI(callback)
+	if err != nil {
+		return fmt.Errorf("invalid --websub-callback: %w", err)
+	}
+	w := &webSub{b: b, callback: callback, secret: secret, pushed: pushed}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{Handler: w, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+	go func() {
+		err := srv.Serve(ln)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Error().AnErr("err", err).Msg("error serving websub callback")
+		}
+	}()
+	log.Info().Str("addr", ln.Addr().String()).Str("callback", callback).Msg("serving websub callback")
+
+	go w.renew(ctx)
+	return nil
+}
+
+func (w *webSub) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.verify(rw, r)
+	case http.MethodPost:
+		w.notify(rw, r)
+	default:
+		rw.Header().Set("Allow", "GET, POST")
+		http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// verify answers the hub's check that we asked for a subscription change
+func (w *webSub) verify(rw http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	mode, topic := q.Get("hub.mode"), q.Get("hub.topic")
+	cId := channelId(strings.TrimPrefix(topic, websubTopic))
+	log := log.With().Str("mode", mode).Str("channel_id", string(cId)).Logger()
+	if !strings.HasPrefix(topic, websubTopic) {
+		http.Error(rw, "unknown topic", http.StatusNotFound)
+		return
+	}
+
+	switch mode {
+	case "subscribe":
+		if !w.b.isMonitored(cId) {
+			log.Info().Msg("refusing websub subscription for channel that isn't configured")
+			http.Error(rw, "channel not configured", http.StatusNotFound)
+			return
+		}
+		lease, err := strconv.Atoi(q.Get("hub.lease_seconds"))
+		if err != nil {
+			http.Error(rw, "invalid lease", http.StatusBadRequest)
+			return
+		}
+		expires := time.Now().Add(time.Duration(lease) * time.Second)
+		// the hub may verify before we've recorded asking it to
+		err = w.b.db.WebSubVerified(string(cId), expires)
+		if err != nil {
+			log.Error().AnErr("err", err).Msg("error updating websub subscription in db")
+			http.Error(rw, "internal error", http.StatusInternalServerError)
+			return
+		}
+		log.Debug().Time("expires_at", expires).Msg("websub subscription verified")
+
+	case "unsubscribe":
+		if w.b.isMonitored(cId) {
+			http.Error(rw, "channel still configured", http.StatusNotFound)
+			return
+		}
+
+	case "denied":
+		log.Warn().Str("reason", q.Get("hub.reason")).Msg("websub subscription denied")
+		return
+
+	default:
+		http.Error(rw, "unknown mode", http.StatusBadRequest)
+		return
+	}
+	io.WriteString(rw, q.Get("hub.challenge"))
+}
+
+// notify handles a notification that a channel's feed has changed
+func (w *webSub) notify(rw http.ResponseWriter, r *http.Request) {
+	// the hub only needs to know we got it, whatever we make of it
+	defer rw.WriteHeader(http.StatusNoContent)
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		log.Error().AnErr("err", err).Msg("error reading websub notification")
+		return
+	}
+	if w.secret != "" && !validHubSignature(w.secret, r.Header.Get("X-Hub-Signature"), body) {
+		log.Warn().Str("remote_addr", r.RemoteAddr).Msg("ignoring websub notification with invalid signature")
+		return
+	}
+
+	var feed atomFeed
+	err = xml.Unmarshal(body, &feed)
+	if err != nil {
+		log.Error().AnErr("err", err).Msg("error parsing websub notification")
+		return
+	}
+
+	// updates to old videos are notified too, so only check channels we'd check anyway
+	for _, e := range feed.Entries {
+		log := log.With().Str("channel_id", e.ChannelID).Str("video_id", e.VideoID).Str("title", e.Title).Logger()
+		cId := channelId(e.ChannelID)
+		if !w.enabled(cId) {
+			log.Debug().Msg("ignoring websub notification for channel that isn't checked")
+			continue
+		}
+		select {
+		case w.pushed <- cId:
+			log.Info().Msg("websub notification, checking channel")
+		default:
+			log.Warn().Msg("too many websub notifications waiting, leaving channel for the next cycle")
+		}
+	}
+}
+
+// enabled returns whether a channel is configured and enabled
+func (w *webSub) enabled(cId channelId) bool {
+	for _, c := range w.b.currentChannels() {
+		if c.ID == cId {
+			return c.enabled()
+		}
+	}
+	return false
+}
+
+// validHubSignature checks an X-Hub-Signature header is the HMAC of body with secret
+func validHubSignature(secret, signature string, body []byte) bool {
+	sum, ok := strings.CutPrefix(signature, "sha1=")
+	if !ok {
+		return false
+	}
+	got, err := hex.DecodeString(sum)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha1.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(got, mac.Sum(nil))
+}
+
+// renew subscribes to channels straight away and then every hour, until ctx is cancelled
+func (w *webSub) renew(ctx context.Context) {
+	for {
+		w.subscribeDue(ctx)
+		if !sleepContext(ctx, time.Hour) {
+			return
+		}
+	}
+}
+
+// subscribeDue subscribes to each enabled channel that has no subscription,
+// or one that is about to expire or was never verified
+func (w *webSub) subscribeDue(ctx context.Context) {
+	now := time.Now()
+	for _, c := range w.b.currentChannels() {
+		if ctx.Err() != nil {
+			return
+		}
+		// the hub only publishes YouTube channel feeds, playlists, searches and PeerTube channels are still polled
+		if !c.enabled() || c.isPlaylist() || c.search != nil || c.isPeerTube() {
+			continue
+		}
+		log := log.With().Str("channel_name", string(c.Name)).Str("channel_id", string(c.ID)).Logger()
+
+		sub, ok, err := w.b.db.WebSubSubscription(string(c.ID))
+		if err != nil {
+			log.Error().AnErr("err", err).Msg("error querying db")
+			return
+		}
+		if ok && !websubDue(now, sub) {
+			continue
+		}
+
+		err = websubSubscribe(ctx, w.callback, websubTopic+string(c.ID), w.secret)
+		if err != nil {
+			log.Error().AnErr("err", err).Msg("error subscribing to channel with websub hub")
+			continue
+		}
+		err = w.b.db.WebSubRequested(string(c.ID))
+		if err != nil {
+			log.Error().AnErr("err", err).Msg("error updating websub subscription in db")
+			continue
+		}
+		log.Debug().Msg("requested websub subscription")
+	}
+}
+
+// websubDue returns whether a subscription needs renewing: it expires soon, or
+// the hub hasn't verified it a while after it was requested
+func websubDue(now time.Time, sub store.WebSubSubscription) bool {
+	if !sub.ExpiresAt.IsZero() {
+		return now.Add(websubRenewBefore).After(sub.ExpiresAt)
+	}
+	return now.After(sub.RequestedAt.Add(websubVerifyWait))
+}
+
+// websubSubscribe asks the hub to subscribe callback to topic. The hub
+// verifies the subscription with the callback afterwards.
+func websubSubscribe(ctx context.Context, callback, topic, secret string) error {
+	form := url.Values{
+		"hub.callback":      {callback},
+		"hub.topic":         {topic},
+		"hub.mode":          {"subscribe"},
+		"hub.verify":        {"async"},
+		"hub.lease_seconds": {strconv.Itoa(int(websubLease.Seconds()))},
+	}
+	if secret != "" {
+		form.Set("hub.secret", secret)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, websubHub, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusAccepted && res.StatusCode != http.StatusNoContent {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		return fmt.Errorf("unexpected http response %s: %s", res.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+	"google.golang.org/api/youtube/v3"
+
+	"github.com/rs/zerolog/log"
+)
+
+// maxIdsPerCall is the most IDs that list calls accept at once
+const maxIdsPerCall = 50
+
+// newYoutubeService creates a YouTube API client using the keys given by
+// --apikey in turn. All calls made with it are rate limited by --api-rate and
+// --api-jitter, count against quota, and are retried if they fail with a
+// server error or rate limit.
+func newYoutubeService(cliContext *cli.Context, quota *quotaTracker) (*youtube.Service, error) {
+	err := checkAPIKeySet(cliContext)
+	if err != nil {
+		return nil, err
+	}
+	rate := cliContext.Float64("api-rate")
+	if rate <= 0 {
+		return nil, fmt.Errorf("--api-rate must be positive, got %g", rate)
+	}
+	jitter := cliContext.Duration("api-jitter")
+	if jitter < 0 {
+		return nil, fmt.Errorf("--api-jitter must not be negative, got %s", jitter)
+	}
+
+	// a custom http client replaces the API key option, so the transport adds the key
+	tr := &retryTransport{
+		transport: &quotaTransport{
+			quota: quota,
+			transport: &keyTransport{
+				keys: newAPIKeyRing(apiKeysFromFlags(cliContext)),
+				transport: &rateLimitedTransport{
+					limiter:   newRateLimiter(rate, jitter),
+					transport: http.DefaultTransport,
+				},
+			},
+		},
+	}
+	return youtube.NewService(cliContext.Context, option.WithHTTPClient(&http.Client{Transport: tr}))
+}
+
+// isChannelId returns true if s looks like a canonical channel ID (UC...)
+func isChannelId(s string) bool {
+	return len(s) == 24 && strings.HasPrefix(s, "UC")
+}
+
+// isPlaylistId returns true if s looks like the ID of a user-created playlist (PL...)
+func isPlaylistId(s string) bool {
+	return (len(s) == 18 || len(s) == 34) && strings.HasPrefix(s, "PL")
+}
+
+// playlistIdFromURL returns the playlist ID from a playlist URL, or an empty
+// string if s isn't one
+func playlistIdFromURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil || strings.Trim(u.Path, "/") != "playlist" {
+		return ""
+	}
+	if pId := u.Query().Get("list"); isPlaylistId(pId) {
+		return pId
+	}
+	return ""
+}
+
+// resolveChannelId turns a channel ID, @handle or channel URL into a canonical
+// channel ID, returning the channel's title if a lookup was needed. Playlist
+// IDs and URLs are returned as the playlist ID.
+func resolveChannelId(ctx context.Context, service *youtube.Service, s string) (channelId, string, error) {
+	s = strings.TrimSpace(s)
+
+	if isChannelId(s) || isPlaylistId(s) {
+		return channelId(s), "", nil
+	}
+	if pId := playlistIdFromURL(s); pId != "" {
+		return channelId(pId), "", nil
+	}
+	if service == nil {
+		return "", "", fmt.Errorf("%q is not a channel ID, and resolving handles and URLs needs --apikey", s)
+	}
+
+	call := service.Channels.List([]string{"id", "snippet"})
+
+	switch {
+	case strings.HasPrefix(s, "@"):
+		call = call.ForHandle(s)
+
+	case strings.HasPrefix(s, "http://"), strings.HasPrefix(s, "https://"):
+		u, err := url.Parse(s)
+		if err != nil {
+			return "", "", fmt.Errorf("invalid channel URL %q: %w", s, err)
+		}
+		parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+		switch {
+		case strings.HasPrefix(parts[0], "@"):
+			call = call.ForHandle(parts[0])
+		case len(parts) >= 2 && parts[0] == "channel" && isChannelId(parts[1]):
+			return channelId(parts[1]), "", nil
+		case len(parts) >= 2 && parts[0] == "user":
+			call = call.ForUsername(parts[1])
+		case len(parts) >= 2 && parts[0] == "c":
+			// legacy custom URLs mostly became handles of the same name
+			call = call.ForHandle("@" + parts[1])
+		default:
+			return "", "", fmt.Errorf("unrecognised channel URL %q", s)
+		}
+
+	default:
+		return "", "", fmt.Errorf("%q is not a channel ID, playlist ID, @handle or channel URL", s)
+	}
+
+	response, err := call.Context(ctx).Do()
+	if err != nil {
+		return "", "", fmt.Errorf("error resolving channel %q: %w", s, err)
+	}
+	if len(response.Items) == 0 {
+		return "", "", fmt.Errorf("channel %q not found on YouTube", s)
+	}
+
+	cId := channelId(response.Items[0].Id)
+	title := response.Items[0].Snippet.Title
+	log.Info().Str("handle", s).Str("channel_id", string(cId)).Str("title", title).Msg("resolved channel")
+	return cId, title, nil
+}
+
+// isoDurationRegexp matches ISO-8601 durations as used by the YouTube API, e.g. PT1H4M23S or P1DT2H
+var isoDurationRegexp = regexp.MustCompile(`^P(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+)S)?)?$`)
+
+// parseISODuration parses an ISO-8601 duration of days, hours, minutes and seconds
+func parseISODuration(s string) (time.Duration, error) {
+	m := isoDurationRegexp.FindStringSubmatch(s)
+	if m == nil || s == "P" || s == "PT" {
+		return 0, fmt.Errorf("invalid ISO-8601 duration %q", s)
+	}
+	var d time.Duration
+	for i, unit := range []time.Duration{24 * time.Hour, time.Hour, time.Minute, time.Second} {
+		if m[i+1] == "" {
+			continue
+		}
+		n, err := strconv.Atoi(m[i+1])
+		if err != nil {
+			return 0, fmt.Errorf("invalid ISO-8601 duration %q: %w", s, err)
+		}
+		d += time.Duration(n) * unit
+	}
+	return d, nil
+}
+
+// uploadsPlaylistId fetches the ID of the playlist holding a channel's uploads
+func uploadsPlaylistId(ctx context.Context, service *youtube.Service, cId channelId) (string, error) {
+	response, err := service.Channels.List([]string{"contentDetails"}).Id(string(cId)).Context(ctx).Do()
+	if err != nil {
+		return "", err
+	}
+	if len(response.Items) == 0 || response.Items[0].ContentDetails == nil || response.Items[0].ContentDetails.RelatedPlaylists == nil {
+		return "", fmt.Errorf("channel %s not found on YouTube", cId)
+	}
+	return response.Items[0].ContentDetails.RelatedPlaylists.Uploads, nil
+}
+
+// recentPlaylistVideoIds returns the IDs of up to limit videos at the top of
+// a playlist that were published after t. Videos with no publish time yet,
+// such as upcoming premieres, are included. It also returns the etag of the
+// playlist's first page, or errNotModified if that still matches etag.
+func recentPlaylistVideoIds(ctx context.Context, service *youtube.Service, playlistId string, t time.Time, limit int, etag string) ([]string, string, error) {
+	var (
+		ids       []string
+		pageToken string
+		newEtag   string
+	)
+	for len(ids) < limit {
+		call := service.PlaylistItems.List([]string{"contentDetails"}).
+			PlaylistId(playlistId).MaxResults(maxIdsPerCall).PageToken(pageToken)
+		if pageToken == "" && etag != "" {
+			call = call.IfNoneMatch(etag)
+		}
+		response, err := call.Context(ctx).Do()
+		if googleapi.IsNotModified(err) {
+			return nil, etag, errNotModified
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		if pageToken == "" {
+			newEtag = response.Etag
+		}
+		found := false
+		for _, item := range response.Items {
+			if item.ContentDetails == nil || len(ids) == limit {
+				continue
+			}
+			// playlists are ordered by position, which isn't strictly publish order, so check every item
+			if item.ContentDetails.VideoPublishedAt != "" {
+				published, err := time.Parse(time.RFC3339, item.ContentDetails.VideoPublishedAt)
+				if err == nil && !published.After(t) {
+					continue
+				}
+			}
+			ids = append(ids, item.ContentDetails.VideoId)
+			found = true
+		}
+
+		// a page with nothing new means the rest of the playlist is older
+		if !found || response.NextPageToken == "" {
+			break
+		}
+		pageToken = response.NextPageToken
+	}
+	return ids, newEtag, nil
+}
+
+// playlistVideoIds returns the IDs of every video in a playlist, in playlist
+// order. It also returns the etag of the playlist's first page, or
+// errNotModified if that still matches etag. The first page includes the
+// playlist's length, so its etag changes when videos are added to any page.
+func playlistVideoIds(ctx context.Context, service *youtube.Service, playlistId string, etag string) ([]string, string, error) {
+	var (
+		ids       []string
+		pageToken string
+		newEtag   string
+	)
+	for {
+		call := service.PlaylistItems.List([]string{"contentDetails"}).
+			PlaylistId(playlistId).MaxResults(maxIdsPerCall).PageToken(pageToken)
+		if pageToken == "" && etag != "" {
+			call = call.IfNoneMatch(etag)
+		}
+		response, err := call.Context(ctx).Do()
+		if googleapi.IsNotModified(err) {
+			return nil, etag, errNotModified
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		if pageToken == "" {
+			newEtag = response.Etag
+		}
+		for _, item := range response.Items {
+			if item.ContentDetails != nil {
+				ids = append(ids, item.ContentDetails.VideoId)
+			}
+		}
+		if response.NextPageToken == "" || len(response.Items) == 0 {
+			return ids, newEtag, nil
+		}
+		pageToken = response.NextPageToken
+	}
+}
+
+// videosById fetches videos along with their details, up to 50 per call,
+// leaving out any that don't exist or are private. Asking for more parts
+// doesn't cost more quota.
+func videosById(ctx context.Context, service *youtube.Service, ids []string) ([]video, error) {
+	var videos []video
+	for start := 0; start < len(ids); start += maxIdsPerCall {
+		batch := ids[start:min(start+maxIdsPerCall, len(ids))]
+		response, err := service.Videos.List([]string{"snippet", "status", "contentDetails", "liveStreamingDetails", "statistics"}).Id(batch...).MaxResults(maxIdsPerCall).Context(ctx).Do()
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range response.Items {
+			videos = append(videos, videoFromVideo(item))
+		}
+	}
+	return videos, nil
+}
+
+// existingVideos returns which of the given video IDs still exist on YouTube
+func existingVideos(ctx context.Context, service *youtube.Service, ids []string) (map[string]bool, error) {
+	exists := make(map[string]bool)
+	for start := 0; start < len(ids); start += maxIdsPerCall {
+		batch := ids[start:min(start+maxIdsPerCall, len(ids))]
+		response, err := service.Videos.List([]string{"id"}).Id(batch...).MaxResults(maxIdsPerCall).Context(ctx).Do()
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range response.Items {
+			exists[item.Id] = true
+		}
+	}
+	return exists, nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseISODuration(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    time.Duration
+		wantErr bool
+	}{
+		{s: "PT2H", want: 2 * time.Hour},
+		{s: "PT45S", want: 45 * time.Second},
+		{s: "P1DT2H", want: 26 * time.Hour},
+		{s: "PT1H4M23S", want: time.Hour + 4*time.Minute + 23*time.Second},
+		{s: "PT12M3S", want: 12*time.Minute + 3*time.Second},
+		{s: "P1D", want: 24 * time.Hour},
+		{s: "P0D", want: 0},
+		{s: "PT0S", want: 0},
+		{s: "", wantErr: true},
+		{s: "P", wantErr: true},
+		{s: "PT", wantErr: true},
+		{s: "1H", wantErr: true},
+		{s: "PT1.5S", wantErr: true},
+		{s: "P1W", wantErr: true},
+		{s: "PT2H45S ", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			got, err := parseISODuration(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseISODuration(%q) error = %v, want error %t", tt.s, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseISODuration(%q) = %s, want %s", tt.s, got, tt.want)
+			}
+		})
+	}
+}
@@ -3,9 +3,11 @@ module pw-ytbot
 go 1.21.4
 
 require (
+	github.com/lib/pq v1.10.9
 	github.com/rs/zerolog v1.31.0
 	github.com/urfave/cli/v2 v2.27.1
 	google.golang.org/api v0.159.0
+	gopkg.in/yaml.v3 v3.0.1
 	modernc.org/sqlite v1.28.0
 )
 
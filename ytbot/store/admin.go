@@ -0,0 +1,91 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// pruneRule is a table whose rows are removed once they're too old
+type pruneRule struct {
+	table  string
+	column string // expression for when each row was written, compared to the cutoff
+	keep   time.Duration
+}
+
+// pruneRules returns the tables that are pruned, keeping their rows for r
+func pruneRules(r Retention) []pruneRule {
+	return []pruneRule{
+		{"videos_posted", "COALESCE(posted_at, date_posted)", r.Videos},
+		{"search_posts", "posted_at", r.SearchPosts},
+		{"tracked_messages", "posted_at", r.Messages},
+	}
+}
+
+func (s *sqlStore) Prune(r Retention) ([]Pruned, error) {
+	var counts []Pruned
+	for _, rule := range pruneRules(r) {
+		where := fmt.Sprintf("%s < %s", rule.column, s.d.ago(rule.keep))
+		var n int64
+		if s.dryRun {
+			err := s.queryRow(fmt.Sprintf(`SELECT COUNT(*) FROM %s WHERE %s;`, rule.table, where)).Scan(&n)
+			if err != nil {
+				return counts, fmt.Errorf("error counting old %s records in db: %w", rule.table, err)
+			}
+		} else {
+			res, err := s.exec(fmt.Sprintf(`DELETE FROM %s WHERE %s;`, rule.table, where))
+			if err != nil {
+				return counts, fmt.Errorf("error deleting old %s records from db: %w", rule.table, err)
+			}
+			n, err = res.RowsAffected()
+			if err != nil {
+				return counts, err
+			}
+		}
+		counts = append(counts, Pruned{Table: rule.table, Rows: n})
+	}
+	if s.dryRun {
+		return counts, nil
+	}
+	err := s.d.vacuum(s.db)
+	if err != nil {
+		return counts, fmt.Errorf("error vacuuming db: %w", err)
+	}
+	return counts, nil
+}
+
+func (s *sqlStore) Stats() (Stats, error) {
+	var (
+		st  Stats
+		err error
+	)
+	st.SchemaVersion, err = s.SchemaVersion()
+	if err != nil {
+		return st, fmt.Errorf("error reading schema version: %w", err)
+	}
+	st.Size, st.UsedSize, err = s.d.size(s.db)
+	if err != nil {
+		return st, fmt.Errorf("error reading db size: %w", err)
+	}
+
+	var oldest, newest sql.NullString
+	err = s.db.QueryRow(`SELECT MIN(posted_at), MAX(posted_at) FROM videos_posted;`).Scan(&oldest, &newest)
+	if err != nil {
+		return st, fmt.Errorf("error reading posting times from db: %w", err)
+	}
+	st.OldestPosted, st.NewestPosted = oldest.String, newest.String
+
+	tables, err := s.d.tables(s.db)
+	if err != nil {
+		return st, fmt.Errorf("error listing tables in db: %w", err)
+	}
+	for _, table := range tables {
+		t := TableRows{Table: table}
+		err = s.db.QueryRow(fmt.Sprintf(`SELECT COUNT(*) FROM "%s";`, table)).Scan(&t.Rows)
+		if err != nil {
+			return st, fmt.Errorf("error counting %s records in db: %w", table, err)
+		}
+		st.Tables = append(st.Tables, t)
+	}
+	return st, nil
+}
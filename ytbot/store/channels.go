@@ -0,0 +1,386 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+func (s *queries) CheckTime(channelId string) (CheckTime, bool, error) {
+	var (
+		ct                               CheckTime
+		dateChecked, nextCheck, lastSeen sql.NullString
+	)
+	err := s.queryRow(`SELECT date_checked, next_check_at, last_seen_at FROM channel_check_times WHERE id=?;`, channelId).Scan(&dateChecked, &nextCheck, &lastSeen)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ct, false, nil
+	}
+	if err != nil {
+		return ct, false, err
+	}
+	for _, t := range []struct {
+		s  sql.NullString
+		to *time.Time
+	}{{dateChecked, &ct.DateChecked}, {nextCheck, &ct.NextCheckAt}, {lastSeen, &ct.LastSeenAt}} {
+		*t.to, err = parseTime(t.s)
+		if err != nil {
+			return ct, true, err
+		}
+	}
+	return ct, true, nil
+}
+
+func (s *queries) SetCheckTime(channelId string, nextCheckAt time.Time) error {
+	return s.execOne(fmt.Sprintf(
+		`INSERT INTO channel_check_times (id, date_checked, next_check_at) VALUES (?, %s, ?)
+		 ON CONFLICT(id) DO UPDATE SET date_checked=excluded.date_checked, next_check_at=excluded.next_check_at;`, s.d.now()),
+		channelId, formatTime(nextCheckAt))
+}
+
+func (s *queries) SetLastSeen(channelId string, t time.Time) error {
+	seen := formatTime(t)
+	_, err := s.exec(`UPDATE channel_check_times SET last_seen_at=? WHERE id=? AND (last_seen_at IS NULL OR last_seen_at < ?);`, seen, channelId, seen)
+	return err
+}
+
+func (s *queries) Channels() ([]Channel, error) {
+	rows, err := s.query(`SELECT id, name, enabled, source FROM channels ORDER BY name;`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var channels []Channel
+	for rows.Next() {
+		var c Channel
+		err = rows.Scan(&c.ID, &c.Name, &c.Enabled, &c.Source)
+		if err != nil {
+			return nil, err
+		}
+		channels = append(channels, c)
+	}
+	return channels, rows.Err()
+}
+
+func (s *queries) AddChannel(c Channel) error {
+	_, err := s.exec(fmt.Sprintf(`INSERT INTO channels (id, name, added_at, enabled, source) VALUES (?, ?, %s, 1, ?);`, s.d.now()),
+		c.ID, c.Name, c.Source)
+	return err
+}
+
+func (s *queries) RemoveChannel(channelId string) error {
+	err := s.execOne(`DELETE FROM channels WHERE id=?;`, channelId)
+	if errors.Is(err, ErrNoRowChanged) {
+		return ErrChannelNotFound
+	}
+	return err
+}
+
+func (s *queries) SetChannelEnabled(channelId string, enabled bool) error {
+	err := s.execOne(`UPDATE channels SET enabled=? WHERE id=?;`, boolInt(enabled), channelId)
+	if errors.Is(err, ErrNoRowChanged) {
+		return ErrChannelNotFound
+	}
+	return err
+}
+
+func (s *queries) ChannelFailures(channelId string) (ChannelFailures, error) {
+	var (
+		f         ChannelFailures
+		trippedAt sql.NullString
+	)
+	err := s.queryRow(`SELECT failures, last_error, tripped_at FROM channel_failures WHERE channel_id=?;`, channelId).Scan(&f.Failures, &f.LastError, &trippedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return f, nil
+	}
+	if err != nil {
+		return f, err
+	}
+	f.TrippedAt, err = parseTime(trippedAt)
+	return f, err
+}
+
+func (s *queries) SetChannelFailures(channelId string, f ChannelFailures) error {
+	_, err := s.exec(
+		`INSERT INTO channel_failures (channel_id, failures, last_error, tripped_at) VALUES (?, ?, ?, ?)
+		 ON CONFLICT(channel_id) DO UPDATE SET failures=excluded.failures, last_error=excluded.last_error, tripped_at=excluded.tripped_at;`,
+		channelId, f.Failures, f.LastError, nullTime(f.TrippedAt))
+	return err
+}
+
+func (s *queries) ResetChannelFailures(channelId string) (bool, error) {
+	res, err := s.exec(`DELETE FROM channel_failures WHERE channel_id=?;`, channelId)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func (s *queries) RecordUpload(channelId, videoId, publishedAt string, keep int) error {
+	_, err := s.exec(
+		`INSERT INTO channel_uploads (channel_id, video_id, published_at) VALUES (?, ?, ?)
+		 ON CONFLICT(channel_id, video_id) DO NOTHING;`,
+		channelId, videoId, publishedAt)
+	if err != nil {
+		return err
+	}
+	_, err = s.exec(
+		`DELETE FROM channel_uploads WHERE channel_id=? AND video_id NOT IN (
+			SELECT video_id FROM channel_uploads WHERE channel_id=? ORDER BY published_at DESC LIMIT ?
+		 );`,
+		channelId, channelId, keep)
+	return err
+}
+
+func (s *queries) UploadTimes(channelId string) ([]string, error) {
+	return s.strings(`SELECT published_at FROM channel_uploads WHERE channel_id=? ORDER BY published_at;`, channelId)
+}
+
+// strings reads the one column of the rows a query selects
+func (s *queries) strings(query string, args ...any) ([]string, error) {
+	rows, err := s.query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var values []string
+	for rows.Next() {
+		var v string
+		err = rows.Scan(&v)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, rows.Err()
+}
+
+// ChannelMetaFetchedAt returns the zero time for channels whose only
+// details are their uploads playlist or embed colour, cached on their own
+func (s *queries) ChannelMetaFetchedAt(id string) (time.Time, error) {
+	var fetchedAt string
+	err := s.queryRow(`SELECT fetched_at FROM channel_meta WHERE id=?;`, id).Scan(&fetchedAt)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && fetchedAt == "") {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return parseTime(sql.NullString{String: fetchedAt, Valid: true})
+}
+
+func (s *queries) SetChannelMeta(id, title, uploadsPlaylistId, thumbnailURL string) error {
+	_, err := s.exec(fmt.Sprintf(
+		`INSERT INTO channel_meta (id, title, uploads_playlist_id, thumbnail_url, fetched_at) VALUES (?, ?, ?, ?, %s)
+		 ON CONFLICT(id) DO UPDATE SET title=excluded.title, uploads_playlist_id=excluded.uploads_playlist_id,
+		 	thumbnail_url=excluded.thumbnail_url, fetched_at=excluded.fetched_at;`, s.d.now()),
+		id, title, uploadsPlaylistId, thumbnailURL)
+	return err
+}
+
+func (s *queries) ChannelTitles() (map[string]string, error) {
+	rows, err := s.query(`SELECT id, title FROM channel_meta WHERE title != '';`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	titles := make(map[string]string)
+	for rows.Next() {
+		var id, title string
+		err = rows.Scan(&id, &title)
+		if err != nil {
+			return nil, err
+		}
+		titles[id] = title
+	}
+	return titles, rows.Err()
+}
+
+func (s *queries) UploadsPlaylist(channelId string) (string, error) {
+	var playlistId string
+	err := s.queryRow(`SELECT uploads_playlist_id FROM channel_meta WHERE id=? AND uploads_playlist_id != '';`, channelId).Scan(&playlistId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return playlistId, err
+}
+
+func (s *queries) SetUploadsPlaylist(channelId, playlistId string) error {
+	_, err := s.exec(
+		`INSERT INTO channel_meta (id, uploads_playlist_id) VALUES (?, ?)
+		 ON CONFLICT(id) DO UPDATE SET uploads_playlist_id=excluded.uploads_playlist_id;`,
+		channelId, playlistId)
+	return err
+}
+
+func (s *queries) EmbedColor(channelId string) (int, bool, error) {
+	var color sql.NullInt64
+	err := s.queryRow(`SELECT embed_color FROM channel_meta WHERE id=?;`, channelId).Scan(&color)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, false, nil
+	}
+	return int(color.Int64), color.Valid, err
+}
+
+func (s *queries) SetEmbedColor(channelId string, color int) error {
+	_, err := s.exec(
+		`INSERT INTO channel_meta (id, embed_color) VALUES (?, ?)
+		 ON CONFLICT(id) DO UPDATE SET embed_color=excluded.embed_color;`,
+		channelId, color)
+	return err
+}
+
+func (s *queries) FeedCache(channelId string) (string, string, error) {
+	var etag, lastModified string
+	err := s.queryRow(`SELECT etag, last_modified FROM channel_feeds WHERE channel_id=?;`, channelId).Scan(&etag, &lastModified)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", "", nil
+	}
+	return etag, lastModified, err
+}
+
+func (s *queries) SetFeedCache(channelId, etag, lastModified string) error {
+	_, err := s.exec(
+		`INSERT INTO channel_feeds (channel_id, etag, last_modified) VALUES (?, ?, ?)
+		 ON CONFLICT(channel_id) DO UPDATE SET etag=excluded.etag, last_modified=excluded.last_modified;`,
+		channelId, etag, lastModified)
+	return err
+}
+
+func (s *queries) ForgetFeedCache(channelId string) error {
+	_, err := s.exec(`DELETE FROM channel_feeds WHERE channel_id=?;`, channelId)
+	return err
+}
+
+func (s *queries) ListEtag(channelId string) (string, error) {
+	var etag string
+	err := s.queryRow(`SELECT etag FROM list_etags WHERE channel_id=?;`, channelId).Scan(&etag)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return etag, err
+}
+
+func (s *queries) SetListEtag(channelId, etag string) error {
+	_, err := s.exec(
+		`INSERT INTO list_etags (channel_id, etag) VALUES (?, ?)
+		 ON CONFLICT(channel_id) DO UPDATE SET etag=excluded.etag;`, channelId, etag)
+	return err
+}
+
+func (s *queries) ForgetListEtag(channelId string) error {
+	_, err := s.exec(`DELETE FROM list_etags WHERE channel_id=?;`, channelId)
+	return err
+}
+
+func (s *queries) PlaylistItemSeen(playlistId, videoId string) (bool, error) {
+	var n int
+	err := s.queryRow(`SELECT COUNT(*) FROM playlist_items WHERE playlist_id=? AND video_id=?;`, playlistId, videoId).Scan(&n)
+	return n > 0, err
+}
+
+func (s *queries) MarkPlaylistItemSeen(playlistId, videoId string) error {
+	_, err := s.exec(fmt.Sprintf(
+		`INSERT INTO playlist_items (playlist_id, video_id, seen_at) VALUES (?, ?, %s)
+		 ON CONFLICT(playlist_id, video_id) DO NOTHING;`, s.d.now()), playlistId, videoId)
+	return err
+}
+
+func (s *queries) ViewCandidates(channelId string) ([]ViewCandidate, error) {
+	rows, err := s.query(`SELECT video_id, published_at FROM view_candidates WHERE channel_id=?;`, channelId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var candidates []ViewCandidate
+	for rows.Next() {
+		var vc ViewCandidate
+		err = rows.Scan(&vc.VideoID, &vc.PublishedAt)
+		if err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, vc)
+	}
+	return candidates, rows.Err()
+}
+
+func (s *queries) SetViewCandidate(channelId, videoId, publishedAt string, views int64) error {
+	_, err := s.exec(fmt.Sprintf(
+		`INSERT INTO view_candidates (channel_id, video_id, published_at, views, checked_at) VALUES (?, ?, ?, ?, %s)
+		 ON CONFLICT(channel_id, video_id) DO UPDATE SET views=excluded.views, checked_at=excluded.checked_at;`, s.d.now()),
+		channelId, videoId, publishedAt, views)
+	return err
+}
+
+func (s *queries) DeleteViewCandidate(channelId, videoId string) error {
+	_, err := s.exec(`DELETE FROM view_candidates WHERE channel_id=? AND video_id=?;`, channelId, videoId)
+	return err
+}
+
+func (s *queries) ForgetPostedViewCandidates(channelId string) error {
+	_, err := s.exec(`DELETE FROM view_candidates WHERE channel_id=? AND video_id IN (SELECT id FROM videos_posted);`, channelId)
+	return err
+}
+
+func (s *queries) UpcomingStreams(channelId string) ([]string, error) {
+	return s.strings(`SELECT video_id FROM upcoming_streams WHERE channel_id=? AND live_at IS NULL;`, channelId)
+}
+
+func (s *queries) RecordStream(channelId, videoId string, scheduledStart time.Time) error {
+	_, err := s.exec(fmt.Sprintf(
+		`INSERT INTO upcoming_streams (video_id, channel_id, scheduled_start, announced_at) VALUES (?, ?, ?, %s)
+		 ON CONFLICT(video_id) DO UPDATE SET scheduled_start=excluded.scheduled_start;`, s.d.now()),
+		videoId, channelId, formatTime(scheduledStart))
+	return err
+}
+
+func (s *queries) MarkStreamLive(videoId string) error {
+	_, err := s.exec(fmt.Sprintf(`UPDATE upcoming_streams SET live_at=%s WHERE video_id=?;`, s.d.now()), videoId)
+	return err
+}
+
+func (s *queries) ForgetStream(videoId string) error {
+	_, err := s.exec(`DELETE FROM upcoming_streams WHERE video_id=?;`, videoId)
+	return err
+}
+
+func (s *queries) WebSubSubscription(channelId string) (WebSubSubscription, bool, error) {
+	var (
+		sub                    WebSubSubscription
+		requestedAt, expiresAt sql.NullString
+	)
+	err := s.queryRow(`SELECT requested_at, expires_at FROM websub_subscriptions WHERE channel_id=?;`, channelId).Scan(&requestedAt, &expiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return sub, false, nil
+	}
+	if err != nil {
+		return sub, false, err
+	}
+	sub.RequestedAt, err = parseTime(requestedAt)
+	if err != nil {
+		return sub, true, err
+	}
+	sub.ExpiresAt, err = parseTime(expiresAt)
+	return sub, true, err
+}
+
+func (s *queries) WebSubRequested(channelId string) error {
+	_, err := s.exec(fmt.Sprintf(
+		`INSERT INTO websub_subscriptions (channel_id, requested_at) VALUES (?, %s)
+		 ON CONFLICT(channel_id) DO UPDATE SET requested_at=excluded.requested_at;`, s.d.now()),
+		channelId)
+	return err
+}
+
+// WebSubVerified records when the subscription was requested as now if it
+// wasn't recorded, as the hub may verify before it's been
+func (s *queries) WebSubVerified(channelId string, expiresAt time.Time) error {
+	_, err := s.exec(fmt.Sprintf(
+		`INSERT INTO websub_subscriptions (channel_id, requested_at, expires_at) VALUES (?, %s, ?)
+		 ON CONFLICT(channel_id) DO UPDATE SET expires_at=excluded.expires_at;`, s.d.now()),
+		channelId, formatTime(expiresAt))
+	return err
+}
@@ -0,0 +1,495 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+func TestMain(m *testing.M) {
+	log.Logger = zerolog.Nop()
+	m.Run()
+}
+
+// testStore runs the tests every backend must pass, each on a new, empty
+// store from open
+func testStore(t *testing.T, open func(t *testing.T) Store) {
+	for _, test := range []struct {
+		name string
+		run  func(t *testing.T, s Store)
+	}{
+		{"videos", testVideos},
+		{"no row changed", testNoRowChanged},
+		{"check times", testCheckTimes},
+		{"channels", testChannels},
+		{"outbox", testOutbox},
+		{"digests and reports", testDigests},
+		{"messages", testMessages},
+		{"quota", testQuota},
+		{"transactions", testTransactions},
+		{"dry run", testDryRun},
+		{"run lock", testRunLock},
+		{"prune", testPrune},
+		{"stats", testStats},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			s := open(t)
+			defer s.Close()
+			test.run(t, s)
+		})
+	}
+}
+
+func testVideos(t *testing.T, s Store) {
+	_, posted, err := s.IsPosted("video1")
+	if err != nil || posted {
+		t.Fatalf("IsPosted before MarkPosted = %v, %v, want false", posted, err)
+	}
+	check(t, s.MarkPosted("video1", "queued", "youtube"))
+	check(t, s.MarkPosted("video1", "video", "youtube"))
+	postType, posted, err := s.IsPosted("video1")
+	if err != nil || !posted || postType != "video" {
+		t.Fatalf("IsPosted = %q, %v, %v, want video, true", postType, posted, err)
+	}
+
+	published := time.Date(2024, 3, 2, 8, 0, 0, 0, time.UTC)
+	check(t, s.SetVideoDetails("video1", VideoDetails{ChannelID: "UC1", Title: "Landing", ChannelTitle: "Pilot", PublishedAt: published}))
+	check(t, s.MarkDelivered("video1", "https://example.com/hook"))
+	check(t, s.SetVideoMessage("video1", "m1", "c1", ""))
+
+	v, ok, err := s.Video("video1")
+	if err != nil || !ok {
+		t.Fatalf("Video = %v, %v", ok, err)
+	}
+	if v.Title != "Landing" || v.ChannelTitle != "Pilot" || v.PublishedAt != "2024-03-02 08:00:00" ||
+		v.PostedAt == "" || v.Webhook != "https://example.com/hook" || v.DiscordMessageID != "m1" || v.DiscordThreadID != "" {
+		t.Errorf("Video = %+v", v)
+	}
+
+	check(t, s.MarkPosted("video2", "short", "youtube"))
+	check(t, s.SetVideoDetails("video2", VideoDetails{ChannelID: "UC1", Title: "Taxi"}))
+	recent, err := s.RecentlyPosted("UC1", "video1", time.Hour, "video", "short")
+	check(t, err)
+	if len(recent) != 1 || recent[0].ID != "video2" {
+		t.Errorf("RecentlyPosted = %+v, want video2", recent)
+	}
+	recent, err = s.RecentlyPosted("UC1", "video1", time.Hour, "video")
+	check(t, err)
+	if len(recent) != 0 {
+		t.Errorf("RecentlyPosted videos = %+v, want none", recent)
+	}
+
+	imported := Video{ID: "video3", Platform: "youtube", PostType: "video", DatePosted: "2023-01-01 00:00:00", Title: "Imported"}
+	check(t, s.WriteVideo(imported))
+	got, ok, err := s.Video("video3")
+	if err != nil || !ok || !reflect.DeepEqual(got, imported) {
+		t.Errorf("Video after WriteVideo = %+v, %v, %v, want %+v", got, ok, err, imported)
+	}
+	videos, err := s.Videos()
+	check(t, err)
+	if len(videos) != 3 || videos[0].ID != "video3" {
+		t.Errorf("Videos = %+v, want 3, oldest first", videos)
+	}
+
+	counts, err := s.PostCounts(time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	check(t, err)
+	if len(counts) != 2 {
+		t.Errorf("PostCounts = %+v, want video and short of UC1", counts)
+	}
+	channelCounts, err := s.ChannelPostCounts(7*24*time.Hour, 30*24*time.Hour)
+	check(t, err)
+	want := []ChannelPostCount{{ChannelID: "UC1", Posted: []int{1, 1}}}
+	if !reflect.DeepEqual(channelCounts, want) {
+		t.Errorf("ChannelPostCounts = %+v, want %+v", channelCounts, want)
+	}
+}
+
+func testNoRowChanged(t *testing.T, s Store) {
+	err := s.MarkDelivered("never-recorded", "https://example.com/hook")
+	if !errors.Is(err, ErrNoRowChanged) {
+		t.Errorf("MarkDelivered of a video never recorded: error = %v, want %v", err, ErrNoRowChanged)
+	}
+	err = s.DryRun().MarkDelivered("never-recorded", "https://example.com/hook")
+	if err != nil {
+		t.Errorf("MarkDelivered in a dry run: error = %v, want nil", err)
+	}
+}
+
+func testCheckTimes(t *testing.T, s Store) {
+	_, ok, err := s.CheckTime("UC1")
+	if err != nil || ok {
+		t.Fatalf("CheckTime before SetCheckTime = %v, %v", ok, err)
+	}
+	next := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	check(t, s.SetCheckTime("UC1", next))
+	seen := time.Date(2024, 3, 2, 8, 0, 0, 0, time.UTC)
+	check(t, s.SetLastSeen("UC1", seen))
+	check(t, s.SetLastSeen("UC1", seen.Add(-time.Hour)))
+	ct, ok, err := s.CheckTime("UC1")
+	if err != nil || !ok {
+		t.Fatalf("CheckTime = %v, %v", ok, err)
+	}
+	if !ct.NextCheckAt.Equal(next) || !ct.LastSeenAt.Equal(seen) || time.Since(ct.DateChecked) > time.Minute {
+		t.Errorf("CheckTime = %+v", ct)
+	}
+
+	for _, published := range []string{"2024-01-01T00:00:00Z", "2024-01-03T00:00:00Z", "2024-01-02T00:00:00Z"} {
+		check(t, s.RecordUpload("UC1", "v"+published, published, 2))
+	}
+	uploads, err := s.UploadTimes("UC1")
+	check(t, err)
+	if want := []string{"2024-01-02T00:00:00Z", "2024-01-03T00:00:00Z"}; !reflect.DeepEqual(uploads, want) {
+		t.Errorf("UploadTimes = %v, want %v", uploads, want)
+	}
+
+	check(t, s.SetEmbedColor("UC1", 0xff0000))
+	check(t, s.SetUploadsPlaylist("UC1", "UU1"))
+	fetchedAt, err := s.ChannelMetaFetchedAt("UC1")
+	if err != nil || !fetchedAt.IsZero() {
+		t.Errorf("ChannelMetaFetchedAt before SetChannelMeta = %v, %v, want zero", fetchedAt, err)
+	}
+	check(t, s.SetChannelMeta("UC1", "Pilot", "UU1", "https://example.com/thumb.jpg"))
+	color, ok, err := s.EmbedColor("UC1")
+	if err != nil || !ok || color != 0xff0000 {
+		t.Errorf("EmbedColor = %x, %v, %v", color, ok, err)
+	}
+	titles, err := s.ChannelTitles()
+	check(t, err)
+	if titles["UC1"] != "Pilot" {
+		t.Errorf("ChannelTitles = %v", titles)
+	}
+
+	check(t, s.SetFeedCache("UC1", `"etag"`, "Sat, 02 Mar 2024 08:00:00 GMT"))
+	etag, lastModified, err := s.FeedCache("UC1")
+	if err != nil || etag != `"etag"` || lastModified == "" {
+		t.Errorf("FeedCache = %q, %q, %v", etag, lastModified, err)
+	}
+	check(t, s.ForgetFeedCache("UC1"))
+	etag, _, err = s.FeedCache("UC1")
+	if err != nil || etag != "" {
+		t.Errorf("FeedCache after ForgetFeedCache = %q, %v", etag, err)
+	}
+
+	check(t, s.MarkPlaylistItemSeen("PL1", "video1"))
+	check(t, s.MarkPlaylistItemSeen("PL1", "video1"))
+	seenItem, err := s.PlaylistItemSeen("PL1", "video1")
+	if err != nil || !seenItem {
+		t.Errorf("PlaylistItemSeen = %v, %v", seenItem, err)
+	}
+
+	check(t, s.SetViewCandidate("UC1", "video1", "2024-03-02T08:00:00Z", 10))
+	check(t, s.SetViewCandidate("UC1", "video2", "2024-03-02T09:00:00Z", 5_000_000_000))
+	check(t, s.MarkPosted("video1", "video", "youtube"))
+	check(t, s.ForgetPostedViewCandidates("UC1"))
+	candidates, err := s.ViewCandidates("UC1")
+	check(t, err)
+	if len(candidates) != 1 || candidates[0].VideoID != "video2" {
+		t.Errorf("ViewCandidates = %+v, want video2", candidates)
+	}
+
+	check(t, s.RecordStream("UC1", "stream1", next))
+	check(t, s.RecordStream("UC1", "stream2", next))
+	check(t, s.MarkStreamLive("stream1"))
+	streams, err := s.UpcomingStreams("UC1")
+	check(t, err)
+	if !reflect.DeepEqual(streams, []string{"stream2"}) {
+		t.Errorf("UpcomingStreams = %v, want stream2", streams)
+	}
+
+	check(t, s.WebSubRequested("UC1"))
+	check(t, s.WebSubVerified("UC1", next))
+	sub, ok, err := s.WebSubSubscription("UC1")
+	if err != nil || !ok || !sub.ExpiresAt.Equal(next) || sub.RequestedAt.IsZero() {
+		t.Errorf("WebSubSubscription = %+v, %v, %v", sub, ok, err)
+	}
+}
+
+func testChannels(t *testing.T, s Store) {
+	check(t, s.AddChannel(Channel{ID: "UC2", Name: "Bravo", Source: "db"}))
+	check(t, s.AddChannel(Channel{ID: "UC1", Name: "Alpha", Source: "builtin"}))
+	check(t, s.SetChannelEnabled("UC2", false))
+	channels, err := s.Channels()
+	check(t, err)
+	want := []Channel{{ID: "UC1", Name: "Alpha", Enabled: true, Source: "builtin"}, {ID: "UC2", Name: "Bravo", Enabled: false, Source: "db"}}
+	if !reflect.DeepEqual(channels, want) {
+		t.Errorf("Channels = %+v, want %+v", channels, want)
+	}
+	check(t, s.RemoveChannel("UC2"))
+	if err := s.RemoveChannel("UC2"); !errors.Is(err, ErrChannelNotFound) {
+		t.Errorf("RemoveChannel of a removed channel: error = %v, want %v", err, ErrChannelNotFound)
+	}
+	if err := s.SetChannelEnabled("UC2", true); !errors.Is(err, ErrChannelNotFound) {
+		t.Errorf("SetChannelEnabled of a removed channel: error = %v, want %v", err, ErrChannelNotFound)
+	}
+
+	tripped := time.Date(2024, 3, 2, 8, 0, 0, 0, time.UTC)
+	check(t, s.SetChannelFailures("UC1", ChannelFailures{Failures: 3, LastError: "404", TrippedAt: tripped}))
+	f, err := s.ChannelFailures("UC1")
+	if err != nil || f.Failures != 3 || f.LastError != "404" || !f.TrippedAt.Equal(tripped) {
+		t.Errorf("ChannelFailures = %+v, %v", f, err)
+	}
+	reset, err := s.ResetChannelFailures("UC1")
+	if err != nil || !reset {
+		t.Errorf("ResetChannelFailures = %v, %v, want true", reset, err)
+	}
+	reset, err = s.ResetChannelFailures("UC1")
+	if err != nil || reset {
+		t.Errorf("ResetChannelFailures again = %v, %v, want false", reset, err)
+	}
+}
+
+func testOutbox(t *testing.T, s Store) {
+	// queued in the same second, so they're read back in the order they were queued
+	for _, id := range []string{"c", "a", "b"} {
+		check(t, s.QueuePost(PendingPost{VideoID: id, ChannelID: "UC1", Webhook: "hook", Content: "new video " + id, Embeds: "[]", PostType: "video"}))
+	}
+	check(t, s.QueuePost(PendingPost{VideoID: "c", Webhook: "hook", Content: "queued twice"}))
+	check(t, s.SetPendingPostAttempts("a", "hook", 2))
+	if err := s.SetPendingPostAttempts("never-queued", "hook", 1); !errors.Is(err, ErrNoRowChanged) {
+		t.Errorf("SetPendingPostAttempts of a post never queued: error = %v, want %v", err, ErrNoRowChanged)
+	}
+	check(t, s.DeletePendingPost("b", "hook"))
+
+	posts, err := s.PendingPosts()
+	check(t, err)
+	if len(posts) != 2 || posts[0].VideoID != "c" || posts[1].VideoID != "a" {
+		t.Fatalf("PendingPosts = %+v, want c then a", posts)
+	}
+	if posts[0].Content != "new video c" || posts[0].Embeds != "[]" || posts[1].Attempts != 2 || time.Since(posts[0].QueuedAt) > time.Minute {
+		t.Errorf("PendingPosts = %+v", posts)
+	}
+}
+
+func testDigests(t *testing.T, s Store) {
+	for _, id := range []string{"c", "a"} {
+		check(t, s.AddDigestItem(DigestItem{VideoID: id, Webhook: "hook", Forum: true, ChannelID: "UC1", Title: id, URL: "https://youtu.be/" + id}))
+	}
+	check(t, s.DeleteDigestItem("c", "hook"))
+	items, err := s.DigestItems()
+	check(t, err)
+	if len(items) != 1 || items[0].VideoID != "a" || !items[0].Forum {
+		t.Errorf("DigestItems = %+v, want a", items)
+	}
+
+	due := time.Date(2024, 3, 2, 8, 0, 0, 0, time.UTC)
+	for _, kind := range []struct {
+		sent   func(time.Time) (bool, error)
+		record func(time.Time, int) error
+	}{{s.DigestSent, s.RecordDigest}, {s.ReportSent, s.RecordReport}} {
+		sent, err := kind.sent(due)
+		if err != nil || sent {
+			t.Errorf("sent before recording = %v, %v", sent, err)
+		}
+		check(t, kind.record(due, 3))
+		check(t, kind.record(due, 3))
+		sent, err = kind.sent(due.Add(-24 * time.Hour))
+		if err != nil || !sent {
+			t.Errorf("earlier one sent after recording = %v, %v, want true", sent, err)
+		}
+	}
+}
+
+func testMessages(t *testing.T, s Store) {
+	check(t, s.TrackMessage(TrackedMessage{VideoID: "video1", Webhook: "hook", MessageID: "m1", Title: "Old", Content: "Old title"}))
+	check(t, s.TrackMessage(TrackedMessage{VideoID: "pt:video2", Webhook: "hook", MessageID: "m2", Title: "PeerTube", Content: "PeerTube"}))
+	check(t, s.SetMessageThread("video1", "hook", "t1"))
+	check(t, s.RetitleMessage("video1", "hook", "New", "New title", `{"title":"New"}`))
+
+	messages, err := s.MessagesToRetitle(time.Hour, 2, "pt:")
+	check(t, err)
+	want := []TrackedMessage{{VideoID: "video1", Webhook: "hook", MessageID: "m1", Title: "New", Content: "New title", Edits: 1, ThreadID: "t1", Render: `{"title":"New"}`}}
+	if !reflect.DeepEqual(messages, want) {
+		t.Errorf("MessagesToRetitle = %+v, want %+v", messages, want)
+	}
+	messages, err = s.MessagesToRetitle(time.Hour, 1, "pt:")
+	check(t, err)
+	if len(messages) != 0 {
+		t.Errorf("MessagesToRetitle past maxEdits = %+v, want none", messages)
+	}
+
+	check(t, s.MarkMessageDead("video1", "hook"))
+	messages, err = s.LiveMessages("pt:")
+	check(t, err)
+	if len(messages) != 0 {
+		t.Errorf("LiveMessages after MarkMessageDead = %+v, want none", messages)
+	}
+
+	check(t, s.RecordSearchPost("search1", "video1"))
+	check(t, s.RecordSearchPost("search1", "video2"))
+	n, err := s.SearchPostsToday("search1")
+	if err != nil || n != 2 {
+		t.Errorf("SearchPostsToday = %d, %v, want 2", n, err)
+	}
+}
+
+func testQuota(t *testing.T, s Store) {
+	check(t, s.SpendQuota("2024-03-02", "videos.list", 1))
+	check(t, s.SpendQuota("2024-03-02", "videos.list", 1))
+	check(t, s.SpendQuota("2024-03-02", "search.list", 100))
+	used, err := s.QuotaUsed("2024-03-02")
+	if err != nil || used != 102 {
+		t.Errorf("QuotaUsed = %d, %v, want 102", used, err)
+	}
+	usage, err := s.QuotaUsage("2024-03-02")
+	check(t, err)
+	want := []QuotaUsage{{"search.list", 1, 100}, {"videos.list", 2, 2}}
+	if !reflect.DeepEqual(usage, want) {
+		t.Errorf("QuotaUsage = %+v, want %+v", usage, want)
+	}
+}
+
+func testTransactions(t *testing.T, s Store) {
+	tx, err := s.Begin()
+	check(t, err)
+	check(t, tx.MarkPosted("rolled-back", "video", "youtube"))
+	check(t, tx.Rollback())
+
+	tx, err = s.Begin()
+	check(t, err)
+	defer tx.Rollback()
+	check(t, tx.MarkPosted("committed", "video", "youtube"))
+	check(t, tx.Commit())
+
+	for id, want := range map[string]bool{"rolled-back": false, "committed": true} {
+		_, posted, err := s.IsPosted(id)
+		if err != nil || posted != want {
+			t.Errorf("IsPosted(%s) = %v, %v, want %v", id, posted, err, want)
+		}
+	}
+}
+
+func testDryRun(t *testing.T, s Store) {
+	dry := s.DryRun()
+	check(t, dry.MarkPosted("video1", "video", "youtube"))
+	check(t, dry.QueuePost(PendingPost{VideoID: "video1", Webhook: "hook"}))
+	tx, err := dry.Begin()
+	check(t, err)
+	check(t, tx.MarkPosted("video2", "video", "youtube"))
+	check(t, tx.Commit())
+
+	for _, id := range []string{"video1", "video2"} {
+		_, posted, err := s.IsPosted(id)
+		if err != nil || posted {
+			t.Errorf("IsPosted(%s) after a dry run = %v, %v, want false", id, posted, err)
+		}
+	}
+	posts, err := s.PendingPosts()
+	if err != nil || len(posts) != 0 {
+		t.Errorf("PendingPosts after a dry run = %+v, %v, want none", posts, err)
+	}
+
+	// the run lock is still taken
+	ok, err := dry.AcquireLock("dry", time.Minute)
+	if err != nil || !ok {
+		t.Fatalf("AcquireLock in a dry run = %v, %v, want true", ok, err)
+	}
+	holder, _, held, err := s.LockHolder()
+	if err != nil || !held || holder != "dry" {
+		t.Errorf("LockHolder = %q, %v, %v, want dry", holder, held, err)
+	}
+}
+
+func testRunLock(t *testing.T, s Store) {
+	_, _, held, err := s.LockHolder()
+	if err != nil || held {
+		t.Fatalf("LockHolder before AcquireLock = %v, %v", held, err)
+	}
+	ok, err := s.AcquireLock("a", time.Minute)
+	if err != nil || !ok {
+		t.Fatalf("AcquireLock = %v, %v, want true", ok, err)
+	}
+	ok, err = s.AcquireLock("b", time.Minute)
+	if err != nil || ok {
+		t.Errorf("AcquireLock of a held lock = %v, %v, want false", ok, err)
+	}
+	check(t, s.HeartbeatLock("a"))
+	holder, heartbeat, held, err := s.LockHolder()
+	if err != nil || !held || holder != "a" || time.Since(heartbeat) > time.Minute {
+		t.Errorf("LockHolder = %q, %v, %v, %v", holder, heartbeat, held, err)
+	}
+
+	// a lock that hasn't heartbeated for its timeout is taken over
+	time.Sleep(1100 * time.Millisecond)
+	ok, err = s.AcquireLock("b", 0)
+	if err != nil || !ok {
+		t.Errorf("AcquireLock of a stale lock = %v, %v, want true", ok, err)
+	}
+	err = s.HeartbeatLock("a")
+	if !errors.Is(err, ErrNoRowChanged) {
+		t.Errorf("HeartbeatLock of a lock taken over = %v, want %v", err, ErrNoRowChanged)
+	}
+	check(t, s.HeartbeatLock("b"))
+
+	// releasing someone else's lock leaves it alone
+	check(t, s.ReleaseLock("a"))
+	holder, _, _, err = s.LockHolder()
+	if err != nil || holder != "b" {
+		t.Errorf("LockHolder after another holder released = %q, %v, want b", holder, err)
+	}
+	check(t, s.ReleaseLock("b"))
+	_, _, held, err = s.LockHolder()
+	if err != nil || held {
+		t.Errorf("LockHolder after ReleaseLock = %v, %v, want none", held, err)
+	}
+}
+
+func testPrune(t *testing.T, s Store) {
+	for i, datePosted := range []string{"2000-01-01 00:00:00", "2000-01-02 00:00:00"} {
+		check(t, s.WriteVideo(Video{ID: fmt.Sprintf("old%d", i), Platform: "youtube", PostType: "video", DatePosted: datePosted}))
+	}
+	check(t, s.MarkPosted("new", "video", "youtube"))
+	check(t, s.RecordSearchPost("search1", "new"))
+
+	r := Retention{Videos: 30 * 24 * time.Hour, SearchPosts: 48 * time.Hour, Messages: 30 * 24 * time.Hour}
+	want := []Pruned{{"videos_posted", 2}, {"search_posts", 0}, {"tracked_messages", 0}}
+	pruned, err := s.DryRun().Prune(r)
+	check(t, err)
+	if !reflect.DeepEqual(pruned, want) {
+		t.Errorf("Prune in a dry run = %+v, want %+v", pruned, want)
+	}
+	pruned, err = s.Prune(r)
+	check(t, err)
+	if !reflect.DeepEqual(pruned, want) {
+		t.Errorf("Prune = %+v, want %+v", pruned, want)
+	}
+	videos, err := s.Videos()
+	check(t, err)
+	if len(videos) != 1 || videos[0].ID != "new" {
+		t.Errorf("Videos after Prune = %+v, want new", videos)
+	}
+}
+
+func testStats(t *testing.T, s Store) {
+	check(t, s.MarkPosted("video1", "video", "youtube"))
+	check(t, s.MarkDelivered("video1", "hook"))
+	st, err := s.Stats()
+	check(t, err)
+	if st.SchemaVersion != s.LatestVersion() || st.Size <= 0 || st.OldestPosted == "" {
+		t.Errorf("Stats = %+v", st)
+	}
+	rows := make(map[string]int64)
+	for _, tr := range st.Tables {
+		rows[tr.Table] = tr.Rows
+	}
+	if rows["videos_posted"] != 1 || rows["schema_version"] != int64(s.LatestVersion()) {
+		t.Errorf("Stats tables = %v", rows)
+	}
+
+	migrations, err := s.Migrations()
+	check(t, err)
+	if len(migrations) != s.LatestVersion() || migrations[0].Description != "initial schema" {
+		t.Errorf("Migrations = %+v", migrations)
+	}
+}
+
+func check(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
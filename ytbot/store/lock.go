@@ -0,0 +1,50 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// The run lock is the one row of the run_lock table. Its methods always use
+// the database itself, so a dry run still takes the lock.
+
+func (s *sqlStore) AcquireLock(holder string, timeout time.Duration) (bool, error) {
+	res, err := s.db.Exec(s.d.rebind(fmt.Sprintf(
+		`INSERT INTO run_lock (id, holder, heartbeat) VALUES (1, ?, %s)
+		 ON CONFLICT(id) DO UPDATE SET holder=excluded.holder, heartbeat=excluded.heartbeat
+		 WHERE run_lock.heartbeat < %s;`, s.d.now(), s.d.ago(timeout))),
+		holder)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func (s *sqlStore) LockHolder() (string, time.Time, bool, error) {
+	var holder, heartbeat string
+	err := s.db.QueryRow(`SELECT holder, heartbeat FROM run_lock WHERE id = 1;`).Scan(&holder, &heartbeat)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", time.Time{}, false, nil
+	}
+	if err != nil {
+		return "", time.Time{}, false, err
+	}
+	t, err := parseTime(sql.NullString{String: heartbeat, Valid: true})
+	return holder, t, true, err
+}
+
+func (s *sqlStore) HeartbeatLock(holder string) error {
+	res, err := s.db.Exec(s.d.rebind(fmt.Sprintf(`UPDATE run_lock SET heartbeat=%s WHERE id = 1 AND holder=?;`, s.d.now())), holder)
+	if err != nil {
+		return err
+	}
+	return rowChanged(res)
+}
+
+func (s *sqlStore) ReleaseLock(holder string) error {
+	_, err := s.db.Exec(s.d.rebind(`DELETE FROM run_lock WHERE id = 1 AND holder=?;`), holder)
+	return err
+}
@@ -0,0 +1,112 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+func (s *queries) TrackMessage(m TrackedMessage) error {
+	_, err := s.exec(fmt.Sprintf(
+		`INSERT INTO tracked_messages (video_id, webhook, message_id, title, content, posted_at, edits, thread_id, render) VALUES (?, ?, ?, ?, ?, %s, 0, ?, ?)
+		 ON CONFLICT(video_id, webhook) DO UPDATE SET message_id=excluded.message_id, title=excluded.title,
+		 	content=excluded.content, posted_at=excluded.posted_at, edits=0, dead_at=NULL, thread_id=excluded.thread_id, render=excluded.render;`, s.d.now()),
+		m.VideoID, m.Webhook, m.MessageID, m.Title, m.Content, nullString(m.ThreadID), nullString(m.Render))
+	return err
+}
+
+func (s *queries) SetMessageThread(videoId, webhook, threadId string) error {
+	_, err := s.exec(`UPDATE tracked_messages SET thread_id=? WHERE video_id=? AND webhook=?;`, threadId, videoId, webhook)
+	return err
+}
+
+func (s *queries) MessagesToRetitle(within time.Duration, maxEdits int, excludePrefix string) ([]TrackedMessage, error) {
+	return s.trackedMessages(fmt.Sprintf(
+		`WHERE posted_at >= %s AND edits < ? AND dead_at IS NULL AND title != '' AND video_id NOT LIKE ?`, s.d.ago(within)),
+		maxEdits, excludePrefix+"%")
+}
+
+func (s *queries) LiveMessages(excludePrefix string) ([]TrackedMessage, error) {
+	return s.trackedMessages(`WHERE dead_at IS NULL AND video_id NOT LIKE ?`, excludePrefix+"%")
+}
+
+// trackedMessages reads the tracked messages matching where
+func (s *queries) trackedMessages(where string, args ...any) ([]TrackedMessage, error) {
+	rows, err := s.query(`SELECT video_id, webhook, message_id, title, content, edits, thread_id, render FROM tracked_messages `+where+`;`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []TrackedMessage
+	for rows.Next() {
+		var (
+			m                TrackedMessage
+			threadId, render sql.NullString
+		)
+		err = rows.Scan(&m.VideoID, &m.Webhook, &m.MessageID, &m.Title, &m.Content, &m.Edits, &threadId, &render)
+		if err != nil {
+			return nil, err
+		}
+		m.ThreadID, m.Render = threadId.String, render.String
+		messages = append(messages, m)
+	}
+	return messages, rows.Err()
+}
+
+func (s *queries) RetitleMessage(videoId, webhook, title, content, render string) error {
+	return s.execOne(`UPDATE tracked_messages SET title=?, content=?, render=?, edits=tracked_messages.edits+1 WHERE video_id=? AND webhook=?;`,
+		title, content, nullString(render), videoId, webhook)
+}
+
+func (s *queries) MarkMessageDead(videoId, webhook string) error {
+	return s.execOne(fmt.Sprintf(`UPDATE tracked_messages SET dead_at=%s WHERE video_id=? AND webhook=?;`, s.d.now()), videoId, webhook)
+}
+
+func (s *queries) SearchPostsToday(searchId string) (int, error) {
+	var n int
+	err := s.queryRow(fmt.Sprintf(`SELECT COUNT(*) FROM search_posts WHERE search_id=? AND substr(posted_at, 1, 10)=%s;`, s.d.today()), searchId).Scan(&n)
+	return n, err
+}
+
+func (s *queries) RecordSearchPost(searchId, videoId string) error {
+	_, err := s.exec(fmt.Sprintf(
+		`INSERT INTO search_posts (search_id, video_id, posted_at) VALUES (?, ?, %s)
+		 ON CONFLICT(search_id, video_id) DO UPDATE SET posted_at=excluded.posted_at;`, s.d.now()), searchId, videoId)
+	return err
+}
+
+func (s *queries) QuotaUsed(day string) (int, error) {
+	var used int
+	err := s.queryRow(`SELECT COALESCE(SUM(units), 0) FROM quota_usage WHERE date=?;`, day).Scan(&used)
+	return used, err
+}
+
+// SpendQuota qualifies the existing row's columns, which Postgres needs to
+// tell them from excluded's
+func (s *queries) SpendQuota(day, call string, units int) error {
+	_, err := s.exec(
+		`INSERT INTO quota_usage (date, call, calls, units) VALUES (?, ?, 1, ?)
+		 ON CONFLICT(date, call) DO UPDATE SET calls=quota_usage.calls+1, units=quota_usage.units+excluded.units;`,
+		day, call, units)
+	return err
+}
+
+func (s *queries) QuotaUsage(day string) ([]QuotaUsage, error) {
+	rows, err := s.query(`SELECT call, calls, units FROM quota_usage WHERE date=? ORDER BY units DESC, call;`, day)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var usage []QuotaUsage
+	for rows.Next() {
+		var u QuotaUsage
+		err = rows.Scan(&u.Call, &u.Calls, &u.Units)
+		if err != nil {
+			return nil, err
+		}
+		usage = append(usage, u)
+	}
+	return usage, rows.Err()
+}
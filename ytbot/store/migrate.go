@@ -0,0 +1,193 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+)
+
+// migration changes the schema from the version before it to its own
+type migration struct {
+	description string
+	migrate     func(tx *sql.Tx, d dialect, opts Options) error
+}
+
+// migrations are applied in order, each in its own transaction, and never
+// changed once released. A database's schema version is the number of them
+// applied, recorded in the schema_version table. Each is the same on every
+// database, apart from the initial schema.
+var migrations = []migration{
+	{"initial schema", migrateInitialSchema},
+	{"video metadata", migrateVideoMetadata},
+	{"run lock", migrateRunLock},
+	{"message renders", migrateMessageRenders},
+}
+
+// setUp brings the schema up to date before anything else uses the
+// database. The channels table is seeded from the built-in list when it's
+// created.
+func (s *sqlStore) setUp(opts Options) error {
+	exists, err := s.d.tableExists(s.db, "channels")
+	if err != nil {
+		return err
+	}
+	err = s.migrate(opts)
+	if err != nil {
+		return fmt.Errorf("error migrating schema: %w", err)
+	}
+	if exists || !opts.SeedBuiltin {
+		return nil
+	}
+	log.Info().Int("channels", len(opts.Builtin)).Msg("seeding channels table from built-in channel list")
+	for _, c := range opts.Builtin {
+		err = s.AddChannel(c)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *sqlStore) SchemaVersion() (int, error) {
+	return schemaVersion(s.db)
+}
+
+// schemaVersion returns the version of the database's schema, 0 for a
+// database that has never been migrated
+func schemaVersion(q querier) (int, error) {
+	var version int
+	err := q.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_version;`).Scan(&version)
+	return version, err
+}
+
+func (s *sqlStore) LatestVersion() int {
+	return len(migrations)
+}
+
+func (s *sqlStore) Migrations() ([]Migration, error) {
+	rows, err := s.db.Query(`SELECT version, applied_at, description FROM schema_version ORDER BY version;`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var applied []Migration
+	for rows.Next() {
+		var m Migration
+		err = rows.Scan(&m.Version, &m.AppliedAt, &m.Description)
+		if err != nil {
+			return nil, err
+		}
+		applied = append(applied, m)
+	}
+	return applied, rows.Err()
+}
+
+// migrate applies the migrations the database hasn't had yet. A database
+// from a newer ytbot, with migrations this one doesn't know about, is refused
+// rather than used with a schema it doesn't understand.
+func (s *sqlStore) migrate(opts Options) error {
+	_, err := s.db.Exec(
+		`CREATE TABLE IF NOT EXISTS schema_version (
+			version INTEGER PRIMARY KEY,
+			description TEXT NOT NULL,
+			applied_at TEXT NOT NULL
+		 );`)
+	if err != nil {
+		return err
+	}
+	version, err := s.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	if version > len(migrations) {
+		return fmt.Errorf("database schema version %d is newer than this version of ytbot supports (%d), upgrade ytbot", version, len(migrations))
+	}
+	for i := version; i < len(migrations); i++ {
+		err = s.applyMigration(i+1, migrations[i], opts)
+		if err != nil {
+			return fmt.Errorf("error applying migration %d (%s): %w", i+1, migrations[i].description, err)
+		}
+	}
+	return nil
+}
+
+// applyMigration applies a migration and records it, together or not at all.
+// Another instance may have applied it since the version was read, in which
+// case it's left alone.
+func (s *sqlStore) applyMigration(version int, m migration, opts Options) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	current, err := schemaVersion(tx)
+	if err != nil {
+		return err
+	}
+	if current >= version {
+		return nil
+	}
+	log.Info().Int("version", version).Str("migration", m.description).Msg("migrating database schema")
+	err = m.migrate(tx, s.d, opts)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(s.d.rebind(fmt.Sprintf(`INSERT INTO schema_version (version, description, applied_at) VALUES (?, ?, %s);`, s.d.now())), version, m.description)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// migrateInitialSchema is migration 1, which each database has its own of
+func migrateInitialSchema(tx *sql.Tx, d dialect, opts Options) error {
+	return d.initialSchema(tx, opts)
+}
+
+// migrateVideoMetadata is migration 2, recording more about each video in
+// videos_posted, for reports and for finding out why a video was or wasn't
+// posted. Videos recorded before it have NULLs for them.
+func migrateVideoMetadata(tx *sql.Tx, d dialect, opts Options) error {
+	for _, stmt := range []string{
+		`ALTER TABLE videos_posted ADD COLUMN channel_title TEXT;`,
+		`ALTER TABLE videos_posted ADD COLUMN published_at TEXT;`,
+		`ALTER TABLE videos_posted ADD COLUMN posted_at TEXT;`,
+		`ALTER TABLE videos_posted ADD COLUMN webhook TEXT;`,
+		`CREATE INDEX videos_posted_channel_posted_at ON videos_posted (channel_id, posted_at);`,
+	} {
+		_, err := tx.Exec(stmt)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateRunLock is migration 3, the run_lock table, which versions of ytbot
+// before it created when taking the lock, so it may already exist
+func migrateRunLock(tx *sql.Tx, d dialect, opts Options) error {
+	_, err := tx.Exec(
+		`CREATE TABLE IF NOT EXISTS run_lock (
+			id INTEGER PRIMARY KEY CHECK (id = 1),
+			holder TEXT NOT NULL,
+			heartbeat TEXT NOT NULL
+		 );`)
+	return err
+}
+
+// migrateMessageRenders is migration 4, keeping what each message was
+// rendered from, so it can be rendered again when its video's title changes.
+// Messages posted before it have NULLs, and are left as they are.
+func migrateMessageRenders(tx *sql.Tx, d dialect, opts Options) error {
+	for _, stmt := range []string{
+		`ALTER TABLE pending_posts ADD COLUMN render TEXT NOT NULL DEFAULT '';`,
+		`ALTER TABLE tracked_messages ADD COLUMN render TEXT;`,
+	} {
+		_, err := tx.Exec(stmt)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
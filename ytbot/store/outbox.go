@@ -0,0 +1,117 @@
+package store
+
+import (
+	"fmt"
+	"time"
+)
+
+func (s *queries) QueuePost(p PendingPost) error {
+	_, err := s.exec(fmt.Sprintf(
+		`INSERT INTO pending_posts (video_id, channel_id, webhook, content, mention_role_id, embeds, thread_name, flags, post_type, title, event, render, queued_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, %s)
+		 ON CONFLICT(video_id, webhook) DO NOTHING;`, s.d.now()),
+		p.VideoID, p.ChannelID, p.Webhook, p.Content, p.MentionRoleID, p.Embeds, p.ThreadName, p.Flags, p.PostType, p.Title, p.Event, p.Render)
+	return err
+}
+
+func (s *queries) PendingPosts() ([]PendingPost, error) {
+	rows, err := s.query(fmt.Sprintf(
+		`SELECT video_id, channel_id, webhook, content, mention_role_id, embeds, thread_name, flags, post_type, title, event, render, attempts, queued_at
+		 FROM pending_posts ORDER BY queued_at, %s;`, s.d.insertOrder()))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var posts []PendingPost
+	for rows.Next() {
+		var (
+			p        PendingPost
+			queuedAt string
+		)
+		err = rows.Scan(&p.VideoID, &p.ChannelID, &p.Webhook, &p.Content, &p.MentionRoleID, &p.Embeds, &p.ThreadName, &p.Flags, &p.PostType, &p.Title, &p.Event, &p.Render, &p.Attempts, &queuedAt)
+		if err != nil {
+			return nil, err
+		}
+		p.QueuedAt, err = time.Parse(TimeFormat, queuedAt)
+		if err != nil {
+			return nil, fmt.Errorf("invalid time %q queued for video %s: %w", queuedAt, p.VideoID, err)
+		}
+		posts = append(posts, p)
+	}
+	return posts, rows.Err()
+}
+
+func (s *queries) DeletePendingPost(videoId, webhook string) error {
+	_, err := s.exec(`DELETE FROM pending_posts WHERE video_id=? AND webhook=?;`, videoId, webhook)
+	return err
+}
+
+func (s *queries) SetPendingPostAttempts(videoId, webhook string, attempts int) error {
+	return s.execOne(`UPDATE pending_posts SET attempts=? WHERE video_id=? AND webhook=?;`, attempts, videoId, webhook)
+}
+
+func (s *queries) AddDigestItem(it DigestItem) error {
+	_, err := s.exec(fmt.Sprintf(
+		`INSERT INTO digest_items (video_id, webhook, forum, channel_id, channel_title, title, url, added_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, %s)
+		 ON CONFLICT(video_id, webhook) DO NOTHING;`, s.d.now()),
+		it.VideoID, it.Webhook, boolInt(it.Forum), it.ChannelID, it.ChannelTitle, it.Title, it.URL)
+	return err
+}
+
+func (s *queries) DigestItems() ([]DigestItem, error) {
+	rows, err := s.query(fmt.Sprintf(
+		`SELECT video_id, webhook, forum, channel_id, channel_title, title, url
+		 FROM digest_items ORDER BY added_at, %s;`, s.d.insertOrder()))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []DigestItem
+	for rows.Next() {
+		var it DigestItem
+		err = rows.Scan(&it.VideoID, &it.Webhook, &it.Forum, &it.ChannelID, &it.ChannelTitle, &it.Title, &it.URL)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, it)
+	}
+	return items, rows.Err()
+}
+
+func (s *queries) DeleteDigestItem(videoId, webhook string) error {
+	_, err := s.exec(`DELETE FROM digest_items WHERE video_id=? AND webhook=?;`, videoId, webhook)
+	return err
+}
+
+func (s *queries) DigestSent(dueAt time.Time) (bool, error) {
+	return s.sentSince("digests", dueAt)
+}
+
+func (s *queries) RecordDigest(dueAt time.Time, videos int) error {
+	return s.recordSent("digests", dueAt, videos)
+}
+
+func (s *queries) ReportSent(dueAt time.Time) (bool, error) {
+	return s.sentSince("reports", dueAt)
+}
+
+func (s *queries) RecordReport(dueAt time.Time, videos int) error {
+	return s.recordSent("reports", dueAt, videos)
+}
+
+// sentSince returns whether a digest or report due at dueAt, or later, is in table
+func (s *queries) sentSince(table string, dueAt time.Time) (bool, error) {
+	var n int
+	err := s.queryRow(fmt.Sprintf(`SELECT COUNT(*) FROM %s WHERE due_at >= ?;`, table), formatTime(dueAt)).Scan(&n)
+	return n > 0, err
+}
+
+// recordSent records a digest or report due at dueAt as sent now in table
+func (s *queries) recordSent(table string, dueAt time.Time, videos int) error {
+	_, err := s.exec(fmt.Sprintf(`INSERT INTO %s (due_at, sent_at, videos) VALUES (?, %s, ?) ON CONFLICT(due_at) DO NOTHING;`, table, s.d.now()),
+		formatTime(dueAt), videos)
+	return err
+}
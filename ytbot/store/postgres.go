@@ -0,0 +1,269 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "github.com/lib/pq"
+	"github.com/rs/zerolog/log"
+)
+
+// postgresDialect is Postgres's SQL. Timestamps are text in TimeFormat as on
+// sqlite, so now() is formatted to match, in UTC whatever the server's time
+// zone.
+type postgresDialect struct{}
+
+func (postgresDialect) name() string { return DriverPostgres }
+
+// rebind numbers the ? parameters outside of quoted strings as $1, $2, ...
+func (postgresDialect) rebind(query string) string {
+	var b strings.Builder
+	n, quoted := 0, false
+	for _, r := range query {
+		switch {
+		case r == '\'':
+			quoted = !quoted
+		case r == '?' && !quoted:
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func (postgresDialect) now() string {
+	return `to_char(now() AT TIME ZONE 'UTC', 'YYYY-MM-DD HH24:MI:SS')`
+}
+
+func (postgresDialect) ago(d time.Duration) string {
+	return fmt.Sprintf(`to_char((now() - interval '%d seconds') AT TIME ZONE 'UTC', 'YYYY-MM-DD HH24:MI:SS')`, seconds(d))
+}
+
+func (postgresDialect) today() string {
+	return `to_char(now() AT TIME ZONE 'UTC', 'YYYY-MM-DD')`
+}
+
+// insertOrder is the seq column the tables read in insertion order have, as
+// Postgres has no rowid
+func (postgresDialect) insertOrder() string { return "seq" }
+
+func (postgresDialect) tableExists(q querier, table string) (bool, error) {
+	var n int
+	err := q.QueryRow(`SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = $1;`, table).Scan(&n)
+	return n > 0, err
+}
+
+func (postgresDialect) tables(q querier) ([]string, error) {
+	rows, err := q.Query(`SELECT table_name FROM information_schema.tables WHERE table_schema = current_schema() AND table_type = 'BASE TABLE' ORDER BY table_name;`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var tables []string
+	for rows.Next() {
+		var name string
+		err = rows.Scan(&name)
+		if err != nil {
+			return nil, err
+		}
+		tables = append(tables, name)
+	}
+	return tables, rows.Err()
+}
+
+// size returns the size of the whole database for both, as Postgres doesn't
+// say how much of it is free
+func (postgresDialect) size(q querier) (int64, int64, error) {
+	var size int64
+	err := q.QueryRow(`SELECT pg_database_size(current_database());`).Scan(&size)
+	return size, size, err
+}
+
+func (postgresDialect) vacuum(x execer) error {
+	_, err := x.Exec(`VACUUM;`)
+	return err
+}
+
+// openPostgres opens the Postgres database at dsn, a connection string like
+// postgres://ytbot@localhost/ytbot
+func openPostgres(dsn string, opts Options) (Store, error) {
+	log.Debug().Msg("opening postgres database")
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+	err = db.Ping()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	s := newSQLStore(postgresDialect{}, db)
+	err = s.setUp(opts)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// initialSchema is migration 1, the schema sqlite databases had when
+// migrations were versioned. Postgres databases never had anything older.
+// Booleans are INTEGERs, as on sqlite, so both take the same queries.
+func (postgresDialect) initialSchema(tx *sql.Tx, opts Options) error {
+	for _, stmt := range []string{
+		`CREATE TABLE videos_posted (
+			id TEXT PRIMARY KEY,
+			date_posted TEXT NOT NULL,
+			post_type TEXT NOT NULL DEFAULT 'video',
+			channel_id TEXT NOT NULL DEFAULT '',
+			title TEXT NOT NULL DEFAULT '',
+			platform TEXT NOT NULL DEFAULT 'youtube',
+			discord_message_id TEXT,
+			discord_channel_id TEXT,
+			discord_thread_id TEXT
+		 );`,
+		`CREATE TABLE channel_check_times (
+			id TEXT PRIMARY KEY,
+			date_checked TEXT NOT NULL,
+			next_check_at TEXT,
+			last_seen_at TEXT
+		 );`,
+		`CREATE TABLE pending_posts (
+			seq BIGSERIAL,
+			video_id TEXT NOT NULL,
+			channel_id TEXT NOT NULL,
+			webhook TEXT NOT NULL,
+			content TEXT NOT NULL,
+			mention_role_id TEXT NOT NULL DEFAULT '',
+			queued_at TEXT NOT NULL,
+			embeds TEXT NOT NULL DEFAULT '',
+			post_type TEXT NOT NULL DEFAULT 'video',
+			title TEXT NOT NULL DEFAULT '',
+			attempts INTEGER NOT NULL DEFAULT 0,
+			thread_name TEXT NOT NULL DEFAULT '',
+			event TEXT NOT NULL DEFAULT '',
+			flags INTEGER NOT NULL DEFAULT 0,
+			PRIMARY KEY (video_id, webhook)
+		 );`,
+		`CREATE TABLE digest_items (
+			seq BIGSERIAL,
+			video_id TEXT NOT NULL,
+			webhook TEXT NOT NULL,
+			forum INTEGER NOT NULL DEFAULT 0,
+			channel_id TEXT NOT NULL,
+			channel_title TEXT NOT NULL DEFAULT '',
+			title TEXT NOT NULL DEFAULT '',
+			url TEXT NOT NULL,
+			added_at TEXT NOT NULL,
+			PRIMARY KEY (video_id, webhook)
+		 );`,
+		`CREATE TABLE digests (
+			due_at TEXT NOT NULL PRIMARY KEY,
+			sent_at TEXT NOT NULL,
+			videos INTEGER NOT NULL
+		 );`,
+		`CREATE TABLE reports (
+			due_at TEXT NOT NULL PRIMARY KEY,
+			sent_at TEXT NOT NULL,
+			videos INTEGER NOT NULL
+		 );`,
+		`CREATE TABLE channel_uploads (
+			channel_id TEXT NOT NULL,
+			video_id TEXT NOT NULL,
+			published_at TEXT NOT NULL,
+			PRIMARY KEY (channel_id, video_id)
+		 );`,
+		`CREATE TABLE channel_meta (
+			id TEXT PRIMARY KEY,
+			title TEXT NOT NULL DEFAULT '',
+			uploads_playlist_id TEXT NOT NULL DEFAULT '',
+			thumbnail_url TEXT NOT NULL DEFAULT '',
+			fetched_at TEXT NOT NULL DEFAULT '',
+			embed_color INTEGER
+		 );`,
+		`CREATE TABLE playlist_items (
+			playlist_id TEXT NOT NULL,
+			video_id TEXT NOT NULL,
+			seen_at TEXT NOT NULL,
+			PRIMARY KEY (playlist_id, video_id)
+		 );`,
+		`CREATE TABLE channel_feeds (
+			channel_id TEXT PRIMARY KEY,
+			etag TEXT NOT NULL DEFAULT '',
+			last_modified TEXT NOT NULL DEFAULT ''
+		 );`,
+		`CREATE TABLE list_etags (
+			channel_id TEXT PRIMARY KEY,
+			etag TEXT NOT NULL DEFAULT ''
+		 );`,
+		`CREATE TABLE channel_failures (
+			channel_id TEXT PRIMARY KEY,
+			failures INTEGER NOT NULL DEFAULT 0,
+			last_error TEXT NOT NULL DEFAULT '',
+			tripped_at TEXT
+		 );`,
+		`CREATE TABLE upcoming_streams (
+			video_id TEXT PRIMARY KEY,
+			channel_id TEXT NOT NULL,
+			scheduled_start TEXT NOT NULL,
+			announced_at TEXT NOT NULL,
+			live_at TEXT
+		 );`,
+		`CREATE TABLE view_candidates (
+			channel_id TEXT NOT NULL,
+			video_id TEXT NOT NULL,
+			published_at TEXT NOT NULL,
+			views BIGINT NOT NULL DEFAULT 0,
+			checked_at TEXT NOT NULL,
+			PRIMARY KEY (channel_id, video_id)
+		 );`,
+		`CREATE TABLE websub_subscriptions (
+			channel_id TEXT PRIMARY KEY,
+			requested_at TEXT NOT NULL,
+			expires_at TEXT
+		 );`,
+		`CREATE TABLE quota_usage (
+			date TEXT NOT NULL,
+			call TEXT NOT NULL,
+			calls INTEGER NOT NULL,
+			units INTEGER NOT NULL,
+			PRIMARY KEY (date, call)
+		 );`,
+		`CREATE TABLE search_posts (
+			search_id TEXT NOT NULL,
+			video_id TEXT NOT NULL,
+			posted_at TEXT NOT NULL,
+			PRIMARY KEY (search_id, video_id)
+		 );`,
+		`CREATE TABLE tracked_messages (
+			video_id TEXT NOT NULL,
+			webhook TEXT NOT NULL,
+			message_id TEXT NOT NULL,
+			title TEXT NOT NULL,
+			content TEXT NOT NULL,
+			posted_at TEXT NOT NULL,
+			edits INTEGER NOT NULL DEFAULT 0,
+			dead_at TEXT,
+			thread_id TEXT,
+			PRIMARY KEY (video_id, webhook)
+		 );`,
+		`CREATE TABLE channels (
+			id TEXT PRIMARY KEY,
+			name TEXT NOT NULL,
+			added_at TEXT NOT NULL,
+			enabled INTEGER NOT NULL DEFAULT 1,
+			source TEXT NOT NULL DEFAULT 'db'
+		 );`,
+	} {
+		_, err := tx.Exec(stmt)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
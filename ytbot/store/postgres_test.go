@@ -0,0 +1,77 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestPostgres runs the conformance tests against the Postgres server at
+// $YTBOT_TEST_POSTGRES_DSN, each in a schema of its own that's dropped after
+func TestPostgres(t *testing.T) {
+	dsn := os.Getenv("YTBOT_TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("set YTBOT_TEST_POSTGRES_DSN to test against a Postgres server")
+	}
+	admin, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer admin.Close()
+
+	n := 0
+	testStore(t, func(t *testing.T) Store {
+		n++
+		schema := fmt.Sprintf("ytbot_test_%d_%d", time.Now().UnixNano(), n)
+		_, err := admin.Exec(`CREATE SCHEMA ` + schema + `;`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			_, err := admin.Exec(`DROP SCHEMA ` + schema + ` CASCADE;`)
+			if err != nil {
+				t.Error(err)
+			}
+		})
+		s, err := Open(DriverPostgres, withSearchPath(t, dsn, schema), Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	})
+}
+
+// withSearchPath adds search_path to a URL or key=value connection string
+func withSearchPath(t *testing.T, dsn, schema string) string {
+	if !strings.HasPrefix(dsn, "postgres://") && !strings.HasPrefix(dsn, "postgresql://") {
+		return dsn + " search_path=" + schema
+	}
+	u, err := url.Parse(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	q.Set("search_path", schema)
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+func TestPostgresRebind(t *testing.T) {
+	for _, test := range []struct {
+		query, want string
+	}{
+		{`SELECT 1;`, `SELECT 1;`},
+		{`SELECT * FROM t WHERE a=? AND b=?;`, `SELECT * FROM t WHERE a=$1 AND b=$2;`},
+		{`SELECT * FROM t WHERE a='?' AND b=?;`, `SELECT * FROM t WHERE a='?' AND b=$1;`},
+		{`UPDATE t SET a='it''s?', b=? WHERE c=?;`, `UPDATE t SET a='it''s?', b=$1 WHERE c=$2;`},
+	} {
+		got := postgresDialect{}.rebind(test.query)
+		if got != test.want {
+			t.Errorf("rebind(%q) = %q, want %q", test.query, got, test.want)
+		}
+	}
+}
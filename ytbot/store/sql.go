@@ -0,0 +1,211 @@
+package store
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// dialect is what differs between the databases a store can use: how they
+// work out the time, number their parameters and describe themselves
+type dialect interface {
+	name() string
+
+	// rebind rewrites a query's ? parameters into the database's own
+	rebind(query string) string
+
+	// now is an expression for the current time in TimeFormat
+	now() string
+
+	// ago is an expression for the time d before now in TimeFormat
+	ago(d time.Duration) string
+
+	// today is an expression for the current date (UTC) as YYYY-MM-DD
+	today() string
+
+	// insertOrder is a column that orders a table's rows as they were inserted
+	insertOrder() string
+
+	// initialSchema applies migration 1, the schema as it was before
+	// migrations were versioned
+	initialSchema(tx *sql.Tx, opts Options) error
+
+	tableExists(q querier, table string) (bool, error)
+	tables(q querier) ([]string, error)
+
+	// size returns how big the database is, and how much of it is in use
+	size(q querier) (int64, int64, error)
+
+	// vacuum gives the space freed by deleting rows back
+	vacuum(x execer) error
+}
+
+// execer is the part of *sql.DB and *sql.Tx used to write to the database, so
+// that writes can be skipped in dry-run mode. Writes only ever go through
+// Exec, as a write with Query leaves its rows open, holding a connection and
+// a statement, until they're closed.
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// querier is the part of *sql.DB and *sql.Tx used to read from the database
+type querier interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// dryRunExecer logs writes instead of making them
+type dryRunExecer struct{}
+
+func (dryRunExecer) Exec(query string, args ...any) (sql.Result, error) {
+	log.Debug().Str("query", strings.Join(strings.Fields(query), " ")).Interface("args", args).Msg("dry run, not writing to db")
+	return driver.RowsAffected(0), nil
+}
+
+// queries makes the reads and writes of Queries, on the database or in a
+// transaction. Reads go through q and writes through x, so that in a dry
+// run the writes are logged.
+type queries struct {
+	d      dialect
+	q      querier
+	x      execer
+	dryRun bool
+}
+
+func (s *queries) exec(query string, args ...any) (sql.Result, error) {
+	return s.x.Exec(s.d.rebind(query), args...)
+}
+
+func (s *queries) query(query string, args ...any) (*sql.Rows, error) {
+	return s.q.Query(s.d.rebind(query), args...)
+}
+
+func (s *queries) queryRow(query string, args ...any) *sql.Row {
+	return s.q.QueryRow(s.d.rebind(query), args...)
+}
+
+// execOne makes a write that must change a row, returning ErrNoRowChanged
+// if it didn't. Dry runs change nothing, so aren't checked.
+func (s *queries) execOne(query string, args ...any) error {
+	res, err := s.exec(query, args...)
+	if err != nil {
+		return err
+	}
+	if s.dryRun {
+		return nil
+	}
+	return rowChanged(res)
+}
+
+// rowChanged returns ErrNoRowChanged if a write changed no row
+func rowChanged(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNoRowChanged
+	}
+	return nil
+}
+
+// sqlStore is a Store on a database/sql database
+type sqlStore struct {
+	queries
+	db *sql.DB
+}
+
+func newSQLStore(d dialect, db *sql.DB) *sqlStore {
+	return &sqlStore{queries: queries{d: d, q: db, x: db}, db: db}
+}
+
+func (s *sqlStore) DryRun() Store {
+	return &sqlStore{queries: queries{d: s.d, q: s.db, x: dryRunExecer{}, dryRun: true}, db: s.db}
+}
+
+func (s *sqlStore) Begin() (Tx, error) {
+	if s.dryRun {
+		return &sqlTx{queries: s.queries}, nil
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &sqlTx{queries: queries{d: s.d, q: tx, x: tx}, tx: tx}, nil
+}
+
+func (s *sqlStore) Close() error {
+	return s.db.Close()
+}
+
+// sqlTx is a Tx on a database/sql database
+type sqlTx struct {
+	queries
+	tx *sql.Tx // nil in dry-run mode
+}
+
+func (t *sqlTx) Commit() error {
+	if t.tx == nil {
+		return nil
+	}
+	return t.tx.Commit()
+}
+
+func (t *sqlTx) Rollback() error {
+	if t.tx == nil {
+		return nil
+	}
+	err := t.tx.Rollback()
+	if errors.Is(err, sql.ErrTxDone) {
+		return nil
+	}
+	return err
+}
+
+// formatTime formats t as it's stored
+func formatTime(t time.Time) string {
+	return t.UTC().Format(TimeFormat)
+}
+
+// nullTime formats t as it's stored, or NULL if it's zero
+func nullTime(t time.Time) sql.NullString {
+	if t.IsZero() {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: formatTime(t), Valid: true}
+}
+
+// parseTime parses a stored timestamp, the zero time for NULL
+func parseTime(s sql.NullString) (time.Time, error) {
+	if !s.Valid {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(TimeFormat, s.String)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q in db: %w", s.String, err)
+	}
+	return t, nil
+}
+
+// nullString is s, or NULL if it's empty
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// boolInt is b as it's stored, in an INTEGER column
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// seconds is d in whole seconds, for ago
+func seconds(d time.Duration) int64 {
+	return int64(d / time.Second)
+}
@@ -0,0 +1,625 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	_ "modernc.org/sqlite"
+)
+
+// sqliteDialect is SQLite's SQL, the default database
+type sqliteDialect struct{}
+
+func (sqliteDialect) name() string { return DriverSQLite }
+
+func (sqliteDialect) rebind(query string) string { return query }
+
+func (sqliteDialect) now() string { return `datetime('now')` }
+
+func (sqliteDialect) ago(d time.Duration) string {
+	return fmt.Sprintf(`datetime('now', '-%d seconds')`, seconds(d))
+}
+
+func (sqliteDialect) today() string { return `date('now')` }
+
+func (sqliteDialect) insertOrder() string { return "rowid" }
+
+func (sqliteDialect) tableExists(q querier, table string) (bool, error) {
+	var n int
+	err := q.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name=?;`, table).Scan(&n)
+	return n > 0, err
+}
+
+func (sqliteDialect) tables(q querier) ([]string, error) {
+	rows, err := q.Query(`SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' ORDER BY name;`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var tables []string
+	for rows.Next() {
+		var name string
+		err = rows.Scan(&name)
+		if err != nil {
+			return nil, err
+		}
+		tables = append(tables, name)
+	}
+	return tables, rows.Err()
+}
+
+func (sqliteDialect) size(q querier) (int64, int64, error) {
+	var pageCount, pageSize, freePages int64
+	err := q.QueryRow(`SELECT page_count, page_size, freelist_count FROM pragma_page_count(), pragma_page_size(), pragma_freelist_count();`).
+		Scan(&pageCount, &pageSize, &freePages)
+	return pageCount * pageSize, (pageCount - freePages) * pageSize, err
+}
+
+func (sqliteDialect) vacuum(x execer) error {
+	_, err := x.Exec(`VACUUM;`)
+	return err
+}
+
+// sqliteDSN returns the data source name for the database at path. The
+// pragmas are run by the driver on every connection it opens, as
+// busy_timeout and foreign_keys only last as long as the connection.
+func sqliteDSN(path string, opts Options) string {
+	pragmas := url.Values{}
+	pragmas.Add("_pragma", fmt.Sprintf("busy_timeout(%d)", opts.BusyTimeout.Milliseconds()))
+	pragmas.Add("_pragma", "foreign_keys(1)")
+	pragmas.Add("_pragma", fmt.Sprintf("journal_mode(%s)", opts.JournalMode))
+	return path + "?" + pragmas.Encode()
+}
+
+// openSQLite opens the sqlite database at path
+func openSQLite(path string, opts Options) (Store, error) {
+	log := log.With().Str("db", path).Logger()
+	log.Debug().Msg("opening sqlite database")
+	db, err := sql.Open("sqlite", sqliteDSN(path, opts))
+	if err != nil {
+		return nil, err
+	}
+
+	// sqlite silently keeps its old journal mode if it can't switch, e.g. for
+	// an in-memory database, so say which one is in use
+	var journalMode string
+	err = db.QueryRow(`PRAGMA journal_mode;`).Scan(&journalMode)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	log.Debug().Str("journal_mode", journalMode).Dur("busy_timeout", opts.BusyTimeout).Msg("opened sqlite database")
+	if !strings.EqualFold(journalMode, opts.JournalMode) {
+		log.Warn().Str("journal_mode", journalMode).Str("db_journal_mode", opts.JournalMode).Msg("couldn't switch database to --db-journal-mode")
+	}
+
+	s := newSQLStore(sqliteDialect{}, db)
+	err = s.setUp(opts)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// initialSchema is migration 1, the schema as it was before migrations were
+// versioned. Databases created before then are at any point along the way, so
+// it creates whatever tables and columns are missing.
+func (sqliteDialect) initialSchema(tx *sql.Tx, opts Options) error {
+	var exists int
+
+	// create videos_posted table if required
+	log.Debug().Msg("creating videos_posted table if required")
+	_, err := tx.Exec(
+		`CREATE TABLE IF NOT EXISTS videos_posted (
+			id TEXT PRIMARY KEY UNIQUE,
+			date_posted TEXT NOT NULL
+		 ) WITHOUT ROWID;`)
+	if err != nil {
+		return err
+	}
+
+	// add post_type column to databases created before it existed
+	_, err = addColumnIfMissing(tx, "videos_posted", "post_type", "TEXT NOT NULL DEFAULT 'video'")
+	if err != nil {
+		return err
+	}
+
+	// add channel_id and title columns to databases created before they were
+	// recorded for spotting re-uploads
+	_, err = addColumnIfMissing(tx, "videos_posted", "channel_id", "TEXT NOT NULL DEFAULT ''")
+	if err != nil {
+		return err
+	}
+	_, err = addColumnIfMissing(tx, "videos_posted", "title", "TEXT NOT NULL DEFAULT ''")
+	if err != nil {
+		return err
+	}
+
+	// add platform column to databases created before videos from other
+	// platforms, whose IDs are prefixed with the platform, were posted
+	_, err = addColumnIfMissing(tx, "videos_posted", "platform", "TEXT NOT NULL DEFAULT 'youtube'")
+	if err != nil {
+		return err
+	}
+
+	// add discord_message_id and discord_channel_id columns, for the message
+	// a video was posted as when Discord returned it
+	_, err = addColumnIfMissing(tx, "videos_posted", "discord_message_id", "TEXT")
+	if err != nil {
+		return err
+	}
+	_, err = addColumnIfMissing(tx, "videos_posted", "discord_channel_id", "TEXT")
+	if err != nil {
+		return err
+	}
+
+	// add discord_thread_id column, for the thread started under the message
+	// with --create-threads
+	_, err = addColumnIfMissing(tx, "videos_posted", "discord_thread_id", "TEXT")
+	if err != nil {
+		return err
+	}
+
+	// create channel_check times
+	log.Debug().Msg("creating channel_check_times table if required")
+	_, err = tx.Exec(
+		`CREATE TABLE IF NOT EXISTS channel_check_times (
+			id TEXT PRIMARY KEY UNIQUE,
+			date_checked TEXT NOT NULL
+		 ) WITHOUT ROWID;`)
+	if err != nil {
+		return err
+	}
+
+	// add next_check_at column to databases created before checks were scheduled
+	_, err = addColumnIfMissing(tx, "channel_check_times", "next_check_at", "TEXT")
+	if err != nil {
+		return err
+	}
+
+	// create pending_posts table, the outbox of posts waiting to be delivered
+	log.Debug().Msg("creating pending_posts table if required")
+	createPendingPosts :=
+		`CREATE TABLE IF NOT EXISTS pending_posts (
+			video_id TEXT NOT NULL,
+			channel_id TEXT NOT NULL,
+			webhook TEXT NOT NULL,
+			content TEXT NOT NULL,
+			mention_role_id TEXT NOT NULL DEFAULT '',
+			queued_at TEXT NOT NULL,
+			embeds TEXT NOT NULL DEFAULT '',
+			post_type TEXT NOT NULL DEFAULT 'video',
+			title TEXT NOT NULL DEFAULT '',
+			attempts INTEGER NOT NULL DEFAULT 0,
+			thread_name TEXT NOT NULL DEFAULT '',
+			event TEXT NOT NULL DEFAULT '',
+			flags INTEGER NOT NULL DEFAULT 0,
+			PRIMARY KEY (video_id, webhook)
+		 );`
+	_, err = tx.Exec(createPendingPosts)
+	if err != nil {
+		return err
+	}
+	_, err = addColumnIfMissing(tx, "pending_posts", "embeds", "TEXT NOT NULL DEFAULT ''")
+	if err != nil {
+		return err
+	}
+
+	// add the columns needed to deliver every post through the outbox, not
+	// just those queued during quiet hours
+	_, err = addColumnIfMissing(tx, "pending_posts", "post_type", "TEXT NOT NULL DEFAULT 'video'")
+	if err != nil {
+		return err
+	}
+	_, err = addColumnIfMissing(tx, "pending_posts", "title", "TEXT NOT NULL DEFAULT ''")
+	if err != nil {
+		return err
+	}
+	_, err = addColumnIfMissing(tx, "pending_posts", "attempts", "INTEGER NOT NULL DEFAULT 0")
+	if err != nil {
+		return err
+	}
+
+	// add thread_name column, for posts to forum channels
+	_, err = addColumnIfMissing(tx, "pending_posts", "thread_name", "TEXT NOT NULL DEFAULT ''")
+	if err != nil {
+		return err
+	}
+
+	// add event column, for posts to HTTP sinks
+	_, err = addColumnIfMissing(tx, "pending_posts", "event", "TEXT NOT NULL DEFAULT ''")
+	if err != nil {
+		return err
+	}
+
+	// add flags column, for --suppress-embeds
+	_, err = addColumnIfMissing(tx, "pending_posts", "flags", "INTEGER NOT NULL DEFAULT 0")
+	if err != nil {
+		return err
+	}
+
+	// queue posts per webhook, for posting to more than one
+	err = addToPrimaryKey(tx, "pending_posts", "webhook", createPendingPosts)
+	if err != nil {
+		return err
+	}
+
+	// create digest_items table, the uploads waiting for the next --digest
+	log.Debug().Msg("creating digest_items table if required")
+	_, err = tx.Exec(
+		`CREATE TABLE IF NOT EXISTS digest_items (
+			video_id TEXT NOT NULL,
+			webhook TEXT NOT NULL,
+			forum INTEGER NOT NULL DEFAULT 0,
+			channel_id TEXT NOT NULL,
+			channel_title TEXT NOT NULL DEFAULT '',
+			title TEXT NOT NULL DEFAULT '',
+			url TEXT NOT NULL,
+			added_at TEXT NOT NULL,
+			PRIMARY KEY (video_id, webhook)
+		 );`)
+	if err != nil {
+		return err
+	}
+
+	// create digests table, recording each digest sent so one isn't sent twice
+	log.Debug().Msg("creating digests table if required")
+	_, err = tx.Exec(
+		`CREATE TABLE IF NOT EXISTS digests (
+			due_at TEXT NOT NULL PRIMARY KEY,
+			sent_at TEXT NOT NULL,
+			videos INTEGER NOT NULL
+		 );`)
+	if err != nil {
+		return err
+	}
+
+	// create reports table, recording each scheduled report sent so one isn't sent twice
+	log.Debug().Msg("creating reports table if required")
+	_, err = tx.Exec(
+		`CREATE TABLE IF NOT EXISTS reports (
+			due_at TEXT NOT NULL PRIMARY KEY,
+			sent_at TEXT NOT NULL,
+			videos INTEGER NOT NULL
+		 );`)
+	if err != nil {
+		return err
+	}
+
+	// create channel_uploads table, for working out how often channels upload
+	log.Debug().Msg("creating channel_uploads table if required")
+	_, err = tx.Exec(
+		`CREATE TABLE IF NOT EXISTS channel_uploads (
+			channel_id TEXT NOT NULL,
+			video_id TEXT NOT NULL,
+			published_at TEXT NOT NULL,
+			PRIMARY KEY (channel_id, video_id)
+		 ) WITHOUT ROWID;`)
+	if err != nil {
+		return err
+	}
+
+	// add last_seen_at column to databases created before it existed, starting
+	// from each channel's newest recorded upload
+	added, err := addColumnIfMissing(tx, "channel_check_times", "last_seen_at", "TEXT")
+	if err != nil {
+		return err
+	}
+	if added {
+		_, err = tx.Exec(
+			`UPDATE channel_check_times SET last_seen_at=(
+				SELECT datetime(MAX(published_at)) FROM channel_uploads WHERE channel_id=channel_check_times.id
+			 );`)
+		if err != nil {
+			return err
+		}
+	}
+
+	// create channel_meta table, caching each channel's details from YouTube
+	log.Debug().Msg("creating channel_meta table if required")
+	_, err = tx.Exec(
+		`CREATE TABLE IF NOT EXISTS channel_meta (
+			id TEXT PRIMARY KEY UNIQUE,
+			title TEXT NOT NULL DEFAULT '',
+			uploads_playlist_id TEXT NOT NULL DEFAULT '',
+			thumbnail_url TEXT NOT NULL DEFAULT '',
+			fetched_at TEXT NOT NULL DEFAULT ''
+		 ) WITHOUT ROWID;`)
+	if err != nil {
+		return err
+	}
+
+	// add embed_color column, for channels with embed_color auto
+	_, err = addColumnIfMissing(tx, "channel_meta", "embed_color", "INTEGER")
+	if err != nil {
+		return err
+	}
+
+	// move uploads playlists cached before channel_meta existed into it
+	err = tx.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name='channel_playlists';`).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if exists > 0 {
+		log.Info().Msg("moving channel_playlists into channel_meta")
+		_, err = tx.Exec(
+			`INSERT INTO channel_meta (id, uploads_playlist_id)
+			 SELECT channel_id, uploads_playlist_id FROM channel_playlists WHERE true
+			 ON CONFLICT(id) DO NOTHING;`)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`DROP TABLE channel_playlists;`)
+		if err != nil {
+			return err
+		}
+	}
+
+	// create playlist_items table, for the videos in monitored playlists that
+	// have been dealt with, as they stay in playlists long after their
+	// videos_posted records are cleaned up
+	log.Debug().Msg("creating playlist_items table if required")
+	_, err = tx.Exec(
+		`CREATE TABLE IF NOT EXISTS playlist_items (
+			playlist_id TEXT NOT NULL,
+			video_id TEXT NOT NULL,
+			seen_at TEXT NOT NULL,
+			PRIMARY KEY (playlist_id, video_id)
+		 ) WITHOUT ROWID;`)
+	if err != nil {
+		return err
+	}
+
+	// create channel_feeds table, for the caching headers of each channel's feed
+	log.Debug().Msg("creating channel_feeds table if required")
+	_, err = tx.Exec(
+		`CREATE TABLE IF NOT EXISTS channel_feeds (
+			channel_id TEXT PRIMARY KEY UNIQUE,
+			etag TEXT NOT NULL DEFAULT '',
+			last_modified TEXT NOT NULL DEFAULT ''
+		 ) WITHOUT ROWID;`)
+	if err != nil {
+		return err
+	}
+
+	// create list_etags table, for the etag of each channel's or playlist's last playlist items
+	log.Debug().Msg("creating list_etags table if required")
+	_, err = tx.Exec(
+		`CREATE TABLE IF NOT EXISTS list_etags (
+			channel_id TEXT PRIMARY KEY UNIQUE,
+			etag TEXT NOT NULL DEFAULT ''
+		 ) WITHOUT ROWID;`)
+	if err != nil {
+		return err
+	}
+
+	// create channel_failures table, for each channel's consecutive failed checks
+	log.Debug().Msg("creating channel_failures table if required")
+	_, err = tx.Exec(
+		`CREATE TABLE IF NOT EXISTS channel_failures (
+			channel_id TEXT PRIMARY KEY UNIQUE,
+			failures INTEGER NOT NULL DEFAULT 0,
+			last_error TEXT NOT NULL DEFAULT '',
+			tripped_at TEXT
+		 ) WITHOUT ROWID;`)
+	if err != nil {
+		return err
+	}
+
+	// create upcoming_streams table, for announced streams followed until they go live
+	log.Debug().Msg("creating upcoming_streams table if required")
+	_, err = tx.Exec(
+		`CREATE TABLE IF NOT EXISTS upcoming_streams (
+			video_id TEXT PRIMARY KEY UNIQUE,
+			channel_id TEXT NOT NULL,
+			scheduled_start TEXT NOT NULL,
+			announced_at TEXT NOT NULL,
+			live_at TEXT
+		 ) WITHOUT ROWID;`)
+	if err != nil {
+		return err
+	}
+
+	// create view_candidates table, for videos waiting to reach their channel's min_views
+	log.Debug().Msg("creating view_candidates table if required")
+	_, err = tx.Exec(
+		`CREATE TABLE IF NOT EXISTS view_candidates (
+			channel_id TEXT NOT NULL,
+			video_id TEXT NOT NULL,
+			published_at TEXT NOT NULL,
+			views INTEGER NOT NULL DEFAULT 0,
+			checked_at TEXT NOT NULL,
+			PRIMARY KEY (channel_id, video_id)
+		 ) WITHOUT ROWID;`)
+	if err != nil {
+		return err
+	}
+
+	// create websub_subscriptions table, for when each channel's subscription needs renewing
+	log.Debug().Msg("creating websub_subscriptions table if required")
+	_, err = tx.Exec(
+		`CREATE TABLE IF NOT EXISTS websub_subscriptions (
+			channel_id TEXT PRIMARY KEY UNIQUE,
+			requested_at TEXT NOT NULL,
+			expires_at TEXT
+		 ) WITHOUT ROWID;`)
+	if err != nil {
+		return err
+	}
+
+	// create quota_usage table, for the API quota used each day
+	log.Debug().Msg("creating quota_usage table if required")
+	_, err = tx.Exec(
+		`CREATE TABLE IF NOT EXISTS quota_usage (
+			date TEXT NOT NULL,
+			call TEXT NOT NULL,
+			calls INTEGER NOT NULL,
+			units INTEGER NOT NULL,
+			PRIMARY KEY (date, call)
+		 ) WITHOUT ROWID;`)
+	if err != nil {
+		return err
+	}
+
+	// create search_posts table, for each search's daily post limit
+	log.Debug().Msg("creating search_posts table if required")
+	_, err = tx.Exec(
+		`CREATE TABLE IF NOT EXISTS search_posts (
+			search_id TEXT NOT NULL,
+			video_id TEXT NOT NULL,
+			posted_at TEXT NOT NULL,
+			PRIMARY KEY (search_id, video_id)
+		 ) WITHOUT ROWID;`)
+	if err != nil {
+		return err
+	}
+
+	// create tracked_messages table, for editing or deleting posted messages
+	// when their video's title changes or it is taken down
+	log.Debug().Msg("creating tracked_messages table if required")
+	createTrackedMessages :=
+		`CREATE TABLE IF NOT EXISTS tracked_messages (
+			video_id TEXT NOT NULL,
+			webhook TEXT NOT NULL,
+			message_id TEXT NOT NULL,
+			title TEXT NOT NULL,
+			content TEXT NOT NULL,
+			posted_at TEXT NOT NULL,
+			edits INTEGER NOT NULL DEFAULT 0,
+			dead_at TEXT,
+			thread_id TEXT,
+			PRIMARY KEY (video_id, webhook)
+		 ) WITHOUT ROWID;`
+	_, err = tx.Exec(createTrackedMessages)
+	if err != nil {
+		return err
+	}
+
+	// add dead_at column to databases created before posts were audited
+	_, err = addColumnIfMissing(tx, "tracked_messages", "dead_at", "TEXT")
+	if err != nil {
+		return err
+	}
+
+	// add thread_id column, for the thread started under a message with --create-threads
+	_, err = addColumnIfMissing(tx, "tracked_messages", "thread_id", "TEXT")
+	if err != nil {
+		return err
+	}
+
+	// track messages per webhook, for videos posted to more than one
+	err = addToPrimaryKey(tx, "tracked_messages", "webhook", createTrackedMessages)
+	if err != nil {
+		return err
+	}
+
+	// create channels table
+	log.Debug().Msg("creating channels table if required")
+	_, err = tx.Exec(
+		`CREATE TABLE IF NOT EXISTS channels (
+			id TEXT PRIMARY KEY UNIQUE,
+			name TEXT NOT NULL,
+			added_at TEXT NOT NULL,
+			enabled INTEGER NOT NULL DEFAULT 1
+		 ) WITHOUT ROWID;`)
+	if err != nil {
+		return err
+	}
+
+	// add source column to databases created before it existed, assuming any
+	// built-in channels in them were seeded from the built-in list
+	added, err = addColumnIfMissing(tx, "channels", "source", "TEXT NOT NULL DEFAULT 'db'")
+	if err != nil {
+		return err
+	}
+	if added {
+		for _, c := range opts.Builtin {
+			_, err = tx.Exec(`UPDATE channels SET source=? WHERE id=?;`, c.Source, c.ID)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// addToPrimaryKey rebuilds a table created before column was part of its
+// primary key, as SQLite can't change an existing table's key. create is the
+// table's CREATE TABLE IF NOT EXISTS statement, with the new key and every
+// column of the old table.
+func addToPrimaryKey(tx *sql.Tx, table, column, create string) error {
+	rows, err := tx.Query(fmt.Sprintf(`SELECT name, pk FROM pragma_table_info('%s');`, table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	var columns []string
+	for rows.Next() {
+		var (
+			name string
+			pk   int
+		)
+		err = rows.Scan(&name, &pk)
+		if err != nil {
+			return err
+		}
+		if name == column && pk > 0 {
+			return nil
+		}
+		columns = append(columns, name)
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	log.Info().Str("table", table).Str("column", column).Msg("adding column to table's primary key")
+	old := table + "_old"
+	cols := strings.Join(columns, ", ")
+	for _, stmt := range []string{
+		fmt.Sprintf(`ALTER TABLE %s RENAME TO %s;`, table, old),
+		create,
+		fmt.Sprintf(`INSERT INTO %s (%s) SELECT %s FROM %s;`, table, cols, cols, old),
+		fmt.Sprintf(`DROP TABLE %s;`, old),
+	} {
+		_, err = tx.Exec(stmt)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addColumnIfMissing adds a column to an existing table if it isn't already
+// there, returning whether it was added
+func addColumnIfMissing(tx *sql.Tx, table, column, definition string) (bool, error) {
+	rows, err := tx.Query(fmt.Sprintf(`SELECT name FROM pragma_table_info('%s');`, table))
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		err = rows.Scan(&name)
+		if err != nil {
+			return false, err
+		}
+		if name == column {
+			return false, nil
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return false, err
+	}
+	rows.Close()
+
+	log.Info().Str("table", table).Str("column", column).Msg("adding column to table")
+	_, err = tx.Exec(fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s;`, table, column, definition))
+	return err == nil, err
+}
@@ -0,0 +1,163 @@
+package store
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var testOptions = Options{JournalMode: "wal", BusyTimeout: 5 * time.Second}
+
+func TestSQLite(t *testing.T) {
+	testStore(t, func(t *testing.T) Store {
+		s, err := Open(DriverSQLite, filepath.Join(t.TempDir(), "ytbot.db"), testOptions)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	})
+}
+
+func TestManyWritesDontLockDB(t *testing.T) {
+	dbfile := filepath.Join(t.TempDir(), "ytbot.db")
+	s, err := Open(DriverSQLite, dbfile, testOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// what a check and delivery write for each video, along with the reads between them
+	const videos = 300
+	for i := 0; i < videos; i++ {
+		id := fmt.Sprintf("video%06d", i)
+		_, posted, err := s.IsPosted(id)
+		if err != nil {
+			t.Fatalf("video %d: %v", i, err)
+		}
+		if posted {
+			t.Fatalf("video %d recorded before it was posted", i)
+		}
+		for _, write := range []func() error{
+			func() error { return s.SetCheckTime("UC1", time.Now()) },
+			func() error { return s.MarkPosted(id, "queued", "youtube") },
+			func() error {
+				return s.SetVideoDetails(id, VideoDetails{ChannelID: "UC1", Title: fmt.Sprintf("Video %d", i), ChannelTitle: "Mentour Pilot"})
+			},
+			func() error { return s.MarkPosted(id, "video", "youtube") },
+			func() error { return s.MarkDelivered(id, "https://discord.com/api/webhooks/1/test") },
+			func() error { return s.SetVideoMessage(id, "1", "2", "") },
+		} {
+			// e.g. "database is locked" from a write that left its statement open
+			err = write()
+			if err != nil {
+				t.Fatalf("video %d: %v", i, err)
+			}
+		}
+	}
+
+	// nothing may be left holding the database, so another process can write to it
+	other, err := Open(DriverSQLite, dbfile, Options{JournalMode: "wal"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	err = other.MarkPosted("another", "video", "youtube")
+	if err != nil {
+		t.Fatalf("writing from another connection: %v", err)
+	}
+
+	all, err := s.Videos()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	for _, v := range all {
+		if v.PostedAt != "" && v.DiscordMessageID != "" {
+			n++
+		}
+	}
+	if n != videos {
+		t.Errorf("%d videos recorded as posted, want %d", n, videos)
+	}
+}
+
+// concurrentWrites has two goroutines, each with its own connections to the
+// same database opened with opts, write to it at once, holding the write lock
+// for a moment in each transaction. It returns the errors they got.
+func concurrentWrites(t *testing.T, opts Options) []error {
+	dbfile := filepath.Join(t.TempDir(), "ytbot.db")
+	s, err := Open(DriverSQLite, dbfile, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	const writes = 50
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []error
+	)
+	for w := 0; w < 2; w++ {
+		s, err := Open(DriverSQLite, dbfile, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer s.Close()
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < writes; i++ {
+				id := fmt.Sprintf("w%dv%04d", w, i)
+				err := func() error {
+					tx, err := s.Begin()
+					if err != nil {
+						return err
+					}
+					defer tx.Rollback()
+					err = tx.MarkPosted(id, "video", "youtube")
+					if err != nil {
+						return err
+					}
+					time.Sleep(2 * time.Millisecond)
+					err = tx.SetVideoDetails(id, VideoDetails{ChannelID: fmt.Sprintf("UCwriter%d", w), Title: "Video"})
+					if err != nil {
+						return err
+					}
+					return tx.Commit()
+				}()
+				if err == nil {
+					err = s.MarkDelivered(id, "https://discord.com/api/webhooks/1/test")
+				}
+				if err != nil {
+					mu.Lock()
+					errs = append(errs, fmt.Errorf("writer %d, video %d: %w", w, i, err))
+					mu.Unlock()
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	return errs
+}
+
+func TestConcurrentWriters(t *testing.T) {
+	errs := concurrentWrites(t, testOptions)
+	for _, err := range errs {
+		t.Error(err)
+	}
+
+	// without the busy timeout the same writes fail, so they really do contend
+	errs = concurrentWrites(t, Options{JournalMode: "wal"})
+	if len(errs) == 0 {
+		t.Fatal("writers with no busy timeout got no errors, so didn't contend for the database")
+	}
+	for _, err := range errs {
+		if !strings.Contains(err.Error(), "SQLITE_BUSY") {
+			t.Errorf("writer with no busy timeout got %v, want SQLITE_BUSY", err)
+		}
+	}
+}
@@ -0,0 +1,527 @@
+// Package store keeps ytbot's state in a database: the videos it has posted,
+// when each channel was checked and is next due, the outbox of posts waiting
+// to be delivered, and everything else it remembers between runs. SQLite is
+// the default, with Postgres for running against a shared database server.
+//
+// All of ytbot's SQL lives here, including where the databases differ, e.g.
+// in working out the time. Timestamps are stored as text in TimeFormat, in
+// UTC, on every backend, so they compare and export the same.
+package store
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// drivers for --db-driver
+const (
+	DriverSQLite   = "sqlite"
+	DriverPostgres = "postgres"
+)
+
+// TimeFormat is the format timestamps are stored in, that of sqlite's datetime()
+const TimeFormat = "2006-01-02 15:04:05"
+
+// ErrNoRowChanged is returned by a write that must change a row but didn't,
+// e.g. recording the delivery of a video that was never recorded
+var ErrNoRowChanged = errors.New("no row was changed")
+
+// ErrChannelNotFound is returned when changing a channel that isn't in the channels table
+var ErrChannelNotFound = errors.New("channel not found")
+
+// Options are how a store is opened
+type Options struct {
+	JournalMode string        // sqlite's journal mode, e.g. wal
+	BusyTimeout time.Duration // how long sqlite waits for another connection's lock
+
+	// Builtin are the built-in channels, which databases created before
+	// channels had a source have their source set for, and which a new
+	// channels table is seeded from if SeedBuiltin is set
+	Builtin     []Channel
+	SeedBuiltin bool
+}
+
+// Open opens the database at dsn with driver, a path for sqlite or a
+// connection string for Postgres, migrating its schema to the latest version
+func Open(driver, dsn string, opts Options) (Store, error) {
+	switch driver {
+	case DriverSQLite:
+		return openSQLite(dsn, opts)
+	case DriverPostgres:
+		return openPostgres(dsn, opts)
+	}
+	return nil, fmt.Errorf("unknown database driver %q, must be %s or %s", driver, DriverSQLite, DriverPostgres)
+}
+
+// Store is a database of ytbot's state
+type Store interface {
+	Queries
+
+	// Begin starts a transaction, for writes that must be made together or
+	// not at all. In a dry run nothing is written, so it reads straight from
+	// the database.
+	Begin() (Tx, error)
+
+	// DryRun returns the store with its writes logged instead of made. The
+	// run lock is still taken, so a dry run can't overlap a real one.
+	DryRun() Store
+
+	// SchemaVersion returns the version of the database's schema, the number
+	// of migrations applied to it
+	SchemaVersion() (int, error)
+
+	// Migrations returns the migrations applied to the database, oldest first
+	Migrations() ([]Migration, error)
+
+	// LatestVersion returns the schema version this version of ytbot migrates databases to
+	LatestVersion() int
+
+	// Stats describes what's in the database
+	Stats() (Stats, error)
+
+	// Prune removes rows older than r from the tables that are pruned,
+	// returning how many were removed from each, and gives the space back.
+	// In a dry run it only counts the rows it would remove.
+	Prune(r Retention) ([]Pruned, error)
+
+	// AcquireLock takes the run lock for holder, if nobody has it or its
+	// holder hasn't heartbeated for timeout, returning whether it did
+	AcquireLock(holder string, timeout time.Duration) (bool, error)
+
+	// LockHolder returns who holds the run lock and when they last heartbeated
+	LockHolder() (string, time.Time, bool, error)
+
+	// HeartbeatLock keeps holder's run lock fresh, returning ErrNoRowChanged
+	// if holder no longer has it, e.g. as another instance took it over
+	HeartbeatLock(holder string) error
+
+	// ReleaseLock gives up holder's run lock
+	ReleaseLock(holder string) error
+
+	Close() error
+}
+
+// Tx is a transaction, rolled back unless it's committed
+type Tx interface {
+	Queries
+	Commit() error
+
+	// Rollback rolls back the transaction if it hasn't been committed, so it
+	// can be deferred as soon as it's begun
+	Rollback() error
+}
+
+// Queries are the reads and writes of ytbot's state, made on a Store or in a Tx
+type Queries interface {
+	// IsPosted returns how a video was posted, and whether it has been at all
+	IsPosted(videoId string) (string, bool, error)
+
+	// MarkPosted records a video as handled so it is never posted (again), or
+	// updates how it was posted if it already has been
+	MarkPosted(videoId, postType, platform string) error
+
+	// SetVideoDetails records which channel a video came from, its title and
+	// when it was published
+	SetVideoDetails(videoId string, d VideoDetails) error
+
+	// MarkDelivered records when a video was first posted, and the webhook it
+	// was last posted to
+	MarkDelivered(videoId, webhook string) error
+
+	// SetVideoMessage records the Discord message a video was posted as, and
+	// any thread started under it
+	SetVideoMessage(videoId, messageId, channelId, threadId string) error
+
+	// RecentlyPosted returns the videos with a title of one of postTypes
+	// recorded from a channel within the last within, other than excludeId
+	RecentlyPosted(channelId, excludeId string, within time.Duration, postTypes ...string) ([]Video, error)
+
+	// Videos returns every videos_posted record, oldest first
+	Videos() ([]Video, error)
+
+	// Video returns a video's record, and whether it has one
+	Video(videoId string) (Video, bool, error)
+
+	// WriteVideo inserts or updates a video's record, with empty fields as
+	// NULLs, or empty strings for the columns that can't be NULL
+	WriteVideo(v Video) error
+
+	// PostCounts counts the videos of each channel and post type posted
+	// between from and to, or for those that weren't, or were recorded
+	// before posting times were, recorded between them
+	PostCounts(from, to time.Time) ([]PostCount, error)
+
+	// ChannelPostCounts counts the videos posted from each channel in each of
+	// periods up to now, for the channels posted from in the last of them
+	ChannelPostCounts(periods ...time.Duration) ([]ChannelPostCount, error)
+
+	// CheckTime returns when a channel was last checked, and whether it has been
+	CheckTime(channelId string) (CheckTime, bool, error)
+
+	// SetCheckTime records a channel as checked now, next due at nextCheckAt
+	SetCheckTime(channelId string, nextCheckAt time.Time) error
+
+	// SetLastSeen records t as when the newest video seen from a channel was
+	// published, unless a newer one has been seen already
+	SetLastSeen(channelId string, t time.Time) error
+
+	// Channels returns the channels in the channels table, by name
+	Channels() ([]Channel, error)
+
+	// AddChannel adds an enabled channel to the channels table
+	AddChannel(c Channel) error
+
+	// RemoveChannel removes a channel from the channels table, returning
+	// ErrChannelNotFound if it isn't there
+	RemoveChannel(channelId string) error
+
+	// SetChannelEnabled enables or disables a channel in the channels table,
+	// returning ErrChannelNotFound if it isn't there
+	SetChannelEnabled(channelId string, enabled bool) error
+
+	// ChannelFailures returns a channel's run of failed checks, if any
+	ChannelFailures(channelId string) (ChannelFailures, error)
+
+	// SetChannelFailures records a channel's run of failed checks
+	SetChannelFailures(channelId string, f ChannelFailures) error
+
+	// ResetChannelFailures forgets a channel's failed checks, returning whether it had any
+	ResetChannelFailures(channelId string) (bool, error)
+
+	// RecordUpload records when one of a channel's videos was published, as
+	// RFC 3339, keeping only the channel's keep most recent uploads
+	RecordUpload(channelId, videoId, publishedAt string, keep int) error
+
+	// UploadTimes returns when a channel's recorded uploads were published, oldest first
+	UploadTimes(channelId string) ([]string, error)
+
+	// ChannelMetaFetchedAt returns when a channel's details were last
+	// fetched, or the zero time if they never have been
+	ChannelMetaFetchedAt(id string) (time.Time, error)
+
+	// SetChannelMeta stores the details fetched for a channel or playlist
+	SetChannelMeta(id, title, uploadsPlaylistId, thumbnailURL string) error
+
+	// ChannelTitles returns the cached title of each channel that has one
+	ChannelTitles() (map[string]string, error)
+
+	// UploadsPlaylist returns a channel's cached uploads playlist, or "" if it isn't cached
+	UploadsPlaylist(channelId string) (string, error)
+
+	// SetUploadsPlaylist caches a channel's uploads playlist
+	SetUploadsPlaylist(channelId, playlistId string) error
+
+	// EmbedColor returns the embed colour worked out for a channel, and whether there is one
+	EmbedColor(channelId string) (int, bool, error)
+
+	// SetEmbedColor records the embed colour worked out for a channel
+	SetEmbedColor(channelId string, color int) error
+
+	// FeedCache returns the caching headers from the last fetch of a channel's feed
+	FeedCache(channelId string) (etag, lastModified string, err error)
+
+	// SetFeedCache stores the caching headers of a channel's feed
+	SetFeedCache(channelId, etag, lastModified string) error
+
+	// ForgetFeedCache removes the caching headers stored for a channel's feed
+	ForgetFeedCache(channelId string) error
+
+	// ListEtag returns the etag stored for a channel's or playlist's items, or "" if there isn't one
+	ListEtag(channelId string) (string, error)
+
+	// SetListEtag stores the etag of a channel's or playlist's items
+	SetListEtag(channelId, etag string) error
+
+	// ForgetListEtag removes the etag stored for a channel's or playlist's items
+	ForgetListEtag(channelId string) error
+
+	// PlaylistItemSeen returns whether a video in a playlist has been dealt with
+	PlaylistItemSeen(playlistId, videoId string) (bool, error)
+
+	// MarkPlaylistItemSeen records a video in a playlist as dealt with
+	MarkPlaylistItemSeen(playlistId, videoId string) error
+
+	// ViewCandidates returns a channel's videos waiting to reach its min_views
+	ViewCandidates(channelId string) ([]ViewCandidate, error)
+
+	// SetViewCandidate records a video waiting to reach its channel's
+	// min_views, published at publishedAt as RFC 3339, and its views so far
+	SetViewCandidate(channelId, videoId, publishedAt string, views int64) error
+
+	// DeleteViewCandidate stops waiting for a video to reach its channel's min_views
+	DeleteViewCandidate(channelId, videoId string) error
+
+	// ForgetPostedViewCandidates removes a channel's videos waiting for views
+	// that have since been recorded in videos_posted
+	ForgetPostedViewCandidates(channelId string) error
+
+	// UpcomingStreams returns the IDs of a channel's announced streams that
+	// haven't been seen live yet
+	UpcomingStreams(channelId string) ([]string, error)
+
+	// RecordStream records a scheduled stream as announced
+	RecordStream(channelId, videoId string, scheduledStart time.Time) error
+
+	// MarkStreamLive records that an announced stream went live
+	MarkStreamLive(videoId string) error
+
+	// ForgetStream stops following an announced stream
+	ForgetStream(videoId string) error
+
+	// QueuePost adds a post to the outbox, unless the video is already
+	// queued for the webhook
+	QueuePost(p PendingPost) error
+
+	// PendingPosts returns the posts in the outbox, oldest first
+	PendingPosts() ([]PendingPost, error)
+
+	// DeletePendingPost removes a post to one webhook from the outbox
+	DeletePendingPost(videoId, webhook string) error
+
+	// SetPendingPostAttempts records how many times delivering a post has failed
+	SetPendingPostAttempts(videoId, webhook string, attempts int) error
+
+	// AddDigestItem collects an upload for the next digest to a webhook,
+	// unless it already has been
+	AddDigestItem(it DigestItem) error
+
+	// DigestItems returns the uploads waiting for the next digest, oldest first
+	DigestItems() ([]DigestItem, error)
+
+	// DeleteDigestItem removes an upload posted in a digest to a webhook
+	DeleteDigestItem(videoId, webhook string) error
+
+	// DigestSent returns whether the digest due at dueAt, or a later one, has been sent
+	DigestSent(dueAt time.Time) (bool, error)
+
+	// RecordDigest records the digest due at dueAt as sent, with how many videos were in it
+	RecordDigest(dueAt time.Time, videos int) error
+
+	// ReportSent returns whether the report due at dueAt, or a later one, has been sent
+	ReportSent(dueAt time.Time) (bool, error)
+
+	// RecordReport records the report due at dueAt as sent, with how many videos were in it
+	RecordReport(dueAt time.Time, videos int) error
+
+	// TrackMessage records a message posted for a video, replacing any
+	// earlier one to the same webhook
+	TrackMessage(m TrackedMessage) error
+
+	// SetMessageThread records the thread started under a tracked message
+	SetMessageThread(videoId, webhook, threadId string) error
+
+	// MessagesToRetitle returns the tracked messages posted within the last
+	// within, edited fewer than maxEdits times, that aren't dead, whose video
+	// IDs don't start with excludePrefix
+	MessagesToRetitle(within time.Duration, maxEdits int, excludePrefix string) ([]TrackedMessage, error)
+
+	// LiveMessages returns the tracked messages that aren't dead, whose video
+	// IDs don't start with excludePrefix
+	LiveMessages(excludePrefix string) ([]TrackedMessage, error)
+
+	// RetitleMessage records a tracked message as edited for a new title
+	RetitleMessage(videoId, webhook, title, content, render string) error
+
+	// MarkMessageDead records a tracked message's video as no longer available
+	MarkMessageDead(videoId, webhook string) error
+
+	// SearchPostsToday returns how many videos a search has posted today (UTC)
+	SearchPostsToday(searchId string) (int, error)
+
+	// RecordSearchPost records that a search posted a video
+	RecordSearchPost(searchId, videoId string) error
+
+	// WebSubSubscription returns a channel's WebSub subscription, and whether it has one
+	WebSubSubscription(channelId string) (WebSubSubscription, bool, error)
+
+	// WebSubRequested records asking the hub to subscribe to a channel now
+	WebSubRequested(channelId string) error
+
+	// WebSubVerified records the hub verifying a channel's subscription,
+	// which lasts until expiresAt
+	WebSubVerified(channelId string, expiresAt time.Time) error
+
+	// QuotaUsed returns the API quota units used on a quota day
+	QuotaUsed(day string) (int, error)
+
+	// SpendQuota records an API call using units on a quota day
+	SpendQuota(day, call string, units int) error
+
+	// QuotaUsage returns the quota used by each kind of call on a quota day, most first
+	QuotaUsage(day string) ([]QuotaUsage, error)
+}
+
+// Video is a videos_posted record. Fields that weren't recorded for the
+// video are empty.
+type Video struct {
+	ID               string
+	Platform         string
+	PostType         string
+	DatePosted       string
+	ChannelID        string
+	ChannelTitle     string
+	Title            string
+	PublishedAt      string
+	PostedAt         string
+	Webhook          string
+	DiscordMessageID string
+	DiscordChannelID string
+	DiscordThreadID  string
+}
+
+// videoColumns are the videos_posted columns, in the order of Video's fields
+var videoColumns = []string{
+	"id", "platform", "post_type", "date_posted", "channel_id", "channel_title", "title",
+	"published_at", "posted_at", "webhook", "discord_message_id", "discord_channel_id", "discord_thread_id",
+}
+
+// fields returns pointers to the video's fields, in the order of videoColumns
+func (v *Video) fields() []*string {
+	return []*string{
+		&v.ID, &v.Platform, &v.PostType, &v.DatePosted, &v.ChannelID, &v.ChannelTitle, &v.Title,
+		&v.PublishedAt, &v.PostedAt, &v.Webhook, &v.DiscordMessageID, &v.DiscordChannelID, &v.DiscordThreadID,
+	}
+}
+
+// VideoDetails are what's recorded about a video once it's found
+type VideoDetails struct {
+	ChannelID    string
+	Title        string
+	ChannelTitle string
+	PublishedAt  time.Time // zero if it isn't known
+}
+
+// PostCount is how many of a channel's videos were recorded with a post type
+type PostCount struct {
+	ChannelID string // empty for videos recorded before their channel was
+	PostType  string
+	Videos    int
+}
+
+// ChannelPostCount is how many videos were posted from a channel in each of
+// the periods asked for
+type ChannelPostCount struct {
+	ChannelID string
+	Posted    []int
+}
+
+// CheckTime is a channel's row in channel_check_times
+type CheckTime struct {
+	DateChecked time.Time
+	NextCheckAt time.Time // zero for channels last checked before checks were scheduled
+	LastSeenAt  time.Time // zero if no video has been seen
+}
+
+// Channel is a channel in the channels table
+type Channel struct {
+	ID      string
+	Name    string
+	Enabled bool
+	Source  string
+}
+
+// ChannelFailures is a channel's run of consecutive failed checks
+type ChannelFailures struct {
+	Failures  int
+	LastError string
+	TrippedAt time.Time // zero unless the channel is tripped
+}
+
+// ViewCandidate is a video waiting to reach its channel's min_views
+type ViewCandidate struct {
+	VideoID     string
+	PublishedAt string // RFC 3339
+}
+
+// PendingPost is a post in the outbox. Embeds, Event and Render are JSON, or
+// empty for none.
+type PendingPost struct {
+	VideoID       string
+	ChannelID     string
+	Webhook       string
+	Content       string
+	MentionRoleID string
+	Embeds        string
+	ThreadName    string
+	Flags         int
+	PostType      string
+	Title         string
+	Event         string
+	Render        string
+	Attempts      int
+	QueuedAt      time.Time
+}
+
+// DigestItem is an upload waiting for the next digest to a webhook
+type DigestItem struct {
+	VideoID      string
+	Webhook      string
+	Forum        bool
+	ChannelID    string
+	ChannelTitle string
+	Title        string
+	URL          string
+}
+
+// TrackedMessage is a message posted for a video, kept so it can be edited or
+// deleted later. ThreadID and Render are empty if there are none.
+type TrackedMessage struct {
+	VideoID   string
+	Webhook   string
+	MessageID string
+	Title     string
+	Content   string
+	Edits     int
+	ThreadID  string
+	Render    string
+}
+
+// WebSubSubscription is when a channel's WebSub subscription was requested and expires
+type WebSubSubscription struct {
+	RequestedAt time.Time
+	ExpiresAt   time.Time // zero until the hub verifies it
+}
+
+// QuotaUsage is the quota used by one kind of API call on a day
+type QuotaUsage struct {
+	Call  string
+	Calls int
+	Units int
+}
+
+// Migration is a migration applied to the database
+type Migration struct {
+	Version     int
+	AppliedAt   string
+	Description string
+}
+
+// Retention is how long rows are kept in each of the tables that are pruned
+type Retention struct {
+	Videos      time.Duration // videos_posted, by when each was posted or recorded
+	SearchPosts time.Duration
+	Messages    time.Duration // tracked_messages
+}
+
+// Pruned is how many rows were, or in a dry run would be, removed from a table
+type Pruned struct {
+	Table string
+	Rows  int64
+}
+
+// TableRows is how many rows a table has
+type TableRows struct {
+	Table string
+	Rows  int64
+}
+
+// Stats describes what's in the database
+type Stats struct {
+	SchemaVersion int
+	Size          int64  // bytes
+	UsedSize      int64  // bytes, without free pages on sqlite, the same as Size on Postgres
+	OldestPosted  string // empty if nothing has been posted
+	NewestPosted  string // empty if nothing has been posted
+	Tables        []TableRows
+}
@@ -0,0 +1,184 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+func (s *queries) IsPosted(videoId string) (string, bool, error) {
+	var postType string
+	err := s.queryRow(`SELECT post_type FROM videos_posted WHERE id=?;`, videoId).Scan(&postType)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return postType, true, nil
+}
+
+func (s *queries) MarkPosted(videoId, postType, platform string) error {
+	return s.execOne(fmt.Sprintf(
+		`INSERT INTO videos_posted (id, date_posted, post_type, platform) VALUES (?, %s, ?, ?)
+		 ON CONFLICT(id) DO UPDATE SET post_type=excluded.post_type;`, s.d.now()),
+		videoId, postType, platform)
+}
+
+func (s *queries) SetVideoDetails(videoId string, d VideoDetails) error {
+	return s.execOne(`UPDATE videos_posted SET channel_id=?, title=?, channel_title=?, published_at=? WHERE id=?;`,
+		d.ChannelID, d.Title, d.ChannelTitle, nullTime(d.PublishedAt), videoId)
+}
+
+func (s *queries) MarkDelivered(videoId, webhook string) error {
+	return s.execOne(fmt.Sprintf(`UPDATE videos_posted SET posted_at=COALESCE(posted_at, %s), webhook=? WHERE id=?;`, s.d.now()), webhook, videoId)
+}
+
+func (s *queries) SetVideoMessage(videoId, messageId, channelId, threadId string) error {
+	return s.execOne(`UPDATE videos_posted SET discord_message_id=?, discord_channel_id=?, discord_thread_id=? WHERE id=?;`,
+		messageId, channelId, nullString(threadId), videoId)
+}
+
+func (s *queries) RecentlyPosted(channelId, excludeId string, within time.Duration, postTypes ...string) ([]Video, error) {
+	if len(postTypes) == 0 {
+		return nil, nil
+	}
+	args := []any{channelId, excludeId}
+	for _, t := range postTypes {
+		args = append(args, t)
+	}
+	return s.videos(fmt.Sprintf(
+		`SELECT %s FROM videos_posted
+		 WHERE channel_id=? AND id != ? AND title != '' AND post_type IN (?%s) AND date_posted >= %s;`,
+		strings.Join(videoColumns, ", "), strings.Repeat(", ?", len(postTypes)-1), s.d.ago(within)), args...)
+}
+
+func (s *queries) Videos() ([]Video, error) {
+	return s.videos(fmt.Sprintf(`SELECT %s FROM videos_posted ORDER BY date_posted, id;`, strings.Join(videoColumns, ", ")))
+}
+
+// videos reads the videos_posted records a query selects the videoColumns of
+func (s *queries) videos(query string, args ...any) ([]Video, error) {
+	rows, err := s.query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	videos := []Video{}
+	for rows.Next() {
+		var v Video
+		err = scanVideo(rows, &v)
+		if err != nil {
+			return nil, err
+		}
+		videos = append(videos, v)
+	}
+	return videos, rows.Err()
+}
+
+func (s *queries) Video(videoId string) (Video, bool, error) {
+	var v Video
+	err := scanVideo(s.queryRow(fmt.Sprintf(`SELECT %s FROM videos_posted WHERE id=?;`, strings.Join(videoColumns, ", ")), videoId), &v)
+	if errors.Is(err, sql.ErrNoRows) {
+		return v, false, nil
+	}
+	return v, err == nil, err
+}
+
+// scanVideo scans the videoColumns of a videos_posted record into v, with
+// NULLs as empty strings
+func scanVideo(row interface{ Scan(...any) error }, v *Video) error {
+	fields := v.fields()
+	values := make([]sql.NullString, len(fields))
+	dest := make([]any, len(fields))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	err := row.Scan(dest...)
+	if err != nil {
+		return err
+	}
+	for i, f := range fields {
+		*f = values[i].String
+	}
+	return nil
+}
+
+func (s *queries) WriteVideo(v Video) error {
+	updates := make([]string, 0, len(videoColumns)-1)
+	for _, c := range videoColumns[1:] {
+		updates = append(updates, fmt.Sprintf("%s=excluded.%s", c, c))
+	}
+	args := make([]any, 0, len(videoColumns))
+	for i, f := range v.fields() {
+		switch {
+		case *f != "":
+			args = append(args, *f)
+		case videoColumns[i] == "channel_id" || videoColumns[i] == "title":
+			args = append(args, "")
+		default:
+			args = append(args, nil)
+		}
+	}
+	_, err := s.exec(fmt.Sprintf(`INSERT INTO videos_posted (%s) VALUES (?%s) ON CONFLICT(id) DO UPDATE SET %s;`,
+		strings.Join(videoColumns, ", "), strings.Repeat(", ?", len(videoColumns)-1), strings.Join(updates, ", ")), args...)
+	return err
+}
+
+func (s *queries) PostCounts(from, to time.Time) ([]PostCount, error) {
+	f, t := formatTime(from), formatTime(to)
+	rows, err := s.query(
+		`SELECT channel_id, post_type, COUNT(*) FROM videos_posted
+		 WHERE (posted_at >= ? AND posted_at < ?) OR (posted_at IS NULL AND date_posted >= ? AND date_posted < ?)
+		 GROUP BY channel_id, post_type;`,
+		f, t, f, t)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var counts []PostCount
+	for rows.Next() {
+		var c PostCount
+		err = rows.Scan(&c.ChannelID, &c.PostType, &c.Videos)
+		if err != nil {
+			return nil, err
+		}
+		counts = append(counts, c)
+	}
+	return counts, rows.Err()
+}
+
+func (s *queries) ChannelPostCounts(periods ...time.Duration) ([]ChannelPostCount, error) {
+	if len(periods) == 0 {
+		return nil, nil
+	}
+	sums := make([]string, 0, len(periods))
+	for _, p := range periods {
+		sums = append(sums, fmt.Sprintf("SUM(CASE WHEN posted_at >= %s THEN 1 ELSE 0 END)", s.d.ago(p)))
+	}
+	rows, err := s.query(fmt.Sprintf(
+		`SELECT channel_id, %s
+		 FROM videos_posted
+		 WHERE posted_at >= %s
+		 GROUP BY channel_id;`, strings.Join(sums, ", "), s.d.ago(periods[len(periods)-1])))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var counts []ChannelPostCount
+	for rows.Next() {
+		c := ChannelPostCount{Posted: make([]int, len(periods))}
+		dest := []any{&c.ChannelID}
+		for i := range c.Posted {
+			dest = append(dest, &c.Posted[i])
+		}
+		err = rows.Scan(dest...)
+		if err != nil {
+			return nil, err
+		}
+		counts = append(counts, c)
+	}
+	return counts, rows.Err()
+}